@@ -4,12 +4,22 @@ import (
 	"net/http"
 
 	"github.com/NubeDev/flexy/common"
+	"github.com/NubeDev/flexy/utils/apispec"
 	"github.com/NubeDev/flexy/utils/code"
 	"github.com/gin-gonic/gin"
 )
 
 var Routers gin.RoutesInfo
 
+var (
+	// RouteDocs describes the gin routes for the OpenAPI document
+	RouteDocs = apispec.NewRoutes()
+	// SubjectDocs describes the NATS subjects for the AsyncAPI document
+	SubjectDocs = apispec.NewSubjects()
+)
+
+var docsInfo = apispec.Info{Title: "ros", Version: "v1"}
+
 func GetRouterList(c *gin.Context) {
 	appG := common.Gin{C: c}
 
@@ -31,3 +41,18 @@ func GetRouterList(c *gin.Context) {
 
 	appG.Response(http.StatusOK, code.SUCCESS, "Successfully retrieved existing route list", data)
 }
+
+// GetOpenAPI returns the OpenAPI 3 document of the routes
+func GetOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, RouteDocs.OpenAPI(apispec.OpenAPIOpts{
+		Info:     docsInfo,
+		BasePath: "/v1/api",
+		// the JWT is sent in the token header or the ?token= query
+		SecuritySchemes: map[string]*apispec.SecurityScheme{"token": apispec.HeaderAuth("token")},
+	}, Routers))
+}
+
+// GetAsyncAPI returns the AsyncAPI 2 document of the NATS subjects
+func GetAsyncAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, SubjectDocs.AsyncAPI(docsInfo))
+}
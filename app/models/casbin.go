@@ -4,6 +4,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/NubeDev/flexy/utils/natsauth"
 	"github.com/casbin/casbin/v2"
 	gormadapter "github.com/casbin/gorm-adapter/v3"
 	"gorm.io/gorm"
@@ -35,6 +36,25 @@ func SetupCasbin() *casbin.SyncedEnforcer {
 
 }
 
+// SetupNatsCasbin uses the same casbin_rule table as the gin routes, but matches
+// the policies against NATS subjects (eg; "*.post.system.store.>")
+func SetupNatsCasbin() (*casbin.SyncedEnforcer, error) {
+	a, err := gormadapter.NewAdapterByDBWithCustomTable(db, &CasbinRuleM{})
+	if err != nil {
+		return nil, err
+	}
+	e, err := casbin.NewSyncedEnforcer("config/nats_model.conf", a)
+	if err != nil {
+		return nil, err
+	}
+	natsauth.RegisterFunctions(e)
+
+	// Refresh every 12 hours.
+	e.StartAutoLoadPolicy(12 * time.Hour)
+
+	return e, nil
+}
+
 func CreatCasbin(casbin CasbinRuleM) error {
 	res := db.Create(&casbin)
 	if err := res.Error; err != nil {
@@ -1,6 +1,7 @@
 package natsrouter
 
 import (
+	"github.com/NubeDev/flexy/utils/natsauth"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
 )
@@ -8,6 +9,7 @@ import (
 type NatsRouter struct {
 	nc               *nats.Conn
 	JetStreamContext nats.JetStreamContext
+	auth             *natsauth.Authorizer
 }
 
 // NatsHandlerFunc is the handler type for NATS messages.
@@ -24,9 +26,21 @@ func New(nc *nats.Conn) *NatsRouter {
 	return &NatsRouter{nc: nc, JetStreamContext: js}
 }
 
+// UseAuth requires every handler registered after this call to be authorized
+func (r *NatsRouter) UseAuth(auth *natsauth.Authorizer) {
+	r.auth = auth
+}
+
+func (r *NatsRouter) wrap(handler NatsHandlerFunc) nats.MsgHandler {
+	if r.auth == nil {
+		return nats.MsgHandler(handler)
+	}
+	return r.auth.Handler(nats.MsgHandler(handler))
+}
+
 // Handle registers a handler for a NATS subject
 func (r *NatsRouter) Handle(subject string, handler NatsHandlerFunc) {
-	_, err := r.nc.Subscribe(subject, nats.MsgHandler(handler))
+	_, err := r.nc.Subscribe(subject, r.wrap(handler))
 	if err != nil {
 		log.Error().Msgf("err: %v on subscribe to subject: %s", subject, err)
 		return
@@ -35,7 +49,7 @@ func (r *NatsRouter) Handle(subject string, handler NatsHandlerFunc) {
 
 // QueueHandle registers a handler for a NATS subject with a queue group
 func (r *NatsRouter) QueueHandle(subject string, queue string, handler NatsHandlerFunc) {
-	_, err := r.nc.QueueSubscribe(subject, queue, nats.MsgHandler(handler))
+	_, err := r.nc.QueueSubscribe(subject, queue, r.wrap(handler))
 	if err != nil {
 		log.Error().Msgf("err: %v on subscribe to subject: %s", subject, err)
 		return
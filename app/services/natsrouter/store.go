@@ -5,7 +5,6 @@ import (
 	"io"
 	"log"
 	"os"
-	"path/filepath"
 )
 
 // CreateObjectStore will create an object store.
@@ -59,11 +58,8 @@ func (r *NatsRouter) NewObject(storeName, objectName, filePath string, overwrite
 	}
 	defer file.Close()
 
-	// Get the file name
-	fileName := filepath.Base(filePath)
-
 	// Upload the file to the Object Store using an io.Reader
-	_, err = store.Put(&nats.ObjectMeta{Name: fileName}, file)
+	_, err = store.Put(&nats.ObjectMeta{Name: objectName}, file)
 	if err != nil {
 		log.Printf("Error uploading file to object store: %v", err)
 		return err
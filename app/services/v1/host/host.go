@@ -3,6 +3,7 @@ package hostService
 import (
 	"fmt"
 	model "github.com/NubeDev/flexy/app/models"
+	"github.com/NubeDev/flexy/utils/events"
 	"log"
 )
 
@@ -11,7 +12,9 @@ type Fields struct {
 	Ip   string `json:"ip" form:"ip" validate:"required,min=4,max=15" minLength:"4" maxLength:"15"`
 }
 
-type Host struct{}
+type Host struct {
+	events *events.Publisher
+}
 
 var host *Host
 
@@ -24,11 +27,21 @@ func Init() *Host {
 	return host
 }
 
+// SetEvents publishes the host.* events, a nil publisher turns them off
+func (inst *Host) SetEvents(publisher *events.Publisher) {
+	inst.events = publisher
+}
+
 func (inst *Host) Create(body *Fields) (*model.Host, error) {
-	return model.CreateHost(&model.Host{
+	host, err := model.CreateHost(&model.Host{
 		Name: body.Name,
 		IP:   body.Ip,
 	})
+	if err != nil {
+		return nil, err
+	}
+	inst.events.Publish(events.HostCreated, host)
+	return host, nil
 }
 
 func (inst *Host) GetHosts() ([]*model.Host, error) {
@@ -60,6 +73,7 @@ func (inst *Host) Update(uuid string, body *Fields) (*model.Host, error) {
 		log.Printf("Error updating host with UUID %s: %v", uuid, err)
 		return nil, err
 	}
+	inst.events.Publish(events.HostUpdated, host)
 	return host, nil
 }
 
@@ -69,5 +83,6 @@ func (inst *Host) Delete(uuid string) (*model.Message, error) {
 		log.Printf("Error deleting host with UUID %s: %v", uuid, err)
 		return nil, err
 	}
+	inst.events.Publish(events.HostDeleted, map[string]string{"uuid": uuid})
 	return &model.Message{Message: "deleted ok"}, nil
 }
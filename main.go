@@ -3,15 +3,21 @@ package main
 import (
 	"context"
 	"fmt"
+	sysController "github.com/NubeDev/flexy/app/controllers/v1/sys"
 	"github.com/NubeDev/flexy/app/middleware"
 	models "github.com/NubeDev/flexy/app/models"
 	"github.com/NubeDev/flexy/app/services/natsapis"
 	"github.com/NubeDev/flexy/app/services/natsrouter"
 	"github.com/NubeDev/flexy/app/services/rqlservice"
+	hostService "github.com/NubeDev/flexy/app/services/v1/host"
 	"github.com/NubeDev/flexy/app/startup"
 	"github.com/NubeDev/flexy/common"
 	"github.com/NubeDev/flexy/routers"
+	"github.com/NubeDev/flexy/utils/apispec"
 	"github.com/NubeDev/flexy/utils/casbin"
+	"github.com/NubeDev/flexy/utils/events"
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/NubeDev/flexy/utils/natsauth"
 	"github.com/NubeDev/flexy/utils/setting"
 	"github.com/NubeDev/flexy/utils/subjects"
 	"github.com/gin-gonic/gin"
@@ -26,10 +32,13 @@ import (
 )
 
 var globalUUID string
+var subjectPrefix string
 var appID = "ros"
 var port int
 var natsModulePort int
 var useAuth bool
+var useNatsAuth bool
+var natsSeedFile string
 
 func main() {
 
@@ -78,6 +87,15 @@ func main() {
 	}
 	defer natsCloud.Close()
 	natsRouterCloud := natsrouter.New(natsCloud)
+	// bios relays the events from the local broker into the device event stream
+	hostService.Get().SetEvents(events.NewCorePublisher(natsCloud, globalUUID, appID))
+	if useNatsAuth {
+		auth, err := setupNatsAuth()
+		if err != nil {
+			log.Fatal().Msgf("error setting up NATS auth: %v", err)
+		}
+		natsRouterCloud.UseAuth(auth)
+	}
 
 	go bootNatsCloud(globalUUID, natsRouterCloud)
 
@@ -121,9 +139,12 @@ func cli() {
 	}
 
 	rootCmd.Flags().StringVar(&globalUUID, "uuid", "", "UUID for the edge device")
+	rootCmd.Flags().StringVar(&subjectPrefix, "subject-prefix", "", "site/customer prefix of the device, eg; acme.site-1")
 	rootCmd.Flags().IntVar(&port, "port", 0, "HTTP server port")
 	rootCmd.Flags().IntVar(&natsModulePort, "natsModulePort", 4223, "nats module server port")
 	rootCmd.Flags().BoolVar(&useAuth, "auth", true, "use auth")
+	rootCmd.Flags().StringVar(&natsSeedFile, "nats-seed", "", "nkey seed file of the ros user on the local broker, eg; ./nats-conf/local-ros.nk")
+	rootCmd.Flags().BoolVar(&useNatsAuth, "nats-auth", false, "check the NATS requests against the casbin subject policies, add the policies first")
 
 	// Execute the root command
 	if err := rootCmd.Execute(); err != nil {
@@ -134,17 +155,52 @@ func cli() {
 
 func bootNatsCloud(uuid string, natsRouter *natsrouter.NatsRouter) {
 	log.Info().Msgf("starting edge device with UUID: %s", uuid)
-	subject := subjects.NewSubjectBuilder(globalUUID, appID, subjects.IsApp)
-	natsRouter.Handle(fmt.Sprintf("%s.", setting.NatsSettings.TopicPrefix)+uuid+".flex.rql", natsapis.RQLHandler())
+	subject, err := subjects.NewSubjectBuilder(globalUUID, appID, subjects.IsApp)
+	if err == nil && subjectPrefix != "" {
+		subject, err = subject.WithPrefix(subjectPrefix)
+	}
+	if err != nil {
+		log.Fatal().Msgf("error building NATS subjects: %v", err)
+	}
+	rqlSubject := fmt.Sprintf("%s.", setting.NatsSettings.TopicPrefix) + uuid + ".flex.rql"
+	natsRouter.Handle(rqlSubject, natsapis.RQLHandler())
 	natsRouter.Handle(subject.BuildSubject("get", "system", "ping"), natsrouter.PingHandler(uuid))
+	// the broadcast pings for every level of the prefix, eg; global.get.system.ping and acme.global.get.system.ping
+	for _, groupSubject := range subject.GroupSubjects("get", "system", "ping") {
+		natsRouter.Handle(groupSubject, natsrouter.PingHandler(uuid))
+	}
+	sysController.SubjectDocs.Add(
+		apispec.Subject{Subject: rqlSubject, Summary: "Run an RQL script", Request: natsapis.RequestBody{}, Reply: natsapis.Response{}},
+		apispec.Subject{Subject: subject.BuildSubject("get", "system", "ping"), Summary: "Replies pong", Reply: &apispec.Schema{Type: "string", Example: "pong"}},
+		apispec.Subject{Subject: events.Subject(uuid, "host.*"), Summary: "host.created, host.updated and host.deleted events", Request: events.Event{}, Event: true},
+	)
 	select {}
 }
 
+func setupNatsAuth() (*natsauth.Authorizer, error) {
+	var apiKeys []natsauth.APIKey
+	for _, key := range setting.NatsSettings.ApiKeys {
+		apiKeys = append(apiKeys, natsauth.APIKey{Key: key.Key, Role: key.Role})
+	}
+	enforcer, err := casbin.SetupNatsCasbin()
+	if err != nil {
+		return nil, err
+	}
+	return natsauth.New(natsauth.Opts{
+		Enforcer: enforcer,
+		APIKeys:  apiKeys,
+	})
+}
+
 func setupNATS(url string) (*nats.Conn, error) {
 	if url == "" {
 		url = nats.DefaultURL
 	}
-	nc, err := nats.Connect(url)
+	options, err := natlib.SeedFileOptions(natsSeedFile)
+	if err != nil {
+		return nil, err
+	}
+	nc, err := nats.Connect(url, options...)
 	if err != nil {
 		log.Fatal().Msgf("error connecting to NATS: %v", err)
 		return nil, err
@@ -54,7 +54,12 @@ func (inst *App) RunApp() {
 	if debug {
 		fmt.Println("Debug mode is enabled for App A")
 	}
-	inst.subjects = subjects.NewSubjectBuilder(inst.app.AppID, inst.app.AppID, subjects.IsApp)
+	sb, err := subjects.NewSubjectBuilder(inst.app.AppID, inst.app.AppID, subjects.IsApp)
+	if err != nil {
+		fmt.Println("Error invalid app id:", err)
+		return
+	}
+	inst.subjects = sb
 
 	fmt.Printf("App '%s' is running\n", inst.app.AppID)
 
@@ -3,6 +3,7 @@ package main
 import (
 	"archive/zip"
 	"fmt"
+	"github.com/NubeDev/flexy/utils/apppkg"
 	"github.com/common-nighthawk/go-figure"
 	"github.com/spf13/cobra"
 	"io"
@@ -16,7 +17,7 @@ import (
 go run main.go generate --id=app-abc --version=v1.0.3 --desc="A demo app"
 
 
-go build main.go && sudo ./main build --id=app-abc --version=v1.0.3 --arch=amd64 --go-path=/home/user/sdk/go1.23.1/bin/go
+go build main.go && sudo ./main build --id=app-abc --version=v1.0.3 --arch=arm64 --go-path=/home/user/sdk/go1.23.1/bin/go
 
 */
 
@@ -55,7 +56,7 @@ func main() {
 	// Flags for build command
 	buildCmd.Flags().StringVar(&appID, "id", "", "App ID (required)")
 	buildCmd.Flags().StringVar(&appVersion, "version", "v1.0.0", "App version")
-	buildCmd.Flags().StringVar(&appArch, "arch", "amd64", "App architecture (e.g., amd64, arm64)")
+	buildCmd.Flags().StringVar(&appArch, "arch", "", "App architecture: amd64, arm64 or armv7, defaults to this machine's")
 	buildCmd.Flags().StringVar(&goPath, "go-path", "", "Path to go executable (optional)")
 
 	buildCmd.MarkFlagRequired("id")
@@ -147,7 +148,12 @@ func (inst *App) RunApp() {
 	if debug {
 		fmt.Println("Debug mode is enabled for App A")
 	}
-	inst.subjects = subjects.NewSubjectBuilder(inst.app.AppID, inst.app.AppID, subjects.IsApp)
+	sb, err := subjects.NewSubjectBuilder(inst.app.AppID, inst.app.AppID, subjects.IsApp)
+	if err != nil {
+		fmt.Println("Error invalid app id:", err)
+		return
+	}
+	inst.subjects = sb
 
 	fmt.Printf("App '%s' is running\n", inst.app.AppID)
 
@@ -287,10 +293,18 @@ func (inst *App) WaitForShutdown() {
 
 }
 
-// buildApp builds and zips the app, then moves it to /ros/apps/library
-// buildApp builds and zips the app, then moves it to /ros/apps/library
+// buildApp builds and zips the app for an arch, then moves it to /ros/apps/library
 func buildApp(id, version, arch, goPath string) {
 	appDir := filepath.Join(".", id)
+	if arch == "" {
+		arch = apppkg.HostArch()
+	}
+	goEnv, err := apppkg.GoEnv(arch)
+	if err != nil {
+		fmt.Println("Error invalid arch:", err)
+		return
+	}
+	arch = apppkg.NormalizeArch(arch)
 	// Build file name format: [id]-[arch]
 	buildOutputFile := fmt.Sprintf("%s-%s", id, arch)
 
@@ -302,9 +316,10 @@ func buildApp(id, version, arch, goPath string) {
 	// Change the working directory to appDir to build all Go files in that directory
 	buildCmd := exec.Command(goPath, "build", "-o", buildOutputFile)
 	buildCmd.Dir = appDir // Set the working directory to the appDir
+	buildCmd.Env = append(os.Environ(), goEnv...)
 	buildCmd.Stdout = os.Stdout
 	buildCmd.Stderr = os.Stderr
-	err := buildCmd.Run()
+	err = buildCmd.Run()
 	if err != nil {
 		fmt.Println("Error building the app:", err)
 		return
@@ -315,8 +330,8 @@ func buildApp(id, version, arch, goPath string) {
 	zipPath := filepath.Join("/ros/apps/library", zipOutputFile)
 	//zipPath := filepath.Join("/home/user", zipOutputFile) // Optional alternative path for testing
 
-	// Zip the built file and config.yaml
-	err = zipFiles(zipPath, []string{filepath.Join(appDir, buildOutputFile), filepath.Join(appDir, "config.yaml")})
+	// Zip the built file as [id], the name bios runs it by, and config.yaml
+	err = zipFiles(zipPath, map[string]string{id: filepath.Join(appDir, buildOutputFile), "config.yaml": filepath.Join(appDir, "config.yaml")})
 	if err != nil {
 		fmt.Println("Error zipping the app:", err)
 		return
@@ -325,8 +340,8 @@ func buildApp(id, version, arch, goPath string) {
 	fmt.Println("App built and moved to:", zipPath)
 }
 
-// zipFiles creates a zip archive from a list of files
-func zipFiles(filename string, files []string) error {
+// zipFiles creates a zip archive from files, keyed by their name in the zip
+func zipFiles(filename string, files map[string]string) error {
 	newZipFile, err := os.Create(filename)
 	if err != nil {
 		return err
@@ -336,8 +351,8 @@ func zipFiles(filename string, files []string) error {
 	zipWriter := zip.NewWriter(newZipFile)
 	defer zipWriter.Close()
 
-	for _, file := range files {
-		err = addFileToZip(zipWriter, file)
+	for name, file := range files {
+		err = addFileToZip(zipWriter, name, file)
 		if err != nil {
 			return err
 		}
@@ -345,8 +360,8 @@ func zipFiles(filename string, files []string) error {
 	return nil
 }
 
-// addFileToZip adds a file to the zip archive
-func addFileToZip(zipWriter *zip.Writer, filename string) error {
+// addFileToZip adds a file to the zip archive as name
+func addFileToZip(zipWriter *zip.Writer, name, filename string) error {
 	fileToZip, err := os.Open(filename)
 	if err != nil {
 		return err
@@ -363,7 +378,7 @@ func addFileToZip(zipWriter *zip.Writer, filename string) error {
 		return err
 	}
 
-	header.Name = filepath.Base(filename)
+	header.Name = name
 	header.Method = zip.Deflate
 
 	writer, err := zipWriter.CreateHeader(header)
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/NubeDev/flexy/modules/bios/appmanager"
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/events"
+	"github.com/NubeDev/flexy/utils/natsauth"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+/*
+The config files of an installed app, a yaml or json file is checked against the schema the app ships before it's
+written and the old file is backed up.
+
+./nats req abc.get.apps.manager.configs '{"name": "flexy-app", "version": "v1.0.3"}'
+./nats req abc.get.apps.manager.config '{"name": "flexy-app", "version": "v1.0.3", "file": "config.yaml"}'
+./nats req abc.post.apps.manager.config '{"name": "flexy-app", "version": "v1.0.3", "file": "config.yaml", "data": "port: 1661\n", "restart": true}'
+./nats req abc.get.apps.manager.config-history '{"name": "flexy-app", "version": "v1.0.3"}'
+*/
+
+// AppConfigRequest selects an installed app by name or appID, the file is relative to its install directory
+type AppConfigRequest struct {
+	Name    string `json:"name"`
+	AppID   string `json:"appID"`
+	Version string `json:"version"`
+	File    string `json:"file,omitempty"`
+	Data    string `json:"data,omitempty"`    // the new contents of the file
+	Restart bool   `json:"restart,omitempty"` // restart the app once the file is written
+}
+
+// AppConfig is the reply to a config read
+type AppConfig struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	File    string `json:"file"`
+	Data    string `json:"data"`
+}
+
+// AppConfigResult is the reply to a config write
+type AppConfigResult struct {
+	*appmanager.ConfigChange
+	Restarted bool `json:"restarted"`
+}
+
+// decodeAppConfig decodes the request and finds the app name from the appID, it replies with the error
+func (s *Service) decodeAppConfig(m *nats.Msg, fileRequired bool) (*AppConfigRequest, bool) {
+	req := &AppConfigRequest{}
+	if err := json.Unmarshal(m.Data, req); err != nil {
+		s.handleError(m.Reply, code.InvalidParams, fmt.Sprintf("Invalid JSON format: %v", err))
+		return nil, false
+	}
+	if req.Version == "" {
+		s.handleError(m.Reply, code.InvalidParams, "app version is required")
+		return nil, false
+	}
+	if req.Name == "" && req.AppID != "" {
+		app, err := s.appManager.GetAppByID(req.AppID, req.Version)
+		if err != nil {
+			s.handleError(m.Reply, code.NotFound, err.Error())
+			return nil, false
+		}
+		req.Name = app.Name
+	}
+	if req.Name == "" {
+		s.handleError(m.Reply, code.InvalidParams, "app name or appID is required")
+		return nil, false
+	}
+	if fileRequired && req.File == "" {
+		s.handleError(m.Reply, code.InvalidParams, "file is required, eg; config.yaml")
+		return nil, false
+	}
+	return req, true
+}
+
+func (s *Service) handleListAppConfigs(m *nats.Msg) {
+	req, ok := s.decodeAppConfig(m, false)
+	if !ok {
+		return
+	}
+	configs, err := s.appManager.ListAppConfigs(req.Name, req.Version)
+	if err != nil {
+		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error listing the config files: %v", err))
+		return
+	}
+	s.publishResponse(m, configs, code.SUCCESS)
+}
+
+func (s *Service) handleReadAppConfig(m *nats.Msg) {
+	req, ok := s.decodeAppConfig(m, true)
+	if !ok {
+		return
+	}
+	data, err := s.appManager.ReadAppConfig(req.Name, req.Version, req.File)
+	if err != nil {
+		s.handleError(m.Reply, fileErrorCode(err), fmt.Sprintf("Error reading %s: %v", req.File, err))
+		return
+	}
+	s.publishResponse(m, AppConfig{Name: req.Name, Version: req.Version, File: req.File, Data: string(data)}, code.SUCCESS)
+}
+
+func (s *Service) handleWriteAppConfig(m *nats.Msg) {
+	req, ok := s.decodeAppConfig(m, true)
+	if !ok {
+		return
+	}
+	// the user header is only set by nats auth, a caller could send any name without it
+	var user string
+	if s.natsAuth != nil && m.Header != nil {
+		user = m.Header.Get(natsauth.HeaderUser)
+	}
+	change, err := s.appManager.WriteAppConfig(req.Name, req.Version, req.File, []byte(req.Data), user)
+	if err != nil {
+		s.handleError(m.Reply, code.InvalidParams, fmt.Sprintf("Error writing %s: %v", req.File, err))
+		return
+	}
+	result := AppConfigResult{ConfigChange: change}
+	if change.Diff != "" {
+		log.Info().Msgf("app %s %s config %s changed by %q", req.Name, req.Version, change.File, user)
+		s.events.Publish(events.AppConfigChanged, change)
+	}
+	if req.Restart {
+		if err := s.appManager.RestartApp(req.Name); err != nil {
+			s.handleError(m.Reply, code.ERROR, fmt.Sprintf("%s was written but the app failed to restart: %v", req.File, err))
+			return
+		}
+		result.Restarted = true
+	}
+	s.publishResponse(m, result, code.SUCCESS)
+}
+
+func (s *Service) handleAppConfigHistory(m *nats.Msg) {
+	req, ok := s.decodeAppConfig(m, false)
+	if !ok {
+		return
+	}
+	history, err := s.appManager.AppConfigHistory(req.Name, req.Version)
+	if err != nil {
+		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error getting the config history: %v", err))
+		return
+	}
+	s.publishResponse(m, history, code.SUCCESS)
+}
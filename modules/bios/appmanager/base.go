@@ -2,8 +2,11 @@ package appmanager
 
 import (
 	"archive/zip"
+	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"github.com/NubeDev/flexy/utils/apppkg"
 	"github.com/NubeDev/flexy/utils/systemctl"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
@@ -11,13 +14,18 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"time"
 )
 
 type ManagerInterface interface {
+	Arch() string
 	ListLibraryApps() ([]*App, error)
+	ListAllLibraryApps() ([]*App, error)
 	GetLibraryAppByID(appID, version string) (*App, error)
+	AddLibraryApp(fileName string, r io.Reader, sum []byte) (*App, error)
+	LibraryAppSum(fileName string) ([]byte, error)
+	RemoveLibraryApp(fileName string) error
 	ListInstalledApps() ([]*App, error)
 	GetAppByName(name, version string) (*App, error)
 	GetAppByID(appID, version string) (*App, error)
@@ -30,15 +38,23 @@ type ManagerInterface interface {
 	DeleteLibraryApp(appName string) error
 	RestoreBackup(name, version string) error
 	ListBackups() ([]*App, error)
+	ListAppConfigs(name, version string) ([]*ConfigFile, error)
+	ReadAppConfig(name, version, file string) ([]byte, error)
+	WriteAppConfig(name, version, file string, data []byte, user string) (*ConfigChange, error)
+	AppConfigHistory(name, version string) ([]*ConfigChange, error)
+	RestartApp(appName string) error
+	Upgrade(name, fromVersion, toVersion string) error
 }
 
 type AppManager struct {
-	LibraryPath      string // Path to the library directory (e.g., data/library)
-	InstallPath      string // Path to the install directory (e.g., data/install)
-	BackupPath       string // Path to the backup directory (e.g., data/backup)
-	TmpPath          string // Path to the backup directory (e.g., data/tmp)
-	SystemPath       string // Path to the backup directory (e.g., /lib/systemd/system/)
-	systemctlService systemctl.Commands
+	LibraryPath       string // Path to the library directory (e.g., data/library)
+	InstallPath       string // Path to the install directory (e.g., data/install)
+	BackupPath        string // Path to the backup directory (e.g., data/backup)
+	TmpPath           string // Path to the backup directory (e.g., data/tmp)
+	SystemPath        string // Path to the backup directory (e.g., /lib/systemd/system/)
+	systemctlService  systemctl.Commands
+	upgradeCheckDelay time.Duration
+	arch              string // the library only lists the apps for this arch, empty lists every arch
 }
 
 // App struct to hold application details
@@ -48,11 +64,13 @@ type App struct {
 	AppID       string `json:"appID"`
 	Description string `json:"description"`
 	Version     string `json:"version"`
+	Arch        string `json:"arch,omitempty"` // empty runs on any arch
 }
 
 type Config struct {
 	ID          string          `yaml:"id"`
 	Description string          `yaml:"description"`
+	Arch        string          `yaml:"arch"` // the arch the app was built for, overrides the arch in the zip name
 	URL         string          `yaml:"url"`
 	ServiceFile ServiceFileYAML `yaml:"service_file"`
 }
@@ -61,8 +79,8 @@ type ServiceFileYAML struct {
 	Env string `yaml:"env"`
 }
 
-// NewAppManager creates a new AppManager instance
-func NewAppManager(rootPath, systemPath string) (ManagerInterface, error) {
+// NewAppManager creates a new AppManager instance, the arch defaults to the arch bios is running on
+func NewAppManager(rootPath, systemPath, arch string) (ManagerInterface, error) {
 	var libraryPath = "library"
 	var installPath = "installed"
 	var backupPath = "backups"
@@ -73,13 +91,20 @@ func NewAppManager(rootPath, systemPath string) (ManagerInterface, error) {
 	if systemPath == "" {
 		systemPath = "/etc/systemd/system"
 	}
+	if arch == "" {
+		arch = apppkg.HostArch()
+	} else if arch = apppkg.NormalizeArch(arch); arch == "" {
+		return nil, fmt.Errorf("unsupported arch, try: %s", strings.Join(apppkg.Archs, ", "))
+	}
 	am := &AppManager{
-		LibraryPath:      fmt.Sprintf("%s/%s", rootPath, libraryPath),
-		InstallPath:      fmt.Sprintf("%s/%s", rootPath, installPath),
-		BackupPath:       fmt.Sprintf("%s/%s", rootPath, backupPath),
-		TmpPath:          tmpPath,
-		SystemPath:       systemPath,
-		systemctlService: systemctl.New(),
+		LibraryPath:       fmt.Sprintf("%s/%s", rootPath, libraryPath),
+		InstallPath:       fmt.Sprintf("%s/%s", rootPath, installPath),
+		BackupPath:        fmt.Sprintf("%s/%s", rootPath, backupPath),
+		TmpPath:           tmpPath,
+		SystemPath:        systemPath,
+		systemctlService:  systemctl.New(),
+		upgradeCheckDelay: DefaultUpgradeCheckDelay,
+		arch:              arch,
 	}
 	err := am.ensureDirectories()
 	return am, err
@@ -105,10 +130,64 @@ func (inst *AppManager) ensureDirectories() error {
 	return nil
 }
 
+// Arch is the arch of the apps that are listed and installed
+func (inst *AppManager) Arch() string {
+	return inst.arch
+}
+
+// ListLibraryApps lists the apps in the library that run on this arch
 func (inst *AppManager) ListLibraryApps() ([]*App, error) {
+	apps, err := getAppsFromDir(inst.LibraryPath)
+	if err != nil {
+		return nil, err
+	}
+	compatible := apps[:0]
+	for _, app := range apps {
+		if inst.compatible(app) {
+			compatible = append(compatible, app)
+		}
+	}
+	return compatible, nil
+}
+
+// ListAllLibraryApps lists the apps in the library for every arch
+func (inst *AppManager) ListAllLibraryApps() ([]*App, error) {
 	return getAppsFromDir(inst.LibraryPath)
 }
 
+func (inst *AppManager) compatible(app *App) bool {
+	return inst.arch == "" || apppkg.Compatible(inst.arch, app.Arch)
+}
+
+// libraryApp finds an app version in the library for this arch, a zip built for the arch is picked over one without an arch
+func (inst *AppManager) libraryApp(name, version string) (*App, error) {
+	apps, err := inst.ListAllLibraryApps()
+	if err != nil {
+		return nil, err
+	}
+	var found *App
+	var otherArchs []string
+	for _, app := range apps {
+		if app.Name != name || app.Version != version {
+			continue
+		}
+		if !inst.compatible(app) {
+			otherArchs = append(otherArchs, app.Arch)
+			continue
+		}
+		if found == nil || (found.Arch == "" && app.Arch != "") {
+			found = app
+		}
+	}
+	if found == nil {
+		if len(otherArchs) > 0 {
+			return nil, fmt.Errorf("app %s version %s is only in the library for %s, this device is %s", name, version, strings.Join(otherArchs, ", "), inst.arch)
+		}
+		return nil, fmt.Errorf("app %s version %s not found in the library", name, version)
+	}
+	return found, nil
+}
+
 func (inst *AppManager) GetLibraryAppByID(appID, version string) (*App, error) {
 	apps, err := inst.ListLibraryApps()
 	if err != nil {
@@ -137,6 +216,93 @@ func (inst *AppManager) GetLibraryAppByID(appID, version string) (*App, error) {
 	return app, nil
 }
 
+// AddLibraryApp streams an app zip into the library, when sum is set the sha256 of the zip must match it.
+// The zip is written to a temp file first, so a failed or corrupt transfer never replaces a library app.
+func (inst *AppManager) AddLibraryApp(fileName string, r io.Reader, sum []byte) (*App, error) {
+	if err := validLibraryFile(fileName); err != nil {
+		return nil, err
+	}
+	// the temp file isn't a .zip, so it's never listed as a library app
+	tmp, err := os.CreateTemp(inst.LibraryPath, ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %s to the library: %w", fileName, err)
+	}
+	if sum != nil && !bytes.Equal(hash.Sum(nil), sum) {
+		return nil, fmt.Errorf("sha256 mismatch for %s, got %x expected %x", fileName, hash.Sum(nil), sum)
+	}
+	dest := filepath.Join(inst.LibraryPath, fileName)
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return nil, err
+	}
+	// every arch, a zip for another arch is kept for the devices it runs on
+	apps, err := inst.ListAllLibraryApps()
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range apps {
+		if app.Path == dest {
+			return app, nil
+		}
+	}
+	os.Remove(dest)
+	return nil, fmt.Errorf("%s isn't a versioned app zip, eg; my-app-v1.0.0.zip", fileName)
+}
+
+// LibraryAppSum is the sha256 of a zip in the library
+func (inst *AppManager) LibraryAppSum(fileName string) ([]byte, error) {
+	if err := validLibraryFile(fileName); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(inst.LibraryPath, fileName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// RemoveLibraryApp removes one zip from the library, DeleteLibraryApp removes every version of an app.
+// The error is os.ErrNotExist when the zip isn't in the library.
+func (inst *AppManager) RemoveLibraryApp(fileName string) error {
+	if err := validLibraryFile(fileName); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(inst.LibraryPath, fileName)); err != nil {
+		return err
+	}
+	log.Info().Msgf("Library file %s successfully deleted. ", fileName)
+	return nil
+}
+
+// IsAppFileName reports whether a file name is a versioned app zip that can go in the library, eg; my-app-v1.0.0-amd64.zip
+func IsAppFileName(fileName string) bool {
+	if validLibraryFile(fileName) != nil {
+		return false
+	}
+	_, err := apppkg.ParseFileName(fileName)
+	return err == nil
+}
+
+func validLibraryFile(fileName string) error {
+	if fileName != filepath.Base(fileName) || filepath.Ext(fileName) != ".zip" {
+		return fmt.Errorf("invalid app file name %q, expected a zip eg; my-app-v1.0.0.zip", fileName)
+	}
+	return nil
+}
+
 func getAppsFromDir(dir string) ([]*App, error) {
 	// Read the directory contents
 	files, err := ioutil.ReadDir(dir)
@@ -144,25 +310,19 @@ func getAppsFromDir(dir string) ([]*App, error) {
 		return nil, err
 	}
 
-	// Regex pattern to capture app name and version
-	versionedFilenameRegex := regexp.MustCompile(`^(.+?)[-_]?v(\d+(\.\d+)*)(.*)$`)
-
 	var apps []*App
 	for _, file := range files {
 		if filepath.Ext(file.Name()) == ".zip" {
 			fullPath := filepath.Join(dir, file.Name())
-			filename := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
-			matches := versionedFilenameRegex.FindStringSubmatch(filename)
-			if matches != nil {
-				appName := matches[1] + matches[4]    // Combine name and any suffix
-				version := "v" + matches[2]           // Add back 'v' to version
-				appName = strings.Trim(appName, "-_") // Clean up any leading/trailing hyphens or underscores
-
+			// the name, version and arch, eg; app-abc-v1.0.3-amd64.zip is app-abc v1.0.3 amd64
+			pkg, err := apppkg.ParseFileName(file.Name())
+			if err == nil {
 				// Default app details
 				app := &App{
 					Path:    fullPath,
-					Name:    appName,
-					Version: version,
+					Name:    pkg.Name,
+					Version: pkg.Version,
+					Arch:    pkg.Arch,
 				}
 
 				// Try to extract and parse config.yaml from the zip file
@@ -174,6 +334,9 @@ func getAppsFromDir(dir string) ([]*App, error) {
 						// Update the app struct with config details
 						app.AppID = config.ID
 						app.Description = config.Description
+						if arch := apppkg.NormalizeArch(config.Arch); arch != "" {
+							app.Arch = arch
+						}
 					}
 				}
 
@@ -382,19 +545,11 @@ func (inst *AppManager) Install(app *App) error {
 	}
 	var appName = app.Name
 	var version = app.Version
-	apps, err := inst.ListLibraryApps()
+	libraryApp, err := inst.libraryApp(appName, version)
 	if err != nil {
 		return err
 	}
-	for _, appList := range apps {
-		if appList.Name == appName {
-			if appList.Version == version {
-				app.Path = appList.Path
-				break
-			}
-		}
-
-	}
+	app.Path = libraryApp.Path
 	zipFilePath := app.Path
 	installPath := filepath.Join(inst.InstallPath, appName, version)
 
@@ -674,6 +829,10 @@ func (inst *AppManager) unzipApp(zipFilePath, destPath, appName string) error {
 			relPath = parts[0]
 		}
 
+		// a binary built as <name>-<arch>, as the app cli used to zip it, is installed as <name> for the service
+		if suffix, ok := strings.CutPrefix(relPath, appName+"-"); ok && apppkg.NormalizeArch(suffix) != "" {
+			relPath = appName
+		}
 		filePath := filepath.Join(destPath, relPath)
 
 		// Ensure the directory for the file exists
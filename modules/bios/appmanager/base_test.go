@@ -1,13 +1,21 @@
 package appmanager
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"github.com/NubeDev/flexy/utils/apppkg"
 	"github.com/NubeDev/flexy/utils/helpers/pprint"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestNewAppManager(t *testing.T) {
-	manager, err := NewAppManager("", "")
+	manager, err := NewAppManager("", "", "")
 	if err != nil {
 		return
 	}
@@ -19,3 +27,154 @@ func TestNewAppManager(t *testing.T) {
 	}
 	pprint.PrintJSON(apps)
 }
+
+func TestAddLibraryApp(t *testing.T) {
+	manager := &AppManager{LibraryPath: t.TempDir()}
+	data := []byte("not really a zip")
+	sum := sha256.Sum256(data)
+
+	app, err := manager.AddLibraryApp("flexy-app-v1.0.3.zip", bytes.NewReader(data), sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Name != "flexy-app" || app.Version != "v1.0.3" {
+		t.Errorf("app = %+v", app)
+	}
+
+	_, err = manager.AddLibraryApp("flexy-app-v1.0.4.zip", bytes.NewReader(data), make([]byte, sha256.Size))
+	if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Errorf("expected a sha256 mismatch, got %v", err)
+	}
+	for _, name := range []string{"../flexy-app-v1.0.5.zip", "flexy-app-v1.0.5.tar.gz", "flexy-app.zip"} {
+		if _, err := manager.AddLibraryApp(name, bytes.NewReader(data), nil); err == nil {
+			t.Errorf("expected %s to be rejected", name)
+		}
+	}
+	apps, err := manager.ListLibraryApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 {
+		t.Errorf("library = %+v, want only flexy-app v1.0.3", apps)
+	}
+}
+
+func TestRemoveLibraryApp(t *testing.T) {
+	manager := &AppManager{LibraryPath: t.TempDir()}
+	data := []byte("not really a zip")
+	sum := sha256.Sum256(data)
+	if _, err := manager.AddLibraryApp("flexy-app-v1.0.3-amd64.zip", bytes.NewReader(data), nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := manager.LibraryAppSum("flexy-app-v1.0.3-amd64.zip")
+	if err != nil || !bytes.Equal(got, sum[:]) {
+		t.Errorf("sum = %x %v, want %x", got, err, sum)
+	}
+	if err := manager.RemoveLibraryApp("flexy-app-v1.0.3-amd64.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.RemoveLibraryApp("flexy-app-v1.0.3-amd64.zip"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+	if err := manager.RemoveLibraryApp("../flexy-app-v1.0.3.zip"); err == nil {
+		t.Error("expected a path to be rejected")
+	}
+}
+
+func TestIsAppFileName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"flexy-app-v1.0.3.zip":       true,
+		"flexy-app-v1.0.3-amd64.zip": true,
+		"flexy-app.zip":              false,
+		"flexy-app-v1.0.3.tar.gz":    false,
+		"apps/flexy-app-v1.0.3.zip":  false,
+		"flexy-app-v1.0.3.zip@2":     false,
+	} {
+		if got := IsAppFileName(name); got != want {
+			t.Errorf("IsAppFileName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLibraryArch(t *testing.T) {
+	manager := &AppManager{LibraryPath: t.TempDir(), arch: apppkg.AMD64}
+	files := map[string]string{
+		"app-abc-v1.0.3-amd64.zip": "",
+		"app-abc-v1.0.3-armv7.zip": "",
+		"app-abc-v1.0.4.zip":       "",
+		"app-abc-v1.0.5.zip":       "arch: aarch64\n", // the arch in the manifest
+		"app-abc-v1.0.6-arm64.zip": "",
+	}
+	for name, config := range files {
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		f, _ := w.Create("app-abc/config.yaml")
+		f.Write([]byte("id: app-abc\n" + config))
+		w.Close()
+		if _, err := manager.AddLibraryApp(name, &buf, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apps, err := manager.ListLibraryApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, app := range apps {
+		listed = append(listed, app.Name+" "+app.Version+" "+app.Arch)
+	}
+	if strings.Join(listed, ",") != "app-abc v1.0.3 amd64,app-abc v1.0.4 " {
+		t.Errorf("library = %v, want only the amd64 and any arch apps", listed)
+	}
+	all, _ := manager.ListAllLibraryApps()
+	if len(all) != len(files) {
+		t.Errorf("all = %d apps, want %d", len(all), len(files))
+	}
+	if _, err := manager.libraryApp("app-abc", "v1.0.6"); err == nil || !strings.Contains(err.Error(), "only in the library for arm64") {
+		t.Errorf("expected the arch to be incompatible, got %v", err)
+	}
+	if app, err := manager.libraryApp("app-abc", "v1.0.3"); err != nil || app.Arch != apppkg.AMD64 {
+		t.Errorf("app = %+v %v", app, err)
+	}
+}
+
+func TestInstallAppCliZip(t *testing.T) {
+	manager, systemd := newUpgradeTestManager(t)
+	// the app cli builds the binary as <id>-<arch>, older zips still have it by that name
+	for name, binary := range map[string]string{"app-abc-v1.0.0-amd64.zip": "app-abc-amd64", "app-abc-v1.0.1-amd64.zip": "app-abc"} {
+		f, err := os.Create(filepath.Join(manager.LibraryPath, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := zip.NewWriter(f)
+		bin, _ := w.Create(binary)
+		bin.Write([]byte("#!/bin/sh\n"))
+		config, _ := w.Create("config.yaml")
+		config.Write([]byte("id: app-abc\nversion: v1.0.0\n"))
+		w.Close()
+		f.Close()
+	}
+	for _, version := range []string{"v1.0.0", "v1.0.1"} {
+		if err := manager.Install(&App{Name: "app-abc", Version: version}); err != nil {
+			t.Fatal(err)
+		}
+		execPath := filepath.Join(manager.InstallPath, "app-abc", version, "app-abc")
+		info, err := os.Stat(execPath)
+		if err != nil {
+			t.Fatalf("%s: the binary should be installed as app-abc: %v", version, err)
+		}
+		if info.Mode()&0111 == 0 {
+			t.Errorf("%s: the binary isn't executable: %v", version, info.Mode())
+		}
+		service, err := os.ReadFile(filepath.Join(manager.SystemPath, "app-abc.service"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(service), "ExecStart="+execPath) {
+			t.Errorf("%s: service file doesn't start %s:\n%s", version, execPath, service)
+		}
+	}
+	if systemd.commands[len(systemd.commands)-1] != "start app-abc.service" {
+		t.Errorf("commands = %v", systemd.commands)
+	}
+}
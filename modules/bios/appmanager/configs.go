@@ -0,0 +1,296 @@
+package appmanager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NubeDev/flexy/utils/jsonschema"
+	"github.com/NubeDev/flexy/utils/sandbox"
+	"github.com/NubeDev/flexy/utils/textdiff"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+The config files of an installed app are the files under installed/<name>/<version> with a config extension.
+A yaml or json file is validated before it's written, against <file name>.schema.json when the app ships one,
+eg; config.yaml is checked with config.schema.json. The file it replaces is kept in backups/configs/<name>/<version>
+and every change is added to the history.jsonl there, with who made it and the diff.
+*/
+
+const (
+	configBackupDir  = "configs"
+	configHistory    = "history.jsonl"
+	configSchemaExt  = ".schema.json"
+	configTimeFormat = "20060102T150405.000"
+	// configBackups is how many backups of a config file are kept
+	configBackups = 10
+)
+
+var configExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true, ".toml": true, ".env": true, ".conf": true, ".ini": true}
+
+// ConfigFile is a config file of an installed app, the name is relative to the install directory
+type ConfigFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Schema  bool      `json:"schema"` // the app ships a JSON schema for the file
+}
+
+// ConfigChange is a write to a config file, Backup is empty when the file is new
+type ConfigChange struct {
+	App     string    `json:"app"`
+	Version string    `json:"version"`
+	File    string    `json:"file"`
+	User    string    `json:"user,omitempty"`
+	Time    time.Time `json:"time"`
+	Backup  string    `json:"backup,omitempty"`
+	Diff    string    `json:"diff"`
+}
+
+// IsConfigFileName is true for the file extensions that are treated as config files, the schemas aren't
+func IsConfigFileName(fileName string) bool {
+	return configExtensions[strings.ToLower(filepath.Ext(fileName))] && !strings.HasSuffix(fileName, configSchemaExt)
+}
+
+// appConfigFS confines the config files to the install directory of the app
+func (inst *AppManager) appConfigFS(name, version string) (*sandbox.FS, error) {
+	if name == "" || version == "" {
+		return nil, errors.New("app name and version are required")
+	}
+	installPath := filepath.Join(inst.InstallPath, name, version)
+	if filepath.Dir(filepath.Dir(installPath)) != filepath.Clean(inst.InstallPath) {
+		return nil, fmt.Errorf("invalid app name %s or version %s", name, version)
+	}
+	if _, err := os.Stat(installPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("app %s version %s is not installed", name, version)
+	}
+	return sandbox.New(map[string]string{name: installPath})
+}
+
+// ListAppConfigs lists the config files of an installed app
+func (inst *AppManager) ListAppConfigs(name, version string) ([]*ConfigFile, error) {
+	fs, err := inst.appConfigFS(name, version)
+	if err != nil {
+		return nil, err
+	}
+	root, _ := fs.Resolve(name, "/")
+	var files []*ConfigFile
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !IsConfigFileName(info.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		_, statErr := os.Stat(configSchemaPath(path))
+		files = append(files, &ConfigFile{Name: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime(), Schema: statErr == nil})
+		return nil
+	})
+	return files, err
+}
+
+// ReadAppConfig reads a config file of an installed app
+func (inst *AppManager) ReadAppConfig(name, version, file string) ([]byte, error) {
+	path, err := inst.appConfigPath(name, version, file)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// WriteAppConfig validates and writes a config file, the old file is backed up and the change added to the history.
+// Writing the same contents doesn't change anything and the diff is empty.
+func (inst *AppManager) WriteAppConfig(name, version, file string, data []byte, user string) (*ConfigChange, error) {
+	path, err := inst.appConfigPath(name, version, file)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateConfig(path, data); err != nil {
+		return nil, err
+	}
+	old, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	exists := err == nil
+	change := &ConfigChange{App: name, Version: version, File: filepath.ToSlash(filepath.Clean(file)), User: user, Time: time.Now().UTC()}
+	if exists && bytes.Equal(old, data) {
+		return change, nil
+	}
+	change.Diff = textdiff.Unified(change.File, change.File, string(old), string(data))
+
+	backupDir := filepath.Join(inst.BackupPath, configBackupDir, name, version)
+	if exists {
+		change.Backup = fmt.Sprintf("%s.%s", change.File, change.Time.Format(configTimeFormat))
+		backupPath := filepath.Join(backupDir, filepath.FromSlash(change.Backup))
+		if err := os.MkdirAll(filepath.Dir(backupPath), os.ModePerm); err != nil {
+			return nil, err
+		}
+		if err := copyFile(path, backupPath); err != nil {
+			return nil, fmt.Errorf("failed to backup %s: %w", file, err)
+		}
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := pruneConfigBackups(filepath.Join(backupDir, filepath.FromSlash(change.File))); err != nil {
+			return nil, err
+		}
+	}
+	return change, appendConfigHistory(backupDir, change)
+}
+
+// AppConfigHistory lists the config changes of an installed app, newest first
+func (inst *AppManager) AppConfigHistory(name, version string) ([]*ConfigChange, error) {
+	if _, err := inst.appConfigFS(name, version); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(inst.BackupPath, configBackupDir, name, version, configHistory))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*ConfigChange{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var changes []*ConfigChange
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		change := &ConfigChange{}
+		if err := json.Unmarshal(scanner.Bytes(), change); err != nil {
+			continue
+		}
+		changes = append([]*ConfigChange{change}, changes...)
+	}
+	return changes, scanner.Err()
+}
+
+// RestartApp restarts the systemd service of an app
+func (inst *AppManager) RestartApp(appName string) error {
+	if err := inst.systemctlService.SystemdCommand(fmt.Sprintf("%s.service", appName), "restart"); err != nil {
+		return fmt.Errorf("failed to restart service: %w", err)
+	}
+	return nil
+}
+
+func (inst *AppManager) appConfigPath(name, version, file string) (string, error) {
+	if !IsConfigFileName(file) {
+		return "", fmt.Errorf("%s is not a config file, the extension must be one of: .yaml, .yml, .json, .toml, .env, .conf or .ini", file)
+	}
+	fs, err := inst.appConfigFS(name, version)
+	if err != nil {
+		return "", err
+	}
+	return fs.Resolve(name, file)
+}
+
+// configSchemaPath is the schema of a config file, eg; config.yaml is checked with config.schema.json
+func configSchemaPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + configSchemaExt
+}
+
+// validateConfig parses a yaml or json config and checks it against its schema when there is one
+func validateConfig(path string, data []byte) error {
+	var doc any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("invalid YAML: %w", err)
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+	default:
+		return nil
+	}
+	schemaData, err := os.ReadFile(configSchemaPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	schema, err := jsonschema.Parse(schemaData)
+	if err != nil {
+		return err
+	}
+	normalized, err := jsonschema.Normalize(doc)
+	if err != nil {
+		return fmt.Errorf("the config can't be checked against its schema: %w", err)
+	}
+	return schema.Validate(normalized)
+}
+
+// writeFileAtomic writes a temp file next to the config and renames it, so the app never reads half a file
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// pruneConfigBackups deletes the oldest backups of a config file over configBackups
+func pruneConfigBackups(backupPrefix string) error {
+	backups, err := filepath.Glob(backupPrefix + ".*")
+	if err != nil {
+		return err
+	}
+	// the time format sorts oldest first
+	sort.Strings(backups)
+	for i := 0; i < len(backups)-configBackups; i++ {
+		if err := os.Remove(backups[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendConfigHistory(backupDir string, change *ConfigChange) error {
+	if err := os.MkdirAll(backupDir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(backupDir, configHistory), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package appmanager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newConfigTestManager(t *testing.T) (*AppManager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	manager := &AppManager{InstallPath: filepath.Join(dir, "installed"), BackupPath: filepath.Join(dir, "backups")}
+	installPath := filepath.Join(manager.InstallPath, "flexy-app", "v1.0.3")
+	if err := os.MkdirAll(installPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"config.yaml":        "name: flexy\nport: 1660\n",
+		"config.schema.json": `{"type": "object", "required": ["port"], "properties": {"port": {"type": "integer", "maximum": 65535}}}`,
+		"flexy-app":          "binary",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(installPath, name), []byte(data), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return manager, installPath
+}
+
+func TestWriteAppConfig(t *testing.T) {
+	manager, installPath := newConfigTestManager(t)
+
+	configs, err := manager.ListAppConfigs("flexy-app", "v1.0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs[0].Name != "config.yaml" || !configs[0].Schema {
+		t.Errorf("configs = %+v, want only config.yaml with a schema", configs)
+	}
+
+	for data, want := range map[string]string{"port: [": "invalid YAML", "name: flexy\n": "port is required", "port: 70000\n": "must be <= 65535"} {
+		if _, err := manager.WriteAppConfig("flexy-app", "v1.0.3", "config.yaml", []byte(data), "admin"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("write %q: expected %q, got %v", data, want, err)
+		}
+	}
+
+	change, err := manager.WriteAppConfig("flexy-app", "v1.0.3", "config.yaml", []byte("name: flexy\nport: 1661\n"), "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(change.Diff, "-port: 1660\n+port: 1661\n") || change.User != "admin" || change.Backup == "" {
+		t.Errorf("change = %+v", change)
+	}
+	backup, err := os.ReadFile(filepath.Join(manager.BackupPath, configBackupDir, "flexy-app", "v1.0.3", change.Backup))
+	if err != nil || string(backup) != "name: flexy\nport: 1660\n" {
+		t.Errorf("backup = %q %v", backup, err)
+	}
+	if info, _ := os.Stat(filepath.Join(installPath, "config.yaml")); info.Mode().Perm() != 0640 {
+		t.Errorf("the file mode should be kept, got %v", info.Mode())
+	}
+
+	if _, err := manager.WriteAppConfig("flexy-app", "v1.0.3", "extra.env", []byte("DEBUG=1\n"), "viewer"); err != nil {
+		t.Fatal(err)
+	}
+	history, err := manager.AppConfigHistory("flexy-app", "v1.0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].File != "extra.env" || history[0].Backup != "" || history[1].File != "config.yaml" {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestAppConfigPath(t *testing.T) {
+	manager, _ := newConfigTestManager(t)
+	for _, c := range []struct{ name, version, file string }{
+		{"flexy-app", "v1.0.3", "flexy-app"},
+		{"flexy-app", "v1.0.3", "config.schema.json"},
+		{"flexy-app", "v1.0.3", "../../other/v1/config.yaml"},
+		{"flexy-app", "..", "config.yaml"},
+		{"../installed/flexy-app", "v1.0.3", "config.yaml"},
+		{"flexy-app", "v2.0.0", "config.yaml"},
+	} {
+		if _, err := manager.WriteAppConfig(c.name, c.version, c.file, []byte("a: 1\n"), ""); err == nil {
+			t.Errorf("expected %+v to be refused", c)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(manager.InstallPath, "other")); !os.IsNotExist(err) {
+		t.Error("a config was written outside of the app")
+	}
+}
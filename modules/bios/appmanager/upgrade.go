@@ -0,0 +1,154 @@
+package appmanager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultUpgradeCheckDelay is how long an upgraded app must keep running before the old version is removed
+const DefaultUpgradeCheckDelay = 10 * time.Second
+
+// Upgrade replaces an installed version with a version from the library. The new version is unzipped into a staging
+// directory and renamed into place, so a failed unzip leaves the old version untouched. If the new version doesn't
+// start, or isn't running after the check delay, the service is pointed back at the old version. Once the new version
+// is running the old one is moved to the backups. The config files edited on the old version are carried over to the new one.
+func (inst *AppManager) Upgrade(name, fromVersion, toVersion string) error {
+	if name == "" || fromVersion == "" || toVersion == "" {
+		return errors.New("app name and the from and to versions are required")
+	}
+	oldPath := filepath.Join(inst.InstallPath, name, fromVersion)
+	newPath := filepath.Join(inst.InstallPath, name, toVersion)
+	if _, err := os.Stat(oldPath); err != nil {
+		return fmt.Errorf("app %s version %s is not installed", name, fromVersion)
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("app %s version %s is already installed", name, toVersion)
+	}
+	libraryApp, err := inst.libraryApp(name, toVersion)
+	if err != nil {
+		return err
+	}
+
+	config, err := inst.stageApp(libraryApp.Path, name, newPath)
+	if err != nil {
+		return err
+	}
+	inst.carryAppConfigs(name, fromVersion, toVersion)
+	// the service env can come from an edited config.yaml
+	if carried, err := inst.parseConfigFile(filepath.Join(newPath, "config.yaml")); err == nil && carried != nil {
+		config = carried
+	}
+	oldConfig, _ := inst.parseConfigFile(filepath.Join(oldPath, "config.yaml"))
+
+	if err := inst.startVersion(name, newPath, toVersion, config); err != nil {
+		if rollbackErr := inst.rollbackUpgrade(name, oldPath, fromVersion, oldConfig, newPath); rollbackErr != nil {
+			return fmt.Errorf("upgrade of %s to %s failed: %v, and the rollback to %s failed: %v", name, toVersion, err, fromVersion, rollbackErr)
+		}
+		return fmt.Errorf("upgrade of %s to %s failed, rolled back to %s: %w", name, toVersion, fromVersion, err)
+	}
+
+	if err := inst.backupApp(oldPath, filepath.Join(inst.BackupPath, name, fromVersion)); err != nil {
+		log.Error().Msgf("upgrade of %s: failed to backup %s: %v", name, fromVersion, err)
+		return nil
+	}
+	if err := os.RemoveAll(oldPath); err != nil {
+		log.Error().Msgf("upgrade of %s: failed to remove %s: %v", name, fromVersion, err)
+	}
+	return nil
+}
+
+// stageApp unzips an app next to its install path and renames it into place
+func (inst *AppManager) stageApp(zipFilePath, name, installPath string) (*Config, error) {
+	stagingPath := filepath.Join(filepath.Dir(installPath), "."+filepath.Base(installPath)+".staging")
+	if err := os.RemoveAll(stagingPath); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stagingPath)
+	if err := inst.unzipApp(zipFilePath, stagingPath, name); err != nil {
+		return nil, fmt.Errorf("failed to extract binary: %w", err)
+	}
+	configFilePath, err := inst.extractConfigFile(zipFilePath, stagingPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract config.yaml: %w", err)
+	}
+	var config *Config
+	if configFilePath == "" {
+		configFilePath = filepath.Join(stagingPath, "config.yaml")
+	}
+	if _, err := os.Stat(configFilePath); err == nil {
+		if config, err = inst.parseConfigFile(configFilePath); err != nil {
+			return nil, fmt.Errorf("failed to parse config.yaml: %w", err)
+		}
+	}
+	if err := os.Rename(stagingPath, installPath); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// carryAppConfigs writes the config files edited on the old version over the files the new version ships, through
+// WriteAppConfig so the file it replaces is backed up and the change is in the history. A file the new schema rejects is
+// left as the new version ships it.
+func (inst *AppManager) carryAppConfigs(name, fromVersion, toVersion string) {
+	changes, err := inst.AppConfigHistory(name, fromVersion)
+	if err != nil {
+		log.Error().Msgf("upgrade of %s: failed to read the config history of %s: %v", name, fromVersion, err)
+		return
+	}
+	carried := map[string]bool{}
+	for _, change := range changes {
+		if carried[change.File] {
+			continue
+		}
+		carried[change.File] = true
+		data, err := inst.ReadAppConfig(name, fromVersion, change.File)
+		if err != nil {
+			log.Error().Msgf("upgrade of %s: failed to read %s of %s: %v", name, change.File, fromVersion, err)
+			continue
+		}
+		if _, err := inst.WriteAppConfig(name, toVersion, change.File, data, ""); err != nil {
+			log.Warn().Msgf("upgrade of %s: %s of %s not carried over to %s: %v", name, change.File, fromVersion, toVersion, err)
+		}
+	}
+}
+
+// startVersion points the service at an installed version, starts it and checks it is still running after the delay
+func (inst *AppManager) startVersion(name, installPath, version string, config *Config) error {
+	if err := inst.stopAndRemoveOldApp(name); err != nil {
+		return err
+	}
+	if err := inst.createSystemdService(name, installPath, version, config); err != nil {
+		return fmt.Errorf("failed to generate systemctl service file: %w", err)
+	}
+	if err := inst.setupAndStartService(name); err != nil {
+		return err
+	}
+	time.Sleep(inst.upgradeCheckDelay)
+	status, err := inst.systemctlService.SystemdStatus(fmt.Sprintf("%s.service", name))
+	if err != nil {
+		return fmt.Errorf("failed to get the service status: %w", err)
+	}
+	if status.IsFailed || !status.IsActive {
+		return fmt.Errorf("%s.service is not running, status: %s", name, status.Status)
+	}
+	return nil
+}
+
+func (inst *AppManager) rollbackUpgrade(name, oldPath, oldVersion string, oldConfig *Config, newPath string) error {
+	log.Warn().Msgf("upgrade of %s failed, rolling back to %s", name, oldVersion)
+	if err := os.RemoveAll(newPath); err != nil {
+		log.Error().Msgf("upgrade of %s: failed to remove %s: %v", name, newPath, err)
+	}
+	if err := inst.stopAndRemoveOldApp(name); err != nil {
+		return err
+	}
+	if err := inst.createSystemdService(name, oldPath, oldVersion, oldConfig); err != nil {
+		return fmt.Errorf("failed to generate systemctl service file: %w", err)
+	}
+	return inst.setupAndStartService(name)
+}
@@ -0,0 +1,142 @@
+package appmanager
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NubeDev/flexy/utils/apppkg"
+	"github.com/NubeDev/flexy/utils/execute"
+	"github.com/NubeDev/flexy/utils/systemctl"
+)
+
+// fakeSystemctl runs nothing, the service is failed when failVersion is the version in the service file
+type fakeSystemctl struct {
+	systemPath  string
+	failVersion string
+	commands    []string
+}
+
+func (f *fakeSystemctl) Run(body *systemctl.CommandBody) *execute.Response { return nil }
+
+func (f *fakeSystemctl) Uptime(timeout ...int) (*systemctl.UptimeInfo, error) { return nil, nil }
+
+func (f *fakeSystemctl) SystemdStatus(unit string) (*systemctl.StatusResp, error) {
+	data, err := os.ReadFile(filepath.Join(f.systemPath, unit))
+	if err != nil {
+		return nil, err
+	}
+	if f.failVersion != "" && strings.Contains(string(data), "/"+f.failVersion+"/") {
+		return &systemctl.StatusResp{Status: "failed", IsFailed: true}, nil
+	}
+	return &systemctl.StatusResp{Status: "active", IsActive: true}, nil
+}
+
+func (f *fakeSystemctl) SystemdCommand(unit, commandType string) error {
+	f.commands = append(f.commands, commandType+" "+unit)
+	return nil
+}
+
+func (f *fakeSystemctl) SystemdShow(unit, property string) (string, error) { return "", nil }
+
+func (f *fakeSystemctl) SystemdIsEnabled(unit string) (bool, error) { return true, nil }
+
+func newUpgradeTestManager(t *testing.T) (*AppManager, *fakeSystemctl) {
+	t.Helper()
+	dir := t.TempDir()
+	systemd := &fakeSystemctl{systemPath: filepath.Join(dir, "system")}
+	manager := &AppManager{
+		LibraryPath:      filepath.Join(dir, "library"),
+		InstallPath:      filepath.Join(dir, "installed"),
+		BackupPath:       filepath.Join(dir, "backups"),
+		SystemPath:       systemd.systemPath,
+		systemctlService: systemd,
+		arch:             apppkg.AMD64,
+	}
+	for _, path := range []string{manager.LibraryPath, manager.SystemPath, filepath.Join(manager.InstallPath, "flexy-app", "v1.0.3")} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(manager.InstallPath, "flexy-app", "v1.0.3", "flexy-app"), []byte("v1.0.3"), 0755)
+	for _, version := range []string{"v1.0.4", "v1.1.0"} {
+		f, err := os.Create(filepath.Join(manager.LibraryPath, "flexy-app-"+version+"-amd64.zip"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := zip.NewWriter(f)
+		binary, _ := w.Create("flexy-app")
+		binary.Write([]byte(version))
+		config, _ := w.Create("config.yaml")
+		config.Write([]byte("id: flexy-app\n"))
+		w.Close()
+		f.Close()
+	}
+	return manager, systemd
+}
+
+func TestUpgrade(t *testing.T) {
+	manager, systemd := newUpgradeTestManager(t)
+	if err := manager.Upgrade("flexy-app", "v1.0.3", "v1.0.4"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(manager.InstallPath, "flexy-app", "v1.0.4", "flexy-app")); string(data) != "v1.0.4" {
+		t.Errorf("binary = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(manager.InstallPath, "flexy-app", "v1.0.3")); !os.IsNotExist(err) {
+		t.Error("the old version should be removed")
+	}
+	if _, err := os.Stat(filepath.Join(manager.BackupPath, "flexy-app", "v1.0.3", "flexy-app")); err != nil {
+		t.Errorf("the old version should be backed up: %v", err)
+	}
+	apps, _ := manager.ListInstalledApps()
+	if len(apps) != 1 || apps[0].Version != "v1.0.4" {
+		t.Errorf("installed = %+v, the staging directory shouldn't be left", apps)
+	}
+	if err := manager.Upgrade("flexy-app", "v1.0.4", "v1.0.4"); err == nil {
+		t.Error("expected the version to be already installed")
+	}
+	if err := manager.Upgrade("flexy-app", "v1.0.4", "v2.0.0"); err == nil || !strings.Contains(err.Error(), "not found in the library") {
+		t.Errorf("expected the version to be missing, got %v", err)
+	}
+	if len(systemd.commands) == 0 || systemd.commands[len(systemd.commands)-1] != "start flexy-app.service" {
+		t.Errorf("commands = %v", systemd.commands)
+	}
+}
+
+func TestUpgradeCarriesConfigs(t *testing.T) {
+	manager, _ := newUpgradeTestManager(t)
+	edited := "id: flexy-app\nport: 1660\n"
+	if _, err := manager.WriteAppConfig("flexy-app", "v1.0.3", "config.yaml", []byte(edited), "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Upgrade("flexy-app", "v1.0.3", "v1.0.4"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := manager.ReadAppConfig("flexy-app", "v1.0.4", "config.yaml")
+	if err != nil || string(data) != edited {
+		t.Errorf("config.yaml = %q %v, want the edit carried over", data, err)
+	}
+	history, err := manager.AppConfigHistory("flexy-app", "v1.0.4")
+	if err != nil || len(history) != 1 || history[0].Backup == "" {
+		t.Errorf("history = %+v %v, want the shipped config.yaml backed up", history, err)
+	}
+}
+
+func TestUpgradeRollback(t *testing.T) {
+	manager, systemd := newUpgradeTestManager(t)
+	systemd.failVersion = "v1.1.0"
+	err := manager.Upgrade("flexy-app", "v1.0.3", "v1.1.0")
+	if err == nil || !strings.Contains(err.Error(), "rolled back to v1.0.3") {
+		t.Fatalf("expected a rollback, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(manager.InstallPath, "flexy-app", "v1.1.0")); !os.IsNotExist(err) {
+		t.Error("the failed version should be removed")
+	}
+	status, err := systemd.SystemdStatus("flexy-app.service")
+	if err != nil || !status.IsActive {
+		t.Errorf("the service should run the old version again, status = %+v %v", status, err)
+	}
+}
@@ -3,12 +3,24 @@ package main
 import (
 	"fmt"
 	"github.com/NubeDev/flexy/modules/bios/appmanager"
+	"github.com/NubeDev/flexy/utils/apppkg"
 	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/events"
+	"github.com/NubeDev/flexy/utils/natlib"
 	"github.com/nats-io/nats.go"
 )
 
+// appSourceStore in an install request installs the app from the object store
+const appSourceStore = "store"
+
 func (s *Service) handleListLibraryApps(m *nats.Msg) {
-	apps, err := s.appManager.ListLibraryApps()
+	listApps := s.appManager.ListLibraryApps
+	if len(m.Data) > 0 {
+		if decoded, err := s.DecodeApps(m); err == nil && decoded.All {
+			listApps = s.appManager.ListAllLibraryApps
+		}
+	}
+	apps, err := listApps()
 	if err != nil {
 		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error listing library apps: %v", err))
 		return
@@ -35,6 +47,15 @@ func (s *Service) handleInstallApp(m *nats.Msg) {
 		s.handleError(m.Reply, code.ERROR, err.Error())
 		return
 	}
+	switch decoded.Source {
+	case "":
+	case appSourceStore:
+		s.handleInstallFromStore(m, decoded)
+		return
+	default:
+		s.handleError(m.Reply, code.InvalidParams, fmt.Sprintf("unknown app source %s, try: %s", decoded.Source, appSourceStore))
+		return
+	}
 	decoded, err = s.getAppName(decoded)
 	if err != nil {
 		s.handleError(m.Reply, code.ERROR, err.Error())
@@ -53,6 +74,7 @@ func (s *Service) handleInstallApp(m *nats.Msg) {
 	if err != nil {
 		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error installing app: %v", err))
 	} else {
+		s.events.Publish(events.AppInstalled, decoded)
 		out := Message{
 			fmt.Sprintf("App %s version %s installed", decoded.Name, decoded.Version),
 		}
@@ -60,6 +82,65 @@ func (s *Service) handleInstallApp(m *nats.Msg) {
 	}
 }
 
+// handleInstallFromStore streams an app zip from the object store into the library, checks it against the
+// object digest and installs it, eg; {"source": "store", "storeName": "bios", "objectName": "flexy-app-v1.0.3-amd64.zip"}
+func (s *Service) handleInstallFromStore(m *nats.Msg, decoded *App) {
+	if s.natsStore == nil {
+		s.handleError(m.Reply, code.InvalidParams, "Store is not enabled in the config file")
+		return
+	}
+	if decoded.ObjectName == "" {
+		s.handleError(m.Reply, code.InvalidParams, "objectName is required to install from the store")
+		return
+	}
+	if arch := apppkg.ArchOf(decoded.ObjectName); !apppkg.Compatible(s.appManager.Arch(), arch) {
+		s.handleError(m.Reply, code.InvalidParams, fmt.Sprintf("%s is built for %s, this device is %s", decoded.ObjectName, arch, s.appManager.Arch()))
+		return
+	}
+	if decoded.StoreName == "" {
+		decoded.StoreName = s.natsStore.name
+	}
+	libraryApp, err := s.storeToLibrary(decoded.StoreName, decoded.ObjectName)
+	if err != nil {
+		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error copying %s from the store: %v", decoded.ObjectName, err))
+		return
+	}
+	app := &appmanager.App{Name: libraryApp.Name, Version: libraryApp.Version}
+	if err := s.appManager.Install(app); err != nil {
+		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error installing app: %v", err))
+		return
+	}
+	installed := &App{Name: libraryApp.Name, AppID: libraryApp.AppID, Version: libraryApp.Version, Source: appSourceStore, StoreName: decoded.StoreName, ObjectName: decoded.ObjectName}
+	s.events.Publish(events.AppInstalled, installed)
+	out := Message{
+		fmt.Sprintf("App %s version %s installed from %s/%s", libraryApp.Name, libraryApp.Version, decoded.StoreName, decoded.ObjectName),
+	}
+	s.publishResponse(m, out, code.SUCCESS)
+}
+
+// storeToLibrary streams an object into the app library, the sha256 must match the object digest
+func (s *Service) storeToLibrary(storeName, objectName string) (*appmanager.App, error) {
+	store, err := s.natsClient.GetStore(storeName)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := store.Get(objectName)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	// the info of the object being read, so a replaced object can't be checked against the wrong digest
+	info, err := obj.Info()
+	if err != nil {
+		return nil, err
+	}
+	sum, err := natlib.ObjectDigest(info.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return s.appManager.AddLibraryApp(objectName, obj, sum)
+}
+
 // New method to handle setting the decoded.Name based on AppID
 func (s *Service) getAppName(decoded *App) (*App, error) {
 	if decoded.Name == "" {
@@ -108,6 +189,7 @@ func (s *Service) handleUninstallApp(m *nats.Msg) {
 	if err != nil {
 		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error uninstalling app: %v", err))
 	} else {
+		s.events.Publish(events.AppUninstalled, decoded)
 		out := Message{
 			fmt.Sprintf("App %s version %s uninstalled", decoded.Name, decoded.Version),
 		}
@@ -4,11 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/NubeDev/flexy/modules/bios/appmanager"
+	"github.com/NubeDev/flexy/utils/apispec"
 	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/events"
 	githubdownloader "github.com/NubeDev/flexy/utils/gitdownloader"
 	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/NubeDev/flexy/utils/natsauth"
+	"github.com/NubeDev/flexy/utils/natsforwarder"
 	"github.com/NubeDev/flexy/utils/subjects"
 	"github.com/NubeDev/flexy/utils/systemctl"
+	"github.com/gin-gonic/gin"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -24,9 +29,13 @@ type Command struct {
 }
 
 type App struct {
-	Name    string `json:"name"`
-	AppID   string `json:"appID"`
-	Version string `json:"version"`
+	Name       string `json:"name"`
+	AppID      string `json:"appID"`
+	Version    string `json:"version"`
+	Source     string `json:"source,omitempty"`     // "store" installs from the object store, defaults to the library
+	StoreName  string `json:"storeName,omitempty"`  // with source store, defaults to the bios store
+	ObjectName string `json:"objectName,omitempty"` // with source store, eg; flexy-app-v1.0.3-amd64.zip
+	All        bool   `json:"all,omitempty"`        // lists the library packages of every arch, not just this device's
 }
 
 // Service struct to handle NATS and file operations
@@ -36,24 +45,42 @@ type Service struct {
 	gitDownloadPath string
 	natsClient      natlib.NatLib
 	natsConn        *nats.Conn
+	natsOptions     []nats.Option // the connect options of the local broker, eg; the bios-proxy nkey
 	//natsStore          *natsrouter.NatsRouter
 	systemctlService   systemctl.Commands
 	appManager         appmanager.ManagerInterface
 	biosSubjectBuilder *subjects.SubjectBuilder
 	githubDownloader   *githubdownloader.GitHubDownloader
+	releaseSources     map[string]releaseSourceConfig // the release_sources by name
+	releaseSource      string                         // the source used when a git request doesn't set one
+	gitCacheDir        string                         // the release metadata and partial downloads of the github sources
 	services           []string
 	Config             *viper.Viper
 	RootCmd            *cobra.Command
 	natsSubjects       []string
 	natsStore          *natsStore
+	natsAuth           *natsauth.Authorizer
+	kvStore            *kvStore
+	events             *events.Publisher
+	transfers          *transfers
+	files              *fileManager
+	updates            *updateChecker
+	proxyTable         *natsforwarder.Table
+	gateway            *gateway
+	ginRoutes          gin.RoutesInfo
+	routeDocs          *apispec.Routes // described where they're registered, see SetupGin
 }
 
 type Opts struct {
 	GlobalUUID      string
+	SubjectPrefix   string // optional site/customer prefix in front of the subjects, eg; acme.site-1
 	NatsURL         string
 	RootPath        string
 	AppsPath        string
 	SystemPath      string
+	Arch            string // amd64, arm64 or armv7, detected when empty
+	NatsSeedFile    string // the nkey seed of the bios user on the cloud broker, see nats-config
+	ProxySeedFile   string // the nkey seed of the bios-proxy user on the local broker
 	GitToken        string
 	GitDownloadPath string
 	ProxyNatsPort   int
@@ -63,6 +90,7 @@ type Opts struct {
 type natsStore struct {
 	name            string
 	enableNatsStore bool
+	revisions       int // prior revisions of an object kept as name@<revision>
 }
 
 func (s *Service) NewService(opts *Opts) error {
@@ -83,25 +111,46 @@ func (s *Service) NewService(opts *Opts) error {
 	gitToken = opts.GitToken
 	gitDownloadPath = opts.GitDownloadPath
 
-	nc, err := nats.Connect(natsURL)
+	biosSubjectBuilder, err := subjects.NewSubjectBuilder(globalUUID, "bios", subjects.IsBios)
 	if err != nil {
 		return err
 	}
-	appManager, err := appmanager.NewAppManager(dataPath, systemPath)
+	if opts.SubjectPrefix != "" {
+		biosSubjectBuilder, err = biosSubjectBuilder.WithPrefix(opts.SubjectPrefix)
+		if err != nil {
+			return err
+		}
+	}
+	natsOptions, err := natlib.SeedFileOptions(opts.NatsSeedFile)
+	if err != nil {
+		return err
+	}
+	proxyOptions, err := natlib.SeedFileOptions(opts.ProxySeedFile)
+	if err != nil {
+		return err
+	}
+	nc, err := nats.Connect(natsURL, natsOptions...)
+	if err != nil {
+		return err
+	}
+	appManager, err := appmanager.NewAppManager(dataPath, systemPath, opts.Arch)
 	if err != nil {
 		return err
 	}
+	log.Info().Msgf("bios arch: %s", appManager.Arch())
 	log.Info().Msgf("start bios NATS server: %v", natsURL)
 
 	// Assign initialized components to the Service struct
 	s.globalUUID = globalUUID
 	s.gitDownloadPath = gitDownloadPath
 	s.natsConn = nc
+	s.natsOptions = proxyOptions
 	s.systemctlService = systemctl.New()
 	s.appManager = appManager
-	s.biosSubjectBuilder = subjects.NewSubjectBuilder(globalUUID, "bios", subjects.IsBios)
+	s.biosSubjectBuilder = biosSubjectBuilder
 	s.githubDownloader = githubdownloader.New(gitToken, gitDownloadPath)
 	s.natsClient = natlib.New(natlib.NewOpts{
+		NatsConn:        nc,
 		EnableJetStream: opts.EnableNatsStore,
 	})
 	return nil
@@ -191,3 +240,10 @@ func (s *Service) handlePing(m *nats.Msg) ([]byte, error) {
 	response := natlib.NewResponse(code.SUCCESS, s.globalUUID, natlib.Args{Description: s.description})
 	return response.ToJSON(), nil
 }
+
+func (s *Service) respondPing(m *nats.Msg) {
+	response, _ := s.handlePing(m)
+	if err := m.Respond(response); err != nil {
+		log.Error().Msgf("failed to respond to ping: %v", err)
+	}
+}
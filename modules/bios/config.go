@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"github.com/NubeDev/flexy/utils"
+	"github.com/NubeDev/flexy/utils/natsauth"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -43,10 +45,14 @@ func (s *Service) SetupCLI() {
 		// Initialize options from the configuration
 		opts := &Opts{
 			GlobalUUID:      s.globalUUID,
+			SubjectPrefix:   s.Config.GetString("subject_prefix"),
 			NatsURL:         natsURL,
 			RootPath:        s.Config.GetString("root_path"),
 			AppsPath:        fmt.Sprintf("%s/%s", s.Config.GetString("root_path"), s.Config.GetString("apps_path")),
 			SystemPath:      s.Config.GetString("system_path"),
+			Arch:            s.Config.GetString("arch"),
+			NatsSeedFile:    s.Config.GetString("nats_seed_file"),
+			ProxySeedFile:   s.Config.GetString("proxy_seed_file"),
 			GitToken:        s.Config.GetString("git_token"),
 			GitDownloadPath: s.Config.GetString("git_download_path"),
 			ProxyNatsPort:   s.Config.GetInt("proxy_port"),
@@ -58,10 +64,24 @@ func (s *Service) SetupCLI() {
 			return fmt.Errorf("failed to initialise services: %v", err)
 		}
 
+		if err := s.releaseSourcesInit(); err != nil {
+			return fmt.Errorf("failed to initialise the release sources: %v", err)
+		}
+
+		if err := s.updatesInit(); err != nil {
+			return fmt.Errorf("failed to initialise the update checks: %v", err)
+		}
+
 		// Retrieve services from the configuration
 		s.services = s.Config.GetStringSlice("services")
 		s.description = s.Config.GetString("description")
 
+		if s.Config.GetBool("nats_auth.enable") {
+			if err := s.natsAuthInit(); err != nil {
+				return fmt.Errorf("failed to initialise nats auth: %v", err)
+			}
+		}
+
 		if enableNatsStore {
 			name := s.Config.GetString("jet_stream.store_name")
 			if name == "" {
@@ -71,6 +91,7 @@ func (s *Service) SetupCLI() {
 			s.natsStore = &natsStore{
 				name:            name,
 				enableNatsStore: true,
+				revisions:       s.Config.GetInt("jet_stream.store_revisions"),
 			}
 
 			err := s.natsStoreInit(name)
@@ -78,6 +99,38 @@ func (s *Service) SetupCLI() {
 				return err
 			}
 		}
+
+		if s.Config.GetBool("jet_stream.kv_enable") {
+			bucket := s.Config.GetString("jet_stream.kv_bucket")
+			if bucket == "" {
+				bucket = "config"
+			}
+			// the bucket lives on the local broker, where the apps can watch it
+			kvURL := s.Config.GetString("jet_stream.kv_url")
+			if kvURL == "" {
+				kvURL = s.localBrokerURL()
+			}
+			err := s.natsKVInit(kvURL, bucket, uint8(s.Config.GetUint("jet_stream.kv_history")))
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := s.natsTransferInit(s.Config.GetString("transfer_dir"), s.Config.GetInt64("transfer_max_size")); err != nil {
+			return fmt.Errorf("failed to initialise transfers: %v", err)
+		}
+
+		if s.Config.GetBool("file_manager.enable") {
+			if err := s.fileManagerInit(); err != nil {
+				return fmt.Errorf("failed to initialise the file manager: %v", err)
+			}
+		}
+
+		if s.Config.GetBool("jet_stream.events_enable") {
+			if err := s.natsEventsInit(s.Config.GetDuration("jet_stream.events_max_age")); err != nil {
+				return fmt.Errorf("failed to initialise events: %v", err)
+			}
+		}
 		return nil
 	}
 
@@ -88,3 +141,50 @@ func (s *Service) SetupCLI() {
 		}
 	}
 }
+
+// defaultJwtSecret is the secret in the example configs, it's public so anyone could sign an admin token with it
+const defaultJwtSecret = "233"
+
+// jwtSecretInit sets the secret tokens are checked with, it must be set and not the public default
+func (s *Service) jwtSecretInit(key string) error {
+	secret := s.Config.GetString("nats_auth.jwt_secret")
+	if secret == "" || secret == defaultJwtSecret {
+		return fmt.Errorf("%s needs nats_auth.jwt_secret, set it to the secret ros signs the tokens with and not the example default", key)
+	}
+	utils.SetJwtSecret(secret)
+	return nil
+}
+
+// natsAuthInit requires a JWT or API key on every bios NATS request, checked
+// against the casbin policy file
+func (s *Service) natsAuthInit() error {
+	modelPath := s.Config.GetString("nats_auth.model")
+	if modelPath == "" {
+		modelPath = "config/nats_model.conf"
+	}
+	policyPath := s.Config.GetString("nats_auth.policy")
+	if policyPath == "" {
+		policyPath = "config/nats_policy.csv"
+	}
+	if err := s.jwtSecretInit("nats_auth"); err != nil {
+		return err
+	}
+	var apiKeys []natsauth.APIKey
+	if err := s.Config.UnmarshalKey("nats_auth.api_keys", &apiKeys); err != nil {
+		return err
+	}
+	enforcer, err := natsauth.NewFileEnforcer(modelPath, policyPath)
+	if err != nil {
+		return err
+	}
+	s.natsAuth, err = natsauth.New(natsauth.Opts{
+		Enforcer:      enforcer,
+		APIKeys:       apiKeys,
+		SubjectPrefix: s.biosSubjectBuilder.Prefix,
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("bios nats auth enabled, policy: %s", policyPath)
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/NubeDev/flexy/utils/apispec"
+	"github.com/NubeDev/flexy/utils/events"
+	"github.com/NubeDev/flexy/utils/guides"
+	"github.com/NubeDev/flexy/utils/natsauth"
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+/*
+The bios subjects are described by the help guide (the reply to <uuid>.get.system.help, like the apps), the AsyncAPI
+document is built from it and from the help guides of the installed apps, asked for through the proxy.
+
+curl -H "X-API-Key: change-me" http://localhost:5000/v1/api/system/openapi.json
+curl -H "X-API-Key: change-me" http://localhost:5000/v1/api/system/asyncapi.json
+*/
+
+// appHelpTimeout is how long an installed app has to answer <app-id>.get.system.help
+const appHelpTimeout = time.Second
+
+var biosDocsInfo = apispec.Info{Title: "bios", Version: "v1"}
+
+// biosHelp describes the bios subjects
+func (s *Service) biosHelp() *guides.HelpGuide {
+	b := s.biosSubjectBuilder
+	method := func(name, description, subject, jsonBody string) guides.Method {
+		return guides.NewMethod(name, description, subject, jsonBody != "", jsonBody, nil)
+	}
+	return guides.NewHelpGuide([]guides.Module{
+		guides.NewModule("system", []guides.Method{
+			method("ping", "Replies with the uuid and description of the device", b.BuildSubject("get", "system", "ping"), ""),
+			method("help", "Replies with this help guide", b.BuildSubject("get", "system", "help"), ""),
+			method("systemctlStatus", "Status of a service", b.BuildSubject("get", "system", "systemctl.status"), `{"name": "ufw"}`),
+			method("systemctlIsEnabled", "Whether a service is enabled", b.BuildSubject("get", "system", "systemctl.is-enabled"), `{"name": "ufw"}`),
+			method("systemctlShow", "A property of a service", b.BuildSubject("get", "system", "systemctl.show"), `{"name": "ufw", "property": "ActiveState"}`),
+			method("systemctlCommand", "start, stop, restart, enable or disable a service", b.BuildSubject("post", "system", "systemctl.*"), `{"name": "ufw"}`),
+		}),
+		guides.NewModule("apps", []guides.Method{
+			method("appsInstalled", "List the installed apps", b.BuildSubject("get", "apps", "manager.installed"), ""),
+			method("appsLibrary", "List the apps in the library built for this device, all lists every arch", b.BuildSubject("get", "apps", "manager.library"), `{"all": false}`),
+			method("appsInstall", "Install an app from the library, or an app zip from the object store with source store", b.BuildSubject("post", "apps", "manager.install"),
+				`{"name": "flexy-app", "version": "v1.0.3", "source": "store", "storeName": "bios", "objectName": "flexy-app-v1.0.3-amd64.zip"}`),
+			method("appsUninstall", "Uninstall an app", b.BuildSubject("post", "apps", "manager.uninstall"), `{"name": "flexy-app", "version": "v1.0.3"}`),
+			method("appsConfigs", "List the config files of an installed app", b.BuildSubject("get", "apps", "manager.configs"), `{"name": "flexy-app", "version": "v1.0.3"}`),
+			method("appsConfig", "Read a config file of an installed app", b.BuildSubject("get", "apps", "manager.config"), `{"name": "flexy-app", "version": "v1.0.3", "file": "config.yaml"}`),
+			method("appsConfigWrite", "Validate, backup and write a config file, restart restarts the app", b.BuildSubject("post", "apps", "manager.config"),
+				`{"name": "flexy-app", "version": "v1.0.3", "file": "config.yaml", "data": "port: 1661\n", "restart": true}`),
+			method("appsConfigHistory", "Who changed the config files of an installed app, with the diffs", b.BuildSubject("get", "apps", "manager.config-history"), `{"name": "flexy-app", "version": "v1.0.3"}`),
+			method("appsUpdates", "The update state of the installed apps and when they were last checked", b.BuildSubject("get", "apps", "manager.updates"), ""),
+			method("appsCheckUpdates", "Check the release sources for newer versions of the installed apps now", b.BuildSubject("post", "apps", "manager.check-updates"), ""),
+			method("appsUpgrade", "Download and install a newer version, the latest found by the last check when no version is set", b.BuildSubject("post", "apps", "manager.upgrade"),
+				`{"name": "flexy-app", "version": "v1.0.4"}`),
+		}),
+		guides.NewModule("git", []guides.Method{
+			method("gitAssets", "List the assets of a release", b.BuildSubject("get", "git", "manager.assets"), `{"owner": "NubeDev", "repo": "flexy", "tag": "v1.0.3"}`),
+			method("gitAsset", "Download the asset of a release for an arch, defaults to this device's arch", b.BuildSubject("post", "git", "manager.asset"), `{"owner": "NubeDev", "repo": "flexy", "tag": "v1.0.3", "arch": "amd64"}`),
+			method("gitAssetFromSource", "Download from a release source in the config", b.BuildSubject("post", "git", "manager.asset"),
+				`{"owner": "NubeDev", "repo": "flexy", "tag": "v1.0.3", "arch": "amd64", "source": "builds"}`),
+		}),
+		guides.NewModule("store", []guides.Method{
+			method("storeList", "List the object stores", b.BuildSubject("post", "system", "store.get.stores"), `{}`),
+			method("storeObjects", "List the objects in a store", b.BuildSubject("post", "system", "store.get.object"), `{"storeName": "bios"}`),
+			method("storeObjectInfo", "The info and metadata of an object, or of an older revision", b.BuildSubject("post", "system", "store.get.object.info"), `{"storeName": "bios", "objectName": "app.zip", "revision": 2}`),
+			method("storeObjectRevisions", "The current object then its older revisions, newest first", b.BuildSubject("post", "system", "store.get.object.revisions"), `{"storeName": "bios", "objectName": "app.zip"}`),
+			method("storeAdd", "Add an object, the data is base64, the object it replaces is kept as objectName@revision", b.BuildSubject("post", "system", "store.add.object"),
+				`{"storeName": "bios", "objectName": "app.zip", "description": "flexy app", "metadata": {"appID": "flexy-app", "version": "v1.0.3", "arch": "amd64"}, "data": "aGVsbG8="}`),
+			method("storeDelete", "Delete an object", b.BuildSubject("post", "system", "store.delete.object"), `{"storeName": "bios", "objectName": "app.zip"}`),
+			method("storeDownload", "Save an object (or an older revision) to a directory on the device", b.BuildSubject("post", "system", "store.download.object"), `{"storeName": "bios", "objectName": "app.zip", "destinationPath": "/home/user"}`),
+			method("storeStatus", "The size, number of objects and config of a store", b.BuildSubject("post", "system", "store.get.store.status"), `{"storeName": "bios"}`),
+			method("storeCreate", "Create a store, the ttl is a duration and the storage is file or memory", b.BuildSubject("post", "system", "store.create.store"),
+				`{"storeName": "apps", "config": {"description": "app zips", "maxBytes": 1073741824, "ttl": "720h", "storage": "file", "replicas": 1}}`),
+			method("storeDrop", "Delete a store and its objects, the stores used by bios can't be dropped", b.BuildSubject("post", "system", "store.drop.store"), `{"storeName": "apps"}`),
+			method("storePurge", "Delete every object in a store", b.BuildSubject("post", "system", "store.purge"), `{"storeName": "apps"}`),
+		}),
+		guides.NewModule("transfer", []guides.Method{
+			method("transferStoreUpload", "Chunked upload into the object store, see natlib.Upload", b.BuildSubject("post", "system", "transfer.store.upload"), ""),
+			method("transferStoreDownload", "Chunked download from the object store, see natlib.Download", b.BuildSubject("get", "system", "transfer.store.download"), ""),
+			method("transferFileUpload", "Chunked upload of a file into a file manager root, meta: root, path", b.BuildSubject("post", "system", "transfer.file.upload"), ""),
+			method("transferFileDownload", "Chunked download of a file from a file manager root, meta: root, path", b.BuildSubject("get", "system", "transfer.file.download"), ""),
+		}),
+		guides.NewModule("files", []guides.Method{
+			method("filesRoots", "The file manager root directories", b.BuildSubject("get", "system", "files.roots"), ""),
+			method("filesList", "List a directory", b.BuildSubject("get", "system", "files.list"), `{"root": "logs", "path": "/"}`),
+			method("filesStat", "The size, mode and time of a file", b.BuildSubject("get", "system", "files.stat"), `{"root": "logs", "path": "syslog"}`),
+			method("filesRead", "Read a byte range of a file, the data is base64", b.BuildSubject("get", "system", "files.read"), `{"root": "logs", "path": "syslog", "offset": 0, "length": 4096}`),
+			method("filesSHA256", "The sha256 of a file", b.BuildSubject("get", "system", "files.sha256"), `{"root": "data", "path": "app-abc/db.sqlite"}`),
+			method("filesWrite", "Write or append to a file, the data is base64", b.BuildSubject("post", "system", "files.write"), `{"root": "config", "path": "app-abc/config.yaml", "data": "ZGVidWc6IHRydWUK", "mode": "0644"}`),
+			method("filesMkdir", "Create a directory", b.BuildSubject("post", "system", "files.mkdir"), `{"root": "data", "path": "backup"}`),
+			method("filesChmod", "Change the mode of a file", b.BuildSubject("post", "system", "files.chmod"), `{"root": "data", "path": "app-abc/run.sh", "mode": "0755"}`),
+			method("filesMove", "Move a file or directory, destRoot defaults to root", b.BuildSubject("post", "system", "files.move"), `{"root": "data", "path": "app-abc/db.sqlite", "dest": "backup/db.sqlite"}`),
+			method("filesDelete", "Delete a file, recursive deletes a directory", b.BuildSubject("post", "system", "files.delete"), `{"root": "data", "path": "backup", "recursive": true}`),
+			method("filesZip", "Zip a file or directory", b.BuildSubject("post", "system", "files.zip"), `{"root": "data", "path": "app-abc", "destRoot": "data", "dest": "backup/app-abc.zip"}`),
+			method("filesUnzip", "Unzip into a directory", b.BuildSubject("post", "system", "files.unzip"), `{"root": "data", "path": "backup/app-abc.zip", "dest": "restored"}`),
+		}),
+		guides.NewModule("kv", []guides.Method{
+			method("kvKeys", "List the keys of the app config bucket", b.BuildSubject("get", "system", "kv.keys"), `{"bucket": "config"}`),
+			method("kvValue", "Get a key", b.BuildSubject("get", "system", "kv.value"), `{"key": "app-abc.debug"}`),
+			method("kvHistory", "History of a key", b.BuildSubject("get", "system", "kv.history"), `{"key": "app-abc.debug"}`),
+			method("kvPut", "Set a key", b.BuildSubject("post", "system", "kv.put"), `{"key": "app-abc.debug", "value": "true"}`),
+			method("kvDelete", "Delete a key, the app reverts to its config file", b.BuildSubject("post", "system", "kv.delete"), `{"key": "app-abc.debug"}`),
+		}),
+		guides.NewModule("proxy", []guides.Method{
+			method("proxyRoutes", "The proxy routing table", b.BuildSubject("get", "system", "proxy.routes"), ""),
+			method("proxyStats", "Forwarding stats per target", b.BuildSubject("get", "system", "proxy.stats"), ""),
+			method("proxyReload", "Reload the proxy routes from the config file", b.BuildSubject("post", "system", "proxy.reload"), ""),
+			method("proxyForward", "Forwarded to <subject> on the broker of the app", b.AddGlobalUUID("proxy.>"), ""),
+		}),
+	})
+}
+
+func (s *Service) handleHelp(m *nats.Msg) {
+	b, err := json.Marshal(s.biosHelp())
+	if err != nil {
+		log.Error().Msgf("failed to marshal the help guide: %v", err)
+		return
+	}
+	if err := m.Respond(b); err != nil {
+		log.Error().Msgf("failed to respond to help: %v", err)
+	}
+}
+
+// getOpenAPI handles GET /v1/api/system/openapi.json
+func (s *Service) getOpenAPI(c *gin.Context) {
+	opts := apispec.OpenAPIOpts{Info: biosDocsInfo, BasePath: "/api"}
+	if s.gateway.auth != nil {
+		opts.SecuritySchemes = map[string]*apispec.SecurityScheme{
+			"bearer": apispec.BearerAuth(),
+			"apiKey": apispec.HeaderAuth(natsauth.HeaderAPIKey),
+		}
+	}
+	c.JSON(http.StatusOK, s.routeDocs.OpenAPI(opts, s.ginRoutes))
+}
+
+// getAsyncAPI handles GET /v1/api/system/asyncapi.json, the apps that don't answer in time are left out
+func (s *Service) getAsyncAPI(c *gin.Context) {
+	docs := apispec.NewSubjects()
+	docs.AddHelpGuide(s.biosHelp())
+	docs.Add(apispec.Subject{Subject: events.Subject(s.globalUUID, ">"), Summary: "Device events, see the EVENTS_<uuid> stream", Request: events.Event{}, Event: true})
+
+	apps, err := s.appManager.ListInstalledApps()
+	if err != nil {
+		log.Error().Msgf("asyncapi failed to list the installed apps: %v", err)
+	}
+	var wg sync.WaitGroup
+	for _, app := range apps {
+		wg.Add(1)
+		go func(appID string) {
+			defer wg.Done()
+			guide, err := s.appHelp(appID)
+			if err != nil {
+				log.Debug().Msgf("asyncapi skipped app %s: %v", appID, err)
+				return
+			}
+			// the app subjects are reached through the proxy
+			subjects := apispec.HelpGuideSubjects(guide)
+			for i := range subjects {
+				subjects[i].Subject = s.biosSubjectBuilder.AddGlobalUUID("proxy." + subjects[i].Subject)
+			}
+			docs.Add(subjects...)
+		}(app.AppID)
+	}
+	wg.Wait()
+	c.JSON(http.StatusOK, docs.AsyncAPI(biosDocsInfo))
+}
+
+// appHelp asks an installed app for its help guide through the proxy
+func (s *Service) appHelp(appID string) (*guides.HelpGuide, error) {
+	msg, err := s.natsConn.Request(s.biosSubjectBuilder.AddGlobalUUID("proxy."+appID+".get.system.help"), nil, appHelpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	var guide guides.HelpGuide
+	if err := json.Unmarshal(msg.Data, &guide); err != nil {
+		return nil, err
+	}
+	return &guide, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"time"
+
+	"github.com/NubeDev/flexy/utils/events"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+/*
+Usage, events are kept in the stream EVENTS_<uuid> on the cloud broker
+
+./nats sub "abc.event.>"
+./nats stream view EVENTS_abc
+*/
+
+// ServiceEvent is the data of the service.* events
+type ServiceEvent struct {
+	Name   string `json:"name"`
+	AppID  string `json:"appID,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// StoreEvent is the data of the store.* events
+type StoreEvent struct {
+	StoreName  string `json:"storeName"`
+	ObjectName string `json:"objectName,omitempty"`
+	Size       int    `json:"size,omitempty"`
+}
+
+var serviceActionEvents = map[string]string{
+	"start":   events.ServiceStarted,
+	"stop":    events.ServiceStopped,
+	"restart": events.ServiceRestarted,
+	"enable":  events.ServiceEnabled,
+	"disable": events.ServiceDisabled,
+}
+
+func (s *Service) natsEventsInit(maxAge time.Duration) error {
+	js, err := s.natsConn.JetStream()
+	if err != nil {
+		return err
+	}
+	s.events, err = events.NewPublisher(js, s.globalUUID, "bios", maxAge)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("bios events enabled, stream: %s", events.StreamName(s.globalUUID))
+	return nil
+}
+
+// eventsRelay copies the events published by ros and the apps on the local broker into the device stream
+func (s *Service) eventsRelay(localURL string) error {
+	nc, err := nats.Connect(localURL, s.natsOptions...)
+	if err != nil {
+		return err
+	}
+	_, err = nc.Subscribe(events.Subject(s.globalUUID, ">"), func(m *nats.Msg) {
+		event, err := events.Decode(m)
+		if err != nil {
+			log.Error().Msgf("events relay: %v", err)
+			return
+		}
+		if err := s.events.PublishEvent(event); err != nil {
+			log.Error().Msgf("events relay failed to publish %s: %v", event.Type, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("bios events relay from: %s", localURL)
+	return nil
+}
+
+// servicesMonitor publishes a service.failed event when one of the configured services fails
+func (s *Service) servicesMonitor(interval time.Duration) {
+	failed := map[string]bool{}
+	for {
+		for _, name := range s.services {
+			status, err := s.systemctlService.SystemdStatus(name)
+			if err != nil {
+				continue
+			}
+			if status.IsFailed && !failed[name] {
+				s.events.Publish(events.ServiceFailed, ServiceEvent{Name: name, Status: status.Status})
+			}
+			failed[name] = status.IsFailed
+		}
+		time.Sleep(interval)
+	}
+}
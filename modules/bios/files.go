@@ -1,225 +1,288 @@
 package main
 
 import (
-	"archive/zip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/NubeDev/flexy/utils/code"
-	"github.com/nats-io/nats.go"
-	"io"
-	"io/ioutil"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/natsauth"
+	"github.com/NubeDev/flexy/utils/sandbox"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 )
 
-func (s *Service) handleReadFile(m *nats.Msg) {
-	cmd, err := s.DecodeCommand(m)
-	if err != nil {
-		s.handleError(m.Reply, code.ERROR, err.Error())
-		return
-	}
+/*
+The file manager only works in the file_manager.roots directories, a path is relative to its root and can't leave it.
+The get actions only read, every action is written to the audit log.
+
+./nats req abc.get.system.files.roots ''
+./nats req abc.get.system.files.list '{"root": "logs", "path": "/"}'
+./nats req abc.get.system.files.read '{"root": "logs", "path": "syslog", "offset": 0, "length": 4096}'
+./nats req abc.post.system.files.write '{"root": "config", "path": "app-abc/config.yaml", "data": "ZGVidWc6IHRydWUK"}'
+./nats req abc.post.system.files.move '{"root": "data", "path": "app-abc/db.sqlite", "destRoot": "data", "dest": "backup/db.sqlite"}'
+./nats req abc.post.system.files.delete '{"root": "data", "path": "backup", "recursive": true}'
+*/
+
+// defaultFileMaxRead is how much a files.read returns when file_manager.max_read isn't set
+const defaultFileMaxRead = 1 << 20
+
+type fileManager struct {
+	fs      *sandbox.FS
+	maxRead int64
+	audit   zerolog.Logger
+}
 
-	path, err := s.GetCommandValue(cmd, "path")
-	if err != nil {
-		s.handleError(m.Reply, code.InvalidParams, err.Error())
-		return
-	}
+// FileRequest is the body of the files.* requests, Dest is in the same root unless DestRoot is set
+type FileRequest struct {
+	Root      string `json:"root"`
+	Path      string `json:"path"`
+	DestRoot  string `json:"destRoot,omitempty"`  // move, zip and unzip
+	Dest      string `json:"dest,omitempty"`      // move, zip and unzip
+	Offset    int64  `json:"offset,omitempty"`    // read
+	Length    int64  `json:"length,omitempty"`    // read, defaults to file_manager.max_read
+	Data      string `json:"data,omitempty"`      // write, base64
+	Append    bool   `json:"append,omitempty"`    // write
+	Mode      string `json:"mode,omitempty"`      // write and chmod, octal eg; 0644
+	Recursive bool   `json:"recursive,omitempty"` // delete a directory and its contents
+}
 
-	content, err := s.ReadFile(path)
+// FileContent is the reply to files.read, the data is base64
+type FileContent struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Length int    `json:"length"`
+	Size   int64  `json:"size"` // of the whole file
+	Data   string `json:"data"`
+}
+
+// fileManagerInit loads the roots, max_read and audit log from the config
+func (s *Service) fileManagerInit() error {
+	var roots map[string]string
+	if err := s.Config.UnmarshalKey("file_manager.roots", &roots); err != nil {
+		return fmt.Errorf("invalid file_manager.roots in config: %v", err)
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("file_manager.roots is required when the file manager is enabled")
+	}
+	fs, err := sandbox.New(roots)
 	if err != nil {
-		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error reading file: %v", err))
-	} else {
-		s.publish(m.Reply, content, code.SUCCESS)
+		return err
+	}
+	f := &fileManager{fs: fs, maxRead: s.Config.GetInt64("file_manager.max_read"), audit: log.Logger}
+	if f.maxRead <= 0 {
+		f.maxRead = defaultFileMaxRead
 	}
+	if path := s.Config.GetString("file_manager.audit_log"); path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return fmt.Errorf("failed to open the file manager audit log: %v", err)
+		}
+		f.audit = zerolog.New(file).With().Timestamp().Logger()
+	}
+	s.files = f
+	log.Info().Msgf("bios file manager enabled, roots: %v", fs.RootNames())
+	return nil
 }
 
-func (s *Service) handleMakeDir(m *nats.Msg) {
-	cmd, err := s.DecodeCommand(m)
+func (s *Service) filesSubscribe() error {
+	err := s.addNatsSubscribe(s.biosSubjectBuilder.BuildSubject("get", "system", "files.*"), s.handleFilesGet)
 	if err != nil {
-		s.handleError(m.Reply, code.ERROR, err.Error())
-		return
+		return err
 	}
+	return s.addNatsSubscribe(s.biosSubjectBuilder.BuildSubject("post", "system", "files.*"), s.handleFilesPost)
+}
 
-	path, err := s.GetCommandValue(cmd, "path")
-	if err != nil {
-		s.handleError(m.Reply, code.InvalidParams, err.Error())
-		return
+func (s *Service) handleFilesGet(m *nats.Msg) {
+	actions := map[string]func(*FileRequest) (any, error){
+		"roots":  s.fileRoots,
+		"list":   s.fileList,
+		"stat":   s.fileStat,
+		"read":   s.fileRead,
+		"sha256": s.fileSHA256,
 	}
+	s.handleFiles(m, actions)
+}
 
-	err = s.MakeDir(path)
-	if err != nil {
-		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error creating directory: %v", err))
-	} else {
-		s.publish(m.Reply, "Directory created", code.SUCCESS)
+func (s *Service) handleFilesPost(m *nats.Msg) {
+	actions := map[string]func(*FileRequest) (any, error){
+		"write":  s.fileWrite,
+		"mkdir":  s.fileMkdir,
+		"chmod":  s.fileChmod,
+		"move":   s.fileMove,
+		"delete": s.fileDelete,
+		"zip":    s.fileZip,
+		"unzip":  s.fileUnzip,
 	}
+	s.handleFiles(m, actions)
 }
 
-func (s *Service) handleDeleteDir(m *nats.Msg) {
-	cmd, err := s.DecodeCommand(m)
-	if err != nil {
-		s.handleError(m.Reply, code.ERROR, err.Error())
+func (s *Service) handleFiles(m *nats.Msg, actions map[string]func(*FileRequest) (any, error)) {
+	subjectParts := strings.Split(m.Subject, ".")
+	action := subjectParts[len(subjectParts)-1]
+	handler, ok := actions[action]
+	if !ok {
+		s.handleError(m.Reply, code.UnknownCommand, fmt.Sprintf("Unknown files action: %s", action))
 		return
 	}
-
-	path, err := s.GetCommandValue(cmd, "path")
+	req := &FileRequest{}
+	if len(m.Data) > 0 {
+		if err := json.Unmarshal(m.Data, req); err != nil {
+			s.handleError(m.Reply, code.InvalidParams, fmt.Sprintf("Invalid JSON format: %v", err))
+			return
+		}
+	}
+	if req.DestRoot == "" {
+		req.DestRoot = req.Root
+	}
+	result, err := handler(req)
+	s.files.auditLog(m, action, req, err)
 	if err != nil {
-		s.handleError(m.Reply, code.InvalidParams, err.Error())
+		s.handleError(m.Reply, fileErrorCode(err), err.Error())
 		return
 	}
+	s.publishResponse(m, result, code.SUCCESS)
+}
 
-	err = s.DeleteDir(path)
+// auditLog logs every file manager request with who sent it, the user is set by nats_auth
+func (f *fileManager) auditLog(m *nats.Msg, action string, req *FileRequest, err error) {
+	event := f.audit.Info()
 	if err != nil {
-		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error deleting directory: %v", err))
-	} else {
-		s.publish(m.Reply, "Directory deleted", code.SUCCESS)
+		event = f.audit.Warn().Str("error", err.Error())
+	}
+	event = event.Str("audit", "files").Str("action", action).Str("root", req.Root).Str("path", req.Path)
+	if req.Dest != "" {
+		event = event.Str("destRoot", req.DestRoot).Str("dest", req.Dest)
 	}
+	if m.Header != nil && m.Header.Get(natsauth.HeaderUser) != "" {
+		event = event.Str("user", m.Header.Get(natsauth.HeaderUser)).Str("role", m.Header.Get(natsauth.HeaderRole))
+	}
+	event.Msg("file manager request")
 }
 
-func (s *Service) handleZipFolder(m *nats.Msg) {
-	cmd, err := s.DecodeCommand(m)
-	if err != nil {
-		s.handleError(m.Reply, code.ERROR, err.Error())
-		return
+func fileErrorCode(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return code.NotFound
+	case os.IsPermission(err):
+		return code.Forbidden
+	case errors.Is(err, sandbox.ErrOutsideRoot), errors.Is(err, sandbox.ErrUnknownRoot):
+		return code.InvalidParams
 	}
+	return code.ERROR
+}
 
-	srcDir, err := s.GetCommandValue(cmd, "srcDir")
-	if err != nil {
-		s.handleError(m.Reply, code.InvalidParams, err.Error())
-		return
+func fileMode(mode string, defaultMode os.FileMode) (os.FileMode, error) {
+	if mode == "" {
+		return defaultMode, nil
 	}
-
-	dstZip, err := s.GetCommandValue(cmd, "dstZip")
-	if err != nil {
-		s.handleError(m.Reply, code.InvalidParams, err.Error())
-		return
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, fmt.Errorf("invalid mode %s, expected octal permissions eg; 0644", mode)
 	}
+	return os.FileMode(value), nil
+}
 
-	err = s.ZipFolder(srcDir, dstZip)
-	if err != nil {
-		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error zipping folder: %v", err))
-	} else {
-		s.publish(m.Reply, "Folder zipped", code.SUCCESS)
-	}
+func (s *Service) fileRoots(_ *FileRequest) (any, error) {
+	return s.files.fs.Roots(), nil
 }
 
-func (s *Service) handleUnzipFolder(m *nats.Msg) {
-	cmd, err := s.DecodeCommand(m)
+func (s *Service) fileList(req *FileRequest) (any, error) {
+	return s.files.fs.List(req.Root, req.Path)
+}
+
+func (s *Service) fileStat(req *FileRequest) (any, error) {
+	return s.files.fs.Stat(req.Root, req.Path)
+}
+
+func (s *Service) fileRead(req *FileRequest) (any, error) {
+	length := req.Length
+	if length <= 0 || length > s.files.maxRead {
+		length = s.files.maxRead
+	}
+	data, size, err := s.files.fs.Read(req.Root, req.Path, req.Offset, length)
 	if err != nil {
-		s.handleError(m.Reply, code.ERROR, err.Error())
-		return
+		return nil, err
 	}
+	return FileContent{Path: req.Path, Offset: req.Offset, Length: len(data), Size: size, Data: base64.StdEncoding.EncodeToString(data)}, nil
+}
 
-	srcZip, err := s.GetCommandValue(cmd, "srcZip")
+func (s *Service) fileSHA256(req *FileRequest) (any, error) {
+	sum, err := s.files.fs.SHA256(req.Root, req.Path)
 	if err != nil {
-		s.handleError(m.Reply, code.InvalidParams, err.Error())
-		return
+		return nil, err
 	}
+	return map[string]string{"path": req.Path, "sha256": sum}, nil
+}
 
-	destDir, err := s.GetCommandValue(cmd, "destDir")
+func (s *Service) fileWrite(req *FileRequest) (any, error) {
+	data, err := base64.StdEncoding.DecodeString(req.Data)
 	if err != nil {
-		s.handleError(m.Reply, code.InvalidParams, err.Error())
-		return
+		return nil, fmt.Errorf("invalid base64 data: %v", err)
 	}
-
-	err = s.UnzipFolder(srcZip, destDir)
+	mode, err := fileMode(req.Mode, 0644)
 	if err != nil {
-		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error unzipping folder: %v", err))
-	} else {
-		s.publish(m.Reply, "Folder unzipped", code.SUCCESS)
+		return nil, err
 	}
+	return s.files.fs.Write(req.Root, req.Path, data, req.Append, mode)
 }
 
-// ReadFile reads the contents of a file
-func (s *Service) ReadFile(path string) (string, error) {
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return "", err
+func (s *Service) fileMkdir(req *FileRequest) (any, error) {
+	if err := s.files.fs.Mkdir(req.Root, req.Path); err != nil {
+		return nil, err
 	}
-	return string(data), nil
+	return Message{"Directory created"}, nil
 }
 
-// MakeDir creates a new directory
-func (s *Service) MakeDir(path string) error {
-	return os.MkdirAll(path, os.ModePerm)
+func (s *Service) fileChmod(req *FileRequest) (any, error) {
+	if req.Mode == "" {
+		return nil, fmt.Errorf("mode is required, eg; 0644")
+	}
+	mode, err := fileMode(req.Mode, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.files.fs.Chmod(req.Root, req.Path, mode); err != nil {
+		return nil, err
+	}
+	return Message{"Mode changed"}, nil
 }
 
-// DeleteDir deletes an existing directory
-func (s *Service) DeleteDir(path string) error {
-	return os.RemoveAll(path)
+func (s *Service) fileMove(req *FileRequest) (any, error) {
+	if req.Dest == "" {
+		return nil, fmt.Errorf("dest is required")
+	}
+	if err := s.files.fs.Move(req.Root, req.Path, req.DestRoot, req.Dest); err != nil {
+		return nil, err
+	}
+	return Message{"Moved"}, nil
 }
 
-// ZipFolder zips the contents of a folder
-func (s *Service) ZipFolder(srcDir, dstZip string) error {
-	zipFile, err := os.Create(dstZip)
-	if err != nil {
-		return err
+func (s *Service) fileDelete(req *FileRequest) (any, error) {
+	if err := s.files.fs.Delete(req.Root, req.Path, req.Recursive); err != nil {
+		return nil, err
 	}
-	defer zipFile.Close()
-
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
-
-	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		relPath := strings.TrimPrefix(path, filepath.Dir(srcDir)+"/")
-		zipFile, err := zipWriter.Create(relPath)
-		if err != nil {
-			return err
-		}
-
-		fileContent, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer fileContent.Close()
-
-		_, err = io.Copy(zipFile, fileContent)
-		return err
-	})
-	return err
+	return Message{"Deleted"}, nil
 }
 
-// UnzipFolder extracts a zip archive into a destination folder
-func (s *Service) UnzipFolder(srcZip, destDir string) error {
-	zipReader, err := zip.OpenReader(srcZip)
-	if err != nil {
-		return err
+func (s *Service) fileZip(req *FileRequest) (any, error) {
+	if req.Dest == "" {
+		return nil, fmt.Errorf("dest is required, eg; backup/app.zip")
 	}
-	defer zipReader.Close()
-
-	for _, file := range zipReader.File {
-		fpath := filepath.Join(destDir, file.Name)
-		if file.FileInfo().IsDir() {
-			os.MkdirAll(fpath, os.ModePerm)
-			continue
-		}
-
-		if err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return err
-		}
-
-		destFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			return err
-		}
-
-		fileInArchive, err := file.Open()
-		if err != nil {
-			destFile.Close()
-			return err
-		}
+	if err := s.files.fs.Zip(req.Root, req.Path, req.DestRoot, req.Dest); err != nil {
+		return nil, err
+	}
+	return Message{"Zipped"}, nil
+}
 
-		_, err = io.Copy(destFile, fileInArchive)
-		destFile.Close()
-		fileInArchive.Close()
-		if err != nil {
-			return err
-		}
+func (s *Service) fileUnzip(req *FileRequest) (any, error) {
+	if err := s.files.fs.Unzip(req.Root, req.Path, req.DestRoot, req.Dest); err != nil {
+		return nil, err
 	}
-	return nil
+	return Message{"Unzipped"}, nil
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/natsauth"
+	"github.com/NubeDev/flexy/utils/subjects"
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+/*
+The HTTP gateway turns /api/proxy/<subject/with/slashes> into a NATS request. Every request needs a JWT or API key
+(the same jwt_secret and api_keys as nats_auth) and the subject must match the allowlist of the caller's role.
+
+curl -X POST -H "Authorization: Bearer change-me" http://localhost:5000/api/proxy/abc/get/system/systemctl/status -d '{"name": "ufw"}'
+*/
+
+// gatewayAnonymousRole is the role of the requests when web_server.auth is disabled
+const gatewayAnonymousRole = "anonymous"
+
+const (
+	gatewayIdentityKey = "identity"
+	gatewaySubjectKey  = "subject"
+)
+
+type gateway struct {
+	auth   *natsauth.Authorizer // nil when web_server.auth is disabled
+	prefix string               // the subject_prefix, removed from a subject before it's checked against the allowlists
+	allow  map[string][]string  // subject patterns by role
+	read   map[string][]string  // the patterns each role can subscribe to over /api/ws and /api/sse
+	audit  zerolog.Logger
+	stream streamOpts
+	lock   sync.Mutex
+	subs   map[string]int // live websocket/sse subscriptions by user
+}
+
+// gatewayInit loads the gateway auth, allowlist and audit log from the config
+func (s *Service) gatewayInit() error {
+	g := &gateway{prefix: s.biosSubjectBuilder.Prefix, allow: map[string][]string{}, read: map[string][]string{}, subs: map[string]int{}}
+	if err := s.Config.UnmarshalKey("web_server.allow", &g.allow); err != nil {
+		return fmt.Errorf("invalid web_server.allow in config: %v", err)
+	}
+	if err := s.Config.UnmarshalKey("web_server.subscribe", &g.read); err != nil {
+		return fmt.Errorf("invalid web_server.subscribe in config: %v", err)
+	}
+	for key, allow := range map[string]map[string][]string{"web_server.allow": g.allow, "web_server.subscribe": g.read} {
+		for role, patterns := range allow {
+			for _, pattern := range patterns {
+				if !validGatewayPattern(pattern) {
+					return fmt.Errorf("invalid %s pattern %q for role %s", key, pattern, role)
+				}
+			}
+		}
+	}
+
+	if !s.Config.IsSet("web_server.auth") || s.Config.GetBool("web_server.auth") {
+		var apiKeys []natsauth.APIKey
+		if err := s.Config.UnmarshalKey("nats_auth.api_keys", &apiKeys); err != nil {
+			return err
+		}
+		if err := s.jwtSecretInit("web_server.auth"); err != nil {
+			return err
+		}
+		auth, err := natsauth.NewAuthenticator(apiKeys)
+		if err != nil {
+			return err
+		}
+		g.auth = auth
+	} else {
+		log.Warn().Msgf("web_server.auth is disabled, gateway requests use the %s role", gatewayAnonymousRole)
+	}
+
+	if err := s.Config.UnmarshalKey("web_server.stream", &g.stream); err != nil {
+		return fmt.Errorf("invalid web_server.stream in config: %v", err)
+	}
+	g.stream = g.stream.withDefaults()
+
+	g.audit = log.Logger
+	if path := s.Config.GetString("web_server.audit_log"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return fmt.Errorf("failed to open the audit log: %v", err)
+		}
+		g.audit = zerolog.New(f).With().Timestamp().Logger()
+	}
+	s.gateway = g
+	return nil
+}
+
+// authenticate checks the JWT or API key on the request, like the ros JWTHandler
+func (g *gateway) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if g.auth == nil {
+			c.Set(gatewayIdentityKey, &natsauth.Identity{Username: gatewayAnonymousRole, RoleKey: gatewayAnonymousRole})
+			c.Next()
+			return
+		}
+		token := c.GetHeader(natsauth.HeaderToken)
+		if token == "" {
+			token = c.Query("token")
+		}
+		identity, responseCode, err := g.auth.AuthenticateCredentials(natsauth.Credentials{
+			APIKey:        c.GetHeader(natsauth.HeaderAPIKey),
+			Authorization: c.GetHeader(natsauth.HeaderAuthorization),
+			Token:         token,
+		})
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code": responseCode,
+				"msg":  code.GetMsg(responseCode),
+				"data": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+		c.Set(gatewayIdentityKey, identity)
+		c.Next()
+	}
+}
+
+// allowed reports whether the identity's role can send a request to subject, or subscribe to it when it has
+// wildcards (every subject it matches must be allowed). Admins can use any subject.
+func (g *gateway) allowed(identity *natsauth.Identity, subject string) bool {
+	return matchAllowlist(g.allow, identity, subjects.TrimPrefix(g.prefix, subject))
+}
+
+// subscribeAllowed checks a subscription against web_server.subscribe
+func (g *gateway) subscribeAllowed(identity *natsauth.Identity, subject string) bool {
+	return matchAllowlist(g.read, identity, subjects.TrimPrefix(g.prefix, subject))
+}
+
+func matchAllowlist(allow map[string][]string, identity *natsauth.Identity, subject string) bool {
+	if identity.IsAdmin {
+		return true
+	}
+	for _, pattern := range allow[identity.RoleKey] {
+		if subjects.MatchPattern(subject, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize aborts the request when the subject isn't in the allowlist, it returns false if it did
+func (g *gateway) authorize(c *gin.Context, subject string) bool {
+	return g.authorizeList(c, g.allow, subject)
+}
+
+// authorizeSubscribe is authorize for a subscription, checked against web_server.subscribe
+func (g *gateway) authorizeSubscribe(c *gin.Context, subject string) bool {
+	return g.authorizeList(c, g.read, subject)
+}
+
+func (g *gateway) authorizeList(c *gin.Context, allow map[string][]string, subject string) bool {
+	c.Set(gatewaySubjectKey, subject)
+	identity := gatewayIdentity(c)
+	if matchAllowlist(allow, identity, subjects.TrimPrefix(g.prefix, subject)) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"code": code.Forbidden,
+		"msg":  code.GetMsg(code.Forbidden),
+		"data": forbiddenMessage(identity, subject),
+	})
+	c.Abort()
+	return false
+}
+
+func forbiddenMessage(identity *natsauth.Identity, subject string) string {
+	return fmt.Sprintf("[%s] with role [%s] is not allowed to use the subject [%s]", identity.Username, identity.RoleKey, subject)
+}
+
+// acquireSub counts a live subscription for the user, false means the user is at web_server.stream.max_subscriptions
+func (g *gateway) acquireSub(user string) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.subs[user] >= g.stream.MaxSubscriptions {
+		return false
+	}
+	g.subs[user]++
+	return true
+}
+
+func (g *gateway) releaseSub(user string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.subs[user]--
+	if g.subs[user] <= 0 {
+		delete(g.subs, user)
+	}
+}
+
+// auditLog logs every gateway request once it's done, with who sent it and the subject
+func (g *gateway) auditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		event := g.audit.Info().
+			Str("audit", "gateway").
+			Str("method", c.Request.Method).
+			Str("path", c.Request.URL.Path).
+			Str("remote", c.ClientIP()).
+			Int("status", c.Writer.Status()).
+			Dur("latency", time.Since(start))
+		if _, ok := c.Get(gatewayIdentityKey); ok {
+			identity := gatewayIdentity(c)
+			event = event.Str("user", identity.Username).Str("role", identity.RoleKey)
+		}
+		if subject := c.GetString(gatewaySubjectKey); subject != "" {
+			event = event.Str("subject", subject)
+		}
+		event.Msg("gateway request")
+	}
+}
+
+// validGatewayPattern checks a subject pattern has no empty tokens and ">" is only used last
+func validGatewayPattern(pattern string) bool {
+	tokens := strings.Split(pattern, ".")
+	for i, token := range tokens {
+		if token == "" || (token == ">" && i != len(tokens)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// setAuthHeaders copies the request credentials onto the NATS message, so nats_auth checks the same caller
+func setAuthHeaders(c *gin.Context, header nats.Header) {
+	found := false
+	for _, key := range []string{natsauth.HeaderAuthorization, natsauth.HeaderAPIKey, natsauth.HeaderToken} {
+		if value := c.GetHeader(key); value != "" {
+			header.Set(key, value)
+			found = true
+		}
+	}
+	// browsers can't set headers on a websocket or EventSource
+	if token := c.Query("token"); !found && token != "" {
+		header.Set(natsauth.HeaderAuthorization, "Bearer "+token)
+	}
+}
+
+func gatewayIdentity(c *gin.Context) *natsauth.Identity {
+	identity, ok := c.Get(gatewayIdentityKey)
+	if !ok {
+		return &natsauth.Identity{Username: gatewayAnonymousRole, RoleKey: gatewayAnonymousRole}
+	}
+	return identity.(*natsauth.Identity)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/NubeDev/flexy/utils/natsauth"
+)
+
+func TestGatewayAllowedPrefix(t *testing.T) {
+	g := &gateway{
+		prefix: "acme.site-1",
+		allow: map[string][]string{
+			"operator": {"*.get.>", "*.post.apps.>"},
+			"viewer":   {"*.get.>", "global.get.system.ping"},
+		},
+		read: map[string][]string{"viewer": {"*.event.>"}},
+	}
+	tests := []struct {
+		role    string
+		subject string
+		want    bool
+	}{
+		{"viewer", "acme.site-1.abc.get.system.ping", true},
+		{"viewer", "acme.site-1.global.get.system.ping", true},
+		{"viewer", "acme.site-1.abc.post.apps.install", false},
+		{"operator", "acme.site-1.abc.post.apps.install", true},
+		{"operator", "other.site.abc.post.apps.install", false},
+	}
+	for _, tt := range tests {
+		identity := &natsauth.Identity{Username: "bob", RoleKey: tt.role}
+		if got := g.allowed(identity, tt.subject); got != tt.want {
+			t.Errorf("%s %s: allowed = %v, want %v", tt.role, tt.subject, got, tt.want)
+		}
+	}
+	// the events keep the bare ids
+	if !g.subscribeAllowed(&natsauth.Identity{RoleKey: "viewer"}, "abc.event.>") {
+		t.Error("viewer should be able to subscribe to the events")
+	}
+}
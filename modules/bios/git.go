@@ -8,6 +8,72 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
+// releaseSourceConfig is a release_sources entry in the config
+type releaseSourceConfig struct {
+	Type  string `mapstructure:"type"` // github, gitea, gitlab or http
+	URL   string `mapstructure:"url"`
+	Token string `mapstructure:"token"`
+}
+
+// releaseSourcesInit checks the release_sources in the config, the sources are created per request with its token
+func (s *Service) releaseSourcesInit() error {
+	if err := s.Config.UnmarshalKey("release_sources", &s.releaseSources); err != nil {
+		return fmt.Errorf("invalid release_sources in config: %v", err)
+	}
+	for name, config := range s.releaseSources {
+		if _, err := githubdownloader.NewReleaseSource(config.Type, config.URL, config.Token); err != nil {
+			return fmt.Errorf("release source %s: %v", name, err)
+		}
+	}
+	s.gitCacheDir = s.Config.GetString("git_cache_dir")
+	if s.gitCacheDir != "" {
+		s.githubDownloader.SetCacheDir(s.gitCacheDir)
+	}
+	s.releaseSource = s.Config.GetString("release_source")
+	if _, ok := s.releaseSources[s.releaseSource]; s.releaseSource != "" && !ok {
+		return fmt.Errorf("release_source %s is not in release_sources", s.releaseSource)
+	}
+	return nil
+}
+
+// gitReleaseSource picks the source of a request: a configured source by name or the bios github downloader. A request
+// can't point bios at a server that isn't in release_sources.
+func (s *Service) gitReleaseSource(decoded *githubdownloader.RepoAsset) (githubdownloader.ReleaseSource, error) {
+	name := decoded.Source
+	if name == "" {
+		name = s.releaseSource
+	}
+	if config, ok := s.releaseSources[name]; ok {
+		token := config.Token
+		if decoded.Token != "" {
+			token = decoded.Token
+		}
+		return s.newReleaseSource(config.Type, config.URL, token)
+	}
+	if decoded.URL != "" {
+		return nil, fmt.Errorf("a url can't be set on a request, add the source to release_sources")
+	}
+	if name != "" && name != githubdownloader.SourceGitHub {
+		return nil, fmt.Errorf("release source %s is not in release_sources", name)
+	}
+	if decoded.Token != "" {
+		s.githubDownloader.UpdateToken(decoded.Token)
+	}
+	return s.githubDownloader, nil
+}
+
+// newReleaseSource creates a source, a github source shares the bios cache dir
+func (s *Service) newReleaseSource(kind, url, token string) (githubdownloader.ReleaseSource, error) {
+	source, err := githubdownloader.NewReleaseSource(kind, url, token)
+	if err != nil {
+		return nil, err
+	}
+	if gd, ok := source.(*githubdownloader.GitHubDownloader); ok && s.gitCacheDir != "" {
+		gd.SetCacheDir(s.gitCacheDir)
+	}
+	return source, nil
+}
+
 func (s *Service) DecodeGitRepoAsset(m *nats.Msg) (*githubdownloader.RepoAsset, error) {
 	var cmd githubdownloader.RepoAsset
 	if err := json.Unmarshal(m.Data, &cmd); err != nil {
@@ -52,17 +118,18 @@ func (s *Service) gitDownloadAsset(m *nats.Msg) {
 		return
 	}
 	if decoded.Arch == "" {
-		s.handleError(m.Reply, code.InvalidParams, "arch is required")
-		return
+		decoded.Arch = s.appManager.Arch()
 	}
-	if decoded.Token != "" {
-		s.githubDownloader.UpdateToken(decoded.Token)
+	source, err := s.gitReleaseSource(decoded)
+	if err != nil {
+		s.handleError(m.Reply, code.InvalidParams, err.Error())
+		return
 	}
-	err = s.githubDownloader.DownloadReleaseByArchVersion(decoded.Owner, decoded.Repo, decoded.Tag, decoded.Arch, s.gitDownloadPath, nil)
+	result, err := githubdownloader.DownloadByArchVersion(source, decoded.Owner, decoded.Repo, decoded.Tag, decoded.Arch, s.gitDownloadPath)
 	if err != nil {
 		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error downloading: %s err: %v", decoded.Repo, err))
 	} else {
-		s.publish(m.Reply, fmt.Sprintf("downlaoded %s%s", decoded.Repo, s.gitDownloadPath), code.SUCCESS)
+		s.publishResponse(m, result, code.SUCCESS)
 	}
 }
 
@@ -84,11 +151,13 @@ func (s *Service) gitListAllAssets(m *nats.Msg) {
 		s.handleError(m.Reply, code.InvalidParams, "repo is required")
 		return
 	}
-	if decoded.Token != "" {
-		s.githubDownloader.UpdateToken(decoded.Token)
+	source, err := s.gitReleaseSource(decoded)
+	if err != nil {
+		s.handleError(m.Reply, code.InvalidParams, err.Error())
+		return
 	}
 
-	resp, err := s.githubDownloader.ListAllAssets(decoded.Owner, decoded.Repo, nil)
+	resp, err := source.Assets(decoded.Owner, decoded.Repo)
 	if err != nil {
 		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error installing app: %v", err))
 	} else {
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+	"strings"
+)
+
+/*
+Usage, the kv bucket is on the local broker so apps can watch their config
+
+./nats req abc.get.system.kv.keys '{"bucket": "config"}'
+./nats req abc.get.system.kv.value '{"key": "app-abc.debug"}'
+./nats req abc.get.system.kv.history '{"key": "app-abc.debug"}'
+./nats req abc.post.system.kv.put '{"key": "app-abc.debug", "value": "true"}'
+./nats req abc.post.system.kv.delete '{"key": "app-abc.debug"}'
+*/
+
+type kvStore struct {
+	bucket string
+	client natlib.NatLib
+}
+
+type KVRequest struct {
+	Bucket  string `json:"bucket"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	History uint8  `json:"history"` // Used when creating a bucket
+}
+
+func (s *Service) natsKVInit(natsURL, bucket string, history uint8) error {
+	client := natlib.New(natlib.NewOpts{
+		URL:             natsURL,
+		EnableJetStream: true,
+		Options:         s.natsOptions,
+	})
+	err := client.CreateKVBucket(bucket, &nats.KeyValueConfig{
+		Bucket:  bucket,
+		History: history,
+	})
+	if err != nil {
+		return err
+	}
+	s.kvStore = &kvStore{
+		bucket: bucket,
+		client: client,
+	}
+	log.Info().Msgf("bios kv bucket: %s on: %s", bucket, natsURL)
+	return nil
+}
+
+func (s *Service) decodeKVRequest(m *nats.Msg) (*KVRequest, error) {
+	var decoded KVRequest
+	if len(m.Data) > 0 {
+		if err := json.Unmarshal(m.Data, &decoded); err != nil {
+			return nil, fmt.Errorf("invalid JSON format: %v", err)
+		}
+	}
+	if decoded.Bucket == "" {
+		decoded.Bucket = s.kvStore.bucket
+	}
+	return &decoded, nil
+}
+
+// Central handler for "GET" requests for the kv store
+func (s *Service) handleKVGet(m *nats.Msg) {
+	if s.kvStore == nil {
+		s.handleError(m.Reply, code.InvalidParams, "KV is not enabled in the config file")
+		return
+	}
+	decoded, err := s.decodeKVRequest(m)
+	if err != nil {
+		s.handleError(m.Reply, code.ERROR, err.Error())
+		return
+	}
+	subjectParts := strings.Split(m.Subject, ".")
+	action := subjectParts[len(subjectParts)-1]
+
+	switch action {
+	case "buckets":
+		buckets, err := s.kvStore.client.GetKVBuckets()
+		if err != nil {
+			s.handleError(m.Reply, code.ERROR, err.Error())
+			return
+		}
+		s.publishResponse(m, buckets, code.SUCCESS)
+	case "keys":
+		keys, err := s.kvStore.client.GetKVKeys(decoded.Bucket)
+		if err != nil {
+			s.handleError(m.Reply, code.ERROR, err.Error())
+			return
+		}
+		s.publishResponse(m, keys, code.SUCCESS)
+	case "value":
+		if s.validateField(m.Reply, decoded.Key, "Key is required") == "" {
+			return
+		}
+		entry, err := s.kvStore.client.GetKV(decoded.Bucket, decoded.Key)
+		if err != nil {
+			s.handleError(m.Reply, code.ERROR, err.Error())
+			return
+		}
+		s.publishResponse(m, natlib.NewKVEntry(entry), code.SUCCESS)
+	case "history":
+		if s.validateField(m.Reply, decoded.Key, "Key is required") == "" {
+			return
+		}
+		entries, err := s.kvStore.client.GetKVHistory(decoded.Bucket, decoded.Key)
+		if err != nil {
+			s.handleError(m.Reply, code.ERROR, err.Error())
+			return
+		}
+		out := make([]*natlib.KVEntry, 0, len(entries))
+		for _, entry := range entries {
+			out = append(out, natlib.NewKVEntry(entry))
+		}
+		s.publishResponse(m, out, code.SUCCESS)
+	default:
+		message := fmt.Sprintf("Unknown GET action in kv: %s", action)
+		log.Error().Msg(message)
+		s.handleError(m.Reply, code.UnknownCommand, message)
+	}
+}
+
+// Central handler for "POST" requests for the kv store
+func (s *Service) handleKVPost(m *nats.Msg) {
+	if s.kvStore == nil {
+		s.handleError(m.Reply, code.InvalidParams, "KV is not enabled in the config file")
+		return
+	}
+	decoded, err := s.decodeKVRequest(m)
+	if err != nil {
+		s.handleError(m.Reply, code.ERROR, err.Error())
+		return
+	}
+	subjectParts := strings.Split(m.Subject, ".")
+	action := subjectParts[len(subjectParts)-1]
+
+	switch action {
+	case "create":
+		err := s.kvStore.client.CreateKVBucket(decoded.Bucket, &nats.KeyValueConfig{
+			Bucket:  decoded.Bucket,
+			History: decoded.History,
+		})
+		if err != nil {
+			s.handleError(m.Reply, code.ERROR, err.Error())
+			return
+		}
+		s.publishResponse(m, Message{fmt.Sprintf("Bucket %s created", decoded.Bucket)}, code.SUCCESS)
+	case "put":
+		if s.validateField(m.Reply, decoded.Key, "Key is required") == "" {
+			return
+		}
+		revision, err := s.kvStore.client.PutKV(decoded.Bucket, decoded.Key, []byte(decoded.Value))
+		if err != nil {
+			s.handleError(m.Reply, code.ERROR, err.Error())
+			return
+		}
+		s.publishResponse(m, Message{fmt.Sprintf("Key %s updated to revision %d", decoded.Key, revision)}, code.SUCCESS)
+	case "delete":
+		if s.validateField(m.Reply, decoded.Key, "Key is required") == "" {
+			return
+		}
+		err := s.kvStore.client.DeleteKV(decoded.Bucket, decoded.Key)
+		if err != nil {
+			s.handleError(m.Reply, code.ERROR, err.Error())
+			return
+		}
+		s.publishResponse(m, Message{fmt.Sprintf("Key %s deleted", decoded.Key)}, code.SUCCESS)
+	default:
+		message := fmt.Sprintf("Unknown POST action in kv: %s", action)
+		log.Error().Msg(message)
+		s.handleError(m.Reply, code.UnknownCommand, message)
+	}
+}
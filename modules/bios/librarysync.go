@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+
+	"github.com/NubeDev/flexy/modules/bios/appmanager"
+	"github.com/NubeDev/flexy/utils/events"
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+/*
+Library sync mirrors the app zips of an object store into the app library, so a package put into the store once reaches
+every device watching it. The objects named like an app zip (eg; flexy-app-v1.0.3-amd64.zip) are copied in after the
+sha256 is checked against the object digest, and a deleted object removes the zip of the same name from the library.
+
+jet_stream:
+  library_sync_enable: true
+  library_sync_store: "apps"
+
+./nats object put apps ./flexy-app-v1.0.3-amd64.zip
+./nats sub "abc.event.library.>"
+*/
+
+// LibrarySyncEvent is the data of the library.* events
+type LibrarySyncEvent struct {
+	StoreName  string `json:"storeName"`
+	ObjectName string `json:"objectName"`
+	Name       string `json:"name,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// librarySyncInit watches the store, the objects already in it are synced first
+func (s *Service) librarySyncInit(storeName string) error {
+	store, err := s.natsClient.GetStore(storeName)
+	if err != nil {
+		return err
+	}
+	watcher, err := store.Watch()
+	if err != nil {
+		return err
+	}
+	go func() {
+		for info := range watcher.Updates() {
+			// a nil info marks the end of the objects already in the store
+			if info == nil {
+				log.Info().Msgf("library sync is up to date with store %s", storeName)
+				continue
+			}
+			s.librarySyncObject(storeName, info)
+		}
+	}()
+	log.Info().Msgf("library sync enabled, store: %s", storeName)
+	return nil
+}
+
+func (s *Service) librarySyncObject(storeName string, info *nats.ObjectInfo) {
+	if !appmanager.IsAppFileName(info.Name) || (info.Opts != nil && info.Opts.Link != nil) {
+		return
+	}
+	event := LibrarySyncEvent{StoreName: storeName, ObjectName: info.Name}
+	if info.Deleted {
+		err := s.appManager.RemoveLibraryApp(info.Name)
+		if errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		if err != nil {
+			s.librarySyncFailed(event, err)
+			return
+		}
+		s.events.Publish(events.LibraryPruned, event)
+		return
+	}
+	sum, err := natlib.ObjectDigest(info.Digest)
+	if err != nil {
+		s.librarySyncFailed(event, err)
+		return
+	}
+	// the watch starts with every object in the store, the zips already in the library aren't copied again
+	if current, err := s.appManager.LibraryAppSum(info.Name); err == nil && bytes.Equal(current, sum) {
+		return
+	}
+	app, err := s.storeToLibrary(storeName, info.Name)
+	if err != nil {
+		s.librarySyncFailed(event, err)
+		return
+	}
+	event.Name, event.Version = app.Name, app.Version
+	log.Info().Msgf("library sync copied %s from store %s", info.Name, storeName)
+	s.events.Publish(events.LibrarySynced, event)
+}
+
+func (s *Service) librarySyncFailed(event LibrarySyncEvent, err error) {
+	log.Error().Msgf("library sync of %s failed: %v", event.ObjectName, err)
+	event.Error = err.Error()
+	s.events.Publish(events.LibrarySyncFailed, event)
+}
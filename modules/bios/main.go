@@ -40,9 +40,20 @@ func (s *Service) InitNATS(natsURL string) error {
 }
 
 func (s *Service) StartGinServer() {
+	if s.Config.IsSet("web_server.enable") && !s.Config.GetBool("web_server.enable") {
+		fmt.Println("Gin server is disabled (web_server.enable)")
+		return
+	}
+	if err := s.gatewayInit(); err != nil {
+		fmt.Printf("Failed to start Gin server: %v\n", err)
+		os.Exit(1)
+	}
 	r := s.SetupGin() // Setup Gin server with routes
 
-	port := s.Config.GetString("gin_port")
+	port := s.Config.GetString("web_server.port")
+	if port == "" {
+		port = s.Config.GetString("gin_port") // the old key
+	}
 	if port == "" {
 		port = "8080" // Default to port 8080 if not specified
 	}
@@ -6,6 +6,7 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
 	"strings"
+	"time"
 )
 
 // StartService starts the NATS subscription and listens for commands
@@ -13,13 +14,24 @@ import (
 func (s *Service) StartService() error {
 	var err error
 
-	go s.natsForwarder(s.natsConn, fmt.Sprintf("nats://127.0.0.1:%d", s.Config.GetInt("proxy_port")))
+	err = s.natsProxyInit()
+	if err != nil {
+		return err
+	}
+
+	// answer the broadcast ping for every level of the subject prefix, so a whole site or customer can be pinged
+	for _, subject := range s.biosSubjectBuilder.GroupSubjects("get", "system", "ping") {
+		_, err = s.natsConn.Subscribe(subject, s.respondPing)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.addNatsSubscribe(s.biosSubjectBuilder.BuildSubject("get", "system", "help"), s.handleHelp)
+	if err != nil {
+		return err
+	}
 
-	//err = s.natsClient.SubscribeWithRespond(s.biosSubjectBuilder.GlobalSubject("get", "system", "ping"), s.handlePing, &natlib.Opts{})
-	//if err != nil {
-	//	return err
-	//}
-	//
 	//err = s.natsClient.SubscribeWithRespond(s.biosSubjectBuilder.BuildSubject("get", "system", "ping"), s.handlePing, &natlib.Opts{})
 	//if err != nil {
 	//	return err
@@ -60,6 +72,76 @@ func (s *Service) StartService() error {
 		return err
 	}
 
+	if s.Config.GetBool("jet_stream.library_sync_enable") {
+		storeName := s.Config.GetString("jet_stream.library_sync_store")
+		if storeName == "" && s.natsStore != nil {
+			storeName = s.natsStore.name
+		}
+		if storeName == "" {
+			return fmt.Errorf("jet_stream.library_sync_store is required when the store isn't enabled")
+		}
+		if err := s.natsStoreInit(storeName); err != nil {
+			return err
+		}
+		if err := s.librarySyncInit(storeName); err != nil {
+			return fmt.Errorf("failed to start the library sync: %v", err)
+		}
+	}
+
+	if s.events != nil {
+		// ros and the apps publish their events on the local broker, when that's
+		// the same broker as bios the stream already has them
+		proxyPort := s.Config.GetInt("proxy_port")
+		if !strings.HasSuffix(s.natsConn.ConnectedAddr(), fmt.Sprintf(":%d", proxyPort)) {
+			err = s.eventsRelay(s.localBrokerURL())
+			if err != nil {
+				return err
+			}
+		}
+		interval := s.Config.GetDuration("jet_stream.events_monitor_interval")
+		if interval <= 0 {
+			interval = 30 * time.Second
+		}
+		go s.servicesMonitor(interval)
+	}
+
+	if s.updates.config.Enable {
+		go s.updatesMonitor()
+	}
+
+	// Proxy routing table handlers
+	err = s.addNatsSubscribe(s.biosSubjectBuilder.BuildSubject("get", "system", "proxy.*"), s.handleProxyGet)
+	if err != nil {
+		return err
+	}
+	err = s.addNatsSubscribe(s.biosSubjectBuilder.BuildSubject("post", "system", "proxy.*"), s.handleProxyPost)
+	if err != nil {
+		return err
+	}
+
+	// Chunked transfer handlers
+	err = s.transferSubscribe()
+	if err != nil {
+		return err
+	}
+
+	if s.files != nil {
+		err = s.filesSubscribe()
+		if err != nil {
+			return err
+		}
+	}
+
+	// KV handlers
+	err = s.addNatsSubscribe(s.biosSubjectBuilder.BuildSubject("get", "system", "kv.*"), s.handleKVGet)
+	if err != nil {
+		return err
+	}
+	err = s.addNatsSubscribe(s.biosSubjectBuilder.BuildSubject("post", "system", "kv.*"), s.handleKVPost)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -76,6 +158,14 @@ func (s *Service) handleAppsGet(m *nats.Msg) {
 		s.handleListInstalledApps(m)
 	case "library":
 		s.handleListLibraryApps(m)
+	case "configs":
+		s.handleListAppConfigs(m)
+	case "config":
+		s.handleReadAppConfig(m)
+	case "config-history":
+		s.handleAppConfigHistory(m)
+	case "updates":
+		s.handleUpdatesStatus(m)
 	default:
 		message := fmt.Sprintf("Unknown GET action in apps manager: %s", action)
 		log.Error().Msg(message)
@@ -93,6 +183,12 @@ func (s *Service) handleAppsPost(m *nats.Msg) {
 		s.handleInstallApp(m)
 	case "uninstall":
 		s.handleUninstallApp(m)
+	case "config":
+		s.handleWriteAppConfig(m)
+	case "check-updates":
+		s.handleCheckUpdates(m)
+	case "upgrade":
+		s.handleUpgradeApp(m)
 	default:
 		message := fmt.Sprintf("Unknown POST action in apps manager: %s", action)
 		log.Error().Msg(message)
@@ -132,6 +228,18 @@ func (s *Service) handleGitPost(m *nats.Msg) {
 }
 
 func (s *Service) addNatsSubscribe(subj string, cb nats.MsgHandler) error {
+	if s.natsAuth != nil {
+		cb = s.natsAuth.Handler(cb)
+	}
 	_, err := s.natsConn.Subscribe(subj, cb)
 	return err
 }
+
+// addNatsQueueSubscribe is addNatsSubscribe for a queue group
+func (s *Service) addNatsQueueSubscribe(subj, queue string, cb nats.MsgHandler) error {
+	if s.natsAuth != nil {
+		cb = s.natsAuth.Handler(cb)
+	}
+	_, err := s.natsConn.QueueSubscribe(subj, queue, cb)
+	return err
+}
@@ -2,45 +2,168 @@ package main
 
 import (
 	"fmt"
+	"github.com/NubeDev/flexy/utils/code"
 	"github.com/NubeDev/flexy/utils/natsforwarder"
-	"github.com/NubeDev/flexy/utils/subjects"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
+	"strings"
 	"time"
 )
 
-// natsForwarder
-// will forward a message to ROS or a module
-func (s *Service) natsForwarder(sourceNATS *nats.Conn, targetURL string) {
-	// Connect to the NATS server for the source
-	// Create a forwarder to forward requests to the target NATS server
-	timeout := 5 * time.Second
-	forwarder, err := natsforwarder.NewForwarder(targetURL, timeout)
-	if err != nil {
-		log.Fatal().Msgf("Error creating NATS forwarder: %v", err)
+/*
+Usage, [<prefix>.]<uuid>.proxy.<app-id>.<subject> is forwarded to <subject> on the broker the app is routed to
+
+./nats req abc.get.system.proxy.routes ''
+./nats req abc.get.system.proxy.stats ''
+./nats req abc.post.system.proxy.reload ''
+*/
+
+// ProxyRoute sets the forward timeout for the target subjects matching Subject
+type ProxyRoute struct {
+	Subject string        `mapstructure:"subject"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// proxyNoDefault in proxy_targets.default_url rejects the apps without a route
+const proxyNoDefault = "none"
+
+// natsProxyInit forwards the <uuid>.proxy.> messages from the cloud to ROS or a module
+func (s *Service) natsProxyInit() error {
+	timeout := s.Config.GetDuration("proxy_timeout")
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	s.proxyTable = natsforwarder.NewTable(timeout)
+	s.proxyTable.SetConnectOptions(s.natsOptions...)
+	// the identity headers can only be trusted when nats auth set them
+	s.proxyTable.SetKeepIdentity(s.natsAuth != nil)
+	if err := s.loadProxyRoutes(); err != nil {
+		return err
 	}
-	defer forwarder.Close()
 
-	subjectModules := fmt.Sprintf("%s.proxy.>", s.globalUUID)
-	// Set up a handler to forward requests to the target subject
-	_, err = sourceNATS.QueueSubscribe(subjectModules, "rql_queue", func(m *nats.Msg) {
-		forwardSubject := subjects.GetSubjectParts(m.Subject)
-		if err != nil {
-			log.Info().Msgf("failed to get app-id from subject: %s", m.Subject)
-		}
-		log.Info().Msgf("module foward message subject: %s", forwardSubject)
-		log.Info().Msgf("module foward message data: %s", string(m.Data))
-		err := forwarder.ForwardRequest(m, forwardSubject)
+	prefix := s.biosSubjectBuilder.AddGlobalUUID("proxy.")
+	// Set up a handler to forward requests (or plain publishes) to the target subject, with their headers, nats auth
+	// checks them first
+	err := s.addNatsQueueSubscribe(prefix+">", "rql_queue", func(m *nats.Msg) {
+		appSubject := strings.TrimPrefix(m.Subject, prefix)
+		log.Info().Msgf("module foward message subject: %s", appSubject)
+		err := s.proxyTable.Forward(m, appSubject)
 		if err != nil {
 			log.Error().Msgf("NATS forwarder failed to foward message: %v", err)
-			return
 		}
 	})
 	if err != nil {
-		log.Fatal().Msgf("Error creating NATS forwarder: %v", err)
-		return
+		return err
+	}
+
+	// Bridge local subjects up to the cloud as <prefix>.<uuid>.<subject>
+	bridges := s.Config.GetStringSlice("proxy_bridges")
+	if len(bridges) == 0 {
+		return nil
+	}
+	bridgeURL := s.proxyDefaultURL()
+	if bridgeURL == proxyNoDefault {
+		bridgeURL = s.localBrokerURL()
+	}
+	localNATS, err := nats.Connect(bridgeURL, s.natsOptions...)
+	if err != nil {
+		return err
+	}
+	for _, subject := range bridges {
+		_, err := natsforwarder.Bridge(localNATS, s.natsConn, subject, func(subject string) string {
+			if strings.HasPrefix(subject, s.biosSubjectBuilder.AddGlobalUUID("")) {
+				return subject
+			}
+			return s.biosSubjectBuilder.AddGlobalUUID(subject)
+		})
+		if err != nil {
+			log.Error().Msgf("failed to bridge subject %s: %v", subject, err)
+			continue
+		}
+		log.Info().Msgf("bridging local subject: %s to the cloud", subject)
+	}
+	return nil
+}
+
+func (s *Service) proxyDefaultURL() string {
+	url := s.Config.GetString("proxy_targets.default_url")
+	if url == "" {
+		url = s.localBrokerURL()
 	}
+	return url
+}
+
+// localBrokerURL is the broker ros and the apps are on
+func (s *Service) localBrokerURL() string {
+	return fmt.Sprintf("nats://127.0.0.1:%d", s.Config.GetInt("proxy_port"))
+}
+
+// loadProxyRoutes loads the routing table and timeouts from the config
+func (s *Service) loadProxyRoutes() error {
+	var timeouts []ProxyRoute
+	if err := s.Config.UnmarshalKey("proxy_routes", &timeouts); err != nil {
+		return fmt.Errorf("invalid proxy_routes in config: %v", err)
+	}
+	for _, route := range timeouts {
+		s.proxyTable.SetRouteTimeout(route.Subject, route.Timeout)
+	}
+	var breakerOpts natsforwarder.BreakerOpts
+	if err := s.Config.UnmarshalKey("proxy_breaker", &breakerOpts); err != nil {
+		return fmt.Errorf("invalid proxy_breaker in config: %v", err)
+	}
+	s.proxyTable.SetBreakerOpts(breakerOpts)
+	var routes []natsforwarder.Route
+	if err := s.Config.UnmarshalKey("proxy_targets.apps", &routes); err != nil {
+		return fmt.Errorf("invalid proxy_targets in config: %v", err)
+	}
+	defaultURL := s.proxyDefaultURL()
+	if defaultURL == proxyNoDefault {
+		defaultURL = ""
+	}
+	if err := s.proxyTable.Load(routes, defaultURL); err != nil {
+		return err
+	}
+	log.Info().Msgf("bios proxy loaded %d routes, default: %s", len(routes), defaultURL)
+	return nil
+}
 
-	// Keep the program running
-	select {}
+// Central handler for "GET" requests for the proxy
+func (s *Service) handleProxyGet(m *nats.Msg) {
+	subjectParts := strings.Split(m.Subject, ".")
+	action := subjectParts[len(subjectParts)-1]
+
+	switch action {
+	case "routes":
+		s.publishResponse(m, s.proxyTable.Routes(), code.SUCCESS)
+	case "stats":
+		s.publishResponse(m, s.proxyTable.Stats(), code.SUCCESS)
+	default:
+		message := fmt.Sprintf("Unknown GET action in proxy: %s", action)
+		log.Error().Msg(message)
+		s.handleError(m.Reply, code.UnknownCommand, message)
+	}
+}
+
+// Central handler for "POST" requests for the proxy
+func (s *Service) handleProxyPost(m *nats.Msg) {
+	subjectParts := strings.Split(m.Subject, ".")
+	action := subjectParts[len(subjectParts)-1]
+
+	switch action {
+	case "reload":
+		// re-read the config file, so route changes don't need a restart
+		if err := s.Config.ReadInConfig(); err != nil {
+			s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error reading config: %v", err))
+			return
+		}
+		if err := s.loadProxyRoutes(); err != nil {
+			s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error loading proxy routes: %v", err))
+			return
+		}
+		s.publishResponse(m, s.proxyTable.Routes(), code.SUCCESS)
+	default:
+		message := fmt.Sprintf("Unknown POST action in proxy: %s", action)
+		log.Error().Msg(message)
+		s.handleError(m.Reply, code.UnknownCommand, message)
+	}
 }
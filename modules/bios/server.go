@@ -1,12 +1,12 @@
 package main
 
 import (
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
+	"github.com/NubeDev/flexy/utils/apispec"
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/natlib"
 	"github.com/gin-gonic/gin"
 	"github.com/nats-io/nats.go"
-	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
@@ -14,10 +14,41 @@ import (
 
 func (s *Service) SetupGin() *gin.Engine {
 	r := gin.Default()
-	// Add the POST proxy route
-	r.POST("/api/upload/:uuid", s.natsProxyFileUpload)
-	r.POST("/api/proxy/*topic", s.natsProxyHandler)
-
+	// the routes are described for /v1/api/system/openapi.json as they're registered
+	s.routeDocs = apispec.NewRoutes()
+	handle := s.routeDocs.Handle
+	// every api request is authenticated and audited, the handlers check the subject allowlist
+	api := r.Group("/api", s.gateway.auditLog(), s.gateway.authenticate())
+	handle(api, http.MethodPost, "/upload/:uuid", apispec.Route{Summary: "Upload a file into the object store in chunks, the StoreName and ObjectName headers are required",
+		Body: &apispec.Schema{Type: "object", Properties: map[string]*apispec.Schema{"file": {Type: "string", Format: "binary"}}}, BodyType: gin.MIMEMultipartPOSTForm},
+		s.natsProxyFileUpload)
+	handle(api, http.MethodPost, "/proxy/*topic", apispec.Route{Summary: "Send a NATS request, the topic is the subject with / for .", Body: &apispec.Schema{},
+		Params: []*apispec.Parameter{{Name: "X-Timeout", In: "header", Schema: &apispec.Schema{Type: "string", Example: "5s"}}}},
+		s.natsProxyHandler)
+	handle(api, http.MethodGet, "/ws", apispec.Route{Summary: "Websocket for NATS subscriptions and requests, the frames are JSON"}, s.streamWebsocket)
+	handle(api, http.MethodGet, "/sse/*subject", apispec.Route{Summary: "Server-sent events of a NATS subscription"}, s.streamSSE)
+	handle(api, http.MethodGet, "/store/:store", apispec.Route{Summary: "List the objects in a store", Response: storeObjectList{},
+		Params: []*apispec.Parameter{
+			{Name: "offset", In: "query", Schema: &apispec.Schema{Type: "integer"}},
+			{Name: "limit", In: "query", Schema: &apispec.Schema{Type: "integer"}},
+		}},
+		s.storeList)
+	handle(api, http.MethodPut, "/store/:store/:object", apispec.Route{Summary: "Stream the body (or the file field of a multipart form) into the store",
+		Body: &apispec.Schema{Type: "string", Format: "binary"}, BodyType: "application/octet-stream", Response: nats.ObjectInfo{},
+		Params: []*apispec.Parameter{
+			{Name: headerObjectDescription, In: "header", Schema: &apispec.Schema{Type: "string"}},
+			{Name: headerObjectMetaPrefix + "Version", In: "header", Description: "any " + headerObjectMetaPrefix + "* header is kept in the metadata", Schema: &apispec.Schema{Type: "string"}},
+		}},
+		s.storePut)
+	handle(api, http.MethodGet, "/store/:store/:object", apispec.Route{Summary: "Stream an object, a single Range is supported",
+		Params: []*apispec.Parameter{{Name: "Range", In: "header", Schema: &apispec.Schema{Type: "string", Example: "bytes=0-1023"}}}},
+		s.storeGet)
+	handle(api, http.MethodHead, "/store/:store/:object", apispec.Route{Summary: "The size and ETag of an object"}, s.storeGet)
+	docs := r.Group("/v1/api/system", s.gateway.auditLog(), s.gateway.authenticate())
+	handle(docs, http.MethodGet, "/openapi.json", apispec.Route{Summary: "OpenAPI document of the routes"}, s.getOpenAPI)
+	handle(docs, http.MethodGet, "/asyncapi.json", apispec.Route{Summary: "AsyncAPI document of the NATS subjects of bios and the installed apps"}, s.getAsyncAPI)
+
+	s.ginRoutes = r.Routes()
 	return r
 }
 
@@ -50,6 +81,11 @@ func (s *Service) natsProxyFileUpload(c *gin.Context) {
 		}
 	}
 
+	subject := fmt.Sprintf("%s.post.system.transfer.store.upload", c.Param("uuid"))
+	if !s.gateway.authorize(c, subject) {
+		return
+	}
+
 	// Get the uploaded file
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -65,16 +101,6 @@ func (s *Service) natsProxyFileUpload(c *gin.Context) {
 	}
 	defer uploadedFile.Close()
 
-	// Read the file contents
-	fileBytes, err := ioutil.ReadAll(uploadedFile)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read the file content"})
-		return
-	}
-
-	// Base64 encode the file data
-	encodedData := base64.StdEncoding.EncodeToString(fileBytes)
-
 	storeName := c.GetHeader("StoreName")
 	if storeName == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "StoreName header is required"})
@@ -86,46 +112,30 @@ func (s *Service) natsProxyFileUpload(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "ObjectName header is required"})
 		return
 	}
-	var storeRequest StoreRequest
-	// Set the action to "add.object" and the base64-encoded data
-	storeRequest.StoreName = storeName
-	storeRequest.ObjectName = objectName
-	storeRequest.Action = "add.object"
-	storeRequest.Data = encodedData
-
-	// Marshal the StoreRequest into JSON
-	storeRequestData, err := json.Marshal(storeRequest)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal request data"})
-		return
-	}
 
-	// Create a NATS message with the store request as data
-	msg := &nats.Msg{
-		Subject: fmt.Sprintf("%s.post.system.store.add.object", c.Param("uuid")),
-		Data:    storeRequestData, // JSON-encoded StoreRequest
-		Header:  nats.Header{},
+	// Send the file in chunks, so it's not limited by the broker max_payload
+	info := &natlib.TransferInfo{
+		Name: file.Filename,
+		Meta: map[string]string{"storeName": storeName, "objectName": objectName},
 	}
-
-	// Optionally, set NATS headers with additional file metadata
-	msg.Header.Set("FileName", file.Filename)
-	msg.Header.Set("FileSize", fmt.Sprintf("%d", file.Size))
-
-	// Send the request to NATS with a timeout
-	response, err := s.natsConn.RequestMsg(msg, timeout)
+	opts := &natlib.TransferOpts{Timeout: timeout, Header: nats.Header{}}
+	setAuthHeaders(c, opts.Header)
+	ack, err := natlib.Upload(s.natsConn, subject, uploadedFile, info, opts)
 	if err != nil {
-		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "NATS request timeout or error", "details": err.Error()})
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "NATS upload failed", "details": err.Error()})
 		return
 	}
 
-	// Return the response from NATS as the Gin response
-	c.Data(http.StatusOK, "application/json", response.Data)
+	c.Data(http.StatusOK, "application/json", natlib.NewResponse(code.SUCCESS, ack.Result).ToJSON())
 }
 
 // Handler for the Gin-to-NATS proxy
 func (s *Service) natsProxyHandler(c *gin.Context) {
 	// Use the helper function to extract topic and timeout
 	topic, timeout := s.extractTopicAndTimeout(c)
+	if !s.gateway.authorize(c, topic) {
+		return
+	}
 
 	// Read the POST request body
 	body, err := c.GetRawData()
@@ -146,6 +156,7 @@ func (s *Service) natsProxyHandler(c *gin.Context) {
 	if debug == "true" {
 		msg.Header.Set("Debug", "debug")
 	}
+	setAuthHeaders(c, msg.Header)
 
 	// Send the message over NATS with a timeout and wait for a response
 	response, err := s.natsConn.RequestMsg(msg, timeout)
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/events"
+	"github.com/NubeDev/flexy/utils/natlib"
 	"github.com/nats-io/nats.go"
+	"io"
 	"strings"
 )
 
@@ -21,6 +26,26 @@ Usage
   "destinationPath": "/home/user/app.zip"
 }'
 
+./nats req abc.post.system.store.add.object \
+'{
+  "storeName": "bios",
+  "objectName": "app.zip",
+  "description": "flexy app",
+  "metadata": {"appID": "flexy-app", "version": "v1.0.3", "arch": "amd64"},
+  "data": "aGVsbG8="
+}'
+
+Replacing an object keeps the old one as <objectName>@<revision>, see jet_stream.store_revisions, an older revision
+is read with the revision field or its full name, eg; app.zip@2
+
+./nats req abc.post.system.store.get.object.info '{"storeName": "bios", "objectName": "app.zip", "revision": 2}'
+./nats req abc.post.system.store.get.object.revisions '{"storeName": "bios", "objectName": "app.zip"}'
+
+./nats req abc.post.system.store.create.store '{"storeName": "apps", "config": {"maxBytes": 1073741824, "ttl": "720h", "storage": "file", "replicas": 1}}'
+./nats req abc.post.system.store.get.store.status '{"storeName": "apps"}'
+./nats req abc.post.system.store.purge '{"storeName": "apps"}'
+./nats req abc.post.system.store.drop.store '{"storeName": "apps"}'
+
 */
 
 func (s *Service) natsStoreInit(storeName string) error {
@@ -33,11 +58,24 @@ func (s *Service) natsStoreInit(storeName string) error {
 }
 
 type StoreRequest struct {
-	Action          string `json:"action"` // e.g., "add.object", "delete.object", "download.object"
-	StoreName       string `json:"storeName"`
-	ObjectName      string `json:"objectName"`
-	DestinationPath string `json:"destinationPath"` // Used for download
-	Data            string `json:"data"`            // Base64-encoded data for add.object
+	Action          string              `json:"action"` // e.g., "add.object", "delete.object", "download.object"
+	StoreName       string              `json:"storeName"`
+	ObjectName      string              `json:"objectName"`
+	DestinationPath string              `json:"destinationPath"`       // Used for download
+	Data            string              `json:"data"`                  // Base64-encoded data for add.object
+	Description     string              `json:"description,omitempty"` // Used for add.object
+	Headers         nats.Header         `json:"headers,omitempty"`     // Used for add.object
+	Metadata        map[string]string   `json:"metadata,omitempty"`    // Used for add.object, eg; appID, version and arch
+	Revision        int                 `json:"revision,omitempty"`    // An older revision for get.object.info and download.object
+	Config          *natlib.StoreConfig `json:"config,omitempty"`      // Used for create.store
+}
+
+// object is the name of the object or of the revision asked for
+func (r StoreRequest) object() string {
+	if r.Revision > 0 {
+		return natlib.RevisionName(r.ObjectName, r.Revision)
+	}
+	return r.ObjectName
 }
 
 func (s *Service) handleStore(m *nats.Msg) {
@@ -59,11 +97,18 @@ func (s *Service) handleStore(m *nats.Msg) {
 		return
 	}
 	actionHandlers := map[string]func(*nats.Msg, StoreRequest){
-		"get.stores":      s.handleGetStores,
-		"get.object":      s.handleGetObject,
-		"add.object":      s.handleAddObject,
-		"delete.object":   s.handleDeleteObject,
-		"download.object": s.handleDownloadObject,
+		"get.stores":           s.handleGetStores,
+		"get.object":           s.handleGetObject,
+		"get.object.info":      s.handleGetObjectInfo,
+		"get.object.revisions": s.handleGetObjectRevisions,
+		"add.object":           s.handleAddObject,
+		"delete.object":        s.handleDeleteObject,
+		"download.object":      s.handleDownloadObject,
+		"create.store":         s.handleCreateStore,
+		"drop.store":           s.handleDropStore,
+		"get.store.status":     s.handleStoreStatus,
+		"stat":                 s.handleStoreStatus,
+		"purge":                s.handlePurgeStore,
 	}
 
 	if handler, found := actionHandlers[decoded.Action]; found {
@@ -117,11 +162,13 @@ func (s *Service) handleAddObject(m *nats.Msg, decoded StoreRequest) {
 		s.handleError(m.Reply, code.InvalidParams, "Invalid base64 data: "+err.Error())
 		return
 	}
-	err = s.natsClient.PutBytes(storeName, objectName, dataBytes, true)
+	meta := &nats.ObjectMeta{Name: objectName, Description: decoded.Description, Headers: decoded.Headers, Metadata: decoded.Metadata}
+	_, err = s.putObject(storeName, meta, bytes.NewReader(dataBytes))
 	if err != nil {
 		s.handleError(m.Reply, code.ERROR, err.Error())
 		return
 	}
+	s.events.Publish(events.StoreObjectAdded, StoreEvent{StoreName: storeName, ObjectName: objectName, Size: len(dataBytes)})
 	out := Message{
 		"Object added successfully",
 	}
@@ -139,6 +186,7 @@ func (s *Service) handleDeleteObject(m *nats.Msg, decoded StoreRequest) {
 		s.handleError(m.Reply, code.ERROR, err.Error())
 		return
 	}
+	s.events.Publish(events.StoreObjectDeleted, StoreEvent{StoreName: storeName, ObjectName: objectName})
 	out := Message{
 		"Object deleted successfully",
 	}
@@ -152,7 +200,7 @@ func (s *Service) handleDownloadObject(m *nats.Msg, decoded StoreRequest) {
 	if storeName == "" || objectName == "" || destinationPath == "" {
 		return
 	}
-	err := s.natsClient.DownloadObject(storeName, objectName, destinationPath)
+	err := s.natsClient.DownloadObject(storeName, decoded.object(), destinationPath)
 	if err != nil {
 		s.handleError(m.Reply, code.ERROR, err.Error())
 		return
@@ -163,6 +211,154 @@ func (s *Service) handleDownloadObject(m *nats.Msg, decoded StoreRequest) {
 	s.publishResponse(m, out, code.SUCCESS)
 }
 
+func (s *Service) handleGetObjectInfo(m *nats.Msg, decoded StoreRequest) {
+	storeName := s.validateField(m.Reply, decoded.StoreName, "Store name is required")
+	objectName := s.validateField(m.Reply, decoded.ObjectName, "Object name is required")
+	if storeName == "" || objectName == "" {
+		return
+	}
+	store, err := s.natsClient.GetStore(storeName)
+	if err != nil {
+		s.handleError(m.Reply, code.ERROR, err.Error())
+		return
+	}
+	info, err := store.GetInfo(decoded.object())
+	if err != nil {
+		s.handleError(m.Reply, code.NotFound, err.Error())
+		return
+	}
+	s.publishResponse(m, info, code.SUCCESS)
+}
+
+// handleGetObjectRevisions replies with the current object (if it wasn't deleted) then its kept revisions, newest first
+func (s *Service) handleGetObjectRevisions(m *nats.Msg, decoded StoreRequest) {
+	storeName := s.validateField(m.Reply, decoded.StoreName, "Store name is required")
+	objectName := s.validateField(m.Reply, decoded.ObjectName, "Object name is required")
+	if storeName == "" || objectName == "" {
+		return
+	}
+	store, err := s.natsClient.GetStore(storeName)
+	if err != nil {
+		s.handleError(m.Reply, code.ERROR, err.Error())
+		return
+	}
+	revisions, err := natlib.ObjectRevisions(store, objectName)
+	if err != nil {
+		s.handleError(m.Reply, code.ERROR, err.Error())
+		return
+	}
+	current, err := store.GetInfo(objectName)
+	switch {
+	case err == nil:
+		revisions = append([]*nats.ObjectInfo{current}, revisions...)
+	case !errors.Is(err, nats.ErrObjectNotFound):
+		s.handleError(m.Reply, code.ERROR, err.Error())
+		return
+	}
+	if revisions == nil {
+		revisions = []*nats.ObjectInfo{}
+	}
+	s.publishResponse(m, revisions, code.SUCCESS)
+}
+
+func (s *Service) handleStoreStatus(m *nats.Msg, decoded StoreRequest) {
+	storeName := s.validateField(m.Reply, decoded.StoreName, "Store name is required")
+	if storeName == "" {
+		return
+	}
+	store, err := s.natsClient.GetStore(storeName)
+	if err != nil {
+		s.handleError(m.Reply, code.NotFound, err.Error())
+		return
+	}
+	status, err := natlib.ObjectStoreStatus(store)
+	if err != nil {
+		s.handleError(m.Reply, code.ERROR, err.Error())
+		return
+	}
+	s.publishResponse(m, status, code.SUCCESS)
+}
+
+func (s *Service) handleCreateStore(m *nats.Msg, decoded StoreRequest) {
+	storeName := s.validateField(m.Reply, decoded.StoreName, "Store name is required")
+	if storeName == "" {
+		return
+	}
+	if _, err := s.natsClient.GetStore(storeName); err == nil {
+		s.handleError(m.Reply, code.InvalidParams, fmt.Sprintf("Store %s already exists", storeName))
+		return
+	}
+	if decoded.Config == nil {
+		decoded.Config = &natlib.StoreConfig{}
+	}
+	config, err := decoded.Config.ObjectStoreConfig(storeName)
+	if err != nil {
+		s.handleError(m.Reply, code.InvalidParams, err.Error())
+		return
+	}
+	if err := s.natsClient.CreateObjectStore(storeName, config); err != nil {
+		s.handleError(m.Reply, code.ERROR, err.Error())
+		return
+	}
+	s.events.Publish(events.StoreCreated, StoreEvent{StoreName: storeName})
+	s.publishResponse(m, Message{fmt.Sprintf("Store %s created successfully", storeName)}, code.SUCCESS)
+}
+
+// handleDropStore deletes a store and its objects, the bios store and the library sync store can't be dropped
+func (s *Service) handleDropStore(m *nats.Msg, decoded StoreRequest) {
+	storeName := s.validateField(m.Reply, decoded.StoreName, "Store name is required")
+	if storeName == "" {
+		return
+	}
+	if s.storeInUse(storeName) {
+		s.handleError(m.Reply, code.InvalidParams, fmt.Sprintf("Store %s is used by bios and can't be dropped", storeName))
+		return
+	}
+	if err := s.natsClient.DropStore(storeName); err != nil {
+		s.handleError(m.Reply, code.ERROR, err.Error())
+		return
+	}
+	s.events.Publish(events.StoreDropped, StoreEvent{StoreName: storeName})
+	s.publishResponse(m, Message{fmt.Sprintf("Store %s dropped successfully", storeName)}, code.SUCCESS)
+}
+
+// handlePurgeStore deletes every object in a store, the store and its config are kept
+func (s *Service) handlePurgeStore(m *nats.Msg, decoded StoreRequest) {
+	storeName := s.validateField(m.Reply, decoded.StoreName, "Store name is required")
+	if storeName == "" {
+		return
+	}
+	if _, err := s.natsClient.GetStore(storeName); err != nil {
+		s.handleError(m.Reply, code.NotFound, err.Error())
+		return
+	}
+	if err := s.natsClient.PurgeStore(storeName); err != nil {
+		s.handleError(m.Reply, code.ERROR, err.Error())
+		return
+	}
+	s.events.Publish(events.StorePurged, StoreEvent{StoreName: storeName})
+	s.publishResponse(m, Message{fmt.Sprintf("Store %s purged successfully", storeName)}, code.SUCCESS)
+}
+
+func (s *Service) storeInUse(storeName string) bool {
+	if storeName == s.natsStore.name {
+		return true
+	}
+	if s.Config.GetBool("jet_stream.library_sync_enable") {
+		return storeName == s.Config.GetString("jet_stream.library_sync_store")
+	}
+	return false
+}
+
+// putObject puts an object into a store, the object it replaces is kept as a revision, see jet_stream.store_revisions
+func (s *Service) putObject(storeName string, meta *nats.ObjectMeta, r io.Reader) (*nats.ObjectInfo, error) {
+	store, err := s.natsClient.GetStore(storeName)
+	if err != nil {
+		return nil, err
+	}
+	return natlib.PutObject(store, meta, r, s.natsStore.revisions)
+}
+
 func (s *Service) validateField(reply string, field, errorMsg string) string {
 	if field == "" {
 		s.handleError(reply, code.InvalidParams, errorMsg)
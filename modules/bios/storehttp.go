@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/events"
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+)
+
+/*
+The object store over http, the body is streamed in and out of JetStream so objects aren't held in memory.
+The allowlist subjects are the NATS store actions, a PUT is <uuid>.post.system.store.add.object and the reads use the
+get action so a role with *.get.> can read, <uuid>.get.system.store.get.object (list) and download.object
+
+curl -X PUT -H "X-API-Key: change-me" --data-binary @app.zip http://localhost:5000/api/store/bios/app.zip
+curl -X PUT -H "X-API-Key: change-me" -F file=@app.zip http://localhost:5000/api/store/bios/app.zip
+curl -X PUT -H "X-API-Key: change-me" -H "X-Object-Description: flexy app" -H "X-Object-Meta-Version: v1.0.3" \
+  --data-binary @app.zip http://localhost:5000/api/store/bios/app.zip
+curl -H "X-API-Key: change-me" -H "Range: bytes=0-1023" http://localhost:5000/api/store/bios/app.zip
+curl -H "X-API-Key: change-me" "http://localhost:5000/api/store/bios?offset=0&limit=50"
+*/
+
+const (
+	storeListLimit    = 100
+	storeListMaxLimit = 1000
+
+	headerObjectDescription = "X-Object-Description"
+	headerObjectMetaPrefix  = "X-Object-Meta-"
+)
+
+var errInvalidRange = errors.New("invalid range")
+
+// storeObjectList is a page of the objects in a store
+type storeObjectList struct {
+	Store   string             `json:"store"`
+	Total   int                `json:"total"`
+	Offset  int                `json:"offset"`
+	Limit   int                `json:"limit"`
+	Objects []*nats.ObjectInfo `json:"objects"`
+}
+
+// storeHTTP checks the store is enabled and the method and action are allowed, it returns the object store or nil if
+// it aborted
+func (s *Service) storeHTTP(c *gin.Context, method, action string) nats.ObjectStore {
+	if !s.gateway.authorize(c, s.biosSubjectBuilder.BuildSubject(method, "system", "store."+action)) {
+		return nil
+	}
+	if s.natsStore == nil {
+		storeError(c, http.StatusServiceUnavailable, code.ServiceUnavailable, "Store is not enabled in the config file")
+		return nil
+	}
+	store, err := s.natsClient.GetStore(c.Param("store"))
+	if err != nil {
+		if errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrBucketNotFound) {
+			storeError(c, http.StatusNotFound, code.NotFound, fmt.Sprintf("store %s not found", c.Param("store")))
+			return nil
+		}
+		storeError(c, http.StatusInternalServerError, code.ERROR, err.Error())
+		return nil
+	}
+	return store
+}
+
+// storeList handles GET /api/store/:store, the objects are paged with ?offset= and ?limit=
+func (s *Service) storeList(c *gin.Context) {
+	offset, err := queryInt(c, "offset", 0)
+	if err != nil || offset < 0 {
+		storeError(c, http.StatusBadRequest, code.InvalidParams, "offset must be a positive number")
+		return
+	}
+	limit, err := queryInt(c, "limit", storeListLimit)
+	if err != nil || limit <= 0 || limit > storeListMaxLimit {
+		storeError(c, http.StatusBadRequest, code.InvalidParams, fmt.Sprintf("limit must be between 1 and %d", storeListMaxLimit))
+		return
+	}
+	store := s.storeHTTP(c, "get", "get.object")
+	if store == nil {
+		return
+	}
+	objects, err := store.List()
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		storeError(c, http.StatusInternalServerError, code.ERROR, err.Error())
+		return
+	}
+	page := storeObjectList{Store: c.Param("store"), Total: len(objects), Offset: offset, Limit: limit, Objects: []*nats.ObjectInfo{}}
+	if offset < len(objects) {
+		page.Objects = objects[offset:min(offset+limit, len(objects))]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": code.SUCCESS,
+		"msg":  code.GetMsg(code.SUCCESS),
+		"data": page,
+	})
+}
+
+// storePut handles PUT /api/store/:store/:object, the body is either the raw object or a multipart form with a file field
+func (s *Service) storePut(c *gin.Context) {
+	store := s.storeHTTP(c, "post", "add.object")
+	if store == nil {
+		return
+	}
+	body, err := storeBody(c.Request)
+	if err != nil {
+		storeError(c, http.StatusBadRequest, code.InvalidParams, err.Error())
+		return
+	}
+	objectName := c.Param("object")
+	meta := storeObjectMeta(c.Request.Header, objectName)
+	info, err := natlib.PutObject(store, meta, body, s.natsStore.revisions)
+	if err != nil {
+		storeError(c, http.StatusInternalServerError, code.ERROR, err.Error())
+		return
+	}
+	s.events.Publish(events.StoreObjectAdded, StoreEvent{StoreName: c.Param("store"), ObjectName: objectName, Size: int(info.Size)})
+	c.Header("ETag", objectETag(info))
+	c.JSON(http.StatusOK, gin.H{
+		"code": code.SUCCESS,
+		"msg":  code.GetMsg(code.SUCCESS),
+		"data": info,
+	})
+}
+
+// storeObjectMeta is the meta of an object put over http, the X-Object-Meta-* headers are the metadata with
+// lower case keys, eg; X-Object-Meta-Version: v1.0.3
+func storeObjectMeta(header http.Header, objectName string) *nats.ObjectMeta {
+	meta := &nats.ObjectMeta{Name: objectName, Description: header.Get(headerObjectDescription)}
+	if contentType := header.Get("Content-Type"); contentType != "" && !strings.HasPrefix(contentType, "multipart/") {
+		meta.Headers = nats.Header{"Content-Type": []string{contentType}}
+	}
+	for key, values := range header {
+		if strings.HasPrefix(key, headerObjectMetaPrefix) && len(values) > 0 {
+			if meta.Metadata == nil {
+				meta.Metadata = map[string]string{}
+			}
+			meta.Metadata[strings.ToLower(strings.TrimPrefix(key, headerObjectMetaPrefix))] = values[0]
+		}
+	}
+	return meta
+}
+
+// storeGet handles GET /api/store/:store/:object, a single byte range can be asked for with the Range header
+func (s *Service) storeGet(c *gin.Context) {
+	store := s.storeHTTP(c, "get", "download.object")
+	if store == nil {
+		return
+	}
+	objectName := c.Param("object")
+	info, err := store.GetInfo(objectName)
+	if err != nil {
+		if errors.Is(err, nats.ErrObjectNotFound) {
+			storeError(c, http.StatusNotFound, code.NotFound, fmt.Sprintf("object %s not found", objectName))
+			return
+		}
+		storeError(c, http.StatusInternalServerError, code.ERROR, err.Error())
+		return
+	}
+	etag := objectETag(info)
+	c.Header("ETag", etag)
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	if match := c.GetHeader("If-None-Match"); match != "" && (match == etag || match == "*") {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	start, length := int64(0), int64(info.Size)
+	status := http.StatusOK
+	// an If-Range that doesn't match means the object changed, so the whole object is sent
+	if rangeHeader := c.GetHeader("Range"); rangeHeader != "" && (c.GetHeader("If-Range") == "" || c.GetHeader("If-Range") == etag) {
+		start, length, err = parseRange(rangeHeader, int64(info.Size))
+		if err != nil {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			storeError(c, http.StatusRequestedRangeNotSatisfiable, code.InvalidParams, err.Error())
+			return
+		}
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size))
+		status = http.StatusPartialContent
+	}
+
+	contentType := info.Headers.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": objectName}))
+	c.Header("Content-Length", strconv.FormatInt(length, 10))
+	c.Status(status)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+
+	obj, err := store.Get(objectName)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	defer obj.Close()
+	// the object store can only be read from the start
+	if start > 0 {
+		if _, err := io.CopyN(io.Discard, obj, start); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+	if _, err := io.CopyN(c.Writer, obj, length); err != nil {
+		// the headers are sent so the client sees a short body, the audit log gets the error
+		c.Error(err)
+	}
+}
+
+// storeBody returns the object to store, the first file part of a multipart form or else the request body
+func storeBody(r *http.Request) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no file is received")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// parseRange parses a single "bytes=" range, eg; bytes=0-499, bytes=500- or bytes=-500 (the last 500 bytes)
+func parseRange(header string, size int64) (start, length int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("%w %q, only a single bytes range is supported", errInvalidRange, header)
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("%w %q", errInvalidRange, header)
+	}
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 || size == 0 {
+			return 0, 0, fmt.Errorf("%w %q", errInvalidRange, header)
+		}
+		suffix = min(suffix, size)
+		return size - suffix, suffix, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, fmt.Errorf("%w %q for an object of %d bytes", errInvalidRange, header, size)
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, fmt.Errorf("%w %q", errInvalidRange, header)
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, nil
+}
+
+// objectETag is the object digest as a quoted ETag, eg; "SHA-256=..."
+func objectETag(info *nats.ObjectInfo) string {
+	return strconv.Quote(info.Digest)
+}
+
+func queryInt(c *gin.Context, key string, fallback int) (int, error) {
+	value := c.Query(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func storeError(c *gin.Context, status, responseCode int, msg string) {
+	c.JSON(status, gin.H{
+		"code": responseCode,
+		"msg":  code.GetMsg(responseCode),
+		"data": msg,
+	})
+	c.Abort()
+}
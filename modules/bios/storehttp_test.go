@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/NubeDev/flexy/utils/subjects"
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header        string
+		size          int64
+		start, length int64
+		err           bool
+	}{
+		{header: "bytes=0-99", size: 1000, start: 0, length: 100},
+		{header: "bytes=100-", size: 1000, start: 100, length: 900},
+		{header: "bytes=-100", size: 1000, start: 900, length: 100},
+		{header: "bytes=-2000", size: 1000, start: 0, length: 1000}, // a suffix over the size is the whole object
+		{header: "bytes=900-2000", size: 1000, start: 900, length: 100},
+		{header: "bytes= 5-9", size: 10, start: 5, length: 5},
+		{header: "bytes=1000-", size: 1000, err: true},
+		{header: "bytes=1000-1001", size: 1000, err: true},
+		{header: "bytes=-0", size: 1000, err: true},
+		{header: "bytes=-10", size: 0, err: true},
+		{header: "bytes=50-10", size: 1000, err: true},
+		{header: "bytes=0-9,20-29", size: 1000, err: true},
+		{header: "bytes=a-b", size: 1000, err: true},
+		{header: "bytes=10", size: 1000, err: true},
+		{header: "items=0-9", size: 1000, err: true},
+	}
+	for _, test := range tests {
+		start, length, err := parseRange(test.header, test.size)
+		if test.err {
+			if !errors.Is(err, errInvalidRange) {
+				t.Errorf("parseRange(%q, %d) = %d %d %v, want an invalid range", test.header, test.size, start, length, err)
+			}
+			continue
+		}
+		if err != nil || start != test.start || length != test.length {
+			t.Errorf("parseRange(%q, %d) = %d %d %v, want %d %d", test.header, test.size, start, length, err, test.start, test.length)
+		}
+	}
+}
+
+func TestStoreObjectMeta(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   *nats.ObjectMeta
+	}{
+		{name: "empty", header: http.Header{}, want: &nats.ObjectMeta{Name: "app.zip"}},
+		{
+			name: "description and meta",
+			header: http.Header{
+				headerObjectDescription:            {"the app"},
+				"Content-Type":                     {"application/zip"},
+				headerObjectMetaPrefix + "Version": {"v1.0.3", "v1.0.4"},
+			},
+			want: &nats.ObjectMeta{
+				Name:        "app.zip",
+				Description: "the app",
+				Headers:     nats.Header{"Content-Type": {"application/zip"}},
+				Metadata:    map[string]string{"version": "v1.0.3"},
+			},
+		},
+		{
+			name:   "multipart isn't the object content type",
+			header: http.Header{"Content-Type": {"multipart/form-data; boundary=x"}},
+			want:   &nats.ObjectMeta{Name: "app.zip"},
+		},
+	}
+	for _, test := range tests {
+		if got := storeObjectMeta(test.header, "app.zip"); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: meta = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStoreHTTPAuthorize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sb, err := subjects.NewSubjectBuilder("abc", "bios", subjects.IsBios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{
+		biosSubjectBuilder: sb,
+		gateway:            &gateway{allow: map[string][]string{gatewayAnonymousRole: {"*.get.>"}}},
+	}
+	r := gin.New()
+	r.Use(s.gateway.authenticate())
+	r.GET("/store/:store", s.storeList)
+	r.GET("/store/:store/:object", s.storeGet)
+	r.PUT("/store/:store/:object", s.storePut)
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		// a read gets past the allowlist, the store isn't enabled
+		{http.MethodGet, "/store/bios", http.StatusServiceUnavailable},
+		{http.MethodGet, "/store/bios/app.zip", http.StatusServiceUnavailable},
+		{http.MethodPut, "/store/bios/app.zip", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+		}
+	}
+}
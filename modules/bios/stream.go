@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/natsauth"
+	"github.com/NubeDev/flexy/utils/natsforwarder"
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/websocket"
+)
+
+/*
+Live NATS data for browsers (logs, install progress, point values), with the same auth as /api/proxy. Requests use the
+web_server.allow list, subscriptions the web_server.subscribe list, as sending to a subject doesn't mean a role may read
+everyone else's messages on it. A browser can't set headers on a websocket or EventSource, so the JWT or API key can be
+sent as ?token=
+
+websocket /api/ws, the frames are JSON
+  {"op": "sub", "id": "1", "subject": "abc.event.>"}
+  {"op": "unsub", "id": "1"}
+  {"op": "req", "id": "2", "subject": "abc.get.system.ping", "data": {}, "timeout": "5s"}
+the server sends "ok", "msg", "reply" and "error" frames with the id of the sub or req, and a "dropped" frame
+with the count of messages dropped since the last one when the client can't keep up
+
+server-sent events, each NATS message is a "msg" event
+curl -N "http://localhost:5000/api/sse/abc/event/app/*?token=change-me"
+*/
+
+// Websocket frame ops
+const (
+	streamOpSub     = "sub"
+	streamOpUnsub   = "unsub"
+	streamOpReq     = "req"
+	streamOpOK      = "ok"
+	streamOpMsg     = "msg"
+	streamOpReply   = "reply"
+	streamOpError   = "error"
+	streamOpDropped = "dropped"
+)
+
+// streamFrame is a websocket frame in either direction, and the data of an sse event
+type streamFrame struct {
+	Op      string            `json:"op"`
+	ID      string            `json:"id,omitempty"`
+	Subject string            `json:"subject,omitempty"`
+	Data    json.RawMessage   `json:"data,omitempty"`
+	Header  map[string]string `json:"header,omitempty"`
+	Timeout string            `json:"timeout,omitempty"`
+	Code    int               `json:"code,omitempty"`
+	Message string            `json:"message,omitempty"`
+	Count   uint64            `json:"count,omitempty"`
+}
+
+// streamOpts are the web_server.stream limits
+type streamOpts struct {
+	MaxSubscriptions int           `mapstructure:"max_subscriptions"` // per user across websockets and sse, default 20
+	Buffer           int           `mapstructure:"buffer"`            // messages queued per client before new ones are dropped, default 256
+	WriteTimeout     time.Duration `mapstructure:"write_timeout"`     // a websocket that can't take a frame for this long is closed, default 10s
+}
+
+func (opts streamOpts) withDefaults() streamOpts {
+	if opts.MaxSubscriptions <= 0 {
+		opts.MaxSubscriptions = 20
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 256
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 10 * time.Second
+	}
+	return opts
+}
+
+// streamClient queues the frames for one browser connection, NATS messages are dropped
+// (and counted) instead of blocking the subscription when the browser is too slow
+type streamClient struct {
+	out          chan streamFrame
+	dropped      atomic.Uint64
+	writeTimeout time.Duration
+}
+
+func newStreamClient(opts streamOpts) *streamClient {
+	return &streamClient{
+		out:          make(chan streamFrame, opts.Buffer),
+		writeTimeout: opts.WriteTimeout,
+	}
+}
+
+// send queues a NATS message, dropping it when the queue is full
+func (c *streamClient) send(frame streamFrame) {
+	select {
+	case c.out <- frame:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// reply queues a response to the client, waiting up to the write timeout for room
+func (c *streamClient) reply(frame streamFrame) {
+	select {
+	case c.out <- frame:
+	case <-time.After(c.writeTimeout):
+		c.dropped.Add(1)
+	}
+}
+
+// droppedFrame returns the dropped frame when messages were dropped since the last call
+func (c *streamClient) droppedFrame() (streamFrame, bool) {
+	n := c.dropped.Swap(0)
+	return streamFrame{Op: streamOpDropped, Count: n}, n > 0
+}
+
+// msgFrame converts a NATS message, data that isn't JSON is sent as a JSON string. The credential and identity headers
+// of other users' requests are never sent to the browser.
+func msgFrame(op, id string, m *nats.Msg) streamFrame {
+	frame := streamFrame{Op: op, ID: id, Subject: m.Subject, Data: streamData(m.Data)}
+	for key := range m.Header {
+		if streamHiddenHeader(key) {
+			continue
+		}
+		if frame.Header == nil {
+			frame.Header = map[string]string{}
+		}
+		frame.Header[key] = m.Header.Get(key)
+	}
+	return frame
+}
+
+// streamHiddenHeader is true for the headers that carry credentials or the identity set by nats auth
+func streamHiddenHeader(key string) bool {
+	for _, hidden := range []string{natsauth.HeaderAuthorization, natsauth.HeaderAPIKey, natsauth.HeaderToken} {
+		if strings.EqualFold(key, hidden) {
+			return true
+		}
+	}
+	return strings.HasPrefix(strings.ToLower(key), "x-auth-")
+}
+
+func streamData(data []byte) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+	if json.Valid(data) {
+		return data
+	}
+	b, _ := json.Marshal(string(data))
+	return b
+}
+
+func errorFrame(id string, responseCode int, message string) streamFrame {
+	return streamFrame{Op: streamOpError, ID: id, Code: responseCode, Message: message}
+}
+
+// streamWebsocket multiplexes subscriptions and requests over one websocket
+func (s *Service) streamWebsocket(c *gin.Context) {
+	identity := gatewayIdentity(c)
+	authHeader := nats.Header{}
+	setAuthHeaders(c, authHeader)
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		s.serveWebsocket(ws, identity, authHeader)
+	}}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+func (s *Service) serveWebsocket(ws *websocket.Conn, identity *natsauth.Identity, authHeader nats.Header) {
+	g := s.gateway
+	client := newStreamClient(g.stream)
+	done := make(chan struct{})
+	subs := map[string]*nats.Subscription{}
+	defer func() {
+		close(done)
+		for _, sub := range subs {
+			sub.Unsubscribe()
+			g.releaseSub(identity.Username)
+		}
+		ws.Close()
+	}()
+
+	// writer
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case frame := <-client.out:
+				ws.SetWriteDeadline(time.Now().Add(client.writeTimeout))
+				if dropped, ok := client.droppedFrame(); ok {
+					if err := websocket.JSON.Send(ws, dropped); err != nil {
+						ws.Close()
+						return
+					}
+				}
+				if err := websocket.JSON.Send(ws, frame); err != nil {
+					log.Error().Msgf("gateway websocket write for %s: %v", identity.Username, err)
+					ws.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var frame streamFrame
+		if err := websocket.JSON.Receive(ws, &frame); err != nil {
+			if err != io.EOF {
+				log.Debug().Msgf("gateway websocket read for %s: %v", identity.Username, err)
+			}
+			return
+		}
+		switch frame.Op {
+		case streamOpSub:
+			client.reply(s.websocketSubscribe(client, identity, subs, frame))
+		case streamOpUnsub:
+			sub, ok := subs[frame.ID]
+			if !ok {
+				client.reply(errorFrame(frame.ID, code.NotFound, fmt.Sprintf("no subscription with id: %s", frame.ID)))
+				continue
+			}
+			sub.Unsubscribe()
+			g.releaseSub(identity.Username)
+			delete(subs, frame.ID)
+			client.reply(streamFrame{Op: streamOpOK, ID: frame.ID})
+		case streamOpReq:
+			// requests run on their own, so a slow responder doesn't hold up the socket, they count towards the
+			// max_subscriptions of the user while they're in flight
+			if !g.acquireSub(identity.Username) {
+				client.reply(errorFrame(frame.ID, code.TooManyRequests, fmt.Sprintf("max %d subscriptions and requests per user", g.stream.MaxSubscriptions)))
+				continue
+			}
+			go func(frame streamFrame) {
+				defer g.releaseSub(identity.Username)
+				client.reply(s.websocketRequest(identity, authHeader, frame))
+			}(frame)
+		default:
+			client.reply(errorFrame(frame.ID, code.UnknownCommand, fmt.Sprintf("unknown op: %s, try: %s, %s or %s", frame.Op, streamOpSub, streamOpUnsub, streamOpReq)))
+		}
+	}
+}
+
+// websocketSubscribe adds a subscription to subs, which is only used by the socket's read loop
+func (s *Service) websocketSubscribe(client *streamClient, identity *natsauth.Identity, subs map[string]*nats.Subscription, frame streamFrame) streamFrame {
+	g := s.gateway
+	if frame.ID == "" || !validGatewayPattern(frame.Subject) {
+		return errorFrame(frame.ID, code.InvalidParams, "an id and a valid subject are required")
+	}
+	if _, ok := subs[frame.ID]; ok {
+		return errorFrame(frame.ID, code.InvalidParams, fmt.Sprintf("subscription id already used: %s", frame.ID))
+	}
+	if !g.subscribeAllowed(identity, frame.Subject) {
+		return errorFrame(frame.ID, code.Forbidden, forbiddenMessage(identity, frame.Subject))
+	}
+	if !g.acquireSub(identity.Username) {
+		return errorFrame(frame.ID, code.TooManyRequests, fmt.Sprintf("max %d subscriptions and requests per user", g.stream.MaxSubscriptions))
+	}
+	id := frame.ID
+	sub, err := s.natsConn.Subscribe(frame.Subject, func(m *nats.Msg) {
+		client.send(msgFrame(streamOpMsg, id, m))
+	})
+	if err != nil {
+		g.releaseSub(identity.Username)
+		return errorFrame(id, code.ERROR, err.Error())
+	}
+	subs[id] = sub
+	g.audit.Info().Str("audit", "gateway").Str("user", identity.Username).Str("role", identity.RoleKey).Str("subject", frame.Subject).Msg("websocket subscribe")
+	return streamFrame{Op: streamOpOK, ID: id, Subject: frame.Subject}
+}
+
+func (s *Service) websocketRequest(identity *natsauth.Identity, authHeader nats.Header, frame streamFrame) streamFrame {
+	g := s.gateway
+	if frame.Subject == "" || strings.ContainsAny(frame.Subject, "*>") || !validGatewayPattern(frame.Subject) {
+		return errorFrame(frame.ID, code.InvalidParams, "a subject without wildcards is required")
+	}
+	if !g.allowed(identity, frame.Subject) {
+		return errorFrame(frame.ID, code.Forbidden, forbiddenMessage(identity, frame.Subject))
+	}
+	timeout, ok := natsforwarder.ParseTimeout(frame.Timeout)
+	if !ok {
+		timeout = 5 * time.Second
+	}
+	msg := nats.NewMsg(frame.Subject)
+	msg.Data = frame.Data
+	for key, values := range authHeader {
+		msg.Header[key] = values
+	}
+	g.audit.Info().Str("audit", "gateway").Str("user", identity.Username).Str("role", identity.RoleKey).Str("subject", frame.Subject).Msg("websocket request")
+	resp, err := s.natsConn.RequestMsg(msg, timeout)
+	if err != nil {
+		responseCode := code.ERROR
+		switch {
+		case errors.Is(err, nats.ErrTimeout):
+			responseCode = code.Timeout
+		case errors.Is(err, nats.ErrNoResponders):
+			responseCode = code.ServiceUnavailable
+		}
+		return errorFrame(frame.ID, responseCode, err.Error())
+	}
+	return msgFrame(streamOpReply, frame.ID, resp)
+}
+
+// streamSSE sends the messages on a subject as server-sent events, until the browser disconnects
+func (s *Service) streamSSE(c *gin.Context) {
+	g := s.gateway
+	subject := strings.Replace(c.Param("subject")[1:], "/", ".", -1)
+	if !validGatewayPattern(subject) {
+		c.JSON(http.StatusBadRequest, gin.H{"code": code.InvalidParams, "msg": code.GetMsg(code.InvalidParams), "data": fmt.Sprintf("invalid subject: %s", subject)})
+		return
+	}
+	if !g.authorizeSubscribe(c, subject) {
+		return
+	}
+	identity := gatewayIdentity(c)
+	if !g.acquireSub(identity.Username) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"code": code.TooManyRequests, "msg": code.GetMsg(code.TooManyRequests), "data": fmt.Sprintf("max %d subscriptions per user", g.stream.MaxSubscriptions)})
+		return
+	}
+	defer g.releaseSub(identity.Username)
+
+	client := newStreamClient(g.stream)
+	sub, err := s.natsConn.Subscribe(subject, func(m *nats.Msg) {
+		client.send(msgFrame(streamOpMsg, "", m))
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": code.ERROR, "msg": code.GetMsg(code.ERROR), "data": err.Error()})
+		return
+	}
+	defer sub.Unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // stop nginx buffering the stream
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case frame := <-client.out:
+			if dropped, ok := client.droppedFrame(); ok {
+				c.SSEvent(streamOpDropped, dropped)
+			}
+			c.SSEvent(streamOpMsg, frame)
+			return true
+		case <-heartbeat.C:
+			// a comment keeps idle connections open through proxies
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		}
+	})
+}
@@ -86,6 +86,7 @@ func (s *Service) handleSystemctlPost(m *nats.Msg) {
 		if err != nil {
 			s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error performing %s on service %s: %v", decoded.Action, decoded.Name, err))
 		} else {
+			s.events.Publish(serviceActionEvents[action], ServiceEvent{Name: decoded.Name, AppID: decoded.AppID})
 			out := Message{
 				fmt.Sprintf("Service %s %sed successfully", decoded.Name, decoded.Action),
 			}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/NubeDev/flexy/utils/events"
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+/*
+Chunked transfers, see natlib.Upload and natlib.Download
+
+store upload    abc.post.system.transfer.store.upload    meta: storeName, objectName
+store download  abc.get.system.transfer.store.download   meta: storeName, objectName
+file upload     abc.post.system.transfer.file.upload     meta: root, path
+file download   abc.get.system.transfer.file.download    meta: root, path
+
+The file transfers need the file manager, the path is relative to one of the file_manager.roots, see files.go
+*/
+
+type transfers struct {
+	dir            string
+	storeUploads   *natlib.TransferReceiver
+	storeDownloads *natlib.TransferSender
+	fileUploads    *natlib.TransferReceiver
+	fileDownloads  *natlib.TransferSender
+}
+
+func (s *Service) natsTransferInit(dir string, maxSize int64) error {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "bios-transfer")
+	}
+	t := &transfers{dir: dir}
+	var err error
+	t.storeUploads, err = natlib.NewTransferReceiver(filepath.Join(dir, "store"), s.storeUploadComplete)
+	if err != nil {
+		return err
+	}
+	t.fileUploads, err = natlib.NewTransferReceiver(filepath.Join(dir, "files"), s.fileUploadComplete)
+	if err != nil {
+		return err
+	}
+	t.storeUploads.MaxSize = maxSize
+	t.fileUploads.MaxSize = maxSize
+	t.storeDownloads = natlib.NewTransferSender(s.storeDownloadSource)
+	t.fileDownloads = natlib.NewTransferSender(s.fileDownloadSource)
+	s.transfers = t
+	return nil
+}
+
+func (s *Service) transferSubscribe() error {
+	if s.natsStore != nil {
+		err := s.addNatsSubscribe(s.biosSubjectBuilder.BuildSubject("post", "system", "transfer.store.upload"), s.transfers.storeUploads.Handle)
+		if err != nil {
+			return err
+		}
+		err = s.addNatsSubscribe(s.biosSubjectBuilder.BuildSubject("get", "system", "transfer.store.download"), s.transfers.storeDownloads.Handle)
+		if err != nil {
+			return err
+		}
+	}
+	if s.files == nil {
+		return nil
+	}
+	err := s.addNatsSubscribe(s.biosSubjectBuilder.BuildSubject("post", "system", "transfer.file.upload"), s.transfers.fileUploads.Handle)
+	if err != nil {
+		return err
+	}
+	return s.addNatsSubscribe(s.biosSubjectBuilder.BuildSubject("get", "system", "transfer.file.download"), s.transfers.fileDownloads.Handle)
+}
+
+func transferMeta(info *natlib.TransferInfo, key string) (string, error) {
+	value := info.Meta[key]
+	if value == "" {
+		return "", fmt.Errorf("'%s' is required in the transfer meta", key)
+	}
+	return value, nil
+}
+
+// storeUploadComplete puts the uploaded file into the object store, with the description and metadata from the
+// transfer meta, see natlib.ObjectTransferMeta
+func (s *Service) storeUploadComplete(info *natlib.TransferInfo, path string, _ *nats.Msg) (string, error) {
+	storeName, err := transferMeta(info, "storeName")
+	if err != nil {
+		return "", err
+	}
+	objectName := info.Meta["objectName"]
+	if objectName == "" {
+		objectName = info.Name
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := s.putObject(storeName, natlib.TransferObjectMeta(objectName, info.Meta), file); err != nil {
+		return "", err
+	}
+	s.events.Publish(events.StoreObjectAdded, StoreEvent{StoreName: storeName, ObjectName: objectName, Size: int(info.Size)})
+	return fmt.Sprintf("Object %s added to store %s", objectName, storeName), nil
+}
+
+// storeDownloadSource copies the object to a temp file so it can be read in chunks
+func (s *Service) storeDownloadSource(info *natlib.TransferInfo, _ *nats.Msg) (string, func(), error) {
+	storeName, err := transferMeta(info, "storeName")
+	if err != nil {
+		return "", nil, err
+	}
+	objectName, err := transferMeta(info, "objectName")
+	if err != nil {
+		return "", nil, err
+	}
+	store, err := s.natsClient.GetStore(storeName)
+	if err != nil {
+		return "", nil, err
+	}
+	obj, err := store.Get(objectName)
+	if err != nil {
+		return "", nil, err
+	}
+	defer obj.Close()
+	tmp, err := os.CreateTemp(s.transfers.dir, "download-*")
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = io.Copy(tmp, obj)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", nil, err
+	}
+	info.Name = objectName
+	return tmp.Name(), func() { os.Remove(tmp.Name()) }, nil
+}
+
+// transferFilePath resolves the root and path of a file transfer in the file manager roots
+func (s *Service) transferFilePath(info *natlib.TransferInfo) (*FileRequest, string, error) {
+	root, err := transferMeta(info, "root")
+	if err != nil {
+		return nil, "", err
+	}
+	path, err := transferMeta(info, "path")
+	if err != nil {
+		return nil, "", err
+	}
+	req := &FileRequest{Root: root, Path: path}
+	real, err := s.files.fs.Resolve(root, path)
+	return req, real, err
+}
+
+// fileUploadComplete moves the uploaded file to its destination path
+func (s *Service) fileUploadComplete(info *natlib.TransferInfo, path string, m *nats.Msg) (string, error) {
+	req, dest, err := s.transferFilePath(info)
+	if err == nil {
+		if stat, statErr := os.Stat(dest); statErr == nil && stat.IsDir() {
+			err = fmt.Errorf("%s is a directory", req.Path)
+		}
+	}
+	if err == nil {
+		err = moveFile(path, dest)
+	}
+	if req != nil {
+		s.files.auditLog(m, "transfer.upload", req, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Info().Msgf("transfer file uploaded to: %s", dest)
+	return fmt.Sprintf("File uploaded to %s/%s", req.Root, req.Path), nil
+}
+
+func (s *Service) fileDownloadSource(info *natlib.TransferInfo, m *nats.Msg) (string, func(), error) {
+	req, path, err := s.transferFilePath(info)
+	if err == nil {
+		var stat os.FileInfo
+		if stat, err = os.Stat(path); err == nil && stat.IsDir() {
+			err = fmt.Errorf("%s is a directory, zip it first", req.Path)
+		}
+	}
+	if req != nil {
+		s.files.auditLog(m, "transfer.download", req, err)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return path, nil, nil
+}
+
+// moveFile renames src to dest, copying when they are on different filesystems
+func moveFile(src, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// runJetStream starts an embedded nats-server with JetStream and connects to it
+func runJetStream(t *testing.T) *nats.Conn {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats-server didn't start")
+	}
+	t.Cleanup(ns.Shutdown)
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func TestStoreDownload(t *testing.T) {
+	nc := runJetStream(t)
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "bios"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("flexy "), 50000) // a few chunks
+	if _, err := store.PutBytes("app.zip", data); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Service{natsClient: natlib.New(natlib.NewOpts{NatsConn: nc, EnableJetStream: true}), transfers: &transfers{dir: t.TempDir()}}
+	subject := "abc.get.system.transfer.store.download"
+	if _, err := nc.Subscribe(subject, natlib.NewTransferSender(s.storeDownloadSource).Handle); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(t.TempDir(), "app.zip")
+	info := &natlib.TransferInfo{Meta: map[string]string{"storeName": "bios", "objectName": "app.zip"}}
+	if _, err := natlib.Download(nc, subject, info, dest, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Errorf("downloaded %d bytes, want %d", len(got), len(data))
+	}
+
+	info = &natlib.TransferInfo{Meta: map[string]string{"storeName": "bios", "objectName": "missing.zip"}}
+	if _, err := natlib.Download(nc, subject, info, filepath.Join(t.TempDir(), "missing.zip"), nil); err == nil {
+		t.Error("expected a missing object to fail")
+	}
+	// the temp copies are removed once the downloads are done
+	time.Sleep(100 * time.Millisecond)
+	if entries, _ := os.ReadDir(s.transfers.dir); len(entries) != 0 {
+		t.Errorf("temp files left: %v", entries)
+	}
+}
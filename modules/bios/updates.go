@@ -0,0 +1,548 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NubeDev/flexy/modules/bios/appmanager"
+	"github.com/NubeDev/flexy/utils/apppkg"
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/events"
+	githubdownloader "github.com/NubeDev/flexy/utils/gitdownloader"
+	"github.com/NubeDev/flexy/utils/semver"
+	"github.com/NubeDev/flexy/utils/times"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+/*
+The update checker looks for newer releases of the installed apps in the release sources. What happens when one is
+found is set by the policy of the app:
+
+	manual      only checked with a check-updates request
+	notify      an update.available event is published
+	auto-patch  notify, and a patch release (v1.0.3 to v1.0.4) is downloaded and installed in the maintenance window
+	auto-minor  notify, and a patch or minor release (v1.0.3 to v1.1.0) is installed in the maintenance window
+
+The event reports the newest release, the auto policies install the newest release they allow. With v1.0.4 and v1.1.0
+published, auto-patch installs v1.0.4 while update.available reports v1.1.0.
+
+An upgrade found outside the window is pending, the pending upgrades are checked every minute and start once the window
+opens.
+
+A major release is never installed automatically. A failed install is rolled back to the old version and the same
+version isn't tried again until an upgrade request.
+
+./nats req abc.get.apps.manager.updates ''
+./nats req abc.post.apps.manager.check-updates ''
+./nats req abc.post.apps.manager.upgrade '{"name": "flexy-app", "version": "v1.0.4"}' --timeout 2m
+*/
+
+// the update policies
+const (
+	updatePolicyManual    = "manual"
+	updatePolicyNotify    = "notify"
+	updatePolicyAutoPatch = "auto-patch"
+	updatePolicyAutoMinor = "auto-minor"
+)
+
+// the status of an app in the update checker
+const (
+	updateStatusUpToDate  = "up-to-date"
+	updateStatusAvailable = "available"
+	updateStatusPending   = "pending" // an auto upgrade is waiting for the maintenance window
+	updateStatusUpgrading = "upgrading"
+	updateStatusUpgraded  = "upgraded"
+	updateStatusFailed    = "failed" // the upgrade failed and was rolled back
+	updateStatusError     = "error"  // the check failed, eg; the source wasn't reachable
+	updateStatusManual    = "manual" // not checked yet, the policy is manual
+)
+
+const defaultUpdateInterval = 6 * time.Hour
+
+// updatePendingInterval is how often the pending upgrades are checked against their maintenance window
+const updatePendingInterval = time.Minute
+
+// updatesConfig is the updates section of the config, an app in apps overrides the defaults
+type updatesConfig struct {
+	Enable   bool                       `mapstructure:"enable"`
+	Interval time.Duration              `mapstructure:"interval"`
+	Owner    string                     `mapstructure:"owner"`
+	Source   string                     `mapstructure:"source"`
+	Arch     string                     `mapstructure:"arch"`
+	Policy   string                     `mapstructure:"policy"`
+	Window   string                     `mapstructure:"window"`
+	Apps     map[string]appUpdateConfig `mapstructure:"apps"`
+}
+
+type appUpdateConfig struct {
+	Owner  string `mapstructure:"owner"`
+	Repo   string `mapstructure:"repo"` // defaults to the app name
+	Source string `mapstructure:"source"`
+	Arch   string `mapstructure:"arch"`
+	Policy string `mapstructure:"policy"`
+	Window string `mapstructure:"window"`
+	window *times.Window
+}
+
+// AppUpdate is the update state of an installed app
+type AppUpdate struct {
+	Name        string        `json:"name"`
+	Version     string        `json:"version"` // installed
+	Latest      string        `json:"latest,omitempty"`
+	Change      semver.Change `json:"change,omitempty"`  // patch, minor or major
+	Upgrade     string        `json:"upgrade,omitempty"` // the newest release the policy installs, can be older than latest
+	Policy      string        `json:"policy"`
+	Window      string        `json:"window,omitempty"`
+	Source      string        `json:"source,omitempty"`
+	Status      string        `json:"status"`
+	Error       string        `json:"error,omitempty"`
+	LastCheck   time.Time     `json:"lastCheck,omitempty"`
+	LastUpgrade time.Time     `json:"lastUpgrade,omitempty"`
+}
+
+// UpdatesStatus is the reply to the updates requests
+type UpdatesStatus struct {
+	Enabled   bool         `json:"enabled"`
+	Interval  string       `json:"interval"`
+	LastCheck time.Time    `json:"lastCheck,omitempty"`
+	NextCheck time.Time    `json:"nextCheck,omitempty"`
+	Apps      []*AppUpdate `json:"apps"`
+}
+
+// UpgradeRequest upgrades an installed app, the version defaults to the latest found by the last check
+type UpgradeRequest struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type updateChecker struct {
+	config    updatesConfig
+	defaults  appUpdateConfig
+	checkMu   sync.Mutex // one check or upgrade at a time
+	mu        sync.Mutex
+	apps      map[string]*AppUpdate
+	notified  map[string]string // the latest version an update.available was published for
+	failed    map[string]string // the version an auto upgrade failed on
+	lastCheck time.Time
+}
+
+// updatesInit loads the updates config, the subjects work when the periodic check isn't enabled
+func (s *Service) updatesInit() error {
+	u := &updateChecker{apps: map[string]*AppUpdate{}, notified: map[string]string{}, failed: map[string]string{}}
+	if err := s.Config.UnmarshalKey("updates", &u.config); err != nil {
+		return fmt.Errorf("invalid updates in config: %v", err)
+	}
+	if u.config.Interval <= 0 {
+		u.config.Interval = defaultUpdateInterval
+	}
+	u.defaults = appUpdateConfig{Owner: u.config.Owner, Source: u.config.Source, Arch: u.config.Arch, Policy: u.config.Policy, Window: u.config.Window}
+	if u.defaults.Policy == "" {
+		u.defaults.Policy = updatePolicyNotify
+	}
+	if u.defaults.Arch == "" {
+		u.defaults.Arch = s.appManager.Arch()
+	}
+	if err := s.checkUpdateConfig("updates", &u.defaults); err != nil {
+		return err
+	}
+	for name, config := range u.config.Apps {
+		config = u.appConfig(name, config)
+		if err := s.checkUpdateConfig("updates.apps."+name, &config); err != nil {
+			return err
+		}
+		u.config.Apps[name] = config
+	}
+	s.updates = u
+	return nil
+}
+
+func (s *Service) checkUpdateConfig(key string, config *appUpdateConfig) error {
+	switch config.Policy {
+	case updatePolicyManual, updatePolicyNotify, updatePolicyAutoPatch, updatePolicyAutoMinor:
+	default:
+		return fmt.Errorf("%s: unknown policy %q, try: manual, notify, auto-patch or auto-minor", key, config.Policy)
+	}
+	if _, ok := s.releaseSources[config.Source]; config.Source != "" && !ok && config.Source != githubdownloader.SourceGitHub {
+		return fmt.Errorf("%s: release source %s is not in release_sources", key, config.Source)
+	}
+	window, err := times.ParseWindow(config.Window)
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	config.window = window
+	return nil
+}
+
+// appConfig fills in an app config from the defaults
+func (u *updateChecker) appConfig(name string, config appUpdateConfig) appUpdateConfig {
+	if config.Owner == "" {
+		config.Owner = u.defaults.Owner
+	}
+	if config.Repo == "" {
+		config.Repo = name
+	}
+	if config.Source == "" {
+		config.Source = u.defaults.Source
+	}
+	if config.Arch == "" {
+		config.Arch = u.defaults.Arch
+	}
+	if config.Policy == "" {
+		config.Policy = u.defaults.Policy
+	}
+	if config.Window == "" {
+		config.Window, config.window = u.defaults.Window, u.defaults.window
+	}
+	return config
+}
+
+func (u *updateChecker) configFor(name string) appUpdateConfig {
+	if config, ok := u.config.Apps[name]; ok {
+		return config
+	}
+	return u.appConfig(name, appUpdateConfig{})
+}
+
+// updatesMonitor checks for updates every interval, and starts the pending upgrades once their window opens
+func (s *Service) updatesMonitor() {
+	log.Info().Msgf("update checks enabled, every %s", s.updates.config.Interval)
+	check := time.NewTicker(s.updates.config.Interval)
+	defer check.Stop()
+	pending := time.NewTicker(updatePendingInterval)
+	defer pending.Stop()
+	for {
+		if err := s.checkUpdates(false); err != nil {
+			log.Error().Msgf("update check failed: %v", err)
+		}
+	wait:
+		for {
+			select {
+			case <-check.C:
+				break wait
+			case <-pending.C:
+				s.upgradePending()
+			}
+		}
+	}
+}
+
+// upgradePending upgrades the apps waiting for their maintenance window, when it's open
+func (s *Service) upgradePending() {
+	u := s.updates
+	u.checkMu.Lock()
+	defer u.checkMu.Unlock()
+	type pendingUpgrade struct{ name, version, upgrade string }
+	var upgrades []pendingUpgrade
+	u.mu.Lock()
+	for name, state := range u.apps {
+		if state.Status == updateStatusPending {
+			upgrades = append(upgrades, pendingUpgrade{name, state.Version, state.Upgrade})
+		}
+	}
+	u.mu.Unlock()
+	for _, upgrade := range upgrades {
+		config := u.configFor(upgrade.name)
+		if !config.window.Contains(time.Now()) {
+			continue
+		}
+		s.upgradeApp(config, upgrade.name, upgrade.version, upgrade.upgrade)
+	}
+}
+
+// checkUpdates checks every installed app, the apps with the manual policy are only checked on request
+func (s *Service) checkUpdates(requested bool) error {
+	u := s.updates
+	u.checkMu.Lock()
+	defer u.checkMu.Unlock()
+	installed, err := s.appManager.ListInstalledApps()
+	if err != nil {
+		return err
+	}
+	// an app with more than one version installed is checked from the newest
+	newest := map[string]*appmanager.App{}
+	for _, app := range installed {
+		if current, ok := newest[app.Name]; !ok || newerVersion(app.Version, current.Version) {
+			newest[app.Name] = app
+		}
+	}
+	// drop the apps that were uninstalled
+	u.mu.Lock()
+	for name := range u.apps {
+		if _, ok := newest[name]; !ok {
+			delete(u.apps, name)
+		}
+	}
+	u.mu.Unlock()
+	for _, app := range newest {
+		s.checkAppUpdate(app, requested)
+	}
+	u.mu.Lock()
+	u.lastCheck = time.Now().UTC()
+	u.mu.Unlock()
+	return nil
+}
+
+func (s *Service) checkAppUpdate(app *appmanager.App, requested bool) {
+	u := s.updates
+	config := u.configFor(app.Name)
+	state := u.state(app.Name)
+	u.setState(state, func(state *AppUpdate) {
+		state.Version, state.Policy, state.Window, state.Source = app.Version, config.Policy, config.Window, config.Source
+	})
+	if config.Policy == updatePolicyManual && !requested {
+		u.setState(state, func(state *AppUpdate) {
+			if state.LastCheck.IsZero() {
+				state.Status = updateStatusManual
+			}
+		})
+		return
+	}
+
+	releases, err := s.latestRelease(config, app.Version)
+	now := time.Now().UTC()
+	if err != nil {
+		log.Error().Msgf("update check of %s failed: %v", app.Name, err)
+		u.setState(state, func(state *AppUpdate) {
+			state.Status, state.Error, state.LastCheck = updateStatusError, err.Error(), now
+		})
+		return
+	}
+	if releases.latest == "" {
+		u.setState(state, func(state *AppUpdate) {
+			state.Latest, state.Change, state.Upgrade, state.Status, state.Error, state.LastCheck = "", semver.None, "", updateStatusUpToDate, "", now
+		})
+		return
+	}
+	u.mu.Lock()
+	state.Latest, state.Change, state.Upgrade, state.LastCheck = releases.latest, releases.change, releases.upgrade, now
+	// the version an upgrade failed on stays failed, with its error, until an upgrade request
+	failed := u.failed[app.Name] != "" && (u.failed[app.Name] == state.Latest || u.failed[app.Name] == state.Upgrade)
+	if !failed {
+		state.Status, state.Error = updateStatusAvailable, ""
+	}
+	notify := u.notified[app.Name] != state.Latest
+	u.notified[app.Name] = state.Latest
+	event := *state
+	u.mu.Unlock()
+	if notify {
+		log.Info().Msgf("update available for %s: %s to %s", app.Name, app.Version, event.Latest)
+		s.events.Publish(events.UpdateAvailable, event)
+	}
+
+	if event.Upgrade == "" || u.failed[app.Name] == event.Upgrade {
+		return
+	}
+	if !config.window.Contains(time.Now()) {
+		u.setState(state, func(state *AppUpdate) { state.Status = updateStatusPending })
+		return
+	}
+	s.upgradeApp(config, app.Name, app.Version, event.Upgrade)
+}
+
+// releases is the result of an update check
+type releases struct {
+	latest  string        // the newest release, empty when the installed version is the newest
+	change  semver.Change // from the installed version to latest
+	upgrade string        // the newest release the policy installs, empty when there isn't one
+}
+
+// latestRelease finds the newest release of the arch that is newer than the installed version, and the newest one
+// the policy of the app installs. Pre-releases are only picked when the installed version is a pre-release.
+func (s *Service) latestRelease(config appUpdateConfig, version string) (*releases, error) {
+	if config.Owner == "" {
+		return nil, fmt.Errorf("the repo owner is required, set updates.owner in the config")
+	}
+	current, err := semver.Parse(version)
+	if err != nil {
+		return nil, fmt.Errorf("the installed version: %v", err)
+	}
+	source, err := s.gitReleaseSource(&githubdownloader.RepoAsset{Source: config.Source})
+	if err != nil {
+		return nil, err
+	}
+	assets, err := source.Assets(config.Owner, config.Repo)
+	if err != nil {
+		return nil, err
+	}
+	out := &releases{}
+	latest, upgrade := current, current
+	for _, asset := range assets {
+		if !apppkg.Compatible(config.Arch, asset.Arch) {
+			continue
+		}
+		v, err := semver.Parse(asset.Version)
+		if err != nil || (v.PreRelease != "" && current.PreRelease == "") {
+			continue
+		}
+		if v.Compare(latest) > 0 {
+			latest, out.latest = v, asset.Version
+		}
+		if v.Compare(upgrade) > 0 && autoUpgrade(config.Policy, current.ChangeTo(v)) {
+			upgrade, out.upgrade = v, asset.Version
+		}
+	}
+	out.change = current.ChangeTo(latest)
+	return out, nil
+}
+
+func autoUpgrade(policy string, change semver.Change) bool {
+	switch policy {
+	case updatePolicyAutoPatch:
+		return change == semver.Patch
+	case updatePolicyAutoMinor:
+		return change == semver.Patch || change == semver.Minor
+	}
+	return false
+}
+
+// upgradeApp downloads a version into the library and swaps it in, a failed install is rolled back by the app manager
+func (s *Service) upgradeApp(config appUpdateConfig, name, fromVersion, toVersion string) error {
+	u := s.updates
+	state := u.state(name)
+	u.setState(state, func(state *AppUpdate) {
+		state.Version, state.Status, state.Error = fromVersion, updateStatusUpgrading, ""
+	})
+	log.Info().Msgf("upgrading %s from %s to %s", name, fromVersion, toVersion)
+	upgradeEvent := AppUpgradeEvent{Name: name, FromVersion: fromVersion, ToVersion: toVersion}
+
+	err := s.downloadAndUpgrade(config, name, fromVersion, toVersion)
+	if err != nil {
+		log.Error().Msgf("upgrade of %s to %s failed: %v", name, toVersion, err)
+		u.mu.Lock()
+		state.Status, state.Error = updateStatusFailed, err.Error()
+		u.failed[name] = toVersion
+		u.mu.Unlock()
+		upgradeEvent.Error = err.Error()
+		s.events.Publish(events.AppUpgradeFailed, upgradeEvent)
+		return err
+	}
+	u.mu.Lock()
+	state.Version, state.Status, state.Change, state.LastUpgrade = toVersion, updateStatusUpgraded, semver.None, time.Now().UTC()
+	delete(u.failed, name)
+	u.mu.Unlock()
+	log.Info().Msgf("upgraded %s from %s to %s", name, fromVersion, toVersion)
+	s.events.Publish(events.AppUpgraded, upgradeEvent)
+	return nil
+}
+
+func (s *Service) downloadAndUpgrade(config appUpdateConfig, name, fromVersion, toVersion string) error {
+	source, err := s.gitReleaseSource(&githubdownloader.RepoAsset{Source: config.Source})
+	if err != nil {
+		return err
+	}
+	result, err := githubdownloader.DownloadByArchVersion(source, config.Owner, config.Repo, toVersion, config.Arch, s.gitDownloadPath)
+	if err != nil {
+		return fmt.Errorf("download failed: %v", err)
+	}
+	log.Info().Msgf("downloaded %s %s to %s sha256: %s", name, toVersion, result.Path, result.SHA256)
+	return s.appManager.Upgrade(name, fromVersion, toVersion)
+}
+
+// AppUpgradeEvent is the data of the app.upgraded and app.upgrade.failed events
+type AppUpgradeEvent struct {
+	Name        string `json:"name"`
+	FromVersion string `json:"fromVersion"`
+	ToVersion   string `json:"toVersion"`
+	Error       string `json:"error,omitempty"`
+}
+
+func (u *updateChecker) state(name string) *AppUpdate {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	state, ok := u.apps[name]
+	if !ok {
+		state = &AppUpdate{Name: name}
+		u.apps[name] = state
+	}
+	return state
+}
+
+func (u *updateChecker) setState(state *AppUpdate, update func(state *AppUpdate)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	update(state)
+}
+
+func (u *updateChecker) status() *UpdatesStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	status := &UpdatesStatus{Enabled: u.config.Enable, Interval: u.config.Interval.String(), LastCheck: u.lastCheck, Apps: []*AppUpdate{}}
+	if u.config.Enable && !u.lastCheck.IsZero() {
+		status.NextCheck = u.lastCheck.Add(u.config.Interval)
+	}
+	for _, state := range u.apps {
+		copied := *state
+		status.Apps = append(status.Apps, &copied)
+	}
+	sort.Slice(status.Apps, func(i, j int) bool { return status.Apps[i].Name < status.Apps[j].Name })
+	return status
+}
+
+// newerVersion reports whether a is newer than b, a version that doesn't parse is never newer
+func newerVersion(a, b string) bool {
+	n, err := semver.Compare(a, b)
+	return err == nil && n > 0
+}
+
+func (s *Service) handleUpdatesStatus(m *nats.Msg) {
+	s.publishResponse(m, s.updates.status(), code.SUCCESS)
+}
+
+func (s *Service) handleCheckUpdates(m *nats.Msg) {
+	if err := s.checkUpdates(true); err != nil {
+		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error checking for updates: %v", err))
+		return
+	}
+	s.publishResponse(m, s.updates.status(), code.SUCCESS)
+}
+
+// handleUpgradeApp upgrades an app straight away, whatever its policy and maintenance window
+func (s *Service) handleUpgradeApp(m *nats.Msg) {
+	req := &UpgradeRequest{}
+	if err := json.Unmarshal(m.Data, req); err != nil {
+		s.handleError(m.Reply, code.InvalidParams, fmt.Sprintf("Invalid JSON format: %v", err))
+		return
+	}
+	if req.Name == "" {
+		s.handleError(m.Reply, code.InvalidParams, "app name is required")
+		return
+	}
+	u := s.updates
+	u.checkMu.Lock()
+	defer u.checkMu.Unlock()
+	installed, err := s.appManager.ListInstalledApps()
+	if err != nil {
+		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error listing installed apps: %v", err))
+		return
+	}
+	var fromVersion string
+	for _, app := range installed {
+		if app.Name == req.Name && (fromVersion == "" || newerVersion(app.Version, fromVersion)) {
+			fromVersion = app.Version
+		}
+	}
+	if fromVersion == "" {
+		s.handleError(m.Reply, code.NotFound, fmt.Sprintf("app %s is not installed", req.Name))
+		return
+	}
+	if req.Version == "" {
+		req.Version = u.state(req.Name).Latest
+	}
+	if req.Version == "" {
+		s.handleError(m.Reply, code.InvalidParams, "version is required, or run check-updates to find the latest")
+		return
+	}
+	if !newerVersion(req.Version, fromVersion) {
+		s.handleError(m.Reply, code.InvalidParams, fmt.Sprintf("%s is not newer than the installed version %s", req.Version, fromVersion))
+		return
+	}
+	if err := s.upgradeApp(u.configFor(req.Name), req.Name, fromVersion, req.Version); err != nil {
+		s.handleError(m.Reply, code.ERROR, fmt.Sprintf("Error upgrading %s: %v", req.Name, err))
+		return
+	}
+	s.publishResponse(m, u.status(), code.SUCCESS)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NubeDev/flexy/modules/bios/appmanager"
+	githubdownloader "github.com/NubeDev/flexy/utils/gitdownloader"
+	"github.com/NubeDev/flexy/utils/times"
+)
+
+func TestCheckAppUpdatePolicyRelease(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(githubdownloader.IndexManifest{Assets: []githubdownloader.Asset{
+			{Name: "flexy-app-v1.0.3-amd64.zip", BrowserDownloadURL: "flexy-app-v1.0.3-amd64.zip"},
+			{Name: "flexy-app-v1.0.4-amd64.zip", BrowserDownloadURL: "flexy-app-v1.0.4-amd64.zip"},
+			{Name: "flexy-app-v1.1.0-amd64.zip", BrowserDownloadURL: "flexy-app-v1.1.0-amd64.zip"},
+		}})
+	}))
+	defer server.Close()
+
+	// a window that isn't open today, so the upgrade is left pending
+	day := strings.ToLower(time.Now().Add(48 * time.Hour).Weekday().String()[:3])
+	window, err := times.ParseWindow(day + " 01:00-02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		policy  string
+		upgrade string
+		status  string
+	}{
+		{updatePolicyNotify, "", updateStatusAvailable},
+		{updatePolicyAutoPatch, "v1.0.4", updateStatusPending},
+		{updatePolicyAutoMinor, "v1.1.0", updateStatusPending},
+	}
+	for _, tt := range tests {
+		config := appUpdateConfig{Owner: "NubeDev", Repo: "flexy-app", Source: "builds", Arch: "amd64", Policy: tt.policy, window: window}
+		s := &Service{
+			releaseSources: map[string]releaseSourceConfig{"builds": {Type: githubdownloader.SourceHTTP, URL: server.URL}},
+			updates: &updateChecker{
+				config:   updatesConfig{Apps: map[string]appUpdateConfig{"flexy-app": config}},
+				apps:     map[string]*AppUpdate{},
+				notified: map[string]string{},
+				failed:   map[string]string{},
+			},
+		}
+		s.checkAppUpdate(&appmanager.App{Name: "flexy-app", Version: "v1.0.3"}, false)
+		state := s.updates.state("flexy-app")
+		if state.Latest != "v1.1.0" || state.Upgrade != tt.upgrade || state.Status != tt.status {
+			t.Errorf("%s: latest = %s upgrade = %s status = %s, want v1.1.0 %s %s", tt.policy, state.Latest, state.Upgrade, state.Status, tt.upgrade, tt.status)
+		}
+	}
+}
@@ -4,19 +4,50 @@ import (
 	"encoding/json"
 	"fmt"
 	hostService "github.com/NubeDev/flexy/app/services/v1/host"
+	"github.com/NubeDev/flexy/utils/events"
 	"github.com/NubeDev/flexy/utils/helpers/pprint"
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/NubeDev/flexy/utils/natsconf"
 	"github.com/NubeDev/flexy/utils/rqlclient"
+	"github.com/nats-io/nats.go"
 	"github.com/spf13/cobra"
 	"log"
 	"os"
+	"os/signal"
 	"time"
 )
 
 var (
-	natsURL    string
-	globalUUID string
-	timeout    time.Duration
-	jsonInput  string // The JSON input as a string
+	natsURL       string
+	globalUUID    string
+	subjectPrefix string
+	timeout       time.Duration
+	jsonInput     string // The JSON input as a string
+	token         string
+	nkeySeed      string
+
+	natsConfOut  string
+	natsConfApps []string
+
+	pingExpected int
+	pingMax      int
+	pingQuiet    time.Duration
+	pingGroup    string
+
+	eventsDurable  string
+	eventsFilter   string
+	eventsSince    time.Duration
+	eventsStartSeq uint64
+	eventsFollow   bool
+
+	objectRevision    int
+	objectDescription string
+	objectMetadata    map[string]string
+	storeConfig       natlib.StoreConfig
+
+	appConfigRestart bool
+	appUpdatesCheck  bool
+	appLibraryAll    bool
 )
 
 // rootCmd is the main command when called without any subcommands
@@ -27,10 +58,22 @@ var rootCmd = &cobra.Command{
 }
 
 func runCommand(cmd *cobra.Command, args []string, execFunc func(client *rqlclient.Client, args []string) error) {
-	client, err := rqlclient.New(natsURL, globalUUID)
+	options, err := natlib.SeedFileOptions(nkeySeed)
 	if err != nil {
 		log.Fatalf("failed to create Client: %v", err)
 	}
+	client, err := rqlclient.New(natsURL, globalUUID, options...)
+	if err != nil {
+		log.Fatalf("failed to create Client: %v", err)
+	}
+	if token != "" {
+		client.SetToken(token)
+	}
+	if subjectPrefix != "" {
+		if err := client.SetSubjectPrefix(subjectPrefix); err != nil {
+			log.Fatalf("failed to create Client: %v", err)
+		}
+	}
 
 	err = execFunc(client, args)
 	if err != nil {
@@ -123,11 +166,26 @@ var modulesPing = &cobra.Command{
 	Short: "Ping all the apps",
 	Run: func(cmd *cobra.Command, args []string) {
 		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
-			resp, err := client.PingHostAllCore(timeout)
+			opts := &natlib.RequestAllOpts{
+				Timeout:      timeout,
+				Expected:     pingExpected,
+				MaxResponses: pingMax,
+				QuietPeriod:  pingQuiet,
+			}
+			printResp := func(resp natlib.Response) {
+				pprint.PrintJSON(resp)
+			}
+			var resp []natlib.Response
+			var err error
+			if cmd.Flags().Changed("group") {
+				resp, err = client.PingGroupAll(pingGroup, opts, printResp)
+			} else {
+				resp, err = client.PingHostAll(opts, printResp)
+			}
 			if err != nil {
 				return err
 			}
-			pprint.PrintJSON(resp)
+			fmt.Printf("%d responses\n", len(resp))
 			return nil
 		})
 	},
@@ -233,7 +291,116 @@ var appList = &cobra.Command{
 	Short: "List all available apps from the library that can be installed",
 	Run: func(cmd *cobra.Command, args []string) {
 		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
-			resp, err := client.BiosLibraryApps(timeout)
+			resp, err := client.BiosLibraryApps(appLibraryAll, timeout)
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+var appConfigsCmd = &cobra.Command{
+	Use:   "app-configs",
+	Short: "List the config files of an installed app [appName] [appVersion]",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			resp, err := client.BiosAppConfigs(args[0], args[1], timeout)
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+var appConfigGetCmd = &cobra.Command{
+	Use:   "app-config-get",
+	Short: "Read a config file of an installed app [appName] [appVersion] [file]",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			resp, err := client.BiosAppConfig(args[0], args[1], args[2], timeout)
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+var appConfigPutCmd = &cobra.Command{
+	Use:   "app-config-put",
+	Short: "Validate and write a config file of an installed app from a local file [appName] [appVersion] [file] [localPath]",
+	Args:  cobra.ExactArgs(4),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			data, err := os.ReadFile(args[3])
+			if err != nil {
+				return err
+			}
+			resp, err := client.BiosWriteAppConfig(args[0], args[1], args[2], data, appConfigRestart, timeout)
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+var appConfigHistoryCmd = &cobra.Command{
+	Use:   "app-config-history",
+	Short: "List the config changes of an installed app, with who made them and the diffs [appName] [appVersion]",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			resp, err := client.BiosAppConfigHistory(args[0], args[1], timeout)
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+var appUpdatesCmd = &cobra.Command{
+	Use:   "app-updates",
+	Short: "Show the update state of the installed apps, --check checks the release sources now",
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			var resp interface{}
+			var err error
+			if appUpdatesCheck {
+				resp, err = client.BiosCheckAppUpdates(timeout)
+			} else {
+				resp, err = client.BiosAppUpdates(timeout)
+			}
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+var appUpgradeCmd = &cobra.Command{
+	Use:   "app-upgrade",
+	Short: "Download and install a newer version of an installed app, the latest found by the last check when no version is given [appName] [appVersion]",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			var version string
+			if len(args) > 1 {
+				version = args[1]
+			}
+			resp, err := client.BiosUpgradeApp(args[0], version, timeout)
 			if err != nil {
 				return err
 			}
@@ -491,14 +658,291 @@ var deleteObjectCmd = &cobra.Command{
 	},
 }
 
+var objectInfoCmd = &cobra.Command{
+	Use:   "store-object-info",
+	Short: "Get the info of an object, --revision gets an older revision [storeName] [objectName]",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			resp, err := client.GetObjectInfo(args[0], args[1], objectRevision, timeout)
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+var objectRevisionsCmd = &cobra.Command{
+	Use:   "store-object-revisions",
+	Short: "List the current object then its older revisions [storeName] [objectName]",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			resp, err := client.GetObjectRevisions(args[0], args[1], timeout)
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+var storeStatCmd = &cobra.Command{
+	Use:   "store-stat",
+	Short: "Get the size, number of objects and config of a store [storeName]",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			resp, err := client.StoreStat(args[0], timeout)
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+var storeCreateCmd = &cobra.Command{
+	Use:   "store-create",
+	Short: "Create a store with limits, eg; --max-bytes=1073741824 --ttl=720h [storeName]",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			resp, err := client.CreateStore(args[0], &storeConfig, timeout)
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+var storeDropCmd = &cobra.Command{
+	Use:   "store-drop",
+	Short: "Delete a store and all its objects [storeName]",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			resp, err := client.DropStore(args[0], timeout)
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+var storePurgeCmd = &cobra.Command{
+	Use:   "store-purge",
+	Short: "Delete every object in a store, the store is kept [storeName]",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			resp, err := client.PurgeStore(args[0], timeout)
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(resp)
+			return nil
+		})
+	},
+}
+
+func transferProgress(done, total int64) {
+	fmt.Printf("\r%d/%d bytes", done, total)
+	if done == total {
+		fmt.Println()
+	}
+}
+
+var storeUploadCmd = &cobra.Command{
+	Use:   "store-upload",
+	Short: "Upload a file into a store in chunks, run it again to resume [storeName] [objectName] [localPath]",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			ack, err := client.StoreUploadWithMeta(args[0], args[1], args[2], objectDescription, objectMetadata, &natlib.TransferOpts{Timeout: timeout, OnProgress: transferProgress})
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(ack)
+			return nil
+		})
+	},
+}
+
+var storeDownloadCmd = &cobra.Command{
+	Use:   "store-download",
+	Short: "Download an object from a store in chunks, run it again to resume [storeName] [objectName] [localPath]",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			info, err := client.StoreDownload(args[0], args[1], args[2], &natlib.TransferOpts{Timeout: timeout, OnProgress: transferProgress})
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(info)
+			return nil
+		})
+	},
+}
+
+var fileUploadCmd = &cobra.Command{
+	Use:   "file-upload",
+	Short: "Upload a file to the device in chunks [localPath] [root] [remotePath], the root is a file manager root",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			ack, err := client.FileUpload(args[0], args[1], args[2], &natlib.TransferOpts{Timeout: timeout, OnProgress: transferProgress})
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(ack)
+			return nil
+		})
+	},
+}
+
+var fileDownloadCmd = &cobra.Command{
+	Use:   "file-download",
+	Short: "Download a file from the device in chunks [root] [remotePath] [localPath], the root is a file manager root",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			info, err := client.FileDownload(args[0], args[1], args[2], &natlib.TransferOpts{Timeout: timeout, OnProgress: transferProgress})
+			if err != nil {
+				return err
+			}
+			pprint.PrintJSON(info)
+			return nil
+		})
+	},
+}
+
+// go run main.go nats-config --global-uuid=abc --apps=app-abc,module-abc --out=./nats-conf
+var natsConfigCmd = &cobra.Command{
+	Use:   "nats-config",
+	Short: "Generate nats-server config and nkey users for the cloud (4222) and local (4223) brokers",
+	Long: `Generates a nats-server config for each broker, with an nkey user and subject permissions per role:
+bios, bios-proxy, ros, a module user for each --apps id, and a cloud operator.
+With --subject-prefix the cloud users are scoped to the prefix, plus a tenant operator for every device under it.
+The user seeds are saved next to the config as <broker>-<user>.nk`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if globalUUID == "" {
+			log.Fatalf("--global-uuid is required")
+		}
+		cloud, err := natsconf.PrefixedCloudBrokerConfig(subjectPrefix, globalUUID, 0)
+		if err != nil {
+			log.Fatalf("failed to generate cloud broker config: %v", err)
+		}
+		local, err := natsconf.LocalBrokerConfig(globalUUID, natsConfApps, 0)
+		if err != nil {
+			log.Fatalf("failed to generate local broker config: %v", err)
+		}
+		cloudFiles, err := cloud.Write(natsConfOut, "cloud")
+		if err != nil {
+			log.Fatalf("failed to write cloud broker config: %v", err)
+		}
+		localFiles, err := local.Write(natsConfOut, "local")
+		if err != nil {
+			log.Fatalf("failed to write local broker config: %v", err)
+		}
+		pprint.PrintJSON(append(cloudFiles, localFiles...))
+	},
+}
+
+// go run main.go events --global-uuid=abc --durable=cloud --filter="app.*"
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Subscribe to or replay the device event stream",
+	Long: `Prints the events from the device stream (<uuid>.event.>).
+With --durable the stream remembers the last event received, so running the command again carries on after a disconnect.
+Without --follow the command exits once no new events arrive for the timeout.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommand(cmd, args, func(client *rqlclient.Client, args []string) error {
+			opts := events.SubscribeOpts{
+				Durable:  eventsDurable,
+				Filter:   eventsFilter,
+				StartSeq: eventsStartSeq,
+			}
+			if eventsSince > 0 {
+				opts.Since = time.Now().Add(-eventsSince)
+			}
+			received := make(chan struct{}, 1)
+			sub, err := client.Events(opts, func(event *events.Event, meta *nats.MsgMetadata) {
+				if meta != nil {
+					fmt.Printf("#%d ", meta.Sequence.Stream)
+				}
+				pprint.PrintJSON(event)
+				select {
+				case received <- struct{}{}:
+				default:
+				}
+			})
+			if err != nil {
+				return err
+			}
+			defer sub.Unsubscribe()
+
+			quit := make(chan os.Signal, 1)
+			signal.Notify(quit, os.Interrupt)
+			for {
+				if eventsFollow {
+					<-quit
+					return nil
+				}
+				select {
+				case <-received:
+				case <-quit:
+					return nil
+				case <-time.After(timeout):
+					return nil
+				}
+			}
+		})
+	},
+}
+
 func init() {
 	// Define persistent flags common to all commands
 	rootCmd.PersistentFlags().StringVarP(&natsURL, "url", "u", "nats://localhost:4222", "NATS server URL")
 	rootCmd.PersistentFlags().DurationVarP(&timeout, "timeout", "t", 5*time.Second, "Request timeout")
 	rootCmd.PersistentFlags().StringVarP(&globalUUID, "global-uuid", "c", "", "global UUID")
+	rootCmd.PersistentFlags().StringVar(&subjectPrefix, "subject-prefix", "", "site/customer prefix of the device subjects, eg; acme.site-1")
+	rootCmd.PersistentFlags().StringVar(&token, "token", "", "JWT or API key sent with each request")
+	rootCmd.PersistentFlags().StringVar(&nkeySeed, "nkey", "", "nkey seed file of the user, eg; ./nats-conf/cloud-cloud-operator.nk")
 	createHostCmd.MarkFlagRequired("global-uuid")
 	createHostCmd.Flags().StringVarP(&jsonInput, "json", "j", "", "JSON input")
 	createHostCmd.MarkFlagRequired("json")
+	natsConfigCmd.Flags().StringVarP(&natsConfOut, "out", "o", "./nats-conf", "output directory")
+	natsConfigCmd.Flags().StringSliceVar(&natsConfApps, "apps", nil, "app ids to create module users for")
+	modulesPing.Flags().IntVar(&pingExpected, "expected", 0, "stop once this many responses are received")
+	modulesPing.Flags().IntVar(&pingMax, "max", 0, "max number of responses")
+	modulesPing.Flags().StringVar(&pingGroup, "group", "", "only ping the devices under this prefix, eg; acme or acme.site-1 (defaults to --subject-prefix)")
+	modulesPing.Flags().DurationVar(&pingQuiet, "quiet", 0, "stop when there are no new responses for this long, eg; 500ms")
+	eventsCmd.Flags().StringVar(&eventsDurable, "durable", "", "durable consumer name, to resume from the last event received")
+	eventsCmd.Flags().StringVar(&eventsFilter, "filter", "", "event type filter, eg; app.* or service.failed")
+	eventsCmd.Flags().DurationVar(&eventsSince, "since", 0, "replay the events from this long ago, eg; 1h")
+	eventsCmd.Flags().Uint64Var(&eventsStartSeq, "start-seq", 0, "replay the events from this stream sequence")
+	eventsCmd.Flags().BoolVarP(&eventsFollow, "follow", "f", false, "keep waiting for new events until ctrl+c")
+	objectInfoCmd.Flags().IntVar(&objectRevision, "revision", 0, "an older revision of the object")
+	storeUploadCmd.Flags().StringVar(&objectDescription, "description", "", "description of the object")
+	storeCreateCmd.Flags().StringVar(&storeConfig.Description, "description", "", "description of the store")
+	storeCreateCmd.Flags().Int64Var(&storeConfig.MaxBytes, "max-bytes", 0, "size limit of the store, unlimited by default")
+	storeCreateCmd.Flags().StringVar(&storeConfig.TTL, "ttl", "", "how long objects are kept, eg; 720h, forever by default")
+	storeCreateCmd.Flags().StringVar(&storeConfig.Storage, "storage", "file", "file or memory")
+	storeCreateCmd.Flags().IntVar(&storeConfig.Replicas, "replicas", 1, "number of replicas in a cluster")
+	storeCreateCmd.Flags().BoolVar(&storeConfig.Compression, "compression", false, "compress the store, needs nats-server 2.10")
+	appConfigPutCmd.Flags().BoolVar(&appConfigRestart, "restart", false, "restart the app once the file is written")
+	appUpdatesCmd.Flags().BoolVar(&appUpdatesCheck, "check", false, "check the release sources now")
+	appList.Flags().BoolVar(&appLibraryAll, "all", false, "include the apps built for the other archs")
+	storeUploadCmd.Flags().StringToStringVar(&objectMetadata, "meta", nil, "metadata of the object, eg; appID=flexy-app,version=v1.0.3,arch=amd64")
 
 	// Add the new command to rootCmd
 	rootCmd.AddCommand(downloadReleaseCmd)
@@ -520,6 +964,12 @@ func init() {
 	rootCmd.AddCommand(appUninstallByID)
 	rootCmd.AddCommand(appList)
 	rootCmd.AddCommand(appInstalled)
+	rootCmd.AddCommand(appConfigsCmd)
+	rootCmd.AddCommand(appConfigGetCmd)
+	rootCmd.AddCommand(appConfigPutCmd)
+	rootCmd.AddCommand(appConfigHistoryCmd)
+	rootCmd.AddCommand(appUpdatesCmd)
+	rootCmd.AddCommand(appUpgradeCmd)
 	rootCmd.AddCommand(appSystemctl)
 	rootCmd.AddCommand(systemctlAction)
 	rootCmd.AddCommand(natsRequestCmd)
@@ -528,6 +978,18 @@ func init() {
 	rootCmd.AddCommand(addObjectCmd)
 	rootCmd.AddCommand(downloadObjectCmd)
 	rootCmd.AddCommand(deleteObjectCmd)
+	rootCmd.AddCommand(objectInfoCmd)
+	rootCmd.AddCommand(objectRevisionsCmd)
+	rootCmd.AddCommand(storeStatCmd)
+	rootCmd.AddCommand(storeCreateCmd)
+	rootCmd.AddCommand(storeDropCmd)
+	rootCmd.AddCommand(storePurgeCmd)
+	rootCmd.AddCommand(storeUploadCmd)
+	rootCmd.AddCommand(storeDownloadCmd)
+	rootCmd.AddCommand(fileUploadCmd)
+	rootCmd.AddCommand(fileDownloadCmd)
+	rootCmd.AddCommand(natsConfigCmd)
+	rootCmd.AddCommand(eventsCmd)
 
 }
 
@@ -1,14 +1,19 @@
 package routers
 
 import (
+	"net/http"
+
 	casbinController "github.com/NubeDev/flexy/app/controllers/v1/casbin"
 	"github.com/NubeDev/flexy/app/middleware"
+	casbinService "github.com/NubeDev/flexy/app/services/v1/casbin"
+	"github.com/NubeDev/flexy/common"
+	"github.com/NubeDev/flexy/utils/apispec"
 
 	"github.com/gin-gonic/gin"
 )
 
 func InitCasbinRouter(router *gin.RouterGroup) {
-	endPoint := router.Group("casbin").Use(middleware.TranslationHandler())
+	endPoint := router.Group("casbin", middleware.TranslationHandler())
 	if useAuth {
 		endPoint.Use(
 			middleware.JWTHandler(),
@@ -16,9 +21,9 @@ func InitCasbinRouter(router *gin.RouterGroup) {
 		)
 	}
 	{
-		endPoint.GET("", casbinController.GetCasbinList)
-		endPoint.POST("", casbinController.CreateCasbin)
-		endPoint.PUT("/:id", casbinController.UpdateCasbin)
-		endPoint.DELETE("/:id", casbinController.DeleteCasbin)
+		handle(endPoint, http.MethodGet, "", apispec.Route{Summary: "List the casbin policies", Response: common.Response{}}, casbinController.GetCasbinList)
+		handle(endPoint, http.MethodPost, "", apispec.Route{Summary: "Add a casbin policy, v0 is the role, v1 the path and v2 the method", Body: casbinService.AddCasbinStruct{}, Response: common.Response{}}, casbinController.CreateCasbin)
+		handle(endPoint, http.MethodPut, "/:id", apispec.Route{Summary: "Update a casbin policy", Body: casbinService.AddCasbinStruct{}, Response: common.Response{}}, casbinController.UpdateCasbin)
+		handle(endPoint, http.MethodDelete, "/:id", apispec.Route{Summary: "Delete a casbin policy", Body: casbinService.AddCasbinStruct{}, Response: common.Response{}}, casbinController.DeleteCasbin)
 	}
 }
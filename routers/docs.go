@@ -0,0 +1,13 @@
+package routers
+
+import (
+	sysController "github.com/NubeDev/flexy/app/controllers/v1/sys"
+	"github.com/NubeDev/flexy/utils/apispec"
+	"github.com/gin-gonic/gin"
+)
+
+// handle registers a route with its description for /v1/api/system/openapi.json, a route registered on the group
+// directly is still listed with its path parameters
+func handle(group *gin.RouterGroup, method, path string, doc apispec.Route, handler gin.HandlerFunc) {
+	sysController.RouteDocs.Handle(group, method, path, doc, handler)
+}
@@ -1,13 +1,18 @@
 package routers
 
 import (
+	"net/http"
+
 	hostController "github.com/NubeDev/flexy/app/controllers/v1/host"
 	"github.com/NubeDev/flexy/app/middleware"
+	hostService "github.com/NubeDev/flexy/app/services/v1/host"
+	"github.com/NubeDev/flexy/common"
+	"github.com/NubeDev/flexy/utils/apispec"
 	"github.com/gin-gonic/gin"
 )
 
 func InitHostRouter(Router *gin.RouterGroup) {
-	endPoint := Router.Group("hosts").Use(middleware.TranslationHandler())
+	endPoint := Router.Group("hosts", middleware.TranslationHandler())
 	if useAuth {
 		endPoint.Use(
 			middleware.JWTHandler(),
@@ -15,10 +20,10 @@ func InitHostRouter(Router *gin.RouterGroup) {
 		)
 	}
 	{
-		endPoint.POST("", hostController.CreateHost)
-		endPoint.GET("", hostController.GetHosts)
-		endPoint.GET("/:uuid", hostController.GetHost)
-		endPoint.PATCH("/:uuid", hostController.UpdateHost)
-		endPoint.DELETE("/:uuid", hostController.DeleteHost)
+		handle(endPoint, http.MethodPost, "", apispec.Route{Summary: "Create a host", Body: hostService.Fields{}, Response: common.Response{}}, hostController.CreateHost)
+		handle(endPoint, http.MethodGet, "", apispec.Route{Summary: "List the hosts", Response: common.Response{}}, hostController.GetHosts)
+		handle(endPoint, http.MethodGet, "/:uuid", apispec.Route{Summary: "Get a host", Response: common.Response{}}, hostController.GetHost)
+		handle(endPoint, http.MethodPatch, "/:uuid", apispec.Route{Summary: "Update a host", Body: hostService.Fields{}, Response: common.Response{}}, hostController.UpdateHost)
+		handle(endPoint, http.MethodDelete, "/:uuid", apispec.Route{Summary: "Delete a host", Response: common.Response{}}, hostController.DeleteHost)
 	}
 }
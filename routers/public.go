@@ -1,16 +1,20 @@
 package routers
 
 import (
+	"net/http"
+
 	indexController "github.com/NubeDev/flexy/app/controllers/v1/public"
 	"github.com/NubeDev/flexy/app/middleware"
+	"github.com/NubeDev/flexy/common"
+	"github.com/NubeDev/flexy/utils/apispec"
 	"github.com/gin-gonic/gin"
 )
 
 func InitTestRouter(router *gin.RouterGroup) {
-	test := router.Group("public").Use(
+	test := router.Group("public",
 		middleware.TranslationHandler(),
 	)
 	{
-		test.GET("/ping", indexController.Ping)
+		handle(test, http.MethodGet, "/ping", apispec.Route{Summary: "Check the server is up", Response: common.Response{}, Public: true}, indexController.Ping)
 	}
 }
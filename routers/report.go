@@ -1,13 +1,18 @@
 package routers
 
 import (
+	"net/http"
+
 	reportController "github.com/NubeDev/flexy/app/controllers/v1/report"
 	"github.com/NubeDev/flexy/app/middleware"
+	reportService "github.com/NubeDev/flexy/app/services/v1/report"
+	"github.com/NubeDev/flexy/common"
+	"github.com/NubeDev/flexy/utils/apispec"
 	"github.com/gin-gonic/gin"
 )
 
 func InitReportRouter(router *gin.RouterGroup) {
-	endPoint := router.Group("reports").Use(middleware.TranslationHandler())
+	endPoint := router.Group("reports", middleware.TranslationHandler())
 	if useAuth {
 		endPoint.Use(
 			middleware.JWTHandler(),
@@ -15,6 +20,6 @@ func InitReportRouter(router *gin.RouterGroup) {
 		)
 	}
 	{
-		endPoint.POST("", reportController.Report)
+		handle(endPoint, http.MethodPost, "", apispec.Route{Summary: "Add a report", Body: reportService.ReportStruct{}, Response: common.Response{}}, reportController.Report)
 	}
 }
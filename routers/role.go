@@ -1,13 +1,18 @@
 package routers
 
 import (
+	"net/http"
+
 	roleController "github.com/NubeDev/flexy/app/controllers/v1/role"
 	"github.com/NubeDev/flexy/app/middleware"
+	roleService "github.com/NubeDev/flexy/app/services/v1/role"
+	"github.com/NubeDev/flexy/common"
+	"github.com/NubeDev/flexy/utils/apispec"
 	"github.com/gin-gonic/gin"
 )
 
 func InitRoleRouter(router *gin.RouterGroup) {
-	endPoint := router.Group("role").Use(middleware.TranslationHandler())
+	endPoint := router.Group("role", middleware.TranslationHandler())
 	if !useAuth {
 		endPoint.Use(
 			middleware.JWTHandler(),
@@ -16,9 +21,9 @@ func InitRoleRouter(router *gin.RouterGroup) {
 	}
 
 	{
-		endPoint.GET("", roleController.GetRoles)
-		endPoint.POST("", roleController.CreateRole)
-		endPoint.PUT("/:role_id", roleController.UpdateRole)
-		endPoint.DELETE("/:role_id", roleController.DeleteRole)
+		handle(endPoint, http.MethodGet, "", apispec.Route{Summary: "List the roles", Response: common.Response{}}, roleController.GetRoles)
+		handle(endPoint, http.MethodPost, "", apispec.Route{Summary: "Create a role", Body: roleService.CreateRoleStruct{}, Response: common.Response{}}, roleController.CreateRole)
+		handle(endPoint, http.MethodPut, "/:role_id", apispec.Route{Summary: "Update a role", Body: roleService.UpdateRoleStruct{}, Response: common.Response{}}, roleController.UpdateRole)
+		handle(endPoint, http.MethodDelete, "/:role_id", apispec.Route{Summary: "Delete a role", Response: common.Response{}}, roleController.DeleteRole)
 	}
 }
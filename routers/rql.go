@@ -1,13 +1,17 @@
 package routers
 
 import (
+	"net/http"
+
 	rqlController "github.com/NubeDev/flexy/app/controllers/v1/rql"
 	"github.com/NubeDev/flexy/app/middleware"
+	"github.com/NubeDev/flexy/common"
+	"github.com/NubeDev/flexy/utils/apispec"
 	"github.com/gin-gonic/gin"
 )
 
 func InitRQLRouter(Router *gin.RouterGroup) {
-	endPoint := Router.Group("rql").Use(middleware.TranslationHandler())
+	endPoint := Router.Group("rql", middleware.TranslationHandler())
 	if useAuth {
 		endPoint.Use(
 			middleware.JWTHandler(),
@@ -15,6 +19,6 @@ func InitRQLRouter(Router *gin.RouterGroup) {
 		)
 	}
 	{
-		endPoint.POST("/run", rqlController.RQL)
+		handle(endPoint, http.MethodPost, "/run", apispec.Route{Summary: "Run an RQL script", Body: rqlController.Body{}, Response: common.Response{}}, rqlController.RQL)
 	}
 }
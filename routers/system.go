@@ -1,13 +1,17 @@
 package routers
 
 import (
+	"net/http"
+
 	sysController "github.com/NubeDev/flexy/app/controllers/v1/sys"
 	"github.com/NubeDev/flexy/app/middleware"
+	"github.com/NubeDev/flexy/common"
+	"github.com/NubeDev/flexy/utils/apispec"
 	"github.com/gin-gonic/gin"
 )
 
 func InitSysRouter(router *gin.RouterGroup) {
-	endPoint := router.Group("system").Use(middleware.TranslationHandler())
+	endPoint := router.Group("system", middleware.TranslationHandler())
 	if useAuth {
 		endPoint.Use(
 			middleware.JWTHandler(),
@@ -15,6 +19,8 @@ func InitSysRouter(router *gin.RouterGroup) {
 		)
 	}
 	{
-		endPoint.GET("/router", sysController.GetRouterList) // Route list
+		handle(endPoint, http.MethodGet, "/router", apispec.Route{Summary: "List the routes", Response: common.Response{}}, sysController.GetRouterList)
+		handle(endPoint, http.MethodGet, "/openapi.json", apispec.Route{Summary: "OpenAPI document of the routes"}, sysController.GetOpenAPI)
+		handle(endPoint, http.MethodGet, "/asyncapi.json", apispec.Route{Summary: "AsyncAPI document of the NATS subjects"}, sysController.GetAsyncAPI)
 	}
 }
@@ -1,18 +1,23 @@
 package routers
 
 import (
+	"net/http"
+
 	authController "github.com/NubeDev/flexy/app/controllers/v1/auth"
 	userController "github.com/NubeDev/flexy/app/controllers/v1/user"
 	"github.com/NubeDev/flexy/app/middleware"
+	userService "github.com/NubeDev/flexy/app/services/v1/user"
+	"github.com/NubeDev/flexy/common"
+	"github.com/NubeDev/flexy/utils/apispec"
 	"github.com/gin-gonic/gin"
 )
 
 func InitUserRouter(router *gin.RouterGroup) {
-	router.POST("/login", authController.UserLogin)                  // Login
-	router.POST("/refresh_token", authController.RefreshAccessToken) // Refresh access_token
-	router.POST("/users", userController.CreateUser)                 // Create user
+	handle(router, http.MethodPost, "/login", apispec.Route{Summary: "Log in, the access token is sent in the token header", Body: userService.AuthStruct{}, Response: common.Response{}, Public: true}, authController.UserLogin)
+	handle(router, http.MethodPost, "/refresh_token", apispec.Route{Summary: "Get a new access token", Body: userService.RefreshAccessTokenStruct{}, Response: common.Response{}, Public: true}, authController.RefreshAccessToken)
+	handle(router, http.MethodPost, "/users", apispec.Route{Summary: "Create a user", Body: userService.AddUserStruct{}, Response: common.Response{}, Public: true}, userController.CreateUser)
 
-	endPoint := router.Group("users").Use(middleware.TranslationHandler())
+	endPoint := router.Group("users", middleware.TranslationHandler())
 	if useAuth {
 		endPoint.Use(
 			middleware.JWTHandler(),
@@ -20,9 +25,14 @@ func InitUserRouter(router *gin.RouterGroup) {
 		)
 	}
 	{
-		endPoint.GET("", userController.GetUsers)                       // User list
-		endPoint.PUT("/logout", authController.UserLogout)              // Logout
-		endPoint.PUT("/change_password", authController.ChangePassword) // Change password
-		endPoint.GET("/logged_in", authController.GetLoggedInUser)      // Current logged-in user information
+		handle(endPoint, http.MethodGet, "", apispec.Route{Summary: "List the users", Response: common.Response{}, Params: []*apispec.Parameter{
+			{Name: "username", In: "query", Schema: &apispec.Schema{Type: "string"}},
+			{Name: "status", In: "query", Schema: &apispec.Schema{Type: "integer"}},
+			{Name: "PageNum", In: "query", Schema: &apispec.Schema{Type: "integer"}},
+			{Name: "PageSize", In: "query", Schema: &apispec.Schema{Type: "integer"}},
+		}}, userController.GetUsers)
+		handle(endPoint, http.MethodPut, "/logout", apispec.Route{Summary: "Log out, the token is blocked", Response: common.Response{}}, authController.UserLogout)
+		handle(endPoint, http.MethodPut, "/change_password", apispec.Route{Summary: "Change the password of the logged in user", Body: userService.ChangePasswordStruct{}, Response: common.Response{}}, authController.ChangePassword)
+		handle(endPoint, http.MethodGet, "/logged_in", apispec.Route{Summary: "Get the logged in user", Response: common.Response{}}, authController.GetLoggedInUser)
 	}
 }
@@ -0,0 +1,168 @@
+package apispec
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NubeDev/flexy/utils/guides"
+	"github.com/gin-gonic/gin"
+)
+
+type login struct {
+	Username string `json:"user_name" validate:"required,min=4,max=20" minLength:"4" maxLength:"20"`
+	Password string `json:"password" validate:"required"`
+}
+
+type user struct {
+	login
+	RoleID  uint              `json:"role_id" validate:"omitempty,numeric"`
+	Created time.Time         `json:"created"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Friends []*user           `json:"friends"`
+	secret  string
+	Skip    string `json:"-"`
+}
+
+func TestSchemaOf(t *testing.T) {
+	schema := SchemaOf(user{})
+	if schema.Type != "object" {
+		t.Fatalf("type = %s, want object", schema.Type)
+	}
+	var names []string
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	for _, name := range []string{"user_name", "password", "role_id", "created", "labels", "friends"} {
+		if schema.Properties[name] == nil {
+			t.Errorf("missing property %s in %v", name, names)
+		}
+	}
+	if len(schema.Properties) != 6 {
+		t.Errorf("properties = %v, want 6", names)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"user_name", "password"}) {
+		t.Errorf("required = %v", schema.Required)
+	}
+	if p := schema.Properties["user_name"]; p.MinLength == nil || *p.MinLength != 4 || *p.MaxLength != 20 {
+		t.Errorf("user_name lengths not copied: %+v", p)
+	}
+	if p := schema.Properties["created"]; p.Type != "string" || p.Format != "date-time" {
+		t.Errorf("created = %+v", p)
+	}
+	if p := schema.Properties["labels"]; p.Type != "object" || p.AdditionalProperties.Type != "string" {
+		t.Errorf("labels = %+v", p)
+	}
+	// the recursive friends list stops at the second user
+	if p := schema.Properties["friends"]; p.Type != "array" || p.Items.Type != "object" || p.Items.Properties != nil {
+		t.Errorf("friends = %+v", p)
+	}
+	given := &Schema{Type: "string"}
+	if SchemaOf(given) != given {
+		t.Errorf("a *Schema should be returned as is")
+	}
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	path, params := OpenAPIPath("/api/store/:store/:object")
+	if path != "/api/store/{store}/{object}" {
+		t.Errorf("path = %s", path)
+	}
+	if len(params) != 2 || params[0].Name != "store" || params[1].Name != "object" || !params[0].Required {
+		t.Errorf("params = %+v", params)
+	}
+	path, params = OpenAPIPath("/api/proxy/*topic")
+	if path != "/api/proxy/{topic}" || len(params) != 1 || params[0].Name != "topic" {
+		t.Errorf("catch all = %s %+v", path, params)
+	}
+}
+
+func TestRoutesOpenAPI(t *testing.T) {
+	routes := NewRoutes()
+	routes.Add(
+		Route{Method: http.MethodPost, Path: "/v1/api/login", Summary: "Log in", Body: login{}, Public: true},
+		Route{Method: http.MethodGet, Path: "/v1/api/hosts/:uuid", Summary: "Get a host"},
+	)
+	doc := routes.OpenAPI(OpenAPIOpts{
+		Info:            Info{Title: "ros", Version: "1"},
+		BasePath:        "/v1/api",
+		SecuritySchemes: map[string]*SecurityScheme{"token": HeaderAuth("token")},
+	}, gin.RoutesInfo{
+		{Method: http.MethodPost, Path: "/v1/api/login"},
+		{Method: http.MethodGet, Path: "/v1/api/hosts/:uuid"},
+		{Method: http.MethodDelete, Path: "/v1/api/hosts/:uuid"},
+	})
+	if len(doc.Paths) != 2 {
+		t.Fatalf("paths = %v", doc.Paths)
+	}
+	loginOp := doc.Paths["/v1/api/login"]["post"]
+	if loginOp.Summary != "Log in" || loginOp.RequestBody == nil || len(loginOp.Security) != 1 || len(loginOp.Security[0]) != 0 {
+		t.Errorf("login = %+v", loginOp)
+	}
+	host := doc.Paths["/v1/api/hosts/{uuid}"]
+	if host["get"].Summary != "Get a host" || !reflect.DeepEqual(host["get"].Tags, []string{"hosts"}) {
+		t.Errorf("get host = %+v", host["get"])
+	}
+	// the routes without a description are still listed
+	if host["delete"] == nil || len(host["delete"].Parameters) != 1 || host["delete"].Security != nil {
+		t.Errorf("delete host = %+v", host["delete"])
+	}
+	if _, err := json.Marshal(doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoutesHandle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	routes := NewRoutes()
+	hosts := engine.Group("/v1/api/hosts")
+	handler := func(c *gin.Context) {}
+	routes.Handle(hosts, http.MethodGet, "", Route{Summary: "List the hosts"}, handler)
+	routes.Handle(hosts, http.MethodGet, "/:uuid", Route{Summary: "Get a host"}, handler)
+	routes.Handle(hosts, http.MethodPost, "/sync/", Route{Summary: "Sync the hosts"}, handler)
+	doc := routes.OpenAPI(OpenAPIOpts{BasePath: "/v1/api"}, engine.Routes())
+	for path, summary := range map[string]string{"/v1/api/hosts": "List the hosts", "/v1/api/hosts/{uuid}": "Get a host", "/v1/api/hosts/sync/": "Sync the hosts"} {
+		var got string
+		for _, op := range doc.Paths[path] {
+			got = op.Summary
+		}
+		if got != summary {
+			t.Errorf("%s summary = %q, want %q", path, got, summary)
+		}
+	}
+}
+
+func TestHelpGuideSubjects(t *testing.T) {
+	guide := guides.NewHelpGuide([]guides.Module{
+		guides.NewModule("MathOperations", []guides.Method{
+			guides.NewMethod("mathAdd", "Adds two numbers", "app-abc.post.math.add.run", false, "", []guides.Args{guides.NewArgFloat("num1"), guides.NewArgInt("num2")}),
+			guides.NewMethod("mathConfig", "Sets the config", "app-abc.post.math.config", true, `{"precision": 2, "name": "calc"}`, nil),
+		}),
+	})
+	subjects := NewSubjects()
+	subjects.AddHelpGuide(guide)
+	subjects.Add(Subject{Subject: "abc.event.>", Summary: "device events", Event: true})
+	doc := subjects.AsyncAPI(Info{Title: "app-abc", Version: "1"})
+
+	add := doc.Channels["app-abc.post.math.add.run"]
+	if add == nil || add.Publish == nil {
+		t.Fatalf("channels = %v", doc.Channels)
+	}
+	payload := add.Publish.Message.Payload
+	if payload.Properties["num1"].Type != "number" || payload.Properties["num2"].Type != "integer" {
+		t.Errorf("args payload = %+v", payload)
+	}
+	if add.Publish.Tags[0].Name != "MathOperations" {
+		t.Errorf("tags = %+v", add.Publish.Tags)
+	}
+	config := doc.Channels["app-abc.post.math.config"].Publish.Message.Payload
+	if config.Properties["precision"].Type != "number" || config.Properties["name"].Type != "string" || config.Example == nil {
+		t.Errorf("json_body payload = %+v", config)
+	}
+	if event := doc.Channels["abc.event.>"]; event.Subscribe == nil || event.Publish != nil {
+		t.Errorf("event = %+v", event)
+	}
+}
@@ -0,0 +1,145 @@
+package apispec
+
+import (
+	"sync"
+
+	"github.com/NubeDev/flexy/utils/guides"
+)
+
+const asyncAPIVersion = "2.6.0"
+
+// AsyncAPI is an AsyncAPI 2 document, the channels are NATS subjects
+type AsyncAPI struct {
+	AsyncAPI           string              `json:"asyncapi"`
+	Info               Info                `json:"info"`
+	DefaultContentType string              `json:"defaultContentType"`
+	Channels           map[string]*Channel `json:"channels"`
+}
+
+/*
+Channel is a NATS subject. Publish is what the clients send to the subject and Subscribe what they receive on it,
+the reply to a request is the x-reply of the publish operation as AsyncAPI 2 has no request/reply.
+*/
+type Channel struct {
+	Description string            `json:"description,omitempty"`
+	Publish     *ChannelOperation `json:"publish,omitempty"`
+	Subscribe   *ChannelOperation `json:"subscribe,omitempty"`
+}
+
+type ChannelOperation struct {
+	Summary string   `json:"summary,omitempty"`
+	Tags    []*Tag   `json:"tags,omitempty"`
+	Message *Message `json:"message,omitempty"`
+	Reply   *Message `json:"x-reply,omitempty"`
+}
+
+type Tag struct {
+	Name string `json:"name"`
+}
+
+type Message struct {
+	Name    string  `json:"name,omitempty"`
+	Payload *Schema `json:"payload,omitempty"`
+}
+
+// Subject describes a NATS subject for the AsyncAPI document, eg; <uuid>.get.system.ping
+type Subject struct {
+	Subject string
+	Summary string
+	Module  string // tags the subject, eg; the help guide module
+	Request any    // payload sent to the subject (or the event), a Go value or a *Schema
+	Reply   any    // payload of the reply, a Go value or a *Schema
+	Event   bool   // the service publishes on the subject and the clients subscribe to it
+}
+
+// Subjects collects the subject descriptions, it's safe to use from several goroutines
+type Subjects struct {
+	lock     sync.RWMutex
+	subjects []Subject
+}
+
+func NewSubjects() *Subjects {
+	return &Subjects{}
+}
+
+// Add describes subjects, a subject described twice keeps the last description
+func (s *Subjects) Add(subjects ...Subject) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subjects = append(s.subjects, subjects...)
+}
+
+// AddHelpGuide describes the methods of a help guide (the <app-id>.get.system.help reply of an app)
+func (s *Subjects) AddHelpGuide(guide *guides.HelpGuide) {
+	s.Add(HelpGuideSubjects(guide)...)
+}
+
+// AsyncAPI builds the document with every subject that was added
+func (s *Subjects) AsyncAPI(info Info) *AsyncAPI {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	doc := &AsyncAPI{
+		AsyncAPI:           asyncAPIVersion,
+		Info:               info,
+		DefaultContentType: "application/json",
+		Channels:           map[string]*Channel{},
+	}
+	for _, subject := range s.subjects {
+		channel := &Channel{Description: subject.Summary}
+		op := &ChannelOperation{Summary: subject.Summary}
+		if subject.Module != "" {
+			op.Tags = []*Tag{{Name: subject.Module}}
+		}
+		if subject.Request != nil {
+			op.Message = &Message{Payload: SchemaOf(subject.Request)}
+		}
+		if subject.Reply != nil {
+			op.Reply = &Message{Payload: SchemaOf(subject.Reply)}
+		}
+		if subject.Event {
+			channel.Subscribe = op
+		} else {
+			channel.Publish = op
+		}
+		doc.Channels[subject.Subject] = channel
+	}
+	return doc
+}
+
+// HelpGuideSubjects converts the methods of a help guide, the args become the properties of the payload
+// or the json_body is used as an example of it
+func HelpGuideSubjects(guide *guides.HelpGuide) []Subject {
+	if guide == nil {
+		return nil
+	}
+	var subjects []Subject
+	for _, module := range guide.Modules {
+		for _, method := range module.Methods {
+			subject := Subject{Subject: method.Topic, Summary: method.Description, Module: module.Name}
+			switch {
+			case method.UseJSON && method.JSONBody != "":
+				subject.Request = ExampleSchema(method.JSONBody)
+			case len(method.Args) > 0:
+				payload := &Schema{Type: "object", Properties: map[string]*Schema{}}
+				for _, arg := range method.Args {
+					payload.Properties[arg.Name] = argSchema(arg.Type)
+				}
+				subject.Request = payload
+			}
+			subjects = append(subjects, subject)
+		}
+	}
+	return subjects
+}
+
+func argSchema(argType string) *Schema {
+	switch argType {
+	case "int":
+		return &Schema{Type: "integer"}
+	case "float":
+		return &Schema{Type: "number"}
+	case "bool":
+		return &Schema{Type: "boolean"}
+	}
+	return &Schema{Type: "string"}
+}
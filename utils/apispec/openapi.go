@@ -0,0 +1,242 @@
+package apispec
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const openAPIVersion = "3.0.3"
+
+// Info is the title and version of a document
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPI is an OpenAPI 3 document
+type OpenAPI struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components *Components                      `json:"components,omitempty"`
+	Security   []map[string][]string            `json:"security,omitempty"`
+}
+
+type Components struct {
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+}
+
+// BearerAuth is a JWT in the Authorization header
+func BearerAuth() *SecurityScheme {
+	return &SecurityScheme{Type: "http", Scheme: "bearer", BearerFormat: "JWT"}
+}
+
+// HeaderAuth is a token or API key in the named header
+func HeaderAuth(header string) *SecurityScheme {
+	return &SecurityScheme{Type: "apiKey", In: "header", Name: header}
+}
+
+type Operation struct {
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // path, query or header
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// Route describes a gin route for the OpenAPI document, the path uses the gin syntax, eg; /v1/api/hosts/:uuid
+type Route struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tags        []string     // defaults to the first path segment after the base path
+	Params      []*Parameter // query and header parameters, the path parameters are added from the path
+	Body        any          // request body, a Go value or a *Schema
+	BodyType    string       // content type of the body, defaults to application/json
+	Response    any          // 200 response body, a Go value or a *Schema
+	Public      bool         // doesn't need the security schemes
+}
+
+// OpenAPIOpts are the document wide settings
+type OpenAPIOpts struct {
+	Info            Info
+	BasePath        string                     // stripped when working out the default tag, eg; /v1/api
+	SecuritySchemes map[string]*SecurityScheme // any one of them is needed on the routes that aren't public
+}
+
+// Routes collects the route descriptions, it's safe to use from several goroutines
+type Routes struct {
+	lock   sync.RWMutex
+	routes map[string]Route
+}
+
+func NewRoutes() *Routes {
+	return &Routes{routes: map[string]Route{}}
+}
+
+// Add describes routes, a route described twice keeps the last description
+func (r *Routes) Add(routes ...Route) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, route := range routes {
+		r.routes[routeKey(route.Method, route.Path)] = route
+	}
+}
+
+// Handle registers a route on a gin group and describes it, the method and full path of the description come from the
+// registration so the docs can't drift from the routes
+func (r *Routes) Handle(group *gin.RouterGroup, method, relativePath string, route Route, handlers ...gin.HandlerFunc) {
+	route.Method, route.Path = method, joinPath(group.BasePath(), relativePath)
+	r.Add(route)
+	group.Handle(method, relativePath, handlers...)
+}
+
+// joinPath joins a route path to the group base path the way gin does, a trailing slash is kept
+func joinPath(basePath, relativePath string) string {
+	if relativePath == "" {
+		return basePath
+	}
+	joined := path.Join(basePath, relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(joined, "/") {
+		joined += "/"
+	}
+	return joined
+}
+
+// OpenAPI builds the document for the routes registered on gin, the routes without a description are listed
+// with just their path parameters
+func (r *Routes) OpenAPI(opts OpenAPIOpts, routes gin.RoutesInfo) *OpenAPI {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	doc := &OpenAPI{
+		OpenAPI: openAPIVersion,
+		Info:    opts.Info,
+		Paths:   map[string]map[string]*Operation{},
+	}
+	if len(opts.SecuritySchemes) > 0 {
+		doc.Components = &Components{SecuritySchemes: opts.SecuritySchemes}
+		names := make([]string, 0, len(opts.SecuritySchemes))
+		for name := range opts.SecuritySchemes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			doc.Security = append(doc.Security, map[string][]string{name: {}})
+		}
+	}
+	for _, info := range routes {
+		route, ok := r.routes[routeKey(info.Method, info.Path)]
+		if !ok {
+			route = Route{Method: info.Method, Path: info.Path}
+		}
+		path, params := OpenAPIPath(info.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*Operation{}
+		}
+		doc.Paths[path][strings.ToLower(info.Method)] = route.operation(opts.BasePath, params)
+	}
+	return doc
+}
+
+func (route Route) operation(basePath string, params []*Parameter) *Operation {
+	op := &Operation{
+		Summary:     route.Summary,
+		Description: route.Description,
+		Tags:        route.Tags,
+		Parameters:  append(params, route.Params...),
+		Responses:   map[string]*Response{},
+	}
+	if len(op.Tags) == 0 {
+		if tag := defaultTag(basePath, route.Path); tag != "" {
+			op.Tags = []string{tag}
+		}
+	}
+	if route.Body != nil {
+		bodyType := route.BodyType
+		if bodyType == "" {
+			bodyType = gin.MIMEJSON
+		}
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{bodyType: {Schema: SchemaOf(route.Body)}}}
+	}
+	ok := &Response{Description: http.StatusText(http.StatusOK)}
+	if route.Response != nil {
+		ok.Content = map[string]*MediaType{gin.MIMEJSON: {Schema: SchemaOf(route.Response)}}
+	}
+	op.Responses["200"] = ok
+	if route.Public {
+		// an empty requirement overrides the document security
+		op.Security = []map[string][]string{{}}
+	}
+	return op
+}
+
+// OpenAPIPath converts a gin path to an OpenAPI path with its parameters, eg; /hosts/:uuid is /hosts/{uuid}
+func OpenAPIPath(path string) (string, []*Parameter) {
+	var params []*Parameter
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "" || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+		name := segment[1:]
+		param := &Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}}
+		if segment[0] == '*' {
+			param.Description = "the rest of the path, it can contain /"
+		}
+		params = append(params, param)
+		segments[i] = "{" + name + "}"
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func defaultTag(basePath, path string) string {
+	for _, segment := range strings.Split(strings.TrimPrefix(path, basePath), "/") {
+		if segment != "" && segment[0] != ':' && segment[0] != '*' {
+			return segment
+		}
+	}
+	return ""
+}
+
+func routeKey(method, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
@@ -0,0 +1,172 @@
+package apispec
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is the JSON schema subset used by OpenAPI 3 and AsyncAPI 2
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Example              any                `json:"example,omitempty"`
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+/*
+SchemaOf builds the schema of a Go value from its type, a *Schema is returned as is. Struct fields use their json tag
+name, a `validate:"required"` tag marks the field as required and the `minLength`/`maxLength` tags are copied, eg;
+
+	Name string `json:"name" validate:"required,min=1,max=100" minLength:"1" maxLength:"100"`
+*/
+func SchemaOf(v any) *Schema {
+	if v == nil {
+		return nil
+	}
+	if schema, ok := v.(*Schema); ok {
+		return schema
+	}
+	return schemaOf(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawJSONType:
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		// a type that contains itself is only described once
+		if seen[t] {
+			return &Schema{Type: "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		addFields(schema, t, seen)
+		return schema
+	}
+	// interfaces and funcs can be anything
+	return &Schema{}
+}
+
+func addFields(schema *Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		// embedded structs without a json name are flattened, like encoding/json does
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				addFields(schema, embedded, seen)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := schemaOf(field.Type, seen)
+		if value, err := strconv.Atoi(field.Tag.Get("minLength")); err == nil {
+			property.MinLength = &value
+		}
+		if value, err := strconv.Atoi(field.Tag.Get("maxLength")); err == nil {
+			property.MaxLength = &value
+		}
+		if description := field.Tag.Get("description"); description != "" {
+			property.Description = description
+		}
+		schema.Properties[name] = property
+		if isRequired(field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+func isRequired(validate string) bool {
+	for _, rule := range strings.Split(validate, ",") {
+		if rule == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+// ExampleSchema builds the schema of a JSON example, eg; the json_body of a help guide method.
+// The example is kept on the schema, an invalid example is kept as a string.
+func ExampleSchema(example string) *Schema {
+	var value any
+	if err := json.Unmarshal([]byte(example), &value); err != nil {
+		return &Schema{Type: "string", Example: example}
+	}
+	schema := valueSchema(value)
+	schema.Example = value
+	return schema
+}
+
+func valueSchema(value any) *Schema {
+	switch v := value.(type) {
+	case map[string]any:
+		schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		for key, item := range v {
+			schema.Properties[key] = valueSchema(item)
+		}
+		return schema
+	case []any:
+		if len(v) == 0 {
+			return &Schema{Type: "array", Items: &Schema{}}
+		}
+		return &Schema{Type: "array", Items: valueSchema(v[0])}
+	case string:
+		return &Schema{Type: "string"}
+	case float64:
+		return &Schema{Type: "number"}
+	case bool:
+		return &Schema{Type: "boolean"}
+	}
+	return &Schema{}
+}
@@ -2,8 +2,10 @@ package appcommon
 
 import (
 	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/nats-io/nats.go"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"sync"
 )
 
 type App struct {
@@ -12,6 +14,11 @@ type App struct {
 	NatsConn    natlib.NatLib
 	Config      *viper.Viper
 	RootCmd     *cobra.Command
+
+	fileConfig      map[string]interface{}
+	kvWatcher       nats.KeyWatcher
+	configLock      sync.RWMutex
+	configCallbacks []ConfigChangeFunc
 }
 
 type Opts struct {
@@ -40,7 +40,17 @@ func (app *App) SetupCLI(customSetup func(app *App)) {
 		if natsURL == "" {
 			natsURL = nats.DefaultURL
 		}
-		app.NatsConn = natlib.New(natlib.NewOpts{URL: natsURL})
+		options, err := natlib.SeedFileOptions(app.Config.GetString("nats_seed_file"))
+		if err != nil {
+			return err
+		}
+		enableKV := app.Config.GetBool("kv.enable")
+		app.NatsConn = natlib.New(natlib.NewOpts{URL: natsURL, EnableJetStream: enableKV, Options: options})
+		if enableKV {
+			if err := app.InitKVConfig(app.Config.GetString("kv.bucket")); err != nil {
+				return fmt.Errorf("failed to load config from kv: %v", err)
+			}
+		}
 		return nil
 	}
 
@@ -0,0 +1,115 @@
+package appcommon
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultKVBucket is the bucket bios writes app config to
+const defaultKVBucket = "config"
+
+// ConfigChangeFunc is called when a config value is changed remotely, value is nil when the key was deleted
+type ConfigChangeFunc func(key string, value interface{})
+
+// InitKVConfig layers the values in the JetStream KV bucket over the file config.
+// Keys in the bucket are "<appID>.<config key>", eg; "app-abc.debug" or "app-abc.web.port".
+// Any later changes are applied live, see OnConfigChange.
+func (app *App) InitKVConfig(bucket string) error {
+	if bucket == "" {
+		bucket = defaultKVBucket
+	}
+	if err := app.NatsConn.CreateKVBucket(bucket, nil); err != nil {
+		return err
+	}
+	app.fileConfig = app.Config.AllSettings()
+	prefix := fmt.Sprintf("%s.", app.AppID)
+
+	kv, err := app.NatsConn.GetKVBucket(bucket)
+	if err != nil {
+		return err
+	}
+	// one watch sends the current values, a nil entry and then the updates, so no change is missed in between
+	watcher, err := kv.Watch(prefix + ">")
+	if err != nil {
+		return err
+	}
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		app.applyKVEntry(prefix, entry, false)
+	}
+	app.kvWatcher = watcher
+	go func() {
+		for entry := range watcher.Updates() {
+			if entry != nil {
+				app.applyKVEntry(prefix, entry, true)
+			}
+		}
+	}()
+	log.Info().Msgf("app: %s config layered from kv bucket: %s", app.AppID, bucket)
+	return nil
+}
+
+// OnConfigChange registers a callback for remote config changes
+func (app *App) OnConfigChange(cb ConfigChangeFunc) {
+	app.configLock.Lock()
+	defer app.configLock.Unlock()
+	app.configCallbacks = append(app.configCallbacks, cb)
+}
+
+// ConfigValue returns a config value, safe to call while KV changes are being applied
+func (app *App) ConfigValue(key string) interface{} {
+	app.configLock.RLock()
+	defer app.configLock.RUnlock()
+	return app.Config.Get(key)
+}
+
+func (app *App) applyKVEntry(prefix string, entry nats.KeyValueEntry, notify bool) {
+	key := strings.TrimPrefix(entry.Key(), prefix)
+	var value interface{}
+	if entry.Operation() == nats.KeyValuePut {
+		value = parseKVValue(entry.Value())
+	} else {
+		// deleted or purged, fall back to the value from the config file
+		value = fileValue(app.fileConfig, key)
+	}
+
+	app.configLock.Lock()
+	app.Config.Set(key, value)
+	callbacks := app.configCallbacks
+	app.configLock.Unlock()
+
+	log.Info().Msgf("app: %s config %s changed by kv revision %d", app.AppID, key, entry.Revision())
+	if notify {
+		for _, cb := range callbacks {
+			cb(key, value)
+		}
+	}
+}
+
+// parseKVValue decodes the value as YAML so "true" or "8080" keep their type
+func parseKVValue(data []byte) interface{} {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return string(data)
+	}
+	return value
+}
+
+// fileValue walks the nested settings for a dotted key
+func fileValue(settings map[string]interface{}, key string) interface{} {
+	var current interface{} = settings
+	for _, part := range strings.Split(strings.ToLower(key), ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
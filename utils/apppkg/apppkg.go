@@ -0,0 +1,124 @@
+package apppkg
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+/*
+Package apppkg parses the app package names and picks the packages that run on this device. A package is named
+<name>-v<version>[-<arch>].zip, eg; flexy-app-v1.0.3-arm64.zip, a package without an arch runs on any arch.
+*/
+
+// the supported archs
+const (
+	AMD64 = "amd64"
+	ARM64 = "arm64"
+	ARMv7 = "armv7"
+)
+
+// Archs are the supported archs
+var Archs = []string{AMD64, ARM64, ARMv7}
+
+// archAliases are the other names used for the archs, eg; by uname -m or debian
+var archAliases = map[string]string{
+	"amd64":   AMD64,
+	"x86_64":  AMD64,
+	"x64":     AMD64,
+	"arm64":   ARM64,
+	"aarch64": ARM64,
+	"armv7":   ARMv7,
+	"armv7l":  ARMv7,
+	"armhf":   ARMv7,
+	"arm":     ARMv7,
+}
+
+// HostArch is the arch bios is running on, a 32 bit arm build is armv7
+func HostArch() string {
+	return NormalizeArch(runtime.GOARCH)
+}
+
+// NormalizeArch returns the arch of a name like x86_64 or aarch64, empty when it isn't a supported arch
+func NormalizeArch(name string) string {
+	return archAliases[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// Compatible reports whether a package of an arch runs on the host arch
+func Compatible(hostArch, packageArch string) bool {
+	return packageArch == "" || packageArch == hostArch
+}
+
+// GoEnv is the GOARCH and GOARM to build for an arch
+func GoEnv(arch string) ([]string, error) {
+	switch NormalizeArch(arch) {
+	case AMD64:
+		return []string{"GOARCH=amd64"}, nil
+	case ARM64:
+		return []string{"GOARCH=arm64"}, nil
+	case ARMv7:
+		return []string{"GOARCH=arm", "GOARM=7"}, nil
+	}
+	return nil, fmt.Errorf("unsupported arch %q, try: %s", arch, strings.Join(Archs, ", "))
+}
+
+// Package is the name, version and arch of an app package
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch,omitempty"`
+}
+
+// packageNameRegex captures the name, the version and anything after the version. The version is a token of its own,
+// so the v of an arch (armv7) isn't taken as the version
+var packageNameRegex = regexp.MustCompile(`^(.+?)[-_]v(\d+(\.\d+)*)([-_].*)?$`)
+
+// preReleaseRegex matches a pre-release straight after the version, eg; the -rc.1 of flexy-app-v2.0.0-rc.1-amd64
+var preReleaseRegex = regexp.MustCompile(`^-((?:alpha|beta|rc|pre|dev)(?:\.?[0-9A-Za-z]+)*)`)
+
+// ParseFileName parses a package file name, eg; flexy-app-v1.0.3-amd64.zip is flexy-app v1.0.3 amd64. A pre-release
+// after the version is kept in the version (v2.0.0-rc.1). An arch anywhere after the version is taken as the arch,
+// the rest of the suffix stays part of the name.
+func ParseFileName(fileName string) (*Package, error) {
+	base := strings.TrimSuffix(fileName, ".zip")
+	matches := packageNameRegex.FindStringSubmatch(base)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid app package name %q, expected eg; flexy-app-v1.0.3-amd64.zip", fileName)
+	}
+	pkg := &Package{Version: "v" + matches[2]}
+	rest := matches[4]
+	if pre := preReleaseRegex.FindStringSubmatch(rest); pre != nil {
+		pkg.Version += "-" + pre[1]
+		rest = rest[len(pre[0]):]
+	}
+	var suffix []string
+	for _, token := range nameTokens(rest) {
+		if arch := NormalizeArch(token); arch != "" && pkg.Arch == "" {
+			pkg.Arch = arch
+			continue
+		}
+		suffix = append(suffix, token)
+	}
+	pkg.Name = strings.Trim(matches[1], "-_")
+	if len(suffix) > 0 {
+		pkg.Name += "-" + strings.Join(suffix, "-")
+	}
+	return pkg, nil
+}
+
+// ArchOf finds the arch in a name that may not have a version, eg; flexy-app-armv7.zip
+func ArchOf(name string) string {
+	for _, token := range nameTokens(strings.TrimSuffix(name, ".zip")) {
+		if arch := NormalizeArch(token); arch != "" {
+			return arch
+		}
+	}
+	return ""
+}
+
+func nameTokens(name string) []string {
+	// x86_64 is the one arch with a separator in it
+	name = strings.ReplaceAll(name, "x86_64", AMD64)
+	return strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' })
+}
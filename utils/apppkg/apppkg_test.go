@@ -0,0 +1,49 @@
+package apppkg
+
+import "testing"
+
+func TestParseFileName(t *testing.T) {
+	for name, want := range map[string]Package{
+		"app-abc-v1.0.3-amd64.zip":           {Name: "app-abc", Version: "v1.0.3", Arch: AMD64},
+		"flexy-app-v1.0.3-aarch64.zip":       {Name: "flexy-app", Version: "v1.0.3", Arch: ARM64},
+		"flexy_app_v1.0.3_armhf.zip":         {Name: "flexy_app", Version: "v1.0.3", Arch: ARMv7},
+		"flexy-app-v1.0.3.zip":               {Name: "flexy-app", Version: "v1.0.3"},
+		"flexy-app-v2.1-x86_64.zip":          {Name: "flexy-app", Version: "v2.1", Arch: AMD64},
+		"flexy-app-v1.0.3-lite-arm.zip":      {Name: "flexy-app-lite", Version: "v1.0.3", Arch: ARMv7},
+		"flexy-app-v2.0.0-rc.1-amd64.zip":    {Name: "flexy-app", Version: "v2.0.0-rc.1", Arch: AMD64},
+		"flexy-app-v1.1.0-beta2.zip":         {Name: "flexy-app", Version: "v1.1.0-beta2"},
+		"flexy-app-v2.1-rc.1-lite-arm64.zip": {Name: "flexy-app-lite", Version: "v2.1-rc.1", Arch: ARM64},
+	} {
+		got, err := ParseFileName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != want {
+			t.Errorf("ParseFileName(%q) = %+v, want %+v", name, *got, want)
+		}
+	}
+	for _, name := range []string{"flexy-app.zip", "flexy-app-armv7.zip"} {
+		if _, err := ParseFileName(name); err == nil {
+			t.Errorf("expected %s without a version to be invalid", name)
+		}
+	}
+	if arch := ArchOf("flexy-app-x86_64.zip"); arch != AMD64 {
+		t.Errorf("ArchOf = %q, want amd64", arch)
+	}
+}
+
+func TestArch(t *testing.T) {
+	if HostArch() == "" {
+		t.Skip("unsupported test arch")
+	}
+	if !Compatible(ARM64, "") || !Compatible(ARM64, ARM64) || Compatible(ARM64, AMD64) {
+		t.Error("a package runs on its own arch or on any arch without one")
+	}
+	env, err := GoEnv("armv7l")
+	if err != nil || len(env) != 2 || env[1] != "GOARM=7" {
+		t.Errorf("env = %v %v", env, err)
+	}
+	if _, err := GoEnv("mips"); err == nil {
+		t.Error("expected mips to be unsupported")
+	}
+}
@@ -7,10 +7,20 @@ import (
 )
 
 var (
-	CasbinEnforcer *casbin.SyncedEnforcer
+	CasbinEnforcer     *casbin.SyncedEnforcer
+	NatsCasbinEnforcer *casbin.SyncedEnforcer
 )
 
 func SetupCasbin() *casbin.SyncedEnforcer {
 	CasbinEnforcer = model.SetupCasbin()
 	return CasbinEnforcer
 }
+
+func SetupNatsCasbin() (*casbin.SyncedEnforcer, error) {
+	enforcer, err := model.SetupNatsCasbin()
+	if err != nil {
+		return nil, err
+	}
+	NatsCasbinEnforcer = enforcer
+	return NatsCasbinEnforcer, nil
+}
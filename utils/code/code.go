@@ -1,12 +1,17 @@
 package code
 
 const (
-	SUCCESS        = 200
-	ERROR          = 500
-	InvalidParams  = 400
-	TokenInvalid   = 401
-	UnknownError   = 900
-	UnknownCommand = 902
+	SUCCESS            = 200
+	ERROR              = 500
+	InvalidParams      = 400
+	TokenInvalid       = 401
+	Forbidden          = 403
+	NotFound           = 404
+	TooManyRequests    = 429
+	ServiceUnavailable = 503
+	Timeout            = 504
+	UnknownError       = 900
+	UnknownCommand     = 902
 
 	ErrorAuthCheckTokenFail     = 20001
 	ErrorAuthCheckTokenTimeout  = 20002
@@ -26,6 +31,11 @@ var MsgFlags = map[int]string{
 	UnknownCommand:              "Unknown command",
 	InvalidParams:               "Request parameter error",
 	TokenInvalid:                "Token parameter is invalid or does not exist",
+	Forbidden:                   "Permission denied",
+	NotFound:                    "Not found",
+	TooManyRequests:             "Too many requests",
+	ServiceUnavailable:          "Service unavailable",
+	Timeout:                     "Request timeout",
 	ErrorAuthCheckTokenFail:     "Token authorization failed",
 	ErrorAuthCheckTokenTimeout:  "Token has expired",
 	ErrorAuthToken:              "Token generation failed",
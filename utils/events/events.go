@@ -0,0 +1,227 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+// Standard event types, published on <uuid>.event.<type>
+const (
+	AppInstalled     = "app.installed"
+	AppUninstalled   = "app.uninstalled"
+	AppConfigChanged = "app.config.changed" // the data has who changed the file and the diff
+	AppUpgraded      = "app.upgraded"       // an update was installed by its policy or an upgrade request
+	AppUpgradeFailed = "app.upgrade.failed" // the upgrade was rolled back to the old version
+
+	UpdateAvailable = "update.available" // a newer release of an installed app was found
+
+	ServiceStarted   = "service.started"
+	ServiceStopped   = "service.stopped"
+	ServiceRestarted = "service.restarted"
+	ServiceEnabled   = "service.enabled"
+	ServiceDisabled  = "service.disabled"
+	ServiceFailed    = "service.failed" // the unit crashed or failed to start
+
+	StoreObjectAdded   = "store.object.added"
+	StoreObjectDeleted = "store.object.deleted"
+	StoreCreated       = "store.created"
+	StoreDropped       = "store.dropped"
+	StorePurged        = "store.purged"
+
+	LibrarySynced     = "library.synced"      // an app zip was copied from the watched store into the library
+	LibraryPruned     = "library.pruned"      // an app zip deleted from the watched store was removed from the library
+	LibrarySyncFailed = "library.sync.failed" // eg; the digest didn't match
+
+	HostCreated = "host.created"
+	HostUpdated = "host.updated"
+	HostDeleted = "host.deleted"
+)
+
+// DefaultMaxAge is how long events are kept in the stream
+const DefaultMaxAge = 7 * 24 * time.Hour
+
+// Event is the payload of every message in the stream
+type Event struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	GlobalUUID string          `json:"globalUUID"`
+	Source     string          `json:"source"` // eg; bios, ros
+	Time       time.Time       `json:"time"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+// Subject returns the subject an event type is published on, eg; abc.event.app.installed
+func Subject(globalUUID, eventType string) string {
+	return fmt.Sprintf("%s.event.%s", globalUUID, eventType)
+}
+
+// StreamName returns the name of the stream for a device, stream names can't contain "." "*" ">" or spaces
+func StreamName(globalUUID string) string {
+	name := strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(globalUUID)
+	return fmt.Sprintf("EVENTS_%s", name)
+}
+
+// StreamConfig is the stream of all the events for a device
+func StreamConfig(globalUUID string, maxAge time.Duration) *nats.StreamConfig {
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	return &nats.StreamConfig{
+		Name:     StreamName(globalUUID),
+		Subjects: []string{Subject(globalUUID, ">")},
+		Storage:  nats.FileStorage,
+		MaxAge:   maxAge,
+	}
+}
+
+// CreateStream adds the device stream if it doesn't exist
+func CreateStream(js nats.JetStreamContext, globalUUID string, maxAge time.Duration) error {
+	config := StreamConfig(globalUUID, maxAge)
+	_, err := js.StreamInfo(config.Name)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return err
+	}
+	_, err = js.AddStream(config)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("event stream %s created", config.Name)
+	return nil
+}
+
+// NewEvent creates an event with a new id
+func NewEvent(globalUUID, source, eventType string, data any) (*Event, error) {
+	event := &Event{
+		ID:         uuid.NewString(),
+		Type:       eventType,
+		GlobalUUID: globalUUID,
+		Source:     source,
+		Time:       time.Now().UTC(),
+	}
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		event.Data = b
+	}
+	return event, nil
+}
+
+// Decode decodes an event from a stream message
+func Decode(m *nats.Msg) (*Event, error) {
+	var event Event
+	if err := json.Unmarshal(m.Data, &event); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+	return &event, nil
+}
+
+// Publisher publishes events for a device. A nil Publisher does nothing, so
+// services can call Publish without checking if events are enabled.
+type Publisher struct {
+	globalUUID string
+	source     string
+	js         nats.JetStreamContext
+	nc         *nats.Conn
+}
+
+// NewPublisher publishes events into the device stream, creating it if needed
+func NewPublisher(js nats.JetStreamContext, globalUUID, source string, maxAge time.Duration) (*Publisher, error) {
+	if err := CreateStream(js, globalUUID, maxAge); err != nil {
+		return nil, err
+	}
+	return &Publisher{globalUUID: globalUUID, source: source, js: js}, nil
+}
+
+// NewCorePublisher publishes events as plain NATS messages, for services on the
+// local broker where bios relays them into the device stream
+func NewCorePublisher(nc *nats.Conn, globalUUID, source string) *Publisher {
+	return &Publisher{globalUUID: globalUUID, source: source, nc: nc}
+}
+
+// Publish publishes an event, errors are logged as an event should never fail the request that caused it
+func (p *Publisher) Publish(eventType string, data any) {
+	if p == nil {
+		return
+	}
+	event, err := NewEvent(p.globalUUID, p.source, eventType, data)
+	if err != nil {
+		log.Error().Msgf("failed to create event %s: %v", eventType, err)
+		return
+	}
+	if err := p.PublishEvent(event); err != nil {
+		log.Error().Msgf("failed to publish event %s: %v", eventType, err)
+	}
+}
+
+// PublishEvent publishes an existing event, the id is used to drop duplicates in the stream
+func (p *Publisher) PublishEvent(event *Event) error {
+	if p == nil {
+		return nil
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	subject := Subject(p.globalUUID, event.Type)
+	if p.js != nil {
+		_, err = p.js.Publish(subject, b, nats.MsgId(event.ID))
+		return err
+	}
+	return p.nc.Publish(subject, b)
+}
+
+// SubscribeOpts controls where a subscription starts. With a Durable name the
+// consumer remembers the last acked event, so a client that reconnects gets
+// everything it missed. Otherwise Since or StartSeq replay from a point in the stream,
+// and with none set every event in the stream is delivered.
+type SubscribeOpts struct {
+	Durable  string
+	Filter   string // an event type, wildcards are allowed, eg; app.*
+	Since    time.Time
+	StartSeq uint64
+}
+
+// Subscribe calls cb for each event in the device stream, events are acked after cb returns
+func Subscribe(js nats.JetStreamContext, globalUUID string, opts SubscribeOpts, cb func(event *Event, meta *nats.MsgMetadata)) (*nats.Subscription, error) {
+	filter := opts.Filter
+	if filter == "" {
+		filter = ">"
+	}
+	subOpts := []nats.SubOpt{
+		nats.BindStream(StreamName(globalUUID)),
+		nats.ManualAck(),
+	}
+	switch {
+	case opts.StartSeq > 0:
+		subOpts = append(subOpts, nats.StartSequence(opts.StartSeq))
+	case !opts.Since.IsZero():
+		subOpts = append(subOpts, nats.StartTime(opts.Since))
+	default:
+		subOpts = append(subOpts, nats.DeliverAll())
+	}
+	if opts.Durable != "" {
+		subOpts = append(subOpts, nats.Durable(opts.Durable))
+	}
+	return js.Subscribe(Subject(globalUUID, filter), func(m *nats.Msg) {
+		meta, _ := m.Metadata()
+		event, err := Decode(m)
+		if err != nil {
+			log.Error().Msgf("event stream: %v", err)
+		} else {
+			cb(event, meta)
+		}
+		m.Ack()
+	}, subOpts...)
+}
@@ -0,0 +1,66 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/NubeDev/flexy/utils/subjects"
+	"github.com/nats-io/nats.go"
+)
+
+func TestStream(t *testing.T) {
+	if got := Subject("abc", AppInstalled); got != "abc.event.app.installed" {
+		t.Errorf("Subject() = %s", got)
+	}
+	if got := StreamName("edge.01 *"); got != "EVENTS_edge_01__" {
+		t.Errorf("StreamName() = %s", got)
+	}
+	config := StreamConfig("abc", 0)
+	if config.MaxAge != DefaultMaxAge {
+		t.Errorf("MaxAge = %s want %s", config.MaxAge, DefaultMaxAge)
+	}
+	for _, eventType := range []string{AppInstalled, ServiceFailed, StoreObjectAdded, HostDeleted} {
+		if !subjects.Match(Subject("abc", eventType), config.Subjects[0]) {
+			t.Errorf("stream should capture %s", eventType)
+		}
+	}
+	if subjects.Match(Subject("xyz", AppInstalled), config.Subjects[0]) {
+		t.Error("stream should not capture another device's events")
+	}
+}
+
+func TestEventRoundTrip(t *testing.T) {
+	event, err := NewEvent("abc", "bios", AppInstalled, map[string]string{"name": "flexy-app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.ID == "" || event.Time.IsZero() {
+		t.Fatal("event should have an id and time")
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(&nats.Msg{Data: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != event.ID || decoded.Type != AppInstalled || decoded.Source != "bios" {
+		t.Errorf("decoded event = %+v", decoded)
+	}
+	var data map[string]string
+	if err := json.Unmarshal(decoded.Data, &data); err != nil || data["name"] != "flexy-app" {
+		t.Errorf("decoded data = %s", decoded.Data)
+	}
+	if _, err := Decode(&nats.Msg{Data: []byte("not json")}); err == nil {
+		t.Error("expected an error for an invalid event")
+	}
+}
+
+func TestNilPublisher(t *testing.T) {
+	var p *Publisher
+	p.Publish(AppInstalled, nil)
+	if err := p.PublishEvent(&Event{}); err != nil {
+		t.Errorf("nil publisher should do nothing, got %v", err)
+	}
+}
@@ -3,25 +3,32 @@ package githubdownloader
 import (
 	"archive/zip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"time"
 
+	"github.com/NubeDev/flexy/utils/apppkg"
 	"github.com/google/go-github/v49/github"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/oauth2"
 )
 
 // RepoAsset holds information about a repository asset.
 type RepoAsset struct {
-	Owner string `json:"owner"`
-	Repo  string `json:"repo"`
-	Tag   string `json:"tag"`
-	Arch  string `json:"arch"`
-	Token string `json:"token"`
+	Owner  string `json:"owner"`
+	Repo   string `json:"repo"`
+	Tag    string `json:"tag"`
+	Arch   string `json:"arch"`
+	Token  string `json:"token"`
+	Source string `json:"source,omitempty"` // a release source configured in bios, or github
+	URL    string `json:"url,omitempty"`    // not allowed by bios, the server of a source is set in release_sources
 }
 
 // Asset represents a release asset with additional metadata.
@@ -33,44 +40,86 @@ type Asset struct {
 	ReleaseTag         string `json:"release_tag"`
 	Version            string `json:"version"`
 	Arch               string `json:"arch"`
+	SHA256             string `json:"sha256,omitempty"`       // when the source publishes it, eg; in an http index
+	ChecksumURL        string `json:"checksum_url,omitempty"` // the checksums file published with the release
+	PackageURL         string `json:"package_url,omitempty"`  // the app zip uploaded to a github release, see DownloadAsset
 }
 
 // GitHubDownloader is a client for downloading GitHub releases.
 type GitHubDownloader struct {
-	client          *github.Client
-	gitDownloadPath string
-	ctx             context.Context
+	client           *github.Client
+	baseURL          *url.URL // nil for api.github.com
+	token            string
+	gitDownloadPath  string
+	cacheDir         string
+	maxRateLimitWait time.Duration
+	sleep            func(time.Duration)
+	ctx              context.Context
+}
+
+// DownloadResult is where a download was saved and its sha256
+type DownloadResult struct {
+	Path     string `json:"path"`
+	SHA256   string `json:"sha256"` // of the saved file
+	Size     int64  `json:"size"`
+	Verified bool   `json:"verified"` // the download matched the sha256 published with the release
+	Resumed  bool   `json:"resumed,omitempty"`
 }
 
 // New creates a new GitHubDownloader instance.
 func New(token, gitDownloadPath string) *GitHubDownloader {
-	ctx := context.Background()
-	var httpClient *http.Client
-	if token != "" {
-		ts := oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: token},
-		)
-		httpClient = oauth2.NewClient(ctx, ts)
+	gd := &GitHubDownloader{
+		token:            token,
+		gitDownloadPath:  gitDownloadPath,
+		cacheDir:         DefaultCacheDir,
+		maxRateLimitWait: DefaultMaxRateLimitWait,
+		sleep:            time.Sleep,
+		ctx:              context.Background(),
 	}
-	client := github.NewClient(httpClient)
+	gd.newClient()
+	return gd
+}
 
-	return &GitHubDownloader{
-		client:          client,
-		gitDownloadPath: gitDownloadPath,
-		ctx:             ctx,
+// NewWithURL is a GitHubDownloader for another API server, eg; https://github.example.com/api/v3/ for GitHub Enterprise
+func NewWithURL(baseURL, token, gitDownloadPath string) (*GitHubDownloader, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("invalid github url %s: %w", baseURL, err)
 	}
+	gd := New(token, gitDownloadPath)
+	gd.baseURL = endpoint
+	gd.newClient()
+	return gd, nil
 }
 
-// UpdateToken updates the authentication token and recreates the GitHub client.
-func (gd *GitHubDownloader) UpdateToken(token string) {
-	var httpClient *http.Client
-	if token != "" {
+// newClient creates the GitHub client, its requests go through the ETag cache and rate limit backoff
+func (gd *GitHubDownloader) newClient() {
+	var base http.RoundTripper = http.DefaultTransport
+	if gd.token != "" {
 		ts := oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: token},
+			&oauth2.Token{AccessToken: gd.token},
 		)
-		httpClient = oauth2.NewClient(gd.ctx, ts)
+		base = oauth2.NewClient(gd.ctx, ts).Transport
+	}
+	transport := &cacheTransport{
+		base:     base,
+		keyExtra: gd.token,
+		maxWait:  gd.maxRateLimitWait,
+		sleep:    gd.sleep,
+	}
+	if gd.cacheDir != "" {
+		transport.dir = filepath.Join(gd.cacheDir, "etag")
+	}
+	gd.client = github.NewClient(&http.Client{Transport: transport})
+	if gd.baseURL != nil {
+		gd.client.BaseURL = gd.baseURL
 	}
-	gd.client = github.NewClient(httpClient)
+}
+
+// UpdateToken updates the authentication token and recreates the GitHub client.
+func (gd *GitHubDownloader) UpdateToken(token string) {
+	gd.token = token
+	gd.newClient()
 }
 
 // UpdateDownloadPath updates the download path for assets.
@@ -78,74 +127,165 @@ func (gd *GitHubDownloader) UpdateDownloadPath(path string) {
 	gd.gitDownloadPath = path
 }
 
+// SetCacheDir sets where the release metadata and partial downloads are kept, an empty dir turns the ETag cache off
+func (gd *GitHubDownloader) SetCacheDir(dir string) {
+	gd.cacheDir = dir
+	gd.newClient()
+}
+
+// SetMaxRateLimitWait sets the longest a request waits for the rate limit to reset, 0 fails straight away
+func (gd *GitHubDownloader) SetMaxRateLimitWait(wait time.Duration) {
+	gd.maxRateLimitWait = wait
+	gd.newClient()
+}
+
 // DownloadRelease downloads the specified release zip (using the zipball URL),
 // unzips it, and rezips it without the outer folder. It saves the final zip file
 // to the provided destination directory, using the release name from GitHub.
-func (gd *GitHubDownloader) DownloadRelease(url, destinationDir, releaseName string) error {
+// A download that was cut off is resumed the next time, see DownloadAsset to check a published sha256.
+func (gd *GitHubDownloader) DownloadRelease(url, destinationDir, releaseName string) (*DownloadResult, error) {
+	return gd.downloadRelease(url, destinationDir, releaseName, "")
+}
+
+// downloadRelease checks the downloaded zipball against expectedSHA256 when it's set, before it's re-zipped
+func (gd *GitHubDownloader) downloadRelease(url, destinationDir, releaseName, expectedSHA256 string) (*DownloadResult, error) {
 	// Ensure the destination directory is not empty
 	if destinationDir == "" {
-		return fmt.Errorf("destination directory cannot be empty")
+		return nil, fmt.Errorf("destination directory cannot be empty")
 	}
 
-	// Create a temporary zip file for the download
-	tempZipFile, err := os.CreateTemp("", "github_release_*.zip")
+	partPath := gd.partialPath(url)
+	resumed, err := gd.fetch(url, partPath)
+	if err != nil {
+		return nil, err
+	}
+	sum, _, err := fileSHA256(partPath)
 	if err != nil {
-		return fmt.Errorf("error creating temporary file: %w", err)
+		return nil, err
+	}
+	if expectedSHA256 != "" && !strings.EqualFold(sum, expectedSHA256) {
+		removePartial(partPath)
+		return nil, fmt.Errorf("sha256 mismatch for %s: got %s, want %s", releaseName, sum, expectedSHA256)
 	}
-	defer os.Remove(tempZipFile.Name()) // Ensure the temp file is removed afterwards
-	defer tempZipFile.Close()
 
-	// Create a new HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+	// Unzip the contents to a temporary directory, stripping the outer folder
+	tempDir, err := os.MkdirTemp("", "github_release_unzip_*")
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return nil, fmt.Errorf("error creating temp directory: %w", err)
 	}
+	defer os.RemoveAll(tempDir)
 
-	// Use the GitHub client to execute the request
-	resp, err := gd.client.Client().Do(req)
+	err = unzipWithoutOuterFolder(partPath, tempDir)
 	if err != nil {
-		return fmt.Errorf("error making the request: %w", err)
+		// a corrupt zip can't be resumed
+		removePartial(partPath)
+		return nil, fmt.Errorf("error unzipping file: %w", err)
 	}
-	defer resp.Body.Close()
 
-	// Check if the request was successful
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download file: %s", resp.Status)
+	// Create the final zip file name from the release name
+	finalZipName := fmt.Sprintf("%s.zip", releaseName)
+	finalZipPath := filepath.Join(destinationDir, finalZipName)
+
+	// Rezip the contents directly to the destination
+	err = zipDirectory(tempDir, finalZipPath)
+	if err != nil {
+		return nil, fmt.Errorf("error creating final zip file: %w", err)
 	}
+	removePartial(partPath)
 
-	// Write the response body (the zip file) to the temporary file
-	_, err = io.Copy(tempZipFile, resp.Body)
+	finalSum, size, err := fileSHA256(finalZipPath)
 	if err != nil {
-		return fmt.Errorf("error writing to temp file: %w", err)
+		return nil, err
+	}
+	log.Info().Msgf("downloaded and re-zipped the release to %s", finalZipPath)
+	return &DownloadResult{Path: finalZipPath, SHA256: finalSum, Size: size, Verified: expectedSHA256 != "", Resumed: resumed}, nil
+}
+
+// partialPath is where a download is written until it's complete, by its url
+func (gd *GitHubDownloader) partialPath(url string) string {
+	dir := gd.cacheDir
+	if dir == "" {
+		dir = DefaultCacheDir
 	}
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, "downloads", hex.EncodeToString(sum[:8])+".part")
+}
 
-	// Rewind the temp file for reading
-	tempZipFile.Seek(0, 0)
+// fetch downloads a url into the partial file, it's resumed with a Range request when the server sent an ETag for it
+func (gd *GitHubDownloader) fetch(url, partPath string) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(partPath), os.ModePerm); err != nil {
+		return false, err
+	}
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+	etag, _ := os.ReadFile(partPath + ".etag")
 
-	// Unzip the contents to a temporary directory, stripping the outer folder
-	tempDir, err := os.MkdirTemp("", "github_release_unzip_*")
+	// Create a new HTTP request
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("error creating temp directory: %w", err)
+		return false, fmt.Errorf("error creating request: %w", err)
+	}
+	if offset > 0 && len(etag) > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// the whole file is sent again if it changed since
+		req.Header.Set("If-Range", string(etag))
 	}
-	defer os.RemoveAll(tempDir)
 
-	err = unzipWithoutOuterFolder(tempZipFile.Name(), tempDir)
+	// Use the GitHub client to execute the request
+	resp, err := gd.client.Client().Do(req)
 	if err != nil {
-		return fmt.Errorf("error unzipping file: %w", err)
+		return false, fmt.Errorf("error making the request: %w", err)
 	}
+	defer resp.Body.Close()
 
-	// Create the final zip file name from the release name
-	finalZipName := fmt.Sprintf("%s.zip", releaseName)
-	finalZipPath := filepath.Join(destinationDir, finalZipName)
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	var resumed bool
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPartialContent:
+		flag = os.O_WRONLY | os.O_APPEND
+		resumed = true
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial file was already complete
+		if resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+			return true, nil
+		}
+		removePartial(partPath)
+		return false, fmt.Errorf("failed to resume download: %s", resp.Status)
+	default:
+		return false, fmt.Errorf("failed to download file: %s", resp.Status)
+	}
+	if !resumed {
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			err = os.WriteFile(partPath+".etag", []byte(etag), 0600)
+		} else {
+			err = os.Remove(partPath + ".etag")
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
 
-	// Rezip the contents directly to the destination
-	err = zipDirectory(tempDir, finalZipPath)
+	// Write the response body (the zip file) to the partial file, it's kept when the download is cut off
+	out, err := os.OpenFile(partPath, flag, 0600)
+	if err != nil {
+		return false, fmt.Errorf("error creating partial file: %w", err)
+	}
+	_, err = io.Copy(out, resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		return fmt.Errorf("error creating final zip file: %w", err)
+		return false, fmt.Errorf("error writing to partial file: %w", err)
 	}
+	return resumed, nil
+}
 
-	fmt.Printf("Successfully downloaded and re-zipped the release to %s\n", finalZipPath)
-	return nil
+func removePartial(partPath string) {
+	os.Remove(partPath)
+	os.Remove(partPath + ".etag")
 }
 
 // unzipWithoutOuterFolder extracts a zip file to the given destination directory,
@@ -269,30 +409,12 @@ func (gd *GitHubDownloader) DownloadReleaseByArchVersion(owner, repo, version, a
 	if err != nil {
 		return err
 	}
-	var archMatch bool
-	var versionMatch bool
-	for _, asset := range assets {
-		if asset.Arch == arch {
-			archMatch = true
-			if asset.Version == version {
-				versionMatch = true
-				err := gd.DownloadRelease(asset.ZipDownloadURL, destinationDir, asset.Name)
-				if err != nil {
-					return err
-				}
-				return nil
-			}
-
-		}
-	}
-	if !archMatch {
-		return fmt.Errorf("%s is not a valid version", arch)
-	}
-	if !versionMatch {
-		return fmt.Errorf("%s is not a valid version", version)
+	asset, err := SelectAsset(assets, version, arch)
+	if err != nil {
+		return err
 	}
-	return nil
-
+	_, err = gd.DownloadAsset(*asset, destinationDir)
+	return err
 }
 
 // ListAllAssets lists all assets across all releases.
@@ -305,39 +427,44 @@ func (gd *GitHubDownloader) ListAllAssets(owner, repo string, opts *github.ListO
 		return nil, err
 	}
 	var allAssets []Asset
-	versionRegex := regexp.MustCompile(`-v(\d+\.\d+\.\d+)`)
 	for _, asset := range releases {
-		assetName := asset.GetName()
-		// Extract version and architecture from the asset name
-		version := ""
-		arch := ""
-		// Extract version
-		versionMatch := versionRegex.FindStringSubmatch(assetName)
-		if len(versionMatch) > 1 {
-			version = versionMatch[1] // Extract the version number
+		version, arch := parseAssetName(asset.GetName())
+		if version == "" {
+			version = asset.GetTagName()
 		}
-
-		// Extract architecture (only amd64 and armv7 for now)
-		if strings.Contains(assetName, "amd64") {
-			arch = "amd64"
-		} else if strings.Contains(assetName, "armv7") {
-			arch = "armv7"
+		var checksumURL, packageURL string
+		for _, releaseAsset := range asset.Assets {
+			switch {
+			case isChecksumsFile(releaseAsset.GetName()):
+				checksumURL = releaseAsset.GetBrowserDownloadURL()
+			case releaseAsset.GetName() == assetFileName(asset.GetName()):
+				packageURL = releaseAsset.GetBrowserDownloadURL()
+			}
 		}
-
 		allAssets = append(allAssets, Asset{
 			Name:               asset.GetName(),
 			BrowserDownloadURL: asset.GetAssetsURL(),
 			ZipDownloadURL:     asset.GetZipballURL(),
 			AssetID:            asset.GetID(),
 			ReleaseTag:         asset.GetTagName(),
-			Version:            fmt.Sprintf("v%s", version),
+			Version:            version,
 			Arch:               arch,
+			ChecksumURL:        checksumURL,
+			PackageURL:         packageURL,
 		})
 	}
 
 	return allAssets, nil
 }
 
+// parseAssetName extracts the version and architecture from an asset name, eg; flexy-app-v1.0.3-amd64
+func parseAssetName(assetName string) (version, arch string) {
+	if pkg, err := apppkg.ParseFileName(assetName); err == nil {
+		return pkg.Version, pkg.Arch
+	}
+	return "", apppkg.ArchOf(assetName)
+}
+
 // ListAssetsByVersion lists all assets for a specific release tag.
 func (gd *GitHubDownloader) ListAssetsByVersion(owner, repo, tag string) ([]Asset, error) {
 	release, _, err := gd.client.Repositories.GetReleaseByTag(gd.ctx, owner, repo, tag)
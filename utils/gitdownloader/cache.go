@@ -0,0 +1,227 @@
+package githubdownloader
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+/*
+The cache directory keeps the release metadata by ETag (<cache>/etag) and the partial downloads (<cache>/downloads), so
+a release list that didn't change is answered with a 304 that doesn't count against the GitHub rate limit and a
+download that was cut off is resumed with a Range request.
+*/
+
+// DefaultCacheDir is used until SetCacheDir is called
+var DefaultCacheDir = filepath.Join(os.TempDir(), "flexy-git-cache")
+
+const (
+	// DefaultMaxRateLimitWait is the longest a request waits for the rate limit to reset before it fails
+	DefaultMaxRateLimitWait = time.Minute
+	rateLimitRetries        = 3
+)
+
+// cachedResponse is a GET response kept by its ETag
+type cachedResponse struct {
+	ETag   string      `json:"etag"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// cacheTransport caches the JSON GET responses by ETag and waits out the GitHub rate limit
+type cacheTransport struct {
+	base     http.RoundTripper
+	dir      string
+	keyExtra string // the token, so another token doesn't read a private repo from the cache
+	maxWait  time.Duration
+	sleep    func(time.Duration)
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cacheable := req.Method == http.MethodGet && req.Header.Get("Range") == "" && t.dir != ""
+	var cached *cachedResponse
+	var cachePath string
+	if cacheable {
+		sum := sha256.Sum256([]byte(t.keyExtra + " " + req.URL.String()))
+		cachePath = filepath.Join(t.dir, hex.EncodeToString(sum[:])+".json")
+		cached = readCachedResponse(cachePath)
+		if cached != nil {
+			req = req.Clone(req.Context())
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+	}
+	resp, err := t.roundTripWithBackoff(req)
+	if err != nil || !cacheable {
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		header := cached.Header.Clone()
+		header.Set("X-From-Cache", "1")
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(cached.Body)),
+			ContentLength: int64(len(cached.Body)),
+			Request:       req,
+		}, nil
+	}
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err := writeCachedResponse(cachePath, &cachedResponse{ETag: etag, Header: resp.Header, Body: body}); err != nil {
+		log.Warn().Msgf("git cache: failed to save %s: %v", req.URL, err)
+	}
+	return resp, nil
+}
+
+// roundTripWithBackoff retries a request that hit the rate limit once it resets, if that's within maxWait
+func (t *cacheTransport) roundTripWithBackoff(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		wait, limited := rateLimitWait(resp, time.Now())
+		if !limited || attempt >= rateLimitRetries || wait > t.maxWait || req.Body != nil {
+			return resp, nil
+		}
+		resp.Body.Close()
+		log.Warn().Msgf("git rate limit reached, retrying %s in %s", req.URL, wait)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		default:
+		}
+		t.sleep(wait)
+	}
+}
+
+// rateLimitWait is how long to wait after a rate limited response, from the Retry-After or X-RateLimit-Reset headers
+func rateLimitWait(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	wait := time.Unix(reset, 0).Sub(now) + time.Second
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+func readCachedResponse(path string) *cachedResponse {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	cached := &cachedResponse{}
+	if err := json.Unmarshal(data, cached); err != nil || cached.ETag == "" {
+		return nil
+	}
+	return cached
+}
+
+func writeCachedResponse(path string, cached *cachedResponse) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// isChecksumsFile matches the checksum files published with a release, eg; checksums.txt, SHA256SUMS or flexy_1.0.3_checksums.txt
+func isChecksumsFile(name string) bool {
+	lower := strings.ToLower(name)
+	ext := filepath.Ext(lower)
+	return (strings.Contains(lower, "checksums") || strings.Contains(lower, "sha256sums")) && (ext == "" || ext == ".txt")
+}
+
+// lookupChecksum finds the sha256 of a file in a `<sha256>  <file name>` checksums file
+func lookupChecksum(checksums []byte, names ...string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			continue
+		}
+		// sha256sum marks binary files with a *
+		fileName := strings.TrimPrefix(fields[1], "*")
+		for _, name := range names {
+			if fileName == name {
+				return strings.ToLower(fields[0])
+			}
+		}
+	}
+	return ""
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// fetchChecksum downloads a checksums file and finds the sha256 of an asset in it, the name is tried with and without .zip
+func fetchChecksum(get func(string) (*http.Response, error), checksumURL, assetName string) (string, error) {
+	resp, err := get(checksumURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the checksums: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get the checksums: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	return lookupChecksum(data, assetName, strings.TrimSuffix(assetName, ".zip"), assetName+".zip"), nil
+}
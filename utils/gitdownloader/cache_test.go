@@ -0,0 +1,172 @@
+package githubdownloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type cacheTestServer struct {
+	*httptest.Server
+	zip          []byte
+	releaseCalls atomic.Int32
+	notModified  atomic.Int32
+	ranges       []string
+}
+
+func newCacheTestServer(t *testing.T) *cacheTestServer {
+	t.Helper()
+	ts := &cacheTestServer{zip: zipball(t)}
+	sum := sha256.Sum256(ts.zip)
+	var limited atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/NubeDev/flexy-app/releases", func(w http.ResponseWriter, r *http.Request) {
+		ts.releaseCalls.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			ts.notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprintf(w, `[{"id": 1, "name": "flexy-app-v1.0.3-amd64", "tag_name": "v1.0.3", "zipball_url": "%[1]s/zipball",
+			"assets": [{"name": "checksums.txt", "browser_download_url": "%[1]s/checksums.txt"},
+				{"name": "flexy-app-v1.0.3-amd64.zip", "browser_download_url": "%[1]s/files/flexy-app-v1.0.3-amd64.zip"}]},
+			{"id": 2, "name": "flexy-app-v1.0.4-amd64", "tag_name": "v1.0.4", "zipball_url": "%[1]s/zipball",
+			"assets": [{"name": "checksums.txt", "browser_download_url": "%[1]s/checksums.txt"},
+				{"name": "flexy-app-v1.0.4-amd64.zip", "browser_download_url": "%[1]s/files/flexy-app-v1.0.3-amd64.zip"}]},
+			{"id": 3, "name": "flexy-app-v1.0.5-amd64", "tag_name": "v1.0.5", "zipball_url": "%[1]s/zipball",
+			"assets": [{"name": "checksums.txt", "browser_download_url": "%[1]s/checksums.txt"}]}]`, ts.URL)
+	})
+	mux.HandleFunc("/api/v3/repos/NubeDev/limited/releases", func(w http.ResponseWriter, r *http.Request) {
+		if !limited.Swap(true) {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(2*time.Second).Unix()))
+			http.Error(w, `{"message": "API rate limit exceeded"}`, http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("/zipball", func(w http.ResponseWriter, r *http.Request) {
+		ts.ranges = append(ts.ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"z1"`)
+		http.ServeContent(w, r, "zipball.zip", time.Time{}, bytes.NewReader(ts.zip))
+	})
+	mux.HandleFunc("/files/flexy-app-v1.0.3-amd64.zip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(ts.zip)
+	})
+	mux.HandleFunc("/checksums.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  flexy-app-v1.0.3-amd64.zip\n%s  flexy-app-v1.0.4-amd64.zip\n%[2]s  flexy-app-v1.0.5-amd64.zip\n", hex.EncodeToString(sum[:]), strings.Repeat("0", 64))
+	})
+	ts.Server = httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newCacheTestDownloader(t *testing.T, ts *cacheTestServer) *GitHubDownloader {
+	t.Helper()
+	gd, err := NewWithURL(ts.URL+"/api/v3/", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gd.SetCacheDir(t.TempDir())
+	return gd
+}
+
+func TestReleaseETagCache(t *testing.T) {
+	ts := newCacheTestServer(t)
+	gd := newCacheTestDownloader(t, ts)
+	for i := 0; i < 2; i++ {
+		assets, err := gd.Assets("NubeDev", "flexy-app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(assets) != 3 || assets[0].ChecksumURL != ts.URL+"/checksums.txt" || assets[0].PackageURL != ts.URL+"/files/flexy-app-v1.0.3-amd64.zip" {
+			t.Errorf("assets = %+v", assets)
+		}
+	}
+	if ts.releaseCalls.Load() != 2 || ts.notModified.Load() != 1 {
+		t.Errorf("calls = %d, not modified = %d, want the second list answered from the cache", ts.releaseCalls.Load(), ts.notModified.Load())
+	}
+}
+
+func TestDownloadChecksum(t *testing.T) {
+	ts := newCacheTestServer(t)
+	gd := newCacheTestDownloader(t, ts)
+	dir := t.TempDir()
+	result, err := DownloadByArchVersion(gd, "NubeDev", "flexy-app", "v1.0.3", "amd64", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Verified || result.Path != filepath.Join(dir, "flexy-app-v1.0.3-amd64.zip") || len(result.SHA256) != 64 {
+		t.Errorf("result = %+v", result)
+	}
+	if data, _ := os.ReadFile(result.Path); !bytes.Equal(data, ts.zip) {
+		t.Error("the uploaded zip should be saved as is")
+	}
+	if _, err := DownloadByArchVersion(gd, "NubeDev", "flexy-app", "v1.0.4", "amd64", dir); err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Errorf("expected a sha256 mismatch, got %v", err)
+	}
+	// a release without the uploaded zip is downloaded as its zipball, the checksums file can't verify it
+	result, err = DownloadByArchVersion(gd, "NubeDev", "flexy-app", "v1.0.5", "amd64", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Verified {
+		t.Errorf("result = %+v, a zipball can't be verified", result)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	ts := newCacheTestServer(t)
+	gd := newCacheTestDownloader(t, ts)
+	url := ts.URL + "/zipball"
+	partPath := gd.partialPath(url)
+	half := len(ts.zip) / 2
+	if err := os.MkdirAll(filepath.Dir(partPath), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(partPath, ts.zip[:half], 0600)
+	os.WriteFile(partPath+".etag", []byte(`"z1"`), 0600)
+
+	result, err := gd.DownloadRelease(url, t.TempDir(), "flexy-app-v1.0.3-amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Resumed || ts.ranges[0] != fmt.Sprintf("bytes=%d-", half) {
+		t.Errorf("result = %+v, ranges = %v", result, ts.ranges)
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Error("the partial download should be removed once it's complete")
+	}
+}
+
+func TestRateLimitBackoff(t *testing.T) {
+	ts := newCacheTestServer(t)
+	gd := newCacheTestDownloader(t, ts)
+	var waited time.Duration
+	gd.sleep = func(d time.Duration) { waited += d }
+	gd.newClient()
+	if _, err := gd.Assets("NubeDev", "limited"); err != nil {
+		t.Fatal(err)
+	}
+	if waited <= 0 || waited > 4*time.Second {
+		t.Errorf("waited %s, want until the reset", waited)
+	}
+
+	ts2 := newCacheTestServer(t)
+	gd2 := newCacheTestDownloader(t, ts2)
+	gd2.SetMaxRateLimitWait(0)
+	if _, err := gd2.Assets("NubeDev", "limited"); err == nil {
+		t.Error("expected the rate limit error when the reset is too far away")
+	}
+}
@@ -0,0 +1,409 @@
+package githubdownloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/NubeDev/flexy/utils/apppkg"
+)
+
+/*
+A ReleaseSource lists the release assets of a repo and downloads them, the sources are:
+
+	github: the release zipballs from the GitHub API, re-zipped without the outer folder (see DownloadRelease), the
+	        release metadata is cached by ETag and the downloads are resumed, see cache.go
+	gitea:  the files attached to the releases, <url>/api/v1/repos/<owner>/<repo>/releases, checked with checksums.txt
+	gitlab: the asset links of the releases, <url>/api/v4/projects/<owner>%2F<repo>/releases
+	http:   a static file index, <url>/<owner>/<repo>/index.json eg; {"assets": [{"name": "flexy-app-v1.0.3-amd64.zip",
+	        "browser_download_url": "flexy-app-v1.0.3-amd64.zip", "sha256": "..."}]}, the urls are relative to the index
+*/
+
+const (
+	SourceGitHub = "github"
+	SourceGitea  = "gitea"
+	SourceGitLab = "gitlab"
+	SourceHTTP   = "http"
+)
+
+// ReleaseSource is where the app releases are downloaded from
+type ReleaseSource interface {
+	// Assets lists the assets of every release of a repo
+	Assets(owner, repo string) ([]Asset, error)
+	// DownloadAsset downloads an asset into the directory, it's checked against the published sha256 when there is one
+	DownloadAsset(asset Asset, destinationDir string) (*DownloadResult, error)
+}
+
+// NewReleaseSource creates a source by its kind, the url is required except for github
+func NewReleaseSource(kind, baseURL, token string) (ReleaseSource, error) {
+	if kind != SourceGitHub && kind != "" && baseURL == "" {
+		return nil, fmt.Errorf("url is required for a %s release source", kind)
+	}
+	switch kind {
+	case SourceGitHub, "":
+		if baseURL == "" {
+			return New(token, ""), nil
+		}
+		return NewWithURL(baseURL, token, "")
+	case SourceGitea:
+		return NewGiteaSource(baseURL, token), nil
+	case SourceGitLab:
+		return NewGitLabSource(baseURL, token), nil
+	case SourceHTTP:
+		return NewHTTPIndexSource(baseURL, token), nil
+	}
+	return nil, fmt.Errorf("unknown release source %s, try: %s, %s, %s or %s", kind, SourceGitHub, SourceGitea, SourceGitLab, SourceHTTP)
+}
+
+// SelectAsset picks the asset of a version for an arch, an asset without an arch is used when there isn't one built for
+// the arch. An empty arch is the arch this is running on, eg; arm64 on a raspberry pi 4.
+func SelectAsset(assets []Asset, version, arch string) (*Asset, error) {
+	if arch == "" {
+		arch = apppkg.HostArch()
+	} else if normalized := apppkg.NormalizeArch(arch); normalized != "" {
+		arch = normalized
+	}
+	var archMatch bool
+	var anyArch *Asset
+	for i, asset := range assets {
+		if asset.Arch != arch && asset.Arch != "" {
+			continue
+		}
+		archMatch = true
+		if asset.Version != version {
+			continue
+		}
+		if asset.Arch == arch {
+			return &assets[i], nil
+		}
+		if anyArch == nil {
+			anyArch = &assets[i]
+		}
+	}
+	if anyArch != nil {
+		return anyArch, nil
+	}
+	if !archMatch {
+		return nil, fmt.Errorf("%s is not a valid arch", arch)
+	}
+	return nil, fmt.Errorf("%s is not a valid version", version)
+}
+
+// DownloadByArchVersion downloads the asset of a version for an arch from any source
+func DownloadByArchVersion(source ReleaseSource, owner, repo, version, arch, destinationDir string) (*DownloadResult, error) {
+	if destinationDir == "" {
+		return nil, fmt.Errorf("destination directory cannot be empty")
+	}
+	assets, err := source.Assets(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	asset, err := SelectAsset(assets, version, arch)
+	if err != nil {
+		return nil, err
+	}
+	return source.DownloadAsset(*asset, destinationDir)
+}
+
+// Assets lists the releases of a repo, see ListAllAssets
+func (gd *GitHubDownloader) Assets(owner, repo string) ([]Asset, error) {
+	return gd.ListAllAssets(owner, repo, nil)
+}
+
+// DownloadAsset downloads the app zip uploaded to the release, <release name>.zip, checked against the checksums file of
+// the release when it lists it. A release without one is downloaded as its zipball and re-zipped, see DownloadRelease.
+// GitHub makes the zipball from the source, so a checksums file (which only lists the uploaded assets) can't verify it.
+func (gd *GitHubDownloader) DownloadAsset(asset Asset, destinationDir string) (*DownloadResult, error) {
+	if asset.PackageURL == "" {
+		return gd.downloadRelease(asset.ZipDownloadURL, destinationDir, asset.Name, asset.SHA256)
+	}
+	uploaded := Asset{Name: assetFileName(asset.Name), BrowserDownloadURL: asset.PackageURL, SHA256: asset.SHA256, ChecksumURL: asset.ChecksumURL}
+	return httpSource{client: gd.client.Client()}.download(uploaded, destinationDir)
+}
+
+func (gd *GitHubDownloader) get(rawURL string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return gd.client.Client().Do(req)
+}
+
+// httpSource is the http client of the sources that aren't github
+type httpSource struct {
+	baseURL string
+	client  *http.Client
+	auth    func(req *http.Request)
+}
+
+func newHTTPSource(baseURL string, auth func(req *http.Request)) httpSource {
+	return httpSource{baseURL: strings.TrimSuffix(baseURL, "/"), client: &http.Client{Timeout: 10 * time.Minute}, auth: auth}
+}
+
+func (h httpSource) get(rawURL string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	if h.auth != nil && h.sameHost(req.URL) {
+		h.auth(req)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making the request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return resp, nil
+}
+
+// sameHost is true when a url is on the server of the source, the token isn't sent to the other hosts an asset or
+// checksum url can point to
+func (h httpSource) sameHost(u *url.URL) bool {
+	base, err := url.Parse(h.baseURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Scheme, base.Scheme) && strings.EqualFold(u.Host, base.Host)
+}
+
+func (h httpSource) getJSON(rawURL string, v any) error {
+	resp, err := h.get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+// download saves an asset as is, it's written to a temp file first so a failed download doesn't leave half a zip
+func (h httpSource) download(asset Asset, destinationDir string) (*DownloadResult, error) {
+	if destinationDir == "" {
+		return nil, fmt.Errorf("destination directory cannot be empty")
+	}
+	expected := asset.SHA256
+	if expected == "" && asset.ChecksumURL != "" {
+		sum, err := fetchChecksum(h.get, asset.ChecksumURL, asset.Name)
+		if err != nil {
+			return nil, err
+		}
+		expected = sum
+	}
+	rawURL := asset.BrowserDownloadURL
+	if rawURL == "" {
+		rawURL = asset.ZipDownloadURL
+	}
+	resp, err := h.get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := os.MkdirAll(destinationDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(destinationDir, ".download-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %w", asset.Name, err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if expected != "" && !strings.EqualFold(sum, expected) {
+		return nil, fmt.Errorf("sha256 mismatch for %s: got %s, want %s", asset.Name, sum, expected)
+	}
+	finalPath := filepath.Join(destinationDir, assetFileName(asset.Name))
+	if err := os.Rename(tmp.Name(), finalPath); err != nil {
+		return nil, err
+	}
+	return &DownloadResult{Path: finalPath, SHA256: sum, Size: size, Verified: expected != ""}, nil
+}
+
+// assetFileName is the file name an asset is saved as, the uploaded assets already end in .zip
+func assetFileName(name string) string {
+	name = filepath.Base(filepath.FromSlash(name))
+	if filepath.Ext(name) != ".zip" {
+		name += ".zip"
+	}
+	return name
+}
+
+func newAsset(name, downloadURL, tag string, id int64) Asset {
+	version, arch := parseAssetName(name)
+	if version == "" {
+		version = tag
+	}
+	return Asset{Name: name, BrowserDownloadURL: downloadURL, AssetID: id, ReleaseTag: tag, Version: version, Arch: arch}
+}
+
+// GiteaSource lists the files attached to the releases of a Gitea (or Forgejo) repo
+type GiteaSource struct {
+	httpSource
+}
+
+func NewGiteaSource(baseURL, token string) *GiteaSource {
+	return &GiteaSource{newHTTPSource(baseURL, func(req *http.Request) {
+		if token != "" {
+			req.Header.Set("Authorization", "token "+token)
+		}
+	})}
+}
+
+func (g *GiteaSource) Assets(owner, repo string) ([]Asset, error) {
+	var releases []struct {
+		TagName string `json:"tag_name"`
+		Assets  []struct {
+			ID                 int64  `json:"id"`
+			Name               string `json:"name"`
+			BrowserDownloadURL string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	err := g.getJSON(fmt.Sprintf("%s/api/v1/repos/%s/%s/releases?limit=50", g.baseURL, url.PathEscape(owner), url.PathEscape(repo)), &releases)
+	if err != nil {
+		return nil, err
+	}
+	var assets []Asset
+	for _, release := range releases {
+		var checksumURL string
+		for _, asset := range release.Assets {
+			if isChecksumsFile(asset.Name) {
+				checksumURL = asset.BrowserDownloadURL
+			}
+		}
+		for _, asset := range release.Assets {
+			if isChecksumsFile(asset.Name) {
+				continue
+			}
+			a := newAsset(asset.Name, asset.BrowserDownloadURL, release.TagName, asset.ID)
+			a.ChecksumURL = checksumURL
+			assets = append(assets, a)
+		}
+	}
+	return assets, nil
+}
+
+func (g *GiteaSource) DownloadAsset(asset Asset, destinationDir string) (*DownloadResult, error) {
+	return g.download(asset, destinationDir)
+}
+
+// GitLabSource lists the asset links of the releases of a GitLab project
+type GitLabSource struct {
+	httpSource
+}
+
+func NewGitLabSource(baseURL, token string) *GitLabSource {
+	return &GitLabSource{newHTTPSource(baseURL, func(req *http.Request) {
+		if token != "" {
+			req.Header.Set("PRIVATE-TOKEN", token)
+		}
+	})}
+}
+
+func (g *GitLabSource) Assets(owner, repo string) ([]Asset, error) {
+	var releases []struct {
+		TagName string `json:"tag_name"`
+		Assets  struct {
+			Links []struct {
+				ID             int64  `json:"id"`
+				Name           string `json:"name"`
+				URL            string `json:"url"`
+				DirectAssetURL string `json:"direct_asset_url"`
+			} `json:"links"`
+		} `json:"assets"`
+	}
+	project := url.PathEscape(owner + "/" + repo)
+	if err := g.getJSON(fmt.Sprintf("%s/api/v4/projects/%s/releases", g.baseURL, project), &releases); err != nil {
+		return nil, err
+	}
+	var assets []Asset
+	for _, release := range releases {
+		for _, link := range release.Assets.Links {
+			downloadURL := link.DirectAssetURL
+			if downloadURL == "" {
+				downloadURL = link.URL
+			}
+			assets = append(assets, newAsset(link.Name, downloadURL, release.TagName, link.ID))
+		}
+	}
+	return assets, nil
+}
+
+func (g *GitLabSource) DownloadAsset(asset Asset, destinationDir string) (*DownloadResult, error) {
+	return g.download(asset, destinationDir)
+}
+
+// HTTPIndexSource reads the assets from an index.json on a plain file server
+type HTTPIndexSource struct {
+	httpSource
+}
+
+// IndexManifest is the index.json of an HTTPIndexSource, the version and arch default to the ones in the asset name
+type IndexManifest struct {
+	Assets []Asset `json:"assets"`
+}
+
+func NewHTTPIndexSource(baseURL, token string) *HTTPIndexSource {
+	return &HTTPIndexSource{newHTTPSource(baseURL, func(req *http.Request) {
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	})}
+}
+
+// IndexURL is where the index of a repo is read from, the owner is optional
+func (h *HTTPIndexSource) IndexURL(owner, repo string) string {
+	return h.baseURL + path.Join("/", url.PathEscape(owner), url.PathEscape(repo), "index.json")
+}
+
+func (h *HTTPIndexSource) Assets(owner, repo string) ([]Asset, error) {
+	indexURL := h.IndexURL(owner, repo)
+	var manifest IndexManifest
+	if err := h.getJSON(indexURL, &manifest); err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return nil, err
+	}
+	assets := make([]Asset, 0, len(manifest.Assets))
+	for _, asset := range manifest.Assets {
+		ref, err := url.Parse(asset.BrowserDownloadURL)
+		if err != nil || asset.BrowserDownloadURL == "" {
+			return nil, fmt.Errorf("invalid url for asset %s in %s", asset.Name, indexURL)
+		}
+		parsed := newAsset(asset.Name, base.ResolveReference(ref).String(), asset.ReleaseTag, asset.AssetID)
+		if asset.Version == "" {
+			asset.Version = parsed.Version
+		}
+		if asset.Arch == "" {
+			asset.Arch = parsed.Arch
+		}
+		asset.BrowserDownloadURL = parsed.BrowserDownloadURL
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+func (h *HTTPIndexSource) DownloadAsset(asset Asset, destinationDir string) (*DownloadResult, error) {
+	return h.download(asset, destinationDir)
+}
@@ -0,0 +1,235 @@
+package githubdownloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NubeDev/flexy/utils/apppkg"
+)
+
+var testZip = []byte("PK app zip")
+
+// newReleaseServer serves the release APIs of every source
+func newReleaseServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	var server *httptest.Server
+	mux.HandleFunc("/api/v3/repos/NubeDev/flexy-app/releases", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"id": 1, "name": "flexy-app-v1.0.3-amd64", "tag_name": "v1.0.3", "zipball_url": "%s/zipball/v1.0.3"},
+			{"id": 2, "name": "flexy-app-v1.0.3-armv7", "tag_name": "v1.0.3", "zipball_url": "%s/zipball/v1.0.3"}]`, server.URL, server.URL)
+	})
+	mux.HandleFunc("/zipball/v1.0.3", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(zipball(t))
+	})
+	mux.HandleFunc("/api/v1/repos/NubeDev/flexy-app/releases", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `[{"tag_name": "v1.0.3", "assets": [{"id": 7, "name": "flexy-app-v1.0.3-amd64.zip", "browser_download_url": "%s/files/flexy-app-v1.0.3-amd64.zip"}]}]`, server.URL)
+	})
+	mux.HandleFunc("/api/v4/projects/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v4/projects/NubeDev%2Fflexy-app/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `[{"tag_name": "v1.0.4", "assets": {"links": [{"id": 9, "name": "flexy-app-armv7.zip", "url": "%s/files/flexy-app-v1.0.3-amd64.zip"}]}}]`, server.URL)
+	})
+	sum := sha256.Sum256(testZip)
+	mux.HandleFunc("/builds/NubeDev/flexy-app/index.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"assets": [{"name": "flexy-app-v1.0.3-amd64.zip", "browser_download_url": "../../../files/flexy-app-v1.0.3-amd64.zip", "sha256": "%s"},
+			{"name": "flexy-app-v1.0.4-amd64.zip", "browser_download_url": "../../../files/flexy-app-v1.0.3-amd64.zip", "sha256": "bad"}]}`, hex.EncodeToString(sum[:]))
+	})
+	mux.HandleFunc("/files/flexy-app-v1.0.3-amd64.zip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testZip)
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// zipball is a release zipball, the files are in an outer folder
+func zipball(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.Create("NubeDev-flexy-app-abc123/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("id: flexy-app\n"))
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestGitHubSource(t *testing.T) {
+	server := newReleaseServer(t)
+	source, err := NewReleaseSource(SourceGitHub, server.URL+"/api/v3/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.(*GitHubDownloader).SetCacheDir(t.TempDir())
+	dir := t.TempDir()
+	result, err := DownloadByArchVersion(source, "NubeDev", "flexy-app", "v1.0.3", "armv7", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Path != filepath.Join(dir, "flexy-app-v1.0.3-armv7.zip") || len(result.SHA256) != 64 || result.Verified {
+		t.Errorf("result = %+v", result)
+	}
+	reader, err := zip.OpenReader(result.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if len(reader.File) != 1 || reader.File[0].Name != "config.yaml" {
+		t.Errorf("expected the outer folder to be removed, got %s", reader.File[0].Name)
+	}
+	if _, err := DownloadByArchVersion(source, "NubeDev", "flexy-app", "v1.0.3", "arm64", dir); err == nil || !strings.Contains(err.Error(), "not a valid arch") {
+		t.Errorf("expected an invalid arch, got %v", err)
+	}
+}
+
+func TestGiteaSource(t *testing.T) {
+	server := newReleaseServer(t)
+	if _, err := NewGiteaSource(server.URL, "").Assets("NubeDev", "flexy-app"); err == nil {
+		t.Error("expected the token to be required")
+	}
+	source, err := NewReleaseSource(SourceGitea, server.URL, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets, err := source.Assets("NubeDev", "flexy-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 1 || assets[0].Version != "v1.0.3" || assets[0].Arch != "amd64" || assets[0].AssetID != 7 {
+		t.Errorf("assets = %+v", assets)
+	}
+	result, err := DownloadByArchVersion(source, "NubeDev", "flexy-app", "v1.0.3", "amd64", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(result.Path); !bytes.Equal(data, testZip) || filepath.Base(result.Path) != "flexy-app-v1.0.3-amd64.zip" {
+		t.Errorf("downloaded %s = %q", result.Path, data)
+	}
+}
+
+func TestHTTPSourceAuthHost(t *testing.T) {
+	var leaked string
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Authorization")
+		w.Write(testZip)
+	}))
+	defer files.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `[{"tag_name": "v1.0.3", "assets": [{"id": 7, "name": "flexy-app-v1.0.3-amd64.zip", "browser_download_url": "%s/flexy-app-v1.0.3-amd64.zip"}]}]`, files.URL)
+	}))
+	defer api.Close()
+	if _, err := DownloadByArchVersion(NewGiteaSource(api.URL, "secret"), "NubeDev", "flexy-app", "v1.0.3", "amd64", t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if leaked != "" {
+		t.Errorf("the token was sent to another host: %q", leaked)
+	}
+}
+
+func TestGitLabSource(t *testing.T) {
+	server := newReleaseServer(t)
+	source, err := NewReleaseSource(SourceGitLab, server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the version comes from the tag when it isn't in the name
+	result, err := DownloadByArchVersion(source, "NubeDev", "flexy-app", "v1.0.4", "armv7", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(result.Path) != "flexy-app-armv7.zip" {
+		t.Errorf("path = %s", result.Path)
+	}
+}
+
+func TestHTTPIndexSource(t *testing.T) {
+	server := newReleaseServer(t)
+	source, err := NewReleaseSource(SourceHTTP, server.URL+"/builds", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets, err := source.Assets("NubeDev", "flexy-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 2 || assets[0].BrowserDownloadURL != server.URL+"/files/flexy-app-v1.0.3-amd64.zip" {
+		t.Errorf("assets = %+v", assets)
+	}
+	dir := t.TempDir()
+	if result, err := DownloadByArchVersion(source, "NubeDev", "flexy-app", "v1.0.3", "amd64", dir); err != nil || !result.Verified {
+		t.Fatalf("result = %+v %v", result, err)
+	}
+	if _, err := DownloadByArchVersion(source, "NubeDev", "flexy-app", "v1.0.4", "amd64", dir); err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Errorf("expected a sha256 mismatch, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "flexy-app-v1.0.4-amd64.zip")); !os.IsNotExist(err) {
+		t.Error("a download that failed the check shouldn't be kept")
+	}
+	if _, err := NewReleaseSource("ftp", server.URL, ""); err == nil {
+		t.Error("expected an unknown source")
+	}
+}
+
+func TestSelectAsset(t *testing.T) {
+	assets := []Asset{
+		{Name: "flexy-app-v1.0.3-amd64", Version: "v1.0.3", Arch: "amd64"},
+		{Name: "flexy-app-v1.0.3-arm64", Version: "v1.0.3", Arch: "arm64"},
+		{Name: "flexy-app-v1.0.3", Version: "v1.0.3"},
+		{Name: "flexy-app-v1.0.4", Version: "v1.0.4"},
+	}
+	for _, tt := range []struct {
+		version, arch, want string
+	}{
+		{"v1.0.3", "aarch64", "flexy-app-v1.0.3-arm64"},
+		{"v1.0.3", "armv7", "flexy-app-v1.0.3"},
+		{"v1.0.4", "amd64", "flexy-app-v1.0.4"},
+		{"v1.0.3", "", "flexy-app-v1.0.3-" + apppkg.HostArch()},
+	} {
+		asset, err := SelectAsset(assets, tt.version, tt.arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if asset.Name != tt.want {
+			t.Errorf("SelectAsset(%s, %q) = %s, want %s", tt.version, tt.arch, asset.Name, tt.want)
+		}
+	}
+	if _, err := SelectAsset(assets, "v1.0.5", "amd64"); err == nil {
+		t.Error("expected v1.0.5 to be missing")
+	}
+}
+
+func TestParseAssetName(t *testing.T) {
+	for _, tt := range []struct {
+		name, version, arch string
+	}{
+		{"flexy-app-v1.0.3-aarch64", "v1.0.3", "arm64"},
+		{"flexy-app-v2.0.0-rc.1-amd64.zip", "v2.0.0-rc.1", "amd64"},
+		{"flexy-app-v2.1-armv7.zip", "v2.1", "armv7"},
+		{"flexy-app-v1.0.3.zip", "v1.0.3", ""},
+		{"flexy-app-amd64.zip", "", "amd64"},
+	} {
+		if version, arch := parseAssetName(tt.name); version != tt.version || arch != tt.arch {
+			t.Errorf("parseAssetName(%s) = %s %s, want %s %s", tt.name, version, arch, tt.version, tt.arch)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+Package jsonschema validates a document against the subset of JSON schema the apps ship with their config, eg;
+
+	{"type": "object", "required": ["port"], "properties": {"port": {"type": "integer", "minimum": 1, "maximum": 65535}}}
+
+The keywords are type, enum, const, properties, required, additionalProperties, items, minItems, maxItems, minimum,
+maximum, minLength, maxLength and pattern, the others are ignored. The document is JSON decoded, see Normalize.
+*/
+
+// Schema is a decoded JSON schema
+type Schema map[string]any
+
+// Parse decodes a JSON schema
+func Parse(data []byte) (Schema, error) {
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %v", err)
+	}
+	return schema, nil
+}
+
+// Normalize converts a value (eg; decoded from YAML) to what encoding/json decodes, so numbers are float64
+func Normalize(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	return out, json.Unmarshal(data, &out)
+}
+
+// ValidationError is every place the document doesn't match the schema
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "schema validation failed: " + strings.Join(e.Errors, "; ")
+}
+
+// Validate checks a normalized document, the error is a *ValidationError
+func (s Schema) Validate(doc any) error {
+	var errs []string
+	validate(s, doc, "$", &errs)
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func validate(schema map[string]any, v any, path string, errs *[]string) {
+	add := func(format string, args ...any) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !matchesType(types, v) {
+		add("expected %s, got %s", strings.Join(types, " or "), typeOf(v))
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok && !contains(enum, v) {
+		add("must be one of %v", enum)
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, v) {
+		add("must be %v", c)
+	}
+	switch value := v.(type) {
+	case map[string]any:
+		validateObject(schema, value, path, errs)
+	case []any:
+		if min, ok := number(schema["minItems"]); ok && float64(len(value)) < min {
+			add("must have at least %v items", min)
+		}
+		if max, ok := number(schema["maxItems"]); ok && float64(len(value)) > max {
+			add("must have at most %v items", max)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				validate(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case float64:
+		if min, ok := number(schema["minimum"]); ok && value < min {
+			add("must be >= %v", min)
+		}
+		if max, ok := number(schema["maximum"]); ok && value > max {
+			add("must be <= %v", max)
+		}
+	case string:
+		length := float64(len([]rune(value)))
+		if min, ok := number(schema["minLength"]); ok && length < min {
+			add("must be at least %v characters", min)
+		}
+		if max, ok := number(schema["maxLength"]); ok && length > max {
+			add("must be at most %v characters", max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				add("invalid pattern %s in the schema", pattern)
+			} else if !re.MatchString(value) {
+				add("must match %s", pattern)
+			}
+		}
+	}
+}
+
+func validateObject(schema map[string]any, value map[string]any, path string, errs *[]string) {
+	if required, ok := schema["required"].([]any); ok {
+		for _, key := range required {
+			if name, ok := key.(string); ok {
+				if _, found := value[name]; !found {
+					*errs = append(*errs, fmt.Sprintf("%s: %s is required", path, name))
+				}
+			}
+		}
+	}
+	properties, _ := schema["properties"].(map[string]any)
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if property, ok := properties[key].(map[string]any); ok {
+			validate(property, value[key], path+"."+key, errs)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				*errs = append(*errs, fmt.Sprintf("%s: %s is not allowed", path, key))
+			}
+		case map[string]any:
+			validate(additional, value[key], path+"."+key, errs)
+		}
+	}
+}
+
+func schemaTypes(t any) []string {
+	switch value := t.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		var types []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func matchesType(types []string, v any) bool {
+	for _, t := range types {
+		if t == typeOf(v) || (t == "number" && typeOf(v) == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func number(v any) (float64, bool) {
+	n, ok := v.(float64)
+	return n, ok
+}
+
+func contains(values []any, v any) bool {
+	for _, value := range values {
+		if reflect.DeepEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
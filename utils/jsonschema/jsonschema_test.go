@@ -0,0 +1,59 @@
+package jsonschema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["name", "port"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "pattern": "^[a-z-]+$"},
+		"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+		"level": {"enum": ["debug", "info", "error"]},
+		"ratio": {"type": "number"},
+		"hosts": {"type": "array", "maxItems": 2, "items": {"type": "string"}}
+	}
+}`
+
+func validateYAML(t *testing.T, schema Schema, doc string) error {
+	t.Helper()
+	var v any
+	if err := yaml.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatal(err)
+	}
+	normalized, err := Normalize(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema.Validate(normalized)
+}
+
+func TestValidate(t *testing.T) {
+	schema, err := Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateYAML(t, schema, "name: flexy-app\nport: 1660\nratio: 1\nlevel: info\nhosts: [a, b]\n"); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+
+	err = validateYAML(t, schema, "name: Flexy\nport: 70000.5\nlevel: trace\nhosts: [a, b, 3]\nextra: true\n")
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	for _, want := range []string{"$.name: must match", "$.port: expected integer", "$.level: must be one of", "$.hosts: must have at most 2", "$.hosts[2]: expected string", "$: extra is not allowed"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+	if err := validateYAML(t, schema, "name: flexy\n"); err == nil || !strings.Contains(err.Error(), "port is required") {
+		t.Errorf("expected port to be required, got %v", err)
+	}
+}
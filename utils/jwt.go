@@ -12,6 +12,12 @@ import (
 
 var jwtSecret = []byte(setting.AppSetting.JwtSecret)
 
+// SetJwtSecret overrides the secret used to sign and parse tokens, for services
+// that don't load it through setting.Setup (eg; bios)
+func SetJwtSecret(secret string) {
+	jwtSecret = []byte(secret)
+}
+
 type Claims struct {
 	UserId   uint   `json:"userId"`
 	Username string `json:"username"`
@@ -1,6 +1,8 @@
 package natlib
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +12,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -30,6 +33,7 @@ type NatLib interface {
 	Subscribe(subj string, cb nats.MsgHandler, opts *Opts) error
 	SubscribeWithRespond(subj string, handler func(msg *nats.Msg) ([]byte, error), opts *Opts) error
 	RequestAll(subj string, data []byte, timeout time.Duration) ([]*nats.Msg, error)
+	RequestAllWithOpts(subj string, data []byte, opts *RequestAllOpts) ([]*nats.Msg, error)
 	Close() // close server
 
 	// JetStream Object Store methods
@@ -42,7 +46,19 @@ type NatLib interface {
 	GetObject(storeName string, objectName string) ([]byte, error)
 	DeleteObject(storeName string, objectName string) error
 	DropStore(storeName string) error
+	PurgeStore(storeName string) error
 	DownloadObject(storeName string, objectName string, destinationPath string) error
+
+	// JetStream Key Value methods
+	CreateKVBucket(bucket string, config *nats.KeyValueConfig) error
+	GetKVBucket(bucket string) (nats.KeyValue, error)
+	GetKVBuckets() ([]string, error)
+	GetKV(bucket, key string) (nats.KeyValueEntry, error)
+	PutKV(bucket, key string, value []byte) (uint64, error)
+	DeleteKV(bucket, key string) error
+	GetKVKeys(bucket string) ([]string, error)
+	GetKVHistory(bucket, key string) ([]nats.KeyValueEntry, error)
+	WatchKV(bucket, keys string, cb func(entry nats.KeyValueEntry), opts ...nats.WatchOpt) (nats.KeyWatcher, error)
 }
 
 var uuidName = "Global-UUID"
@@ -60,6 +76,20 @@ type NewOpts struct {
 	GlobalUUID      string
 	NatsConn        *nats.Conn
 	EnableJetStream bool
+	Options         []nats.Option // connect options when NatsConn is nil, eg; SeedFileOptions
+}
+
+// SeedFileOptions are the connect options for a user nkey seed file (eg; one written by nats-config), none when the
+// path is empty
+func SeedFileOptions(seedFile string) ([]nats.Option, error) {
+	if seedFile == "" {
+		return nil, nil
+	}
+	option, err := nats.NkeyOptionFromSeed(seedFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the nkey seed %s: %v", seedFile, err)
+	}
+	return []nats.Option{option}, nil
 }
 
 // New creates a new instance of NatLib.
@@ -71,7 +101,7 @@ func New(opts NewOpts) NatLib {
 	var err error
 	var nc *nats.Conn
 	if opts.NatsConn == nil {
-		nc, err = nats.Connect(url)
+		nc, err = nats.Connect(url, opts.Options...)
 		if err != nil {
 			panic(err)
 		}
@@ -79,8 +109,9 @@ func New(opts NewOpts) NatLib {
 		nc = opts.NatsConn
 	}
 	n := &natsLib{
-		nc:       nc,
-		subjects: []*Subjects{},
+		nc:         nc,
+		subjects:   []*Subjects{},
+		globalUUID: opts.GlobalUUID,
 	}
 	if opts.EnableJetStream {
 		js, err := nc.JetStream()
@@ -109,6 +140,9 @@ func (nl *natsLib) Publish(subj string, data []byte, cb nats.MsgHandler, opts *O
 		return err
 	}
 	if nl.globalUUID != "" {
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
 		msg.Header.Set(uuidName, nl.globalUUID)
 	}
 	cb(msg)
@@ -139,6 +173,9 @@ func (nl *natsLib) SubscribeWithRespond(subj string, handler func(msg *nats.Msg)
 			return
 		}
 		if nl.globalUUID != "" {
+			if msg.Header == nil {
+				msg.Header = nats.Header{}
+			}
 			msg.Header.Set(uuidName, nl.globalUUID)
 		}
 		if err := msg.Respond(responseData); err != nil {
@@ -148,9 +185,34 @@ func (nl *natsLib) SubscribeWithRespond(subj string, handler func(msg *nats.Msg)
 	return err
 }
 
+// RequestAllOpts controls when RequestAllWithOpts stops collecting responses,
+// it always stops at the Timeout
+type RequestAllOpts struct {
+	Timeout      time.Duration
+	Expected     int                 // stop once this many responders have answered, eg; the number of known devices
+	MaxResponses int                 // never collect more than this many responses
+	QuietPeriod  time.Duration       // stop when no new response arrives for this long after the first one
+	Header       nats.Header         // extra headers sent with the request, eg; Authorization
+	OnResponse   func(msg *nats.Msg) // called for each response as it arrives
+}
+
 // RequestAll sends a request to the subject and collects all responses
 // received within the specified timeout duration.
 func (nl *natsLib) RequestAll(subj string, data []byte, timeout time.Duration) ([]*nats.Msg, error) {
+	return nl.RequestAllWithOpts(subj, data, &RequestAllOpts{Timeout: timeout})
+}
+
+// RequestAllWithOpts sends a request to the subject and collects the responses (scatter-gather)
+// until the timeout, or earlier when the expected or max number of responses is reached or
+// the responders go quiet.
+func (nl *natsLib) RequestAllWithOpts(subj string, data []byte, opts *RequestAllOpts) ([]*nats.Msg, error) {
+	if opts == nil {
+		opts = &RequestAllOpts{}
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
 	// Create a unique inbox
 	inbox := nats.NewInbox()
 	sub, err := nl.nc.SubscribeSync(inbox)
@@ -160,10 +222,13 @@ func (nl *natsLib) RequestAll(subj string, data []byte, timeout time.Duration) (
 	defer sub.Unsubscribe()
 
 	// Publish the message with the reply set to the inbox
-	m := &nats.Msg{
-		Subject: subj,
-		Reply:   inbox,
-		Data:    data,
+	m := nats.NewMsg(subj)
+	m.Reply = inbox
+	m.Data = data
+	for key, values := range opts.Header {
+		for _, value := range values {
+			m.Header.Add(key, value)
+		}
 	}
 	if nl.globalUUID != "" {
 		m.Header.Set(uuidName, nl.globalUUID)
@@ -183,15 +248,27 @@ func (nl *natsLib) RequestAll(subj string, data []byte, timeout time.Duration) (
 		if remaining <= 0 {
 			break
 		}
+		if opts.QuietPeriod > 0 && len(responses) > 0 && opts.QuietPeriod < remaining {
+			remaining = opts.QuietPeriod
+		}
 		msg, err := sub.NextMsg(remaining)
 		if err != nil {
 			if errors.Is(err, nats.ErrTimeout) {
-				// Timeout reached, exit loop
+				// Timeout or quiet period reached, exit loop
 				break
 			}
 			return nil, err
 		}
 		responses = append(responses, msg)
+		if opts.OnResponse != nil {
+			opts.OnResponse(msg)
+		}
+		if opts.Expected > 0 && len(responses) >= opts.Expected {
+			break
+		}
+		if opts.MaxResponses > 0 && len(responses) >= opts.MaxResponses {
+			break
+		}
 	}
 
 	return responses, nil
@@ -326,6 +403,25 @@ func (nl *natsLib) GetStore(name string) (nats.ObjectStore, error) {
 	return store, nil
 }
 
+// objectDigestPrefix is how the object store marks the sha256 digest of an object
+const objectDigestPrefix = "SHA-256="
+
+// ObjectDigest decodes the sha256 of an object from its ObjectInfo.Digest, eg; SHA-256=<base64url>
+func ObjectDigest(digest string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(digest, objectDigestPrefix)
+	if !ok {
+		return nil, fmt.Errorf("unsupported object digest %q, expected %s", digest, objectDigestPrefix)
+	}
+	sum, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid object digest %q: %v", digest, err)
+	}
+	if len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid object digest %q: expected %d bytes", digest, sha256.Size)
+	}
+	return sum, nil
+}
+
 // GetObject retrieves an object by name from the object store.
 func (nl *natsLib) GetObject(storeName string, objectName string) ([]byte, error) {
 	store, err := nl.GetStore(storeName)
@@ -375,6 +471,18 @@ func (nl *natsLib) DropStore(storeName string) error {
 	return nil
 }
 
+// PurgeStore deletes every object in the store, the store and its config are kept.
+func (nl *natsLib) PurgeStore(storeName string) error {
+	// the objects of a store are kept in the stream OBJ_<store>
+	err := nl.JetStreamContext.PurgeStream(fmt.Sprintf("OBJ_%s", storeName))
+	if err != nil {
+		log.Error().Msgf("Error purging object store %s: %v", storeName, err)
+		return err
+	}
+	log.Info().Msgf("Object store %s purged successfully", storeName)
+	return nil
+}
+
 // DownloadObject downloads an object from the object store and saves it to the specified destination directory.
 // The object will be saved with its original objectName in the destination directory.
 func (nl *natsLib) DownloadObject(storeName string, objectName string, destinationPath string) error {
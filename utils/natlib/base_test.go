@@ -1,7 +1,11 @@
 package natlib
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"github.com/nats-io/nats.go"
 	"testing"
 	"time"
 )
@@ -22,3 +26,69 @@ func TestNew(t *testing.T) {
 	}
 
 }
+
+func TestRequestAllWithOpts(t *testing.T) {
+	nc, err := nats.Connect(nats.DefaultURL)
+	if err != nil {
+		t.Skipf("no nats server: %v", err)
+	}
+	defer nc.Close()
+	nl := New(NewOpts{NatsConn: nc, GlobalUUID: "abc"})
+	subject := nats.NewInbox()
+	for i := 0; i < 3; i++ {
+		_, err := nc.Subscribe(subject, func(m *nats.Msg) {
+			m.Respond([]byte("pong"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	nc.Flush()
+
+	var streamed int
+	start := time.Now()
+	responses, err := nl.RequestAllWithOpts(subject, []byte("ping"), &RequestAllOpts{
+		Timeout:    5 * time.Second,
+		Expected:   3,
+		OnResponse: func(msg *nats.Msg) { streamed++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 3 || streamed != 3 {
+		t.Errorf("got %d responses and %d callbacks, want 3", len(responses), streamed)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("should stop once the expected responses are received")
+	}
+
+	responses, err = nl.RequestAllWithOpts(subject, []byte("ping"), &RequestAllOpts{Timeout: 5 * time.Second, MaxResponses: 1})
+	if err != nil || len(responses) != 1 {
+		t.Errorf("got %d responses want 1, err: %v", len(responses), err)
+	}
+
+	start = time.Now()
+	responses, err = nl.RequestAllWithOpts(subject, []byte("ping"), &RequestAllOpts{Timeout: 5 * time.Second, QuietPeriod: 200 * time.Millisecond})
+	if err != nil || len(responses) != 3 {
+		t.Errorf("got %d responses want 3, err: %v", len(responses), err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("should stop after the quiet period")
+	}
+}
+
+func TestObjectDigest(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello"))
+	got, err := ObjectDigest("SHA-256=" + base64.URLEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, sum[:]) {
+		t.Errorf("digest = %x, want %x", got, sum)
+	}
+	for _, digest := range []string{"", "MD5=abc", "SHA-256=not base64!", "SHA-256=" + base64.URLEncoding.EncodeToString([]byte("short"))} {
+		if _, err := ObjectDigest(digest); err == nil {
+			t.Errorf("expected %q to be rejected", digest)
+		}
+	}
+}
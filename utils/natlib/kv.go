@@ -0,0 +1,156 @@
+package natlib
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+// KVEntry is a JSON friendly copy of a nats.KeyValueEntry
+type KVEntry struct {
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	Revision  uint64    `json:"revision"`
+	Created   time.Time `json:"created"`
+	Operation string    `json:"operation"`
+}
+
+// NewKVEntry converts a nats.KeyValueEntry to a KVEntry
+func NewKVEntry(entry nats.KeyValueEntry) *KVEntry {
+	if entry == nil {
+		return nil
+	}
+	return &KVEntry{
+		Bucket:    entry.Bucket(),
+		Key:       entry.Key(),
+		Value:     string(entry.Value()),
+		Revision:  entry.Revision(),
+		Created:   entry.Created(),
+		Operation: entry.Operation().String(),
+	}
+}
+
+var errJetStreamDisabled = errors.New("jetstream is not enabled on this connection")
+
+// CreateKVBucket creates a key value bucket with the given name and configuration if it doesn't exist.
+func (nl *natsLib) CreateKVBucket(bucket string, config *nats.KeyValueConfig) error {
+	if nl.JetStreamContext == nil {
+		return errJetStreamDisabled
+	}
+	_, err := nl.JetStreamContext.KeyValue(bucket)
+	if err == nil {
+		return nil
+	}
+	if config == nil {
+		config = &nats.KeyValueConfig{
+			Bucket: bucket,
+		}
+	}
+	_, err = nl.JetStreamContext.CreateKeyValue(config)
+	if err != nil {
+		log.Error().Msgf("Error creating kv bucket %s: %v", bucket, err)
+		return err
+	}
+	log.Info().Msgf("KV bucket %s created", bucket)
+	return nil
+}
+
+// GetKVBucket returns the KeyValue for a specific bucket name.
+func (nl *natsLib) GetKVBucket(bucket string) (nats.KeyValue, error) {
+	if nl.JetStreamContext == nil {
+		return nil, errJetStreamDisabled
+	}
+	kv, err := nl.JetStreamContext.KeyValue(bucket)
+	if err != nil {
+		log.Error().Msgf("Error getting kv bucket %s: %v", bucket, err)
+		return nil, err
+	}
+	return kv, nil
+}
+
+// GetKVBuckets returns the list of available key value bucket names.
+func (nl *natsLib) GetKVBuckets() ([]string, error) {
+	if nl.JetStreamContext == nil {
+		return nil, errJetStreamDisabled
+	}
+	var buckets []string
+	for bucket := range nl.JetStreamContext.KeyValueStoreNames() {
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// GetKV returns the latest value for a key.
+func (nl *natsLib) GetKV(bucket, key string) (nats.KeyValueEntry, error) {
+	kv, err := nl.GetKVBucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return kv.Get(key)
+}
+
+// PutKV sets the value for a key and returns the new revision.
+func (nl *natsLib) PutKV(bucket, key string, value []byte) (uint64, error) {
+	kv, err := nl.GetKVBucket(bucket)
+	if err != nil {
+		return 0, err
+	}
+	return kv.Put(key, value)
+}
+
+// DeleteKV places a delete marker on a key, its history is kept.
+func (nl *natsLib) DeleteKV(bucket, key string) error {
+	kv, err := nl.GetKVBucket(bucket)
+	if err != nil {
+		return err
+	}
+	return kv.Delete(key)
+}
+
+// GetKVKeys returns all the keys in a bucket, an empty bucket returns no error.
+func (nl *natsLib) GetKVKeys(bucket string) ([]string, error) {
+	kv, err := nl.GetKVBucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return []string{}, nil
+	}
+	return keys, err
+}
+
+// GetKVHistory returns all the historical values for a key.
+func (nl *natsLib) GetKVHistory(bucket, key string) ([]nats.KeyValueEntry, error) {
+	kv, err := nl.GetKVBucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return kv.History(key)
+}
+
+// WatchKV calls cb for every update to the keys (wildcards are allowed), starting with
+// the current values unless nats.UpdatesOnly() is passed. Call Stop on the returned watcher to stop watching.
+func (nl *natsLib) WatchKV(bucket, keys string, cb func(entry nats.KeyValueEntry), opts ...nats.WatchOpt) (nats.KeyWatcher, error) {
+	kv, err := nl.GetKVBucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := kv.Watch(keys, opts...)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for entry := range watcher.Updates() {
+			// a nil entry marks the end of the initial values
+			if entry == nil {
+				continue
+			}
+			cb(entry)
+		}
+	}()
+	return watcher, nil
+}
@@ -0,0 +1,303 @@
+package natlib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+/*
+Versioned objects: the revision of an object is kept in its metadata, when it's replaced with PutObject the old
+object is copied to <name>@<revision> first, eg; app.zip@3, and only the newest revisions are kept.
+
+The transfer meta of a store upload can carry the object meta too, see ObjectTransferMeta.
+*/
+
+const (
+	// RevisionSeparator separates an object name from its revision, it can't be used in the names of objects
+	RevisionSeparator = "@"
+	// MetaRevision is the metadata key of the object revision
+	MetaRevision = "revision"
+
+	transferMetaDescription = "description"
+	transferMetaPrefix      = "meta."
+)
+
+// RevisionName is the name a revision of an object is kept under, eg; app.zip@3
+func RevisionName(name string, revision int) string {
+	return name + RevisionSeparator + strconv.Itoa(revision)
+}
+
+// ParseRevisionName splits a revision name into the object name and revision, false if it isn't a revision name
+func ParseRevisionName(name string) (string, int, bool) {
+	idx := strings.LastIndex(name, RevisionSeparator)
+	if idx <= 0 {
+		return "", 0, false
+	}
+	revision, err := strconv.Atoi(name[idx+len(RevisionSeparator):])
+	if err != nil || revision < 1 {
+		return "", 0, false
+	}
+	return name[:idx], revision, true
+}
+
+// ObjectRevision is the revision from the object metadata, the objects put before revisions were kept are revision 1
+func ObjectRevision(info *nats.ObjectInfo) int {
+	revision, err := strconv.Atoi(info.Metadata[MetaRevision])
+	if err != nil || revision < 1 {
+		return 1
+	}
+	return revision
+}
+
+// objectLocks serializes the puts of an object in this process, by <store>/<name> with a count of the puts using it
+var objectLocks = struct {
+	sync.Mutex
+	locks map[string]*objectLock
+}{locks: map[string]*objectLock{}}
+
+type objectLock struct {
+	sync.Mutex
+	users int
+}
+
+func lockObject(storeName, name string) func() {
+	name = storeName + "/" + name
+	objectLocks.Lock()
+	lock, ok := objectLocks.locks[name]
+	if !ok {
+		lock = &objectLock{}
+		objectLocks.locks[name] = lock
+	}
+	lock.users++
+	objectLocks.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		objectLocks.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(objectLocks.locks, name)
+		}
+		objectLocks.Unlock()
+	}
+}
+
+/*
+PutObject puts an object into the store and sets its revision. The object it replaces is kept as <name>@<revision>
+and the oldest revisions over keep are deleted, with keep 0 the object is replaced without keeping its history.
+
+Reading the revision, keeping the old object and the put aren't atomic in the store, so the puts of an object are
+serialized in this process. Two processes putting the same object at once can still take the same revision.
+*/
+func PutObject(store nats.ObjectStore, meta *nats.ObjectMeta, r io.Reader, keep int) (*nats.ObjectInfo, error) {
+	if meta == nil || meta.Name == "" {
+		return nil, errors.New("object name is required")
+	}
+	if strings.Contains(meta.Name, RevisionSeparator) {
+		return nil, fmt.Errorf("object name %s can't contain %s, it's used for the revisions", meta.Name, RevisionSeparator)
+	}
+	status, err := store.Status()
+	if err != nil {
+		return nil, err
+	}
+	unlock := lockObject(status.Bucket(), meta.Name)
+	defer unlock()
+	revisions, err := ObjectRevisions(store, meta.Name)
+	if err != nil {
+		return nil, err
+	}
+	revision := 1
+	if len(revisions) > 0 {
+		revision = ObjectRevision(revisions[0]) + 1
+	}
+	current, err := store.GetInfo(meta.Name)
+	switch {
+	case err == nil:
+		if next := ObjectRevision(current) + 1; next > revision {
+			revision = next
+		}
+		if keep > 0 {
+			if err := copyRevision(store, current); err != nil {
+				return nil, fmt.Errorf("failed to keep revision %d of %s: %v", ObjectRevision(current), meta.Name, err)
+			}
+		}
+	case !errors.Is(err, nats.ErrObjectNotFound):
+		return nil, err
+	}
+
+	put := *meta
+	put.Metadata = make(map[string]string, len(meta.Metadata)+1)
+	for key, value := range meta.Metadata {
+		put.Metadata[key] = value
+	}
+	put.Metadata[MetaRevision] = strconv.Itoa(revision)
+	info, err := store.Put(&put, r)
+	if err != nil {
+		return nil, err
+	}
+	return info, pruneRevisions(store, meta.Name, keep)
+}
+
+// ObjectRevisions lists the kept revisions of an object, newest first, the current object isn't included
+func ObjectRevisions(store nats.ObjectStore, name string) ([]*nats.ObjectInfo, error) {
+	objects, err := store.List()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoObjectsFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var revisions []*nats.ObjectInfo
+	for _, object := range objects {
+		if objectName, _, ok := ParseRevisionName(object.Name); ok && objectName == name {
+			revisions = append(revisions, object)
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return ObjectRevision(revisions[i]) > ObjectRevision(revisions[j])
+	})
+	return revisions, nil
+}
+
+func copyRevision(store nats.ObjectStore, current *nats.ObjectInfo) error {
+	obj, err := store.Get(current.Name)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	_, err = store.Put(&nats.ObjectMeta{
+		Name:        RevisionName(current.Name, ObjectRevision(current)),
+		Description: current.Description,
+		Headers:     current.Headers,
+		Metadata:    current.Metadata,
+	}, obj)
+	return err
+}
+
+func pruneRevisions(store nats.ObjectStore, name string, keep int) error {
+	revisions, err := ObjectRevisions(store, name)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(revisions); i++ {
+		if err := store.Delete(revisions[i].Name); err != nil {
+			return fmt.Errorf("failed to delete revision %s: %v", revisions[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// ObjectTransferMeta adds the description and metadata of an object to the meta of a store upload
+func ObjectTransferMeta(transferMeta map[string]string, description string, metadata map[string]string) map[string]string {
+	if transferMeta == nil {
+		transferMeta = map[string]string{}
+	}
+	if description != "" {
+		transferMeta[transferMetaDescription] = description
+	}
+	for key, value := range metadata {
+		transferMeta[transferMetaPrefix+key] = value
+	}
+	return transferMeta
+}
+
+// TransferObjectMeta is the object meta of a store upload, see ObjectTransferMeta
+func TransferObjectMeta(name string, transferMeta map[string]string) *nats.ObjectMeta {
+	meta := &nats.ObjectMeta{Name: name, Description: transferMeta[transferMetaDescription]}
+	for key, value := range transferMeta {
+		if strings.HasPrefix(key, transferMetaPrefix) {
+			if meta.Metadata == nil {
+				meta.Metadata = map[string]string{}
+			}
+			meta.Metadata[strings.TrimPrefix(key, transferMetaPrefix)] = value
+		}
+	}
+	return meta
+}
+
+// StoreConfig is the config of a new object store, eg; {"maxBytes": 1073741824, "ttl": "720h", "storage": "file"}
+type StoreConfig struct {
+	Description string            `json:"description,omitempty"`
+	MaxBytes    int64             `json:"maxBytes,omitempty"` // the store size limit, unlimited by default
+	TTL         string            `json:"ttl,omitempty"`      // how long objects are kept, eg; 720h, forever by default
+	Storage     string            `json:"storage,omitempty"`  // file or memory, defaults to file
+	Replicas    int               `json:"replicas,omitempty"`
+	Compression bool              `json:"compression,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// ObjectStoreConfig converts the config for JetStream
+func (c *StoreConfig) ObjectStoreConfig(bucket string) (*nats.ObjectStoreConfig, error) {
+	config := &nats.ObjectStoreConfig{
+		Bucket:      bucket,
+		Description: c.Description,
+		MaxBytes:    c.MaxBytes,
+		Replicas:    c.Replicas,
+		Compression: c.Compression,
+		Metadata:    c.Metadata,
+	}
+	if c.TTL != "" {
+		ttl, err := time.ParseDuration(c.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl %s: %v", c.TTL, err)
+		}
+		config.TTL = ttl
+	}
+	switch c.Storage {
+	case "", "file":
+		config.Storage = nats.FileStorage
+	case "memory":
+		config.Storage = nats.MemoryStorage
+	default:
+		return nil, fmt.Errorf("invalid storage %s, expected file or memory", c.Storage)
+	}
+	if c.MaxBytes < 0 || c.Replicas < 0 {
+		return nil, errors.New("maxBytes and replicas can't be negative")
+	}
+	return config, nil
+}
+
+// StoreStatus is the size, number of objects and config of an object store
+type StoreStatus struct {
+	Bucket      string            `json:"bucket"`
+	Description string            `json:"description,omitempty"`
+	TTL         string            `json:"ttl"`
+	Storage     string            `json:"storage"`
+	Replicas    int               `json:"replicas"`
+	Sealed      bool              `json:"sealed"`
+	Compressed  bool              `json:"compressed"`
+	Size        uint64            `json:"size"`
+	Objects     int               `json:"objects"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// ObjectStoreStatus gets the status of a store, the objects count doesn't include the deleted objects
+func ObjectStoreStatus(store nats.ObjectStore) (*StoreStatus, error) {
+	status, err := store.Status()
+	if err != nil {
+		return nil, err
+	}
+	objects, err := store.List()
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		return nil, err
+	}
+	return &StoreStatus{
+		Bucket:      status.Bucket(),
+		Description: status.Description(),
+		TTL:         status.TTL().String(),
+		Storage:     status.Storage().String(),
+		Replicas:    status.Replicas(),
+		Sealed:      status.Sealed(),
+		Compressed:  status.IsCompressed(),
+		Size:        status.Size(),
+		Objects:     len(objects),
+		Metadata:    status.Metadata(),
+	}, nil
+}
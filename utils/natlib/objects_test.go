@@ -0,0 +1,248 @@
+package natlib
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// memStore is an in memory nats.ObjectStore with just the methods PutObject uses
+type memStore struct {
+	nats.ObjectStore
+	bucket  string
+	mu      sync.Mutex
+	objects map[string]*memObject
+	delay   time.Duration // how long a put takes
+}
+
+type memObject struct {
+	info nats.ObjectInfo
+	data []byte
+}
+
+type memResult struct {
+	io.Reader
+	info *nats.ObjectInfo
+}
+
+func (r *memResult) Close() error                    { return nil }
+func (r *memResult) Info() (*nats.ObjectInfo, error) { return r.info, nil }
+func (r *memResult) Error() error                    { return nil }
+
+type memStatus struct {
+	nats.ObjectStoreStatus
+	bucket string
+}
+
+func (s *memStatus) Bucket() string { return s.bucket }
+
+func newMemStore() *memStore {
+	return &memStore{bucket: "bios", objects: map[string]*memObject{}}
+}
+
+func (s *memStore) Status() (nats.ObjectStoreStatus, error) {
+	return &memStatus{bucket: s.bucket}, nil
+}
+
+func (s *memStore) Put(meta *nats.ObjectMeta, r io.Reader, _ ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	obj := &memObject{info: nats.ObjectInfo{ObjectMeta: *meta, Bucket: "test", Size: uint64(len(data))}, data: data}
+	s.objects[meta.Name] = obj
+	return &obj.info, nil
+}
+
+func (s *memStore) Get(name string, _ ...nats.GetObjectOpt) (nats.ObjectResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[name]
+	if !ok {
+		return nil, nats.ErrObjectNotFound
+	}
+	return &memResult{Reader: bytes.NewReader(obj.data), info: &obj.info}, nil
+}
+
+func (s *memStore) GetInfo(name string, _ ...nats.GetObjectInfoOpt) (*nats.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[name]
+	if !ok {
+		return nil, nats.ErrObjectNotFound
+	}
+	return &obj.info, nil
+}
+
+func (s *memStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[name]; !ok {
+		return nats.ErrObjectNotFound
+	}
+	delete(s.objects, name)
+	return nil
+}
+
+func (s *memStore) List(_ ...nats.ListObjectsOpt) ([]*nats.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.objects) == 0 {
+		return nil, nats.ErrNoObjectsFound
+	}
+	var objects []*nats.ObjectInfo
+	for _, obj := range s.objects {
+		objects = append(objects, &obj.info)
+	}
+	return objects, nil
+}
+
+func (s *memStore) names() []string {
+	var names []string
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestParseRevisionName(t *testing.T) {
+	name, revision, ok := ParseRevisionName(RevisionName("app@home.zip", 12))
+	if !ok || name != "app@home.zip" || revision != 12 {
+		t.Errorf("got %s %d %v", name, revision, ok)
+	}
+	for _, name := range []string{"app.zip", "@3", "app.zip@", "app.zip@0", "app.zip@x"} {
+		if _, _, ok := ParseRevisionName(name); ok {
+			t.Errorf("%s isn't a revision name", name)
+		}
+	}
+}
+
+func TestPutObject(t *testing.T) {
+	store := newMemStore()
+	for _, data := range []string{"v1", "v2", "v3", "v4"} {
+		meta := &nats.ObjectMeta{Name: "app.zip", Description: data, Metadata: map[string]string{"version": data}}
+		if _, err := PutObject(store, meta, strings.NewReader(data), 2); err != nil {
+			t.Fatal(err)
+		}
+		if meta.Metadata[MetaRevision] != "" {
+			t.Fatal("the meta passed in shouldn't be changed")
+		}
+	}
+	if got := strings.Join(store.names(), ","); got != "app.zip,app.zip@2,app.zip@3" {
+		t.Fatalf("objects = %s", got)
+	}
+	current, _ := store.GetInfo("app.zip")
+	if ObjectRevision(current) != 4 || current.Metadata["version"] != "v4" {
+		t.Errorf("current = %+v", current.ObjectMeta)
+	}
+	revisions, err := ObjectRevisions(store, "app.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Name != "app.zip@3" || revisions[0].Description != "v3" || string(store.objects["app.zip@3"].data) != "v3" {
+		t.Errorf("revisions = %+v", revisions)
+	}
+
+	// a deleted object carries on from the newest revision kept
+	if err := store.Delete("app.zip"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := PutObject(store, &nats.ObjectMeta{Name: "app.zip"}, strings.NewReader("v5"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ObjectRevision(info) != 4 {
+		t.Errorf("revision = %d, want 4", ObjectRevision(info))
+	}
+
+	// keep 0 drops the history
+	if _, err := PutObject(store, &nats.ObjectMeta{Name: "app.zip"}, strings.NewReader("v6"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(store.names(), ","); got != "app.zip" {
+		t.Errorf("objects = %s", got)
+	}
+
+	if _, err := PutObject(store, &nats.ObjectMeta{Name: "app.zip@1"}, strings.NewReader("x"), 2); err == nil {
+		t.Error("expected a name with the revision separator to be rejected")
+	}
+}
+
+func TestPutObjectConcurrent(t *testing.T) {
+	store := newMemStore()
+	store.delay = time.Millisecond
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := PutObject(store, &nats.ObjectMeta{Name: "app.zip"}, strings.NewReader("v"), 20); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	current, _ := store.GetInfo("app.zip")
+	if len(store.objects) != 10 || ObjectRevision(current) != 10 {
+		t.Errorf("objects = %v, revision = %d, want every put to take its own revision", store.names(), ObjectRevision(current))
+	}
+}
+
+func TestPutObjectLockByStore(t *testing.T) {
+	unlock := lockObject("bios", "app.zip")
+	defer unlock()
+	store := newMemStore()
+	store.bucket = "other"
+	done := make(chan error, 1)
+	go func() {
+		_, err := PutObject(store, &nats.ObjectMeta{Name: "app.zip"}, strings.NewReader("v"), 2)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a put to another store shouldn't wait for the lock of the same object name")
+	}
+}
+
+func TestTransferObjectMeta(t *testing.T) {
+	transferMeta := ObjectTransferMeta(map[string]string{"storeName": "bios"}, "flexy app", map[string]string{"appID": "flexy", "arch": "amd64"})
+	meta := TransferObjectMeta("app.zip", transferMeta)
+	if meta.Name != "app.zip" || meta.Description != "flexy app" || len(meta.Metadata) != 2 || meta.Metadata["appID"] != "flexy" {
+		t.Errorf("meta = %+v", meta)
+	}
+	if meta := TransferObjectMeta("app.zip", map[string]string{"storeName": "bios"}); meta.Metadata != nil {
+		t.Errorf("expected no metadata, got %v", meta.Metadata)
+	}
+}
+
+func TestStoreConfig(t *testing.T) {
+	config, err := (&StoreConfig{MaxBytes: 1 << 30, TTL: "720h", Storage: "memory", Replicas: 3}).ObjectStoreConfig("apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Bucket != "apps" || config.TTL != 720*time.Hour || config.Storage != nats.MemoryStorage || config.MaxBytes != 1<<30 || config.Replicas != 3 {
+		t.Errorf("config = %+v", config)
+	}
+	if config, _ := (&StoreConfig{}).ObjectStoreConfig("apps"); config.Storage != nats.FileStorage || config.TTL != 0 {
+		t.Errorf("expected file storage without a ttl, got %+v", config)
+	}
+	for _, c := range []StoreConfig{{TTL: "a month"}, {Storage: "disk"}, {MaxBytes: -1}} {
+		if _, err := c.ObjectStoreConfig("apps"); err == nil {
+			t.Errorf("expected %+v to be rejected", c)
+		}
+	}
+}
@@ -0,0 +1,741 @@
+package natlib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+/*
+Chunked transfer over request/reply, so files larger than the broker max_payload can be sent.
+Every message carries the Transfer-Op and Transfer-ID headers.
+
+Upload (the client sends the file, see Upload and TransferReceiver):
+  init    TransferInfo JSON, the reply acks the next chunk to send, > 0 when resuming
+  chunk   raw bytes with a Transfer-Seq header, each chunk is acked with the next chunk to send
+  commit  the receiver checks the sha256 and size, then hands the file to its complete func
+
+Download (the client pulls the file, see Download and TransferSender):
+  init    TransferInfo JSON with the name/meta of the file, the reply has its size, sha256 and transfer id
+  chunk   the reply is the raw bytes of chunk Transfer-Seq, or a Transfer-Error header
+  commit  tells the sender the download is complete
+*/
+
+// Headers used by the chunked transfer protocol
+const (
+	HeaderTransferOp    = "Transfer-Op"
+	HeaderTransferID    = "Transfer-ID"
+	HeaderTransferSeq   = "Transfer-Seq"
+	HeaderTransferError = "Transfer-Error"
+)
+
+// Transfer operations, sent in the Transfer-Op header
+const (
+	TransferInit   = "init"
+	TransferChunk  = "chunk"
+	TransferCommit = "commit"
+	TransferAbort  = "abort"
+)
+
+// DefaultChunkSize keeps each chunk well under the default 1MB max_payload
+const DefaultChunkSize = 256 * 1024
+
+const (
+	maxChunkSize = 8 * 1024 * 1024
+	transferTTL  = time.Hour // how long a download or an upload can be idle before it's dropped
+)
+
+var transferIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// TransferInfo describes the file being transferred
+type TransferInfo struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Size      int64             `json:"size"`
+	ChunkSize int               `json:"chunkSize"`
+	SHA256    string            `json:"sha256"`
+	Meta      map[string]string `json:"meta,omitempty"`
+}
+
+// TransferAck is the reply to each upload message
+type TransferAck struct {
+	ID       string `json:"id"`
+	NextSeq  int    `json:"nextSeq"`
+	Received int64  `json:"received"`
+	Done     bool   `json:"done"`
+	Result   string `json:"result,omitempty"` // returned by the receivers complete func on commit
+}
+
+// TransferOpts are the client options for Upload and Download
+type TransferOpts struct {
+	Timeout    time.Duration // per message, default 5 seconds
+	ChunkSize  int           // default DefaultChunkSize
+	Retries    int           // per message, default 3
+	Header     nats.Header   // extra headers sent with every message, eg; Authorization
+	OnProgress func(done, total int64)
+}
+
+func (opts *TransferOpts) withDefaults() *TransferOpts {
+	out := TransferOpts{}
+	if opts != nil {
+		out = *opts
+	}
+	if out.Timeout <= 0 {
+		out.Timeout = 5 * time.Second
+	}
+	if out.ChunkSize <= 0 {
+		out.ChunkSize = DefaultChunkSize
+	}
+	if out.Retries <= 0 {
+		out.Retries = 3
+	}
+	return &out
+}
+
+// chunkCount returns the number of chunks needed for size bytes, which is also the next chunk to send
+func chunkCount(size int64, chunkSize int) int {
+	return int((size + int64(chunkSize) - 1) / int64(chunkSize))
+}
+
+func validateTransferInfo(info *TransferInfo) error {
+	if !transferIDPattern.MatchString(info.ID) {
+		return fmt.Errorf("invalid transfer id: %s", info.ID)
+	}
+	if info.Size < 0 {
+		return errors.New("size must not be negative")
+	}
+	if info.ChunkSize <= 0 || info.ChunkSize > maxChunkSize {
+		return fmt.Errorf("chunk size must be between 1 and %d", maxChunkSize)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func transferSeq(m *nats.Msg) (int, error) {
+	seq, err := strconv.Atoi(m.Header.Get(HeaderTransferSeq))
+	if err != nil || seq < 0 {
+		return 0, fmt.Errorf("invalid %s header", HeaderTransferSeq)
+	}
+	return seq, nil
+}
+
+// TransferCompleteFunc is called once an upload is committed, path is the received file
+// which is deleted after the func returns. The returned string is sent back in TransferAck.Result
+type TransferCompleteFunc func(info *TransferInfo, path string, m *nats.Msg) (string, error)
+
+// TransferReceiver receives chunked uploads into a directory. The partial files are
+// kept as <dir>/<id>.part so an upload can be resumed, even after a restart.
+// A part file that isn't written to for an hour is deleted.
+type TransferReceiver struct {
+	MaxSize    int64 // the total size of the uploads in progress, 0 is no limit
+	dir        string
+	onComplete TransferCompleteFunc
+	lock       sync.Mutex
+	transfers  map[string]*TransferInfo
+}
+
+// NewTransferReceiver creates a receiver, use Handle as the NATS handler for the upload subject
+func NewTransferReceiver(dir string, onComplete TransferCompleteFunc) (*TransferReceiver, error) {
+	if onComplete == nil {
+		return nil, errors.New("transfer complete func is required")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &TransferReceiver{
+		dir:        dir,
+		onComplete: onComplete,
+		transfers:  map[string]*TransferInfo{},
+	}, nil
+}
+
+// Handle handles the upload messages, each reply is a Response with a TransferAck payload
+func (r *TransferReceiver) Handle(m *nats.Msg) {
+	ack, err := r.HandleMsg(m)
+	if err != nil {
+		log.Error().Msgf("transfer upload on %s: %v", m.Subject, err)
+		m.Respond(NewResponse(code.ERROR, err.Error()).ToJSON())
+		return
+	}
+	data, _ := json.Marshal(ack)
+	m.Respond(NewResponse(code.SUCCESS, string(data)).ToJSON())
+}
+
+// HandleMsg processes an upload message and returns the ack to reply with
+func (r *TransferReceiver) HandleMsg(m *nats.Msg) (*TransferAck, error) {
+	if m.Header == nil {
+		return nil, fmt.Errorf("missing %s header", HeaderTransferOp)
+	}
+	op := m.Header.Get(HeaderTransferOp)
+	id := m.Header.Get(HeaderTransferID)
+	if op == TransferCommit {
+		// the checksum and complete func run without the lock, so a slow complete (eg; a store put) doesn't hold up
+		// the other uploads
+		info, path, err := r.takeForCommit(id)
+		if err != nil {
+			return nil, err
+		}
+		return r.commit(info, path, m)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if op == TransferInit {
+		return r.init(m)
+	}
+	info, ok := r.transfers[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown transfer id: %s, send %s first", id, TransferInit)
+	}
+	switch op {
+	case TransferChunk:
+		return r.chunk(info, m)
+	case TransferAbort:
+		delete(r.transfers, id)
+		os.Remove(r.partPath(id))
+		return &TransferAck{ID: id}, nil
+	}
+	return nil, fmt.Errorf("unknown transfer op: %s", op)
+}
+
+// takeForCommit removes a complete upload from the transfers and moves its part file aside, so an init or chunk for
+// the same id can't change the file while it's being committed
+func (r *TransferReceiver) takeForCommit(id string) (*TransferInfo, string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	info, ok := r.transfers[id]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown transfer id: %s, send %s first", id, TransferInit)
+	}
+	stat, err := os.Stat(r.partPath(id))
+	if err != nil {
+		return nil, "", err
+	}
+	if stat.Size() != info.Size {
+		return nil, "", fmt.Errorf("received %d of %d bytes", stat.Size(), info.Size)
+	}
+	path := filepath.Join(r.dir, fmt.Sprintf("%s.%s.commit", id, uuid.NewString()))
+	if err := os.Rename(r.partPath(id), path); err != nil {
+		return nil, "", err
+	}
+	delete(r.transfers, id)
+	return info, path, nil
+}
+
+func (r *TransferReceiver) partPath(id string) string {
+	return filepath.Join(r.dir, fmt.Sprintf("%s.part", id))
+}
+
+func (r *TransferReceiver) init(m *nats.Msg) (*TransferAck, error) {
+	var info TransferInfo
+	if err := json.Unmarshal(m.Data, &info); err != nil {
+		return nil, fmt.Errorf("invalid transfer info: %v", err)
+	}
+	if err := validateTransferInfo(&info); err != nil {
+		return nil, err
+	}
+	total, err := r.expire(info.ID)
+	if err != nil {
+		return nil, err
+	}
+	if r.MaxSize > 0 && total+info.Size > r.MaxSize {
+		return nil, fmt.Errorf("upload of %d bytes is over the limit, %d of %d bytes are in use", info.Size, total, r.MaxSize)
+	}
+	// resume from the last complete chunk of an earlier attempt
+	var received int64
+	if stat, err := os.Stat(r.partPath(info.ID)); err == nil {
+		received = stat.Size()
+	}
+	if received > info.Size {
+		received = 0
+	}
+	if received != info.Size {
+		received -= received % int64(info.ChunkSize)
+	}
+	if err := r.truncate(info.ID, received); err != nil {
+		return nil, err
+	}
+	r.transfers[info.ID] = &info
+	return &TransferAck{ID: info.ID, NextSeq: chunkCount(received, info.ChunkSize), Received: received}, nil
+}
+
+// expire deletes the part files of the uploads a client never finished, and returns the size of the other uploads
+// in progress. The part files are checked rather than the transfers, so the uploads from before a restart are included.
+func (r *TransferReceiver) expire(skipID string) (int64, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".part" {
+			continue
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".part")
+		if time.Since(stat.ModTime()) > transferTTL {
+			delete(r.transfers, id)
+			os.Remove(filepath.Join(r.dir, name))
+			continue
+		}
+		if id == skipID {
+			continue
+		}
+		size := stat.Size()
+		if info, ok := r.transfers[id]; ok && info.Size > size {
+			size = info.Size
+		}
+		total += size
+	}
+	return total, nil
+}
+
+func (r *TransferReceiver) truncate(id string, size int64) error {
+	f, err := os.OpenFile(r.partPath(id), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Truncate(size)
+}
+
+func (r *TransferReceiver) chunk(info *TransferInfo, m *nats.Msg) (*TransferAck, error) {
+	seq, err := transferSeq(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Data) > info.ChunkSize {
+		return nil, fmt.Errorf("chunk %d is larger than the chunk size %d", seq, info.ChunkSize)
+	}
+	f, err := os.OpenFile(r.partPath(info.ID), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	received := stat.Size()
+	nextSeq := chunkCount(received, info.ChunkSize)
+	// a resent or out of order chunk, the ack tells the client where to carry on from
+	if seq != nextSeq {
+		return &TransferAck{ID: info.ID, NextSeq: nextSeq, Received: received}, nil
+	}
+	if received+int64(len(m.Data)) > info.Size {
+		return nil, fmt.Errorf("chunk %d is past the end of the file", seq)
+	}
+	if _, err := f.WriteAt(m.Data, received); err != nil {
+		return nil, err
+	}
+	received += int64(len(m.Data))
+	return &TransferAck{ID: info.ID, NextSeq: chunkCount(received, info.ChunkSize), Received: received}, nil
+}
+
+func (r *TransferReceiver) commit(info *TransferInfo, path string, m *nats.Msg) (*TransferAck, error) {
+	defer os.Remove(path)
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	if sum != info.SHA256 {
+		return nil, fmt.Errorf("checksum mismatch got: %s want: %s, the upload must be restarted", sum, info.SHA256)
+	}
+	result, err := r.onComplete(info, path, m)
+	if err != nil {
+		return nil, err
+	}
+	return &TransferAck{ID: info.ID, NextSeq: chunkCount(info.Size, info.ChunkSize), Received: info.Size, Done: true, Result: result}, nil
+}
+
+// TransferSourceFunc returns the local file to send for a download, cleanup (if not nil)
+// is called once the download is complete, eg; to delete a temp file
+type TransferSourceFunc func(info *TransferInfo, m *nats.Msg) (path string, cleanup func(), err error)
+
+type download struct {
+	info     *TransferInfo
+	path     string
+	cleanup  func()
+	lastUsed time.Time
+}
+
+// TransferSender serves chunked downloads
+type TransferSender struct {
+	source    TransferSourceFunc
+	lock      sync.Mutex
+	downloads map[string]*download
+}
+
+// NewTransferSender creates a sender, use Handle as the NATS handler for the download subject
+func NewTransferSender(source TransferSourceFunc) *TransferSender {
+	return &TransferSender{
+		source:    source,
+		downloads: map[string]*download{},
+	}
+}
+
+// Handle handles the download messages
+func (s *TransferSender) Handle(m *nats.Msg) {
+	if m.Header == nil {
+		m.Respond(NewResponse(code.ERROR, fmt.Sprintf("missing %s header", HeaderTransferOp)).ToJSON())
+		return
+	}
+	switch m.Header.Get(HeaderTransferOp) {
+	case TransferInit:
+		info, err := s.init(m)
+		if err != nil {
+			log.Error().Msgf("transfer download on %s: %v", m.Subject, err)
+			m.Respond(NewResponse(code.ERROR, err.Error()).ToJSON())
+			return
+		}
+		data, _ := json.Marshal(info)
+		m.Respond(NewResponse(code.SUCCESS, string(data)).ToJSON())
+	case TransferChunk:
+		reply := nats.NewMsg(m.Reply)
+		data, err := s.ReadChunk(m.Header.Get(HeaderTransferID), m)
+		if err != nil {
+			reply.Header.Set(HeaderTransferError, err.Error())
+		}
+		reply.Header.Set(HeaderTransferSeq, m.Header.Get(HeaderTransferSeq))
+		reply.Data = data
+		m.RespondMsg(reply)
+	case TransferCommit, TransferAbort:
+		s.done(m.Header.Get(HeaderTransferID))
+		m.Respond(NewResponse(code.SUCCESS, "ok").ToJSON())
+	default:
+		m.Respond(NewResponse(code.ERROR, fmt.Sprintf("unknown transfer op: %s", m.Header.Get(HeaderTransferOp))).ToJSON())
+	}
+}
+
+func (s *TransferSender) init(m *nats.Msg) (*TransferInfo, error) {
+	var info TransferInfo
+	if len(m.Data) > 0 {
+		if err := json.Unmarshal(m.Data, &info); err != nil {
+			return nil, fmt.Errorf("invalid transfer info: %v", err)
+		}
+	}
+	if info.ChunkSize <= 0 || info.ChunkSize > maxChunkSize {
+		info.ChunkSize = DefaultChunkSize
+	}
+	s.expire()
+	path, cleanup, err := s.source(&info, m)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	info.ID = uuid.NewString()
+	info.Size = stat.Size()
+	info.SHA256 = sum
+	if info.Name == "" {
+		info.Name = filepath.Base(path)
+	}
+	s.lock.Lock()
+	s.downloads[info.ID] = &download{info: &info, path: path, cleanup: cleanup, lastUsed: time.Now()}
+	s.lock.Unlock()
+	return &info, nil
+}
+
+// ReadChunk returns chunk Transfer-Seq of a download
+func (s *TransferSender) ReadChunk(id string, m *nats.Msg) ([]byte, error) {
+	s.lock.Lock()
+	d, ok := s.downloads[id]
+	if ok {
+		d.lastUsed = time.Now()
+	}
+	s.lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transfer id: %s, send %s first", id, TransferInit)
+	}
+	seq, err := transferSeq(m)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(d.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, d.info.ChunkSize)
+	n, err := f.ReadAt(buf, int64(seq)*int64(d.info.ChunkSize))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (s *TransferSender) done(id string) {
+	s.lock.Lock()
+	d, ok := s.downloads[id]
+	delete(s.downloads, id)
+	s.lock.Unlock()
+	if ok && d.cleanup != nil {
+		d.cleanup()
+	}
+}
+
+// expire drops the downloads a client never finished
+func (s *TransferSender) expire() {
+	s.lock.Lock()
+	var expired []string
+	for id, d := range s.downloads {
+		if time.Since(d.lastUsed) > transferTTL {
+			expired = append(expired, id)
+		}
+	}
+	s.lock.Unlock()
+	for _, id := range expired {
+		s.done(id)
+	}
+}
+
+func newTransferMsg(subject, op, id string, seq int, data []byte, header nats.Header) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	for key, values := range header {
+		for _, value := range values {
+			msg.Header.Add(key, value)
+		}
+	}
+	msg.Header.Set(HeaderTransferOp, op)
+	if id != "" {
+		msg.Header.Set(HeaderTransferID, id)
+	}
+	if seq >= 0 {
+		msg.Header.Set(HeaderTransferSeq, strconv.Itoa(seq))
+	}
+	msg.Data = data
+	return msg
+}
+
+// transferRequest sends a message, retrying when the request times out
+func transferRequest(nc *nats.Conn, msg *nats.Msg, opts *TransferOpts) (*nats.Msg, error) {
+	var err error
+	for attempt := 0; attempt < opts.Retries; attempt++ {
+		var reply *nats.Msg
+		reply, err = nc.RequestMsg(msg, opts.Timeout)
+		if err == nil {
+			return reply, nil
+		}
+		if !errors.Is(err, nats.ErrTimeout) && !errors.Is(err, nats.ErrNoResponders) {
+			return nil, err
+		}
+		log.Warn().Msgf("transfer %s %s on %s attempt %d failed: %v", msg.Header.Get(HeaderTransferOp), msg.Header.Get(HeaderTransferSeq), msg.Subject, attempt+1, err)
+	}
+	return nil, err
+}
+
+// decodeTransferResponse decodes the Response envelope and its JSON payload into out
+func decodeTransferResponse(reply *nats.Msg, out interface{}) error {
+	var resp Response
+	if err := json.Unmarshal(reply.Data, &resp); err != nil {
+		return fmt.Errorf("invalid transfer response: %v", err)
+	}
+	if resp.Code != code.SUCCESS {
+		return errors.New(resp.Payload)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal([]byte(resp.Payload), out)
+}
+
+// uploadID is the default transfer id of an upload, see Upload
+func uploadID(info *TransferInfo) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s", info.SHA256, info.Name)
+	keys := make([]string, 0, len(info.Meta))
+	for key := range info.Meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(h, "\x00%s=%s", key, info.Meta[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+func uploadRequest(nc *nats.Conn, subject, op, id string, seq int, data []byte, opts *TransferOpts) (*TransferAck, error) {
+	reply, err := transferRequest(nc, newTransferMsg(subject, op, id, seq, data, opts.Header), opts)
+	if err != nil {
+		return nil, err
+	}
+	var ack TransferAck
+	if err := decodeTransferResponse(reply, &ack); err != nil {
+		return nil, err
+	}
+	return &ack, nil
+}
+
+// Upload sends r to a TransferReceiver in chunks. The transfer id defaults to a hash of the file sha256, name and meta,
+// so calling Upload again after a failure resumes from the last acked chunk, while the same file sent to another
+// destination at the same time is a separate transfer.
+func Upload(nc *nats.Conn, subject string, r io.ReadSeeker, info *TransferInfo, opts *TransferOpts) (*TransferAck, error) {
+	opts = opts.withDefaults()
+	if info == nil {
+		info = &TransferInfo{}
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return nil, err
+	}
+	info.Size = size
+	info.SHA256 = hex.EncodeToString(h.Sum(nil))
+	info.ChunkSize = opts.ChunkSize
+	if info.ID == "" {
+		info.ID = uploadID(info)
+	}
+	initData, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	ack, err := uploadRequest(nc, subject, TransferInit, info.ID, -1, initData, opts)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, opts.ChunkSize)
+	for int64(ack.NextSeq)*int64(opts.ChunkSize) < size {
+		seq := ack.NextSeq
+		if _, err := r.Seek(int64(seq)*int64(opts.ChunkSize), io.SeekStart); err != nil {
+			return nil, err
+		}
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		ack, err = uploadRequest(nc, subject, TransferChunk, info.ID, seq, buf[:n], opts)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %v", seq, err)
+		}
+		if opts.OnProgress != nil {
+			opts.OnProgress(ack.Received, size)
+		}
+	}
+	return uploadRequest(nc, subject, TransferCommit, info.ID, -1, nil, opts)
+}
+
+// Download pulls a file from a TransferSender into destPath. The data is written to
+// destPath.part first, so calling Download again after a failure resumes from there.
+func Download(nc *nats.Conn, subject string, info *TransferInfo, destPath string, opts *TransferOpts) (*TransferInfo, error) {
+	opts = opts.withDefaults()
+	if info == nil {
+		info = &TransferInfo{}
+	}
+	info.ChunkSize = opts.ChunkSize
+	initData, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := transferRequest(nc, newTransferMsg(subject, TransferInit, "", -1, initData, opts.Header), opts)
+	if err != nil {
+		return nil, err
+	}
+	var remote TransferInfo
+	if err := decodeTransferResponse(reply, &remote); err != nil {
+		return nil, err
+	}
+	defer func() {
+		// let the sender clean up, it also expires the download if this is lost
+		nc.PublishMsg(newTransferMsg(subject, TransferCommit, remote.ID, -1, nil, opts.Header))
+	}()
+
+	partPath := destPath + ".part"
+	if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	received := stat.Size()
+	if received > remote.Size {
+		received = 0
+	}
+	received -= received % int64(remote.ChunkSize)
+	if err := f.Truncate(received); err != nil {
+		f.Close()
+		return nil, err
+	}
+	for received < remote.Size {
+		seq := chunkCount(received, remote.ChunkSize)
+		reply, err := transferRequest(nc, newTransferMsg(subject, TransferChunk, remote.ID, seq, nil, opts.Header), opts)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("chunk %d: %v", seq, err)
+		}
+		if msg := reply.Header.Get(HeaderTransferError); msg != "" {
+			f.Close()
+			return nil, fmt.Errorf("chunk %d: %s", seq, msg)
+		}
+		if len(reply.Data) == 0 {
+			f.Close()
+			return nil, fmt.Errorf("chunk %d is empty, the file may have changed", seq)
+		}
+		if _, err := f.WriteAt(reply.Data, received); err != nil {
+			f.Close()
+			return nil, err
+		}
+		received += int64(len(reply.Data))
+		if opts.OnProgress != nil {
+			opts.OnProgress(received, remote.Size)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	sum, err := fileSHA256(partPath)
+	if err != nil {
+		return nil, err
+	}
+	if sum != remote.SHA256 {
+		os.Remove(partPath)
+		return nil, fmt.Errorf("checksum mismatch got: %s want: %s, the download must be restarted", sum, remote.SHA256)
+	}
+	if err := os.Rename(partPath, destPath); err != nil {
+		return nil, err
+	}
+	return &remote, nil
+}
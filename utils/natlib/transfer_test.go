@@ -0,0 +1,202 @@
+package natlib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func transferMsg(t *testing.T, op, id string, seq int, data []byte) *nats.Msg {
+	t.Helper()
+	return newTransferMsg("upload", op, id, seq, data, nil)
+}
+
+func TestTransferReceiverResume(t *testing.T) {
+	dir := t.TempDir()
+	var completed []byte
+	receiver, err := NewTransferReceiver(dir, func(info *TransferInfo, path string, m *nats.Msg) (string, error) {
+		data, err := os.ReadFile(path)
+		completed = data
+		return info.Name, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := bytes.Repeat([]byte("0123456789"), 3) // 30 bytes, 4 chunks of 8
+	sum := sha256.Sum256(payload)
+	info := TransferInfo{ID: "abc", Name: "file.txt", Size: int64(len(payload)), ChunkSize: 8, SHA256: hex.EncodeToString(sum[:])}
+	initData, _ := json.Marshal(info)
+
+	ack, err := receiver.HandleMsg(transferMsg(t, TransferInit, "", -1, initData))
+	if err != nil || ack.NextSeq != 0 {
+		t.Fatalf("init ack: %+v err: %v", ack, err)
+	}
+	for seq := 0; seq < 2; seq++ {
+		ack, err = receiver.HandleMsg(transferMsg(t, TransferChunk, "abc", seq, payload[seq*8:seq*8+8]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a resent chunk is acked with the next chunk to send
+	ack, err = receiver.HandleMsg(transferMsg(t, TransferChunk, "abc", 0, payload[:8]))
+	if err != nil || ack.NextSeq != 2 || ack.Received != 16 {
+		t.Fatalf("resent chunk ack: %+v err: %v", ack, err)
+	}
+
+	// a new receiver on the same dir resumes from the part file
+	receiver, _ = NewTransferReceiver(dir, receiver.onComplete)
+	ack, err = receiver.HandleMsg(transferMsg(t, TransferInit, "", -1, initData))
+	if err != nil || ack.NextSeq != 2 {
+		t.Fatalf("resume ack: %+v err: %v", ack, err)
+	}
+	for seq := 2; seq < 4; seq++ {
+		end := seq*8 + 8
+		if end > len(payload) {
+			end = len(payload)
+		}
+		ack, err = receiver.HandleMsg(transferMsg(t, TransferChunk, "abc", seq, payload[seq*8:end]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ack.NextSeq != 4 || ack.Received != 30 {
+		t.Fatalf("last chunk ack: %+v", ack)
+	}
+	ack, err = receiver.HandleMsg(transferMsg(t, TransferCommit, "abc", -1, nil))
+	if err != nil || !ack.Done || ack.Result != "file.txt" {
+		t.Fatalf("commit ack: %+v err: %v", ack, err)
+	}
+	if !bytes.Equal(completed, payload) {
+		t.Errorf("completed file = %q", completed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "abc.part")); !os.IsNotExist(err) {
+		t.Error("part file should be removed after commit")
+	}
+}
+
+func TestTransferReceiverChecksum(t *testing.T) {
+	receiver, _ := NewTransferReceiver(t.TempDir(), func(info *TransferInfo, path string, m *nats.Msg) (string, error) {
+		t.Error("complete should not be called")
+		return "", nil
+	})
+	info := TransferInfo{ID: "bad", Size: 3, ChunkSize: 8, SHA256: "nope"}
+	initData, _ := json.Marshal(info)
+	receiver.HandleMsg(transferMsg(t, TransferInit, "", -1, initData))
+	receiver.HandleMsg(transferMsg(t, TransferChunk, "bad", 0, []byte("abc")))
+	if _, err := receiver.HandleMsg(transferMsg(t, TransferCommit, "bad", -1, nil)); err == nil {
+		t.Error("expected a checksum error")
+	}
+	if _, err := receiver.HandleMsg(transferMsg(t, TransferChunk, "unknown", 0, nil)); err == nil {
+		t.Error("expected an error for an unknown transfer")
+	}
+	info.ID = "../escape"
+	initData, _ = json.Marshal(info)
+	if _, err := receiver.HandleMsg(transferMsg(t, TransferInit, "", -1, initData)); err == nil {
+		t.Error("expected an error for an invalid id")
+	}
+}
+
+func TestTransferSenderReadChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	os.WriteFile(path, []byte("hello world"), 0644)
+	var cleaned bool
+	sender := NewTransferSender(func(info *TransferInfo, m *nats.Msg) (string, func(), error) {
+		return path, func() { cleaned = true }, nil
+	})
+	initData, _ := json.Marshal(TransferInfo{ChunkSize: 4})
+	info, err := sender.init(transferMsg(t, TransferInit, "", -1, initData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 11 || info.Name != "file.txt" || info.ID == "" {
+		t.Fatalf("info = %+v", info)
+	}
+	chunk, err := sender.ReadChunk(info.ID, transferMsg(t, TransferChunk, info.ID, 2, nil))
+	if err != nil || string(chunk) != "rld" {
+		t.Fatalf("chunk 2 = %q err: %v", chunk, err)
+	}
+	sender.done(info.ID)
+	if !cleaned {
+		t.Error("cleanup should be called when the download is done")
+	}
+	if _, err := sender.ReadChunk(info.ID, transferMsg(t, TransferChunk, info.ID, 0, nil)); err == nil {
+		t.Error("expected an error after the download is done")
+	}
+}
+
+func TestTransferCommitUnlocked(t *testing.T) {
+	var receiver *TransferReceiver
+	other, _ := json.Marshal(TransferInfo{ID: "other", Size: 1, ChunkSize: 8})
+	receiver, _ = NewTransferReceiver(t.TempDir(), func(info *TransferInfo, path string, m *nats.Msg) (string, error) {
+		// a slow complete func must not block the other uploads
+		_, err := receiver.HandleMsg(transferMsg(t, TransferInit, "", -1, other))
+		return "", err
+	})
+	sum := sha256.Sum256([]byte("abc"))
+	initData, _ := json.Marshal(TransferInfo{ID: "abc", Size: 3, ChunkSize: 8, SHA256: hex.EncodeToString(sum[:])})
+	receiver.HandleMsg(transferMsg(t, TransferInit, "", -1, initData))
+	receiver.HandleMsg(transferMsg(t, TransferChunk, "abc", 0, []byte("abc")))
+	if ack, err := receiver.HandleMsg(transferMsg(t, TransferCommit, "abc", -1, nil)); err != nil || !ack.Done {
+		t.Fatalf("commit ack: %+v err: %v", ack, err)
+	}
+	if _, err := receiver.HandleMsg(transferMsg(t, TransferCommit, "abc", -1, nil)); err == nil {
+		t.Error("expected a second commit to be an unknown transfer")
+	}
+}
+
+func TestUploadID(t *testing.T) {
+	info := &TransferInfo{SHA256: "abc", Name: "app.zip", Meta: map[string]string{"path": "/a"}}
+	same := &TransferInfo{SHA256: "abc", Name: "app.zip", Meta: map[string]string{"path": "/a"}}
+	other := &TransferInfo{SHA256: "abc", Name: "app.zip", Meta: map[string]string{"path": "/b"}}
+	if uploadID(info) != uploadID(same) || !transferIDPattern.MatchString(uploadID(info)) {
+		t.Error("the same upload should get the same id so it can be resumed")
+	}
+	if uploadID(info) == uploadID(other) {
+		t.Error("the same file sent to another destination should get its own id")
+	}
+}
+
+func TestTransferReceiverExpire(t *testing.T) {
+	dir := t.TempDir()
+	receiver, _ := NewTransferReceiver(dir, func(info *TransferInfo, path string, m *nats.Msg) (string, error) {
+		return "", nil
+	})
+	receiver.MaxSize = 100
+	initMsg := func(id string, size int64) *nats.Msg {
+		data, _ := json.Marshal(TransferInfo{ID: id, Size: size, ChunkSize: 8})
+		return transferMsg(t, TransferInit, "", -1, data)
+	}
+	if _, err := receiver.HandleMsg(initMsg("abc", 60)); err != nil {
+		t.Fatal(err)
+	}
+	// abc holds 60 of the 100 bytes, even before its chunks are sent
+	if _, err := receiver.HandleMsg(initMsg("def", 50)); err == nil {
+		t.Fatal("expected the upload to be over the limit")
+	}
+	// resuming abc doesn't count its own part file
+	if _, err := receiver.HandleMsg(initMsg("abc", 60)); err != nil {
+		t.Fatal(err)
+	}
+
+	// an abandoned upload is deleted, which frees its space
+	old := time.Now().Add(-2 * transferTTL)
+	if err := os.Chtimes(filepath.Join(dir, "abc.part"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := receiver.HandleMsg(initMsg("def", 50)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "abc.part")); !os.IsNotExist(err) {
+		t.Error("the abandoned part file should be deleted")
+	}
+	if _, err := receiver.HandleMsg(transferMsg(t, TransferChunk, "abc", 0, []byte("01234567"))); err == nil {
+		t.Error("expected the abandoned transfer to be unknown")
+	}
+}
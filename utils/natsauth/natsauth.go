@@ -0,0 +1,231 @@
+package natsauth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/NubeDev/flexy/utils"
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/NubeDev/flexy/utils/subjects"
+	"github.com/casbin/casbin/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+// Header keys used to carry credentials on a NATS message
+const (
+	HeaderAuthorization = "Authorization" // "Bearer <jwt>" or "Bearer <api-key>"
+	HeaderToken         = "token"         // same as the gin JWTHandler
+	HeaderAPIKey        = "X-API-Key"
+)
+
+// Header keys set by the Authorizer once a message has been authenticated,
+// so handlers can tell who sent it (eg; for audit logs)
+const (
+	HeaderUser = "X-Auth-User"
+	HeaderRole = "X-Auth-Role"
+)
+
+// ActionAny in a policy matches every action
+const ActionAny = "*"
+
+var actions = []string{"get", "post", "put", "patch", "delete"}
+
+// APIKey maps a static key to a casbin role
+type APIKey struct {
+	Key  string `json:"key" yaml:"key" mapstructure:"key"`
+	Role string `json:"role" yaml:"role" mapstructure:"role"`
+}
+
+// Identity is the authenticated sender of a message
+type Identity struct {
+	Username string `json:"username"`
+	RoleKey  string `json:"roleKey"`
+	IsAdmin  bool   `json:"isAdmin"`
+}
+
+type Opts struct {
+	Enforcer      *casbin.SyncedEnforcer
+	APIKeys       []APIKey
+	SubjectPrefix string // the site/customer prefix, removed from a subject before it's checked against the policies
+}
+
+// Authorizer validates the credentials on a NATS message and checks the
+// subject/action against casbin policies
+type Authorizer struct {
+	enforcer *casbin.SyncedEnforcer
+	apiKeys  map[string]string
+	prefix   string
+}
+
+// New creates a new Authorizer
+func New(opts Opts) (*Authorizer, error) {
+	if opts.Enforcer == nil {
+		return nil, errors.New("casbin enforcer is required")
+	}
+	a := &Authorizer{
+		enforcer: opts.Enforcer,
+		apiKeys:  map[string]string{},
+		prefix:   opts.SubjectPrefix,
+	}
+	for _, key := range opts.APIKeys {
+		if key.Key == "" || key.Role == "" {
+			return nil, errors.New("api key and role must not be empty")
+		}
+		a.apiKeys[key.Key] = key.Role
+	}
+	return a, nil
+}
+
+// NewAuthenticator creates an Authorizer that only authenticates, for callers with their own
+// permission checks (eg; the bios HTTP gateway allowlist). Authorize always fails on it.
+func NewAuthenticator(apiKeys []APIKey) (*Authorizer, error) {
+	a := &Authorizer{apiKeys: map[string]string{}}
+	for _, key := range apiKeys {
+		if key.Key == "" || key.Role == "" {
+			return nil, errors.New("api key and role must not be empty")
+		}
+		a.apiKeys[key.Key] = key.Role
+	}
+	return a, nil
+}
+
+// NewFileEnforcer creates an enforcer from a model and a CSV policy file, for
+// services without a database
+func NewFileEnforcer(modelPath, policyPath string) (*casbin.SyncedEnforcer, error) {
+	e, err := casbin.NewSyncedEnforcer(modelPath, policyPath)
+	if err != nil {
+		return nil, err
+	}
+	RegisterFunctions(e)
+	return e, nil
+}
+
+// RegisterFunctions adds the subjectMatch function used by the NATS casbin model
+func RegisterFunctions(e *casbin.SyncedEnforcer) {
+	e.AddFunction("subjectMatch", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return false, fmt.Errorf("subjectMatch expects 2 arguments, got %d", len(args))
+		}
+		subject, _ := args[0].(string)
+		pattern, _ := args[1].(string)
+		return subjects.Match(subject, pattern), nil
+	})
+}
+
+// ActionFromSubject returns the first action token (get, post, put, patch, delete) in a subject
+func ActionFromSubject(subject string) string {
+	for _, token := range strings.Split(subject, ".") {
+		for _, action := range actions {
+			if token == action {
+				return action
+			}
+		}
+	}
+	return ActionAny
+}
+
+// Credentials are the auth values sent with a request, from the NATS or HTTP headers
+type Credentials struct {
+	APIKey        string // X-API-Key
+	Authorization string // "Bearer <jwt>" or "Bearer <api-key>"
+	Token         string // a bare jwt, same as the gin JWTHandler
+}
+
+// Authenticate returns the identity from the message headers
+func (a *Authorizer) Authenticate(m *nats.Msg) (*Identity, int, error) {
+	if m.Header == nil {
+		return nil, code.TokenInvalid, errors.New("no credentials on message")
+	}
+	return a.AuthenticateCredentials(Credentials{
+		APIKey:        m.Header.Get(HeaderAPIKey),
+		Authorization: m.Header.Get(HeaderAuthorization),
+		Token:         m.Header.Get(HeaderToken),
+	})
+}
+
+// AuthenticateCredentials returns the identity for an API key or JWT
+func (a *Authorizer) AuthenticateCredentials(credentials Credentials) (*Identity, int, error) {
+	if credentials.APIKey != "" {
+		return a.fromAPIKey(credentials.APIKey)
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(credentials.Authorization, "Bearer "))
+	if token == "" {
+		token = credentials.Token
+	}
+	if token == "" {
+		return nil, code.TokenInvalid, errors.New("no credentials on request")
+	}
+	if _, ok := a.apiKeys[token]; ok {
+		return a.fromAPIKey(token)
+	}
+	claims, err := utils.ParseToken(token)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet) {
+			return nil, code.ErrorAuthCheckTokenTimeout, err
+		}
+		return nil, code.ErrorAuthCheckTokenFail, err
+	}
+	return &Identity{
+		Username: claims.Username,
+		RoleKey:  claims.RoleKey,
+		IsAdmin:  claims.IsAdmin,
+	}, code.SUCCESS, nil
+}
+
+func (a *Authorizer) fromAPIKey(key string) (*Identity, int, error) {
+	role, ok := a.apiKeys[key]
+	if !ok {
+		return nil, code.ErrorAuthCheckTokenFail, errors.New("invalid api key")
+	}
+	return &Identity{
+		Username: fmt.Sprintf("api-key:%s", role),
+		RoleKey:  role,
+	}, code.SUCCESS, nil
+}
+
+// Authorize authenticates the message and checks it against the casbin policies
+func (a *Authorizer) Authorize(m *nats.Msg) (*Identity, int, error) {
+	identity, responseCode, err := a.Authenticate(m)
+	if err != nil {
+		return nil, responseCode, err
+	}
+	if identity.IsAdmin {
+		return identity, code.SUCCESS, nil
+	}
+	if a.enforcer == nil {
+		return nil, code.Forbidden, errors.New("no casbin policy to authorize the request")
+	}
+	// the policies are written without the subject prefix, so the same policy works at every site
+	subject := subjects.TrimPrefix(a.prefix, m.Subject)
+	action := ActionFromSubject(subject)
+	ok, err := a.enforcer.Enforce(identity.RoleKey, subject, action)
+	if err != nil {
+		return nil, code.ERROR, err
+	}
+	if !ok {
+		return nil, code.Forbidden, fmt.Errorf("[%s] does not have [%s] permissions for the [%s] subject [%s]", identity.Username, identity.RoleKey, m.Subject, action)
+	}
+	return identity, code.SUCCESS, nil
+}
+
+// Handler wraps a NATS handler so it's only called for authorized messages,
+// otherwise the sender gets an error response
+func (a *Authorizer) Handler(cb nats.MsgHandler) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		identity, responseCode, err := a.Authorize(m)
+		if err != nil {
+			log.Error().Msgf("nats auth failed on subject: %s err: %v", m.Subject, err)
+			if m.Reply != "" {
+				m.Respond(natlib.NewResponse(responseCode, err.Error()).ToJSON())
+			}
+			return
+		}
+		m.Header.Set(HeaderUser, identity.Username)
+		m.Header.Set(HeaderRole, identity.RoleKey)
+		cb(m)
+	}
+}
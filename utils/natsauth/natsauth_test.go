@@ -0,0 +1,141 @@
+package natsauth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NubeDev/flexy/utils"
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/nats-io/nats.go"
+)
+
+const testModel = `[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && subjectMatch(r.obj, p.obj) && (p.act == "*" || r.act == p.act) || r.sub == "admin"
+`
+
+const testPolicy = `p, viewer, *.get.>, get
+p, operator, *.post.system.systemctl.*, post
+g, cloud, operator
+`
+
+func newTestAuthorizer(t *testing.T) *Authorizer {
+	dir := t.TempDir()
+	modelPath := filepath.Join(dir, "model.conf")
+	policyPath := filepath.Join(dir, "policy.csv")
+	if err := os.WriteFile(modelPath, []byte(testModel), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(policyPath, []byte(testPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewFileEnforcer(modelPath, policyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(Opts{Enforcer: e, APIKeys: []APIKey{{Key: "cloud-key", Role: "cloud"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuthorize(t *testing.T) {
+	a := newTestAuthorizer(t)
+	utils.SetJwtSecret("test")
+	viewerToken, _, err := utils.GenerateToken(utils.Claims{Username: "bob", RoleKey: "viewer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		subject string
+		header  nats.Header
+		want    int
+	}{
+		{"no credentials", "abc.get.system.ping", nil, code.TokenInvalid},
+		{"bad token", "abc.get.system.ping", nats.Header{HeaderAuthorization: []string{"Bearer nope"}}, code.ErrorAuthCheckTokenFail},
+		{"viewer get", "abc.get.apps.manager.installed", nats.Header{HeaderAuthorization: []string{"Bearer " + viewerToken}}, code.SUCCESS},
+		{"viewer post", "abc.post.system.systemctl.stop", nats.Header{HeaderToken: []string{viewerToken}}, code.Forbidden},
+		{"api key role inheritance", "abc.post.system.systemctl.stop", nats.Header{HeaderAPIKey: []string{"cloud-key"}}, code.SUCCESS},
+		{"api key as bearer", "abc.post.system.systemctl.stop", nats.Header{HeaderAuthorization: []string{"Bearer cloud-key"}}, code.SUCCESS},
+		{"api key wrong subject", "abc.post.system.store.drop.store", nats.Header{HeaderAPIKey: []string{"cloud-key"}}, code.Forbidden},
+		{"unknown api key", "abc.get.system.ping", nats.Header{HeaderAPIKey: []string{"nope"}}, code.ErrorAuthCheckTokenFail},
+	}
+	for _, tt := range tests {
+		_, got, _ := a.Authorize(&nats.Msg{Subject: tt.subject, Header: tt.header})
+		if got != tt.want {
+			t.Errorf("%s: got code %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorizePrefix(t *testing.T) {
+	a := newTestAuthorizer(t)
+	a.prefix = "acme.site-1"
+	utils.SetJwtSecret("test")
+	tests := []struct {
+		role    string
+		subject string
+		want    int
+	}{
+		{"viewer", "acme.site-1.abc.get.system.ping", code.SUCCESS},
+		{"viewer", "acme.global.get.system.ping", code.SUCCESS},
+		{"viewer", "acme.site-1.abc.post.system.systemctl.stop", code.Forbidden},
+		{"operator", "acme.site-1.abc.post.system.systemctl.stop", code.SUCCESS},
+		{"operator", "acme.site-1.abc.post.system.store.drop.store", code.Forbidden},
+	}
+	for _, tt := range tests {
+		token, _, err := utils.GenerateToken(utils.Claims{Username: "bob", RoleKey: tt.role})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, got, _ := a.Authorize(&nats.Msg{Subject: tt.subject, Header: nats.Header{HeaderToken: []string{token}}})
+		if got != tt.want {
+			t.Errorf("%s %s: got code %d, want %d", tt.role, tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestActionFromSubject(t *testing.T) {
+	if got := ActionFromSubject("abc.proxy.app-1.post.points.one"); got != "post" {
+		t.Errorf("got %s, want post", got)
+	}
+	if got := ActionFromSubject("host.abc.flex.rql"); got != ActionAny {
+		t.Errorf("got %s, want %s", got, ActionAny)
+	}
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	a, err := NewAuthenticator([]APIKey{{Key: "cloud-key", Role: "cloud"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, _, err := a.AuthenticateCredentials(Credentials{Authorization: "Bearer cloud-key"})
+	if err != nil || identity.RoleKey != "cloud" {
+		t.Errorf("api key identity = %+v %v", identity, err)
+	}
+	if _, got, _ := a.AuthenticateCredentials(Credentials{}); got != code.TokenInvalid {
+		t.Errorf("no credentials: got code %d", got)
+	}
+	// without a policy only admins are authorized
+	if _, got, _ := a.Authorize(&nats.Msg{Subject: "abc.get.system.ping", Header: nats.Header{HeaderAPIKey: []string{"cloud-key"}}}); got != code.Forbidden {
+		t.Errorf("authorize without a policy: got code %d", got)
+	}
+	if _, err := NewAuthenticator([]APIKey{{Key: "", Role: "cloud"}}); err == nil {
+		t.Error("expected an error for an empty api key")
+	}
+}
@@ -0,0 +1,355 @@
+package natsconf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/NubeDev/flexy/utils/events"
+	"github.com/NubeDev/flexy/utils/subjects"
+	"github.com/nats-io/nkeys"
+)
+
+// Roles that can connect to the cloud or local broker
+const (
+	RoleBios           = "bios"            // bios on the cloud broker
+	RoleBiosProxy      = "bios-proxy"      // bios forwarding cloud requests onto the local broker
+	RoleRos            = "ros"             // ros on the local broker
+	RoleModule         = "module"          // an app/module on the local broker, named by its app id
+	RoleCloudOperator  = "cloud-operator"  // a cloud user/cli sending requests to edge devices
+	RoleTenantOperator = "tenant-operator" // a cloud user/cli sending requests to every device under a subject prefix
+)
+
+const (
+	inbox        = "_INBOX.>"
+	jetStreamAPI = "$JS.API.>"
+	streamNames  = "$JS.API.STREAM.NAMES"
+	rosAppID     = "ros"
+)
+
+// StoreName is the bios object store the operators upload app zips to
+const StoreName = "bios"
+
+// KVBucket is the bucket on the local broker bios keeps the app config in, see appcommon.InitKVConfig
+const KVBucket = "config"
+
+// streamReadAPI are the JetStream API subjects to read one stream: its info, message gets and consumers
+func streamReadAPI(stream string) []string {
+	return []string{
+		"$JS.API.INFO",
+		"$JS.API.STREAM.INFO." + stream,
+		"$JS.API.STREAM.MSG.GET." + stream,
+		"$JS.API.DIRECT.GET." + stream,
+		"$JS.API.DIRECT.GET." + stream + ".>",
+		"$JS.API.CONSUMER.CREATE." + stream,
+		"$JS.API.CONSUMER.CREATE." + stream + ".>",
+		"$JS.API.CONSUMER.DURABLE.CREATE." + stream + ".*",
+		"$JS.API.CONSUMER.INFO." + stream + ".*",
+		"$JS.API.CONSUMER.DELETE." + stream + ".*",
+		"$JS.API.CONSUMER.MSG.NEXT." + stream + ".*",
+		"$JS.ACK." + stream + ".>",
+		"$JS.FC." + stream + ".>",
+	}
+}
+
+// objectStoreAPI are the subjects to read and write the objects of one store
+func objectStoreAPI(store string) []string {
+	stream := "OBJ_" + store
+	return append(streamReadAPI(stream),
+		"$JS.API.STREAM.CREATE."+stream,
+		"$JS.API.STREAM.PURGE."+stream,
+		fmt.Sprintf("$O.%s.>", store),
+	)
+}
+
+// moduleJetStream lets an app read the config bucket and write its own keys, "<appID>.<config key>"
+func moduleJetStream(appID string) []string {
+	stream := "KV_" + KVBucket
+	return append(streamReadAPI(stream),
+		"$JS.API.STREAM.CREATE."+stream,
+		fmt.Sprintf("$KV.%s.%s.>", KVBucket, appID),
+	)
+}
+
+// kvJetStream lets bios create the config bucket and write every key in it
+func kvJetStream() []string {
+	stream := "KV_" + KVBucket
+	return append(streamReadAPI(stream),
+		"$JS.API.STREAM.CREATE."+stream,
+		"$JS.API.STREAM.UPDATE."+stream,
+		streamNames,
+		fmt.Sprintf("$KV.%s.>", KVBucket),
+	)
+}
+
+// biosJetStream is what bios needs from JetStream on the cloud broker: its own event stream and the bios object store,
+// which it can also drop. jet_stream.store_name must be StoreName.
+func biosJetStream(globalUUID string) []string {
+	stream := events.StreamName(globalUUID)
+	out := append(streamReadAPI(stream), "$JS.API.STREAM.CREATE."+stream, "$JS.API.STREAM.UPDATE."+stream)
+	return append(out, append(objectStoreAPI(StoreName), "$JS.API.STREAM.DELETE.OBJ_"+StoreName, streamNames)...)
+}
+
+// operatorJetStream is what an operator needs from JetStream: the device events and the bios object store, not the
+// streams of the other devices on the broker
+func operatorJetStream(globalUUID string) []string {
+	return append(streamReadAPI(events.StreamName(globalUUID)), objectStoreAPI(StoreName)...)
+}
+
+// PermissionList is the allow/deny list for publish or subscribe
+type PermissionList struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Permissions is a nats-server user permissions block
+type Permissions struct {
+	Publish        *PermissionList `json:"publish,omitempty"`
+	Subscribe      *PermissionList `json:"subscribe,omitempty"`
+	AllowResponses bool            `json:"allow_responses,omitempty"`
+}
+
+// User is a nats-server nkey user, the seed is written to its own file and never to the server config
+type User struct {
+	Name        string      `json:"-"`
+	Role        string      `json:"-"`
+	Seed        []byte      `json:"-"`
+	Nkey        string      `json:"nkey"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// Account groups the users on a broker
+type Account struct {
+	JetStream string  `json:"jetstream,omitempty"`
+	Users     []*User `json:"users"`
+}
+
+// ServerConfig is written as JSON, which nats-server accepts as a config file
+type ServerConfig struct {
+	Port      int                 `json:"port"`
+	JetStream string              `json:"jetstream,omitempty"`
+	Accounts  map[string]*Account `json:"accounts"`
+}
+
+// PermissionsFor returns the subject permissions for a role, following the
+// subjects.SubjectBuilder conventions for the given device (and app for RoleModule)
+func PermissionsFor(role, globalUUID, appID string) (Permissions, error) {
+	return PrefixedPermissionsFor(role, "", globalUUID, appID)
+}
+
+// PrefixedPermissionsFor returns the subject permissions for a role on a device under a
+// site/customer subject prefix, eg; acme.site-1. The prefix only changes the cloud broker roles.
+func PrefixedPermissionsFor(role, prefix, globalUUID, appID string) (Permissions, error) {
+	bios, err := subjects.NewSubjectBuilder(globalUUID, "bios", subjects.IsBios)
+	if err != nil {
+		return Permissions{}, err
+	}
+	bios, err = bios.WithPrefix(prefix)
+	if err != nil {
+		return Permissions{}, err
+	}
+	switch role {
+	case RoleBios:
+		publish := append([]string{inbox, bios.AddGlobalUUID(">")}, biosJetStream(globalUUID)...)
+		if prefix != "" {
+			// events keep the bare uuid subject
+			publish = append(publish, events.Subject(globalUUID, ">"))
+		}
+		return Permissions{
+			Publish:        &PermissionList{Allow: publish},
+			Subscribe:      &PermissionList{Allow: append([]string{inbox, bios.AddGlobalUUID(">")}, bios.GroupSubjects("get", "system", "ping")...)},
+			AllowResponses: true,
+		}, nil
+	case RoleBiosProxy:
+		// the proxy forwards to ros and the app (LocalBrokerConfig adds every app), and keeps the config bucket
+		publish := []string{inbox, events.Subject(globalUUID, ">"), fmt.Sprintf("%s.>", rosAppID)}
+		subscribe := []string{inbox, events.Subject(globalUUID, ">")}
+		if appID != "" {
+			publish = append(publish, fmt.Sprintf("%s.>", appID))
+			subscribe = append(subscribe, fmt.Sprintf("%s.>", appID))
+		}
+		return Permissions{
+			Publish:   &PermissionList{Allow: append(publish, kvJetStream()...)},
+			Subscribe: &PermissionList{Allow: subscribe},
+		}, nil
+	case RoleRos:
+		ros, err := subjects.NewSubjectBuilder(globalUUID, rosAppID, subjects.IsApp)
+		if err != nil {
+			return Permissions{}, err
+		}
+		return Permissions{
+			Publish:        &PermissionList{Allow: []string{inbox, jetStreamAPI, events.Subject(globalUUID, ">")}},
+			Subscribe:      &PermissionList{Allow: []string{fmt.Sprintf("%s.>", ros.AppID), fmt.Sprintf("*.%s.>", globalUUID), ros.GlobalSubject("get", "system", "ping")}},
+			AllowResponses: true,
+		}, nil
+	case RoleModule:
+		if appID == "" {
+			return Permissions{}, fmt.Errorf("app id is required for role %s", role)
+		}
+		app, err := subjects.NewSubjectBuilder(globalUUID, appID, subjects.IsApp)
+		if err != nil {
+			return Permissions{}, err
+		}
+		return Permissions{
+			Publish:        &PermissionList{Allow: append([]string{inbox, events.Subject(globalUUID, ">")}, moduleJetStream(app.AppID)...)},
+			Subscribe:      &PermissionList{Allow: []string{fmt.Sprintf("%s.>", app.AppID), app.GlobalSubject("get", "system", "ping")}},
+			AllowResponses: true,
+		}, nil
+	case RoleCloudOperator:
+		return Permissions{
+			Publish:   &PermissionList{Allow: append([]string{bios.AddGlobalUUID(">"), bios.GlobalSubject("get", "system", "ping")}, operatorJetStream(globalUUID)...)},
+			Subscribe: &PermissionList{Allow: []string{inbox}},
+		}, nil
+	case RoleTenantOperator:
+		if prefix == "" {
+			return Permissions{}, fmt.Errorf("a subject prefix is required for role %s", role)
+		}
+		return Permissions{
+			Publish:   &PermissionList{Allow: append([]string{fmt.Sprintf("%s.>", prefix)}, operatorJetStream(globalUUID)...)},
+			Subscribe: &PermissionList{Allow: []string{inbox}},
+		}, nil
+	}
+	return Permissions{}, fmt.Errorf("unknown role: %s try: %s, %s, %s, %s, %s or %s", role, RoleBios, RoleBiosProxy, RoleRos, RoleModule, RoleCloudOperator, RoleTenantOperator)
+}
+
+// NewUser creates a user with a new nkey pair
+func NewUser(name, role string, permissions Permissions) (*User, error) {
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	seed, err := kp.Seed()
+	if err != nil {
+		return nil, err
+	}
+	return &User{
+		Name:        name,
+		Role:        role,
+		Seed:        seed,
+		Nkey:        publicKey,
+		Permissions: permissions,
+	}, nil
+}
+
+func newRoleUser(name, role, prefix, globalUUID, appID string) (*User, error) {
+	permissions, err := PrefixedPermissionsFor(role, prefix, globalUUID, appID)
+	if err != nil {
+		return nil, err
+	}
+	return NewUser(name, role, permissions)
+}
+
+// CloudBrokerConfig builds the config for the cloud broker (default port 4222)
+// with a bios user for the device and a cloud operator user
+func CloudBrokerConfig(globalUUID string, port int) (*ServerConfig, error) {
+	return PrefixedCloudBrokerConfig("", globalUUID, port)
+}
+
+// PrefixedCloudBrokerConfig builds the cloud broker config for a device under a subject prefix,
+// with a tenant operator user for every device under the prefix when it's set
+func PrefixedCloudBrokerConfig(prefix, globalUUID string, port int) (*ServerConfig, error) {
+	if port == 0 {
+		port = 4222
+	}
+	bios, err := newRoleUser(RoleBios, RoleBios, prefix, globalUUID, "")
+	if err != nil {
+		return nil, err
+	}
+	operator, err := newRoleUser(RoleCloudOperator, RoleCloudOperator, prefix, globalUUID, "")
+	if err != nil {
+		return nil, err
+	}
+	users := []*User{bios, operator}
+	if prefix != "" {
+		tenant, err := newRoleUser(RoleTenantOperator, RoleTenantOperator, prefix, globalUUID, "")
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, tenant)
+	}
+	return newServerConfig(port, "CLOUD", users...), nil
+}
+
+// LocalBrokerConfig builds the config for the local broker (default port 4223)
+// with users for the bios proxy, ros and each module
+func LocalBrokerConfig(globalUUID string, appIDs []string, port int) (*ServerConfig, error) {
+	if port == 0 {
+		port = 4223
+	}
+	proxy, err := newRoleUser(RoleBiosProxy, RoleBiosProxy, "", globalUUID, "")
+	if err != nil {
+		return nil, err
+	}
+	ros, err := newRoleUser(RoleRos, RoleRos, "", globalUUID, "")
+	if err != nil {
+		return nil, err
+	}
+	users := []*User{proxy, ros}
+	for _, appID := range appIDs {
+		module, err := newRoleUser(appID, RoleModule, "", globalUUID, appID)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, module)
+		// the proxy forwards the cloud requests to the app, and bridges its subjects up to the cloud
+		proxy.Permissions.Publish.Allow = append(proxy.Permissions.Publish.Allow, fmt.Sprintf("%s.>", appID))
+		proxy.Permissions.Subscribe.Allow = append(proxy.Permissions.Subscribe.Allow, fmt.Sprintf("%s.>", appID))
+	}
+	return newServerConfig(port, "EDGE", users...), nil
+}
+
+func newServerConfig(port int, accountName string, users ...*User) *ServerConfig {
+	return &ServerConfig{
+		Port:      port,
+		JetStream: "enabled",
+		Accounts: map[string]*Account{
+			accountName: {
+				JetStream: "enabled",
+				Users:     users,
+			},
+		},
+	}
+}
+
+// Users returns every user across all accounts
+func (c *ServerConfig) Users() []*User {
+	var users []*User
+	for _, account := range c.Accounts {
+		users = append(users, account.Users...)
+	}
+	return users
+}
+
+// Write saves the server config as <dir>/<name>.conf and each user seed as <dir>/<name>-<user>.nk
+func (c *ServerConfig) Write(dir, name string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// don't escape the ">" wildcard, nats-server reads the strings as is
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(c); err != nil {
+		return nil, err
+	}
+	confPath := filepath.Join(dir, fmt.Sprintf("%s.conf", name))
+	if err := os.WriteFile(confPath, data.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	files := []string{confPath}
+	for _, user := range c.Users() {
+		seedPath := filepath.Join(dir, fmt.Sprintf("%s-%s.nk", name, user.Name))
+		if err := os.WriteFile(seedPath, user.Seed, 0600); err != nil {
+			return nil, err
+		}
+		files = append(files, seedPath)
+	}
+	return files, nil
+}
@@ -0,0 +1,171 @@
+package natsconf
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/NubeDev/flexy/utils/subjects"
+	"github.com/nats-io/nkeys"
+)
+
+func allowed(list *PermissionList, subject string) bool {
+	if list == nil {
+		return false
+	}
+	for _, pattern := range list.Allow {
+		if subjects.Match(subject, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPermissionsFor(t *testing.T) {
+	module, err := PermissionsFor(RoleModule, "abc", "app-abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed(module.Subscribe, "app-abc.post.math.add.run") {
+		t.Error("module should subscribe to its own subjects")
+	}
+	if allowed(module.Subscribe, "app-2.post.math.add.run") {
+		t.Error("module should not subscribe to another app's subjects")
+	}
+	if !allowed(module.Publish, "$JS.API.STREAM.INFO.KV_config") || !allowed(module.Publish, "$JS.API.CONSUMER.CREATE.KV_config.x.$KV.config.app-abc.>") {
+		t.Error("module should read the config bucket")
+	}
+	if !allowed(module.Publish, "$KV.config.app-abc.debug") || allowed(module.Publish, "$KV.config.app-2.debug") {
+		t.Error("module should only write its own config keys")
+	}
+
+	operator, err := PermissionsFor(RoleCloudOperator, "abc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed(operator.Publish, "abc.post.apps.manager.install") {
+		t.Error("operator should publish to the device")
+	}
+	if allowed(operator.Publish, "xyz.post.apps.manager.install") || allowed(operator.Publish, "$JS.API.CONSUMER.CREATE.EVENTS_xyz") {
+		t.Error("operator should not publish to another device")
+	}
+
+	if _, err := PermissionsFor(RoleModule, "abc", ""); err == nil {
+		t.Error("expected an error when the module app id is empty")
+	}
+	if _, err := PermissionsFor("nope", "abc", ""); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+}
+
+func TestPrefixedPermissionsFor(t *testing.T) {
+	bios, err := PrefixedPermissionsFor(RoleBios, "acme.site-1", "abc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed(bios.Subscribe, "acme.site-1.abc.post.apps.manager.install") || !allowed(bios.Subscribe, "acme.global.get.system.ping") {
+		t.Error("bios should subscribe to its prefixed subjects and the group pings")
+	}
+	if allowed(bios.Subscribe, "abc.post.apps.manager.install") {
+		t.Error("bios should not subscribe to the bare uuid subjects under a prefix")
+	}
+	for _, subject := range []string{"$JS.API.STREAM.CREATE.EVENTS_abc", "$JS.API.STREAM.DELETE.OBJ_bios", "$O.bios.chunks.x", "abc.event.app.started"} {
+		if !allowed(bios.Publish, subject) {
+			t.Errorf("bios should publish to %s", subject)
+		}
+	}
+	for _, subject := range []string{"$JS.API.STREAM.DELETE.EVENTS_xyz", "$JS.API.STREAM.INFO.OBJ_other", "$JS.API.CONSUMER.CREATE.EVENTS_xyz"} {
+		if allowed(bios.Publish, subject) {
+			t.Errorf("bios should not publish to %s", subject)
+		}
+	}
+
+	tenant, err := PrefixedPermissionsFor(RoleTenantOperator, "acme", "abc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed(tenant.Publish, "acme.site-2.xyz.get.system.ping") || !allowed(tenant.Publish, "acme.global.get.system.ping") {
+		t.Error("tenant operator should publish to every device under its prefix")
+	}
+	if allowed(tenant.Publish, "other.site-1.abc.get.system.ping") {
+		t.Error("tenant operator should not publish to another tenant")
+	}
+	if !allowed(tenant.Publish, "$JS.API.CONSUMER.CREATE.EVENTS_abc") || !allowed(tenant.Publish, "$O.bios.chunks.x") {
+		t.Error("tenant operator should read the device events and use the bios store")
+	}
+	for _, subject := range []string{"$JS.API.STREAM.INFO.EVENTS_xyz", "$JS.API.STREAM.DELETE.EVENTS_abc", "$JS.API.STREAM.LIST"} {
+		if allowed(tenant.Publish, subject) {
+			t.Errorf("tenant operator should not publish to %s", subject)
+		}
+	}
+	if _, err := PrefixedPermissionsFor(RoleTenantOperator, "", "abc", ""); err == nil {
+		t.Error("expected an error when the tenant prefix is empty")
+	}
+
+	conf, err := PrefixedCloudBrokerConfig("acme", "abc", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Users()) != 3 {
+		t.Errorf("expected the bios, operator and tenant users, got %d", len(conf.Users()))
+	}
+}
+
+func TestLocalBrokerConfig(t *testing.T) {
+	conf, err := LocalBrokerConfig("abc", []string{"app-abc"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := conf.Users()[0]
+	for _, subject := range []string{"app-abc.get.system.ping", "ros.get.system.ping", "$JS.API.STREAM.INFO.KV_config", "$KV.config.app-abc.debug"} {
+		if !allowed(proxy.Permissions.Publish, subject) {
+			t.Errorf("bios-proxy should publish to %s", subject)
+		}
+	}
+	for _, subject := range []string{"app-2.get.system.ping", "$JS.API.STREAM.DELETE.KV_config", "$JS.API.STREAM.INFO.EVENTS_abc", "$SYS.REQ.SERVER.PING"} {
+		if allowed(proxy.Permissions.Publish, subject) {
+			t.Errorf("bios-proxy should not publish to %s", subject)
+		}
+	}
+	dir := t.TempDir()
+	files, err := conf.Write(dir, "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Fatalf("expected the config and 3 seed files, got %v", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ServerConfig
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Port != 4223 {
+		t.Errorf("expected port 4223, got %d", decoded.Port)
+	}
+	for _, user := range conf.Users() {
+		seed, err := os.ReadFile(filepath.Join(dir, "local-"+user.Name+".nk"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		kp, err := nkeys.FromSeed(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKey, _ := kp.PublicKey()
+		if publicKey != user.Nkey {
+			t.Errorf("seed for %s does not match its public nkey", user.Name)
+		}
+		if options, err := natlib.SeedFileOptions(filepath.Join(dir, "local-"+user.Name+".nk")); err != nil || len(options) != 1 {
+			t.Errorf("expected a connect option for the %s seed, got %v", user.Name, err)
+		}
+	}
+	if _, err := natlib.SeedFileOptions(filepath.Join(dir, "missing.nk")); err == nil {
+		t.Error("expected an error for a missing seed file")
+	}
+}
@@ -0,0 +1,190 @@
+package natsforwarder
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// BreakerOpts configures the retries and the circuit breaker of a Forwarder
+type BreakerOpts struct {
+	Retries          int           `json:"retries" mapstructure:"retries"`                    // retries on a no responders error, default 2
+	RetryBackoff     time.Duration `json:"retryBackoff" mapstructure:"retry_backoff"`         // doubled on each retry plus jitter, default 100ms
+	FailureThreshold int           `json:"failureThreshold" mapstructure:"failure_threshold"` // consecutive failures that open the circuit, default 5
+	OpenTimeout      time.Duration `json:"openTimeout" mapstructure:"open_timeout"`           // how long the circuit stays open before a trial request, default 30s
+	PrefixTokens     int           `json:"prefixTokens" mapstructure:"prefix_tokens"`         // subject tokens a circuit is kept for, default 1 (the app id)
+}
+
+func (opts BreakerOpts) withDefaults() BreakerOpts {
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	} else if opts.Retries == 0 {
+		opts.Retries = 2
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 100 * time.Millisecond
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	if opts.PrefixTokens <= 0 {
+		opts.PrefixTokens = 1
+	}
+	return opts
+}
+
+// backoff returns the wait before a retry, attempt starts at 0
+func (opts BreakerOpts) backoff(attempt int) time.Duration {
+	wait := opts.RetryBackoff << attempt
+	return wait + time.Duration(rand.Int63n(int64(opts.RetryBackoff)))
+}
+
+// RouteStats are the counters for a target subject prefix
+type RouteStats struct {
+	Route        string  `json:"route"`
+	Requests     uint64  `json:"requests"`
+	Success      uint64  `json:"success"`
+	Failure      uint64  `json:"failure"`
+	Rejected     uint64  `json:"rejected"` // not sent as the circuit was open
+	Retries      uint64  `json:"retries"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	MaxLatencyMs float64 `json:"maxLatencyMs"`
+	Circuit      string  `json:"circuit"`
+}
+
+type circuit struct {
+	stats        RouteStats
+	totalLatency time.Duration
+	failures     int
+	openedAt     time.Time
+	trial        bool // a half-open trial request is in flight
+}
+
+type breakers struct {
+	lock     sync.Mutex
+	opts     BreakerOpts
+	circuits map[string]*circuit
+	now      func() time.Time
+}
+
+func newBreakers(opts BreakerOpts) *breakers {
+	return &breakers{
+		opts:     opts.withDefaults(),
+		circuits: map[string]*circuit{},
+		now:      time.Now,
+	}
+}
+
+func (b *breakers) setOpts(opts BreakerOpts) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.opts = opts.withDefaults()
+}
+
+func (b *breakers) getOpts() BreakerOpts {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.opts
+}
+
+// route returns the subject prefix a circuit is kept for
+func (b *breakers) route(subject string) string {
+	tokens := strings.Split(subject, ".")
+	n := b.getOpts().PrefixTokens
+	if n > len(tokens) {
+		n = len(tokens)
+	}
+	return strings.Join(tokens[:n], ".")
+}
+
+func (b *breakers) get(route string) *circuit {
+	c, ok := b.circuits[route]
+	if !ok {
+		c = &circuit{stats: RouteStats{Route: route, Circuit: CircuitClosed}}
+		b.circuits[route] = c
+	}
+	return c
+}
+
+// allow reports whether a request can be sent, false means the circuit is open
+func (b *breakers) allow(route string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c := b.get(route)
+	c.stats.Requests++
+	switch c.stats.Circuit {
+	case CircuitOpen:
+		if b.now().Sub(c.openedAt) < b.opts.OpenTimeout {
+			c.stats.Rejected++
+			return false
+		}
+		c.stats.Circuit = CircuitHalfOpen
+		c.trial = true
+		return true
+	case CircuitHalfOpen:
+		// only one trial request at a time
+		if c.trial {
+			c.stats.Rejected++
+			return false
+		}
+		c.trial = true
+	}
+	return true
+}
+
+func (b *breakers) retried(route string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.get(route).stats.Retries++
+}
+
+// done records the result of a request that was allowed
+func (b *breakers) done(route string, latency time.Duration, success bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c := b.get(route)
+	c.trial = false
+	c.totalLatency += latency
+	if ms := float64(latency) / float64(time.Millisecond); ms > c.stats.MaxLatencyMs {
+		c.stats.MaxLatencyMs = ms
+	}
+	if success {
+		c.stats.Success++
+		c.failures = 0
+		c.stats.Circuit = CircuitClosed
+		return
+	}
+	c.stats.Failure++
+	c.failures++
+	if c.stats.Circuit == CircuitHalfOpen || c.failures >= b.opts.FailureThreshold {
+		c.stats.Circuit = CircuitOpen
+		c.openedAt = b.now()
+	}
+}
+
+func (b *breakers) snapshot() []RouteStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	out := make([]RouteStats, 0, len(b.circuits))
+	for _, c := range b.circuits {
+		stats := c.stats
+		if sent := stats.Success + stats.Failure; sent > 0 {
+			stats.AvgLatencyMs = float64(c.totalLatency) / float64(time.Millisecond) / float64(sent)
+		}
+		out = append(out, stats)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Route < out[j].Route })
+	return out
+}
@@ -1,21 +1,48 @@
 package natsforwarder
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/NubeDev/flexy/utils/natsauth"
+	"github.com/NubeDev/flexy/utils/subjects"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
-	"time"
 )
 
+// HeaderTimeout sets the timeout of a forwarded request, eg; "30s" or "30" (seconds)
+const HeaderTimeout = "X-Timeout"
+
+// MaxTimeout caps the X-Timeout header, so a caller can't hold a request open forever
+const MaxTimeout = 5 * time.Minute
+
+type routeTimeout struct {
+	pattern string
+	timeout time.Duration
+}
+
+// ErrCircuitOpen is returned when a request isn't forwarded as its circuit is open
+var ErrCircuitOpen = errors.New("circuit open")
+
 // Forwarder manages the connection to the target NATS server
 type Forwarder struct {
 	natsClient *nats.Conn
 	timeout    time.Duration
+	lock       sync.RWMutex
+	routes     []routeTimeout
+	breakers   *breakers
+	// keepIdentity forwards the natsauth identity headers, only set when they were set by natsauth and not the caller
+	keepIdentity bool
 }
 
 // NewForwarder creates a new NATS forwarder with the given target server URL and timeout
-func NewForwarder(url string, timeout time.Duration) (*Forwarder, error) {
-	nc, err := nats.Connect(url)
+func NewForwarder(url string, timeout time.Duration, options ...nats.Option) (*Forwarder, error) {
+	nc, err := nats.Connect(url, options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to NATS server: %v", err)
 	}
@@ -23,9 +50,39 @@ func NewForwarder(url string, timeout time.Duration) (*Forwarder, error) {
 	return &Forwarder{
 		natsClient: nc,
 		timeout:    timeout,
+		breakers:   newBreakers(BreakerOpts{}),
 	}, nil
 }
 
+// SetBreakerOpts sets the retry and circuit breaker options
+func (f *Forwarder) SetBreakerOpts(opts BreakerOpts) {
+	f.breakers.setOpts(opts)
+}
+
+// SetKeepIdentity forwards the X-Auth-User and X-Auth-Role headers, only use it when natsauth checked the request and
+// set them, otherwise a caller could claim to be anyone
+func (f *Forwarder) SetKeepIdentity(keep bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.keepIdentity = keep
+}
+
+func (f *Forwarder) keepsIdentity() bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.keepIdentity
+}
+
+// Stats returns the counters for each target subject prefix
+func (f *Forwarder) Stats() []RouteStats {
+	return f.breakers.snapshot()
+}
+
+// Conn returns the connection to the target NATS server
+func (f *Forwarder) Conn() *nats.Conn {
+	return f.natsClient
+}
+
 // Close closes the connection to the NATS server
 func (f *Forwarder) Close() {
 	if f.natsClient != nil {
@@ -33,16 +90,144 @@ func (f *Forwarder) Close() {
 	}
 }
 
-// ForwardRequest forwards the incoming NATS message to the target server and responds back
+// SetRouteTimeout sets the default timeout for the target subjects matching pattern
+// (wildcards are allowed), eg; a longer timeout for app installs. The first matching route is used.
+func (f *Forwarder) SetRouteTimeout(pattern string, timeout time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i, route := range f.routes {
+		if route.pattern == pattern {
+			f.routes[i].timeout = timeout
+			return
+		}
+	}
+	f.routes = append(f.routes, routeTimeout{pattern: pattern, timeout: timeout})
+}
+
+// Timeout returns the timeout for a request, the X-Timeout header wins over the route timeout
+func (f *Forwarder) Timeout(m *nats.Msg, targetSubject string) time.Duration {
+	if m.Header != nil {
+		if timeout, ok := ParseTimeout(m.Header.Get(HeaderTimeout)); ok {
+			return timeout
+		}
+	}
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	for _, route := range f.routes {
+		if subjects.Match(targetSubject, route.pattern) {
+			return route.timeout
+		}
+	}
+	return f.timeout
+}
+
+// ParseTimeout parses a duration ("30s", "500ms") or a number of seconds ("30"), capped at MaxTimeout
+func ParseTimeout(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, false
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return 0, false
+	}
+	if timeout > MaxTimeout {
+		timeout = MaxTimeout
+	}
+	return timeout, true
+}
+
+// copyMsg copies the data and headers of m onto a new message for subject, the identity headers are dropped unless
+// keepIdentity is set
+func copyMsg(m *nats.Msg, subject string, keepIdentity bool) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Data = m.Data
+	for key, values := range m.Header {
+		msg.Header[key] = append([]string(nil), values...)
+	}
+	if !keepIdentity {
+		msg.Header.Del(natsauth.HeaderUser)
+		msg.Header.Del(natsauth.HeaderRole)
+	}
+	return msg
+}
+
+// Forward forwards a request, or a plain publish when the message has no reply subject
+func (f *Forwarder) Forward(m *nats.Msg, targetSubject string) error {
+	if m.Reply == "" {
+		return f.Publish(m, targetSubject)
+	}
+	return f.ForwardRequest(m, targetSubject)
+}
+
+// Publish forwards a fire-and-forget message with its headers to the target server
+func (f *Forwarder) Publish(m *nats.Msg, targetSubject string) error {
+	return f.natsClient.PublishMsg(copyMsg(m, targetSubject, f.keepsIdentity()))
+}
+
+// ForwardRequest forwards the incoming NATS message with its headers to the target server
+// and responds back with the reply (and its headers). Errors are sent back as a natlib.Response.
+// A request with no responders is retried with jitter, and once a target fails repeatedly its
+// circuit opens so requests fail fast with a service unavailable response.
 func (f *Forwarder) ForwardRequest(m *nats.Msg, targetSubject string) error {
+	route := f.breakers.route(targetSubject)
+	if !f.breakers.allow(route) {
+		m.Respond(natlib.NewResponse(code.ServiceUnavailable, fmt.Sprintf("%s is unavailable after repeated failures, try again later", route)).ToJSON())
+		return ErrCircuitOpen
+	}
+	opts := f.breakers.getOpts()
+	start := time.Now()
+	deadline := start.Add(f.Timeout(m, targetSubject))
 	// Forward the message to the target NATS server and wait for the response
-	msg, err := f.natsClient.Request(targetSubject, m.Data, f.timeout)
+	var msg *nats.Msg
+	var err error
+	for attempt := 0; ; attempt++ {
+		msg, err = f.natsClient.RequestMsg(copyMsg(m, targetSubject, f.keepsIdentity()), time.Until(deadline))
+		if err == nil || !errors.Is(err, nats.ErrNoResponders) || attempt >= opts.Retries {
+			break
+		}
+		wait := opts.backoff(attempt)
+		if time.Until(deadline) <= wait {
+			break
+		}
+		f.breakers.retried(route)
+		time.Sleep(wait)
+	}
+	f.breakers.done(route, time.Since(start), err == nil)
 	if err != nil {
-		log.Printf("Error forwarding request to target NATS server: %v", err)
-		m.Respond([]byte(fmt.Sprintf("Error forwarding request: %v", err)))
+		log.Error().Msgf("Error forwarding request to %s: %v", targetSubject, err)
+		responseCode := code.ERROR
+		switch {
+		case errors.Is(err, nats.ErrTimeout):
+			responseCode = code.Timeout
+		case errors.Is(err, nats.ErrNoResponders):
+			responseCode = code.ServiceUnavailable
+		}
+		m.Respond(natlib.NewResponse(responseCode, fmt.Sprintf("Error forwarding request to %s: %v", targetSubject, err)).ToJSON())
 		return err
 	}
 	// Respond with the message received from the forwarded request
-	err = m.Respond(msg.Data)
-	return err
+	reply := copyMsg(msg, m.Reply, true)
+	return m.RespondMsg(reply)
+}
+
+// Bridge republishes the messages on subject from one connection onto another, with their headers,
+// eg; edge events flowing up to the cloud. mapSubject renames the subject, nil keeps it as is.
+func Bridge(from, to *nats.Conn, subject string, mapSubject func(subject string) string) (*nats.Subscription, error) {
+	return from.Subscribe(subject, func(m *nats.Msg) {
+		target := m.Subject
+		if mapSubject != nil {
+			target = mapSubject(m.Subject)
+		}
+		// replies can't be routed back across brokers, so only the message is bridged
+		if err := to.PublishMsg(copyMsg(m, target, false)); err != nil {
+			log.Error().Msgf("nats bridge failed to publish %s to %s: %v", m.Subject, target, err)
+		}
+	})
 }
@@ -0,0 +1,191 @@
+package natsforwarder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NubeDev/flexy/utils/natsauth"
+	"github.com/nats-io/nats.go"
+)
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"30s", 30 * time.Second, true},
+		{"500ms", 500 * time.Millisecond, true},
+		{"10", 10 * time.Second, true},
+		{"1h", MaxTimeout, true},
+		{"-1s", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseTimeout(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseTimeout(%q) = %s, %v want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	f := &Forwarder{timeout: 5 * time.Second}
+	f.SetRouteTimeout("*.post.apps.>", time.Minute)
+
+	m := nats.NewMsg("abc.proxy.ros.post.apps.manager.install")
+	if got := f.Timeout(m, "ros.post.apps.manager.install"); got != time.Minute {
+		t.Errorf("route timeout = %s", got)
+	}
+	if got := f.Timeout(m, "ros.get.system.ping"); got != 5*time.Second {
+		t.Errorf("default timeout = %s", got)
+	}
+	m.Header.Set(HeaderTimeout, "2s")
+	if got := f.Timeout(m, "ros.post.apps.manager.install"); got != 2*time.Second {
+		t.Errorf("header timeout = %s", got)
+	}
+	if got := f.Timeout(&nats.Msg{}, "ros.get.system.ping"); got != 5*time.Second {
+		t.Errorf("nil header timeout = %s", got)
+	}
+}
+
+func TestCopyMsg(t *testing.T) {
+	m := nats.NewMsg("abc.proxy.ros.get.system.ping")
+	m.Data = []byte("ping")
+	m.Header.Set("Debug", "debug")
+	m.Header.Set("Authorization", "Bearer token")
+	m.Header.Set(natsauth.HeaderUser, "admin")
+	out := copyMsg(m, "ros.get.system.ping", false)
+	if out.Subject != "ros.get.system.ping" || string(out.Data) != "ping" {
+		t.Errorf("copy = %s %s", out.Subject, out.Data)
+	}
+	if out.Header.Get("Debug") != "debug" || out.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("headers not copied: %v", out.Header)
+	}
+	if out.Header.Get(natsauth.HeaderUser) != "" {
+		t.Error("a caller's identity header should be dropped")
+	}
+	if copyMsg(m, "ros.get.system.ping", true).Header.Get(natsauth.HeaderUser) != "admin" {
+		t.Error("the identity set by natsauth should be kept")
+	}
+	out.Header.Set("Debug", "changed")
+	if m.Header.Get("Debug") != "debug" {
+		t.Error("the copy should not share headers with the original")
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreakers(BreakerOpts{FailureThreshold: 2, OpenTimeout: time.Minute})
+	b.now = func() time.Time { return now }
+
+	route := b.route("ros.post.apps.manager.install")
+	if route != "ros" {
+		t.Fatalf("route = %s", route)
+	}
+	for i := 0; i < 2; i++ {
+		if !b.allow(route) {
+			t.Fatalf("request %d should be allowed", i)
+		}
+		b.done(route, 10*time.Millisecond, false)
+	}
+	if b.allow(route) {
+		t.Fatal("the circuit should be open after the failure threshold")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.allow(route) {
+		t.Fatal("a trial request should be allowed after the open timeout")
+	}
+	if b.allow(route) {
+		t.Fatal("only one trial request should be allowed while half-open")
+	}
+	b.done(route, 30*time.Millisecond, true)
+	if !b.allow(route) {
+		t.Fatal("the circuit should close after a successful trial")
+	}
+	b.done(route, 20*time.Millisecond, true)
+
+	stats := b.snapshot()
+	if len(stats) != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	got := stats[0]
+	if got.Requests != 6 || got.Success != 2 || got.Failure != 2 || got.Rejected != 2 || got.Circuit != CircuitClosed {
+		t.Errorf("stats = %+v", got)
+	}
+	if got.AvgLatencyMs != 17.5 || got.MaxLatencyMs != 30 {
+		t.Errorf("latency avg = %v max = %v", got.AvgLatencyMs, got.MaxLatencyMs)
+	}
+}
+
+func TestBreakerHalfOpenFailure(t *testing.T) {
+	now := time.Now()
+	b := newBreakers(BreakerOpts{FailureThreshold: 1, OpenTimeout: time.Second, PrefixTokens: 3})
+	b.now = func() time.Time { return now }
+
+	route := b.route("ros.get.system.ping")
+	if route != "ros.get.system" {
+		t.Fatalf("route = %s", route)
+	}
+	b.allow(route)
+	b.done(route, time.Millisecond, false)
+	now = now.Add(time.Second)
+	if !b.allow(route) {
+		t.Fatal("a trial request should be allowed after the open timeout")
+	}
+	b.done(route, time.Millisecond, false)
+	if b.allow(route) {
+		t.Fatal("a failed trial should open the circuit again")
+	}
+}
+
+func TestTableResolve(t *testing.T) {
+	local, ros := &Forwarder{}, &Forwarder{}
+	table := NewTable(time.Second)
+	table.routes = map[string]Route{
+		"ros":      {AppID: "ros", URL: "nats://ros"},
+		"rubix-os": {AppID: "rubix-os", URL: "nats://ros", Rewrite: "ros"},
+	}
+	table.forwarders = map[string]*Forwarder{"nats://ros": ros, "nats://local": local}
+
+	f, target, err := table.Resolve("rubix-os.get.system.ping")
+	if err != nil || f != ros || target != "ros.get.system.ping" {
+		t.Errorf("rewrite route = %v %s %v", f == ros, target, err)
+	}
+	if _, _, err := table.Resolve("app-abc.get.system.ping"); err == nil {
+		t.Error("expected an error for an app without a route")
+	}
+	if _, _, err := table.Resolve("ros"); err == nil {
+		t.Error("expected an error for a subject without an app id")
+	}
+
+	table.defaultRoute = &Route{AppID: DefaultRouteID, URL: "nats://local"}
+	f, target, err = table.Resolve("app-abc.get.system.ping")
+	if err != nil || f != local || target != "app-abc.get.system.ping" {
+		t.Errorf("default route = %v %s %v", f == local, target, err)
+	}
+	if _, _, err := table.Resolve("$JS.API.STREAM.DELETE.KV_config"); err == nil {
+		t.Error("expected the default route to refuse a system subject")
+	}
+	routes := table.Routes()
+	if len(routes) != 3 || routes[0].AppID != "ros" || routes[2].AppID != DefaultRouteID {
+		t.Errorf("routes = %+v", routes)
+	}
+}
+
+func TestTableLoadValidation(t *testing.T) {
+	table := NewTable(time.Second)
+	bad := [][]Route{
+		{{AppID: "", URL: "nats://local"}},
+		{{AppID: "app.abc", URL: "nats://local"}},
+		{{AppID: "app-abc"}},
+		{{AppID: "app-abc", URL: "nats://local"}, {AppID: "app-abc", URL: "nats://other"}},
+	}
+	for _, routes := range bad {
+		if err := table.Load(routes, ""); err == nil {
+			t.Errorf("expected an error loading %+v", routes)
+		}
+	}
+}
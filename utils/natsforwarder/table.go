@@ -0,0 +1,233 @@
+package natsforwarder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NubeDev/flexy/utils/code"
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultRouteID is the app id listed for the default route
+const DefaultRouteID = "*"
+
+// Route maps an app id to the broker it runs on
+type Route struct {
+	AppID   string `json:"appID" mapstructure:"app_id"`
+	URL     string `json:"url" mapstructure:"url"`
+	Rewrite string `json:"rewrite,omitempty" mapstructure:"rewrite"` // replaces the app id in the forwarded subject
+}
+
+// Table routes proxied messages to a Forwarder per target broker, by the app id in the subject
+type Table struct {
+	lock          sync.RWMutex
+	timeout       time.Duration
+	routeTimeouts []routeTimeout
+	breakerOpts   BreakerOpts
+	keepIdentity  bool
+	routes        map[string]Route
+	defaultRoute  *Route
+	forwarders    map[string]*Forwarder // by url
+	options       []nats.Option
+}
+
+// NewTable creates an empty routing table, timeout is the default forward timeout
+func NewTable(timeout time.Duration) *Table {
+	return &Table{
+		timeout:    timeout,
+		routes:     map[string]Route{},
+		forwarders: map[string]*Forwarder{},
+	}
+}
+
+// SetConnectOptions sets the options used to connect to the brokers, eg; an nkey seed, call it before Load
+func (t *Table) SetConnectOptions(options ...nats.Option) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.options = options
+}
+
+// SetRouteTimeout sets the forward timeout for target subjects matching pattern on every broker
+func (t *Table) SetRouteTimeout(pattern string, timeout time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	found := false
+	for i, route := range t.routeTimeouts {
+		if route.pattern == pattern {
+			t.routeTimeouts[i].timeout = timeout
+			found = true
+		}
+	}
+	if !found {
+		t.routeTimeouts = append(t.routeTimeouts, routeTimeout{pattern: pattern, timeout: timeout})
+	}
+	for _, f := range t.forwarders {
+		f.SetRouteTimeout(pattern, timeout)
+	}
+}
+
+// SetBreakerOpts sets the retry and circuit breaker options on every broker
+func (t *Table) SetBreakerOpts(opts BreakerOpts) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.breakerOpts = opts
+	for _, f := range t.forwarders {
+		f.SetBreakerOpts(opts)
+	}
+}
+
+// SetKeepIdentity sets Forwarder.SetKeepIdentity on every broker
+func (t *Table) SetKeepIdentity(keep bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.keepIdentity = keep
+	for _, f := range t.forwarders {
+		f.SetKeepIdentity(keep)
+	}
+}
+
+// Stats returns the counters for each target subject prefix across all brokers
+func (t *Table) Stats() []RouteStats {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	var out []RouteStats
+	for _, f := range t.forwarders {
+		out = append(out, f.Stats()...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Route < out[j].Route })
+	return out
+}
+
+// Load replaces the routes, connecting to any new brokers and closing the ones no longer used.
+// Without a defaultURL, messages for apps not in the routes are rejected. On error the old routes are kept.
+func (t *Table) Load(routes []Route, defaultURL string) error {
+	next := map[string]Route{}
+	for _, route := range routes {
+		if route.AppID == "" || strings.ContainsAny(route.AppID, ".*> ") {
+			return fmt.Errorf("invalid app id in proxy route: %q", route.AppID)
+		}
+		if route.URL == "" {
+			return fmt.Errorf("url is required for the proxy route of app: %s", route.AppID)
+		}
+		if _, ok := next[route.AppID]; ok {
+			return fmt.Errorf("duplicate proxy route for app: %s", route.AppID)
+		}
+		next[route.AppID] = route
+	}
+	var nextDefault *Route
+	if defaultURL != "" {
+		nextDefault = &Route{AppID: DefaultRouteID, URL: defaultURL}
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	urls := map[string]bool{}
+	if nextDefault != nil {
+		urls[nextDefault.URL] = true
+	}
+	for _, route := range next {
+		urls[route.URL] = true
+	}
+	forwarders := map[string]*Forwarder{}
+	for url := range urls {
+		if f, ok := t.forwarders[url]; ok {
+			forwarders[url] = f
+			continue
+		}
+		f, err := NewForwarder(url, t.timeout, t.options...)
+		if err != nil {
+			// close the connections opened for this load
+			for newURL, newF := range forwarders {
+				if _, ok := t.forwarders[newURL]; !ok {
+					newF.Close()
+				}
+			}
+			return err
+		}
+		for _, rt := range t.routeTimeouts {
+			f.SetRouteTimeout(rt.pattern, rt.timeout)
+		}
+		f.SetBreakerOpts(t.breakerOpts)
+		f.SetKeepIdentity(t.keepIdentity)
+		forwarders[url] = f
+	}
+	for url, f := range t.forwarders {
+		if _, ok := forwarders[url]; !ok {
+			log.Info().Msgf("closing nats proxy on url: %s", url)
+			f.Close()
+		}
+	}
+	t.routes = next
+	t.defaultRoute = nextDefault
+	t.forwarders = forwarders
+	return nil
+}
+
+// Routes lists the routes sorted by app id, with the default route last
+func (t *Table) Routes() []Route {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	out := make([]Route, 0, len(t.routes)+1)
+	for _, route := range t.routes {
+		out = append(out, route)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AppID < out[j].AppID })
+	if t.defaultRoute != nil {
+		out = append(out, *t.defaultRoute)
+	}
+	return out
+}
+
+// Resolve returns the forwarder and target subject for an app subject, eg; app-abc.get.system.ping
+func (t *Table) Resolve(appSubject string) (*Forwarder, string, error) {
+	appID, rest, _ := strings.Cut(appSubject, ".")
+	if appID == "" || rest == "" {
+		return nil, "", fmt.Errorf("invalid proxy subject: %s, expected <app-id>.<subject>", appSubject)
+	}
+	// the system subjects ($JS, $KV, $SYS...) and the inboxes of the broker are never an app
+	if strings.HasPrefix(appID, "$") || appID == "_INBOX" {
+		return nil, "", fmt.Errorf("invalid proxy app id: %s", appID)
+	}
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	route, ok := t.routes[appID]
+	if !ok {
+		if t.defaultRoute == nil {
+			return nil, "", fmt.Errorf("no proxy route for app: %s", appID)
+		}
+		route = *t.defaultRoute
+	}
+	target := appSubject
+	if route.Rewrite != "" {
+		target = fmt.Sprintf("%s.%s", route.Rewrite, rest)
+	}
+	return t.forwarders[route.URL], target, nil
+}
+
+// Forward resolves the route for an app subject and forwards the message,
+// a message that can't be routed gets a natlib.Response error
+func (t *Table) Forward(m *nats.Msg, appSubject string) error {
+	f, target, err := t.Resolve(appSubject)
+	if err != nil {
+		if m.Reply != "" {
+			m.Respond(natlib.NewResponse(code.NotFound, err.Error()).ToJSON())
+		}
+		return err
+	}
+	return f.Forward(m, target)
+}
+
+// Close closes the connection to every broker
+func (t *Table) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, f := range t.forwarders {
+		f.Close()
+	}
+	t.forwarders = map[string]*Forwarder{}
+}
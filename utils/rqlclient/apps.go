@@ -24,8 +24,49 @@ func (inst *Client) BiosInstalledApps(timeout time.Duration) (interface{}, error
 	return inst.biosCommandRequest(body, "get", "apps", "manager.installed", timeout)
 }
 
-// BiosLibraryApps retrieves a list of available apps in the library on the client
-func (inst *Client) BiosLibraryApps(timeout time.Duration) (interface{}, error) {
-	body := map[string]string{"body": ""}
+// BiosLibraryApps retrieves a list of available apps in the library on the client, all includes the apps built for
+// the other archs
+func (inst *Client) BiosLibraryApps(all bool, timeout time.Duration) (interface{}, error) {
+	body := map[string]interface{}{"all": all}
 	return inst.biosCommandRequest(body, "get", "apps", "manager.library", timeout)
 }
+
+// BiosAppConfigs lists the config files of an installed app
+func (inst *Client) BiosAppConfigs(appName, version string, timeout time.Duration) (interface{}, error) {
+	body := map[string]string{"name": appName, "version": version}
+	return inst.biosCommandRequest(body, "get", "apps", "manager.configs", timeout)
+}
+
+// BiosAppConfig reads a config file of an installed app, eg; config.yaml
+func (inst *Client) BiosAppConfig(appName, version, file string, timeout time.Duration) (interface{}, error) {
+	body := map[string]string{"name": appName, "version": version, "file": file}
+	return inst.biosCommandRequest(body, "get", "apps", "manager.config", timeout)
+}
+
+// BiosWriteAppConfig validates and writes a config file of an installed app, restart restarts the app once it's written
+func (inst *Client) BiosWriteAppConfig(appName, version, file string, data []byte, restart bool, timeout time.Duration) (interface{}, error) {
+	body := map[string]interface{}{"name": appName, "version": version, "file": file, "data": string(data), "restart": restart}
+	return inst.biosCommandRequest(body, "post", "apps", "manager.config", timeout)
+}
+
+// BiosAppConfigHistory lists who changed the config files of an installed app, with the diffs
+func (inst *Client) BiosAppConfigHistory(appName, version string, timeout time.Duration) (interface{}, error) {
+	body := map[string]string{"name": appName, "version": version}
+	return inst.biosCommandRequest(body, "get", "apps", "manager.config-history", timeout)
+}
+
+// BiosAppUpdates gets the update state of the installed apps and when they were last checked
+func (inst *Client) BiosAppUpdates(timeout time.Duration) (interface{}, error) {
+	return inst.biosCommandRequest(nil, "get", "apps", "manager.updates", timeout)
+}
+
+// BiosCheckAppUpdates checks the release sources for newer versions of the installed apps now
+func (inst *Client) BiosCheckAppUpdates(timeout time.Duration) (interface{}, error) {
+	return inst.biosCommandRequest(nil, "post", "apps", "manager.check-updates", timeout)
+}
+
+// BiosUpgradeApp downloads and installs a newer version of an app, the version defaults to the latest found by the last check
+func (inst *Client) BiosUpgradeApp(appName, version string, timeout time.Duration) (interface{}, error) {
+	body := map[string]string{"name": appName, "version": version}
+	return inst.biosCommandRequest(body, "post", "apps", "manager.upgrade", timeout)
+}
@@ -7,6 +7,7 @@ import (
 	hostService "github.com/NubeDev/flexy/app/services/v1/host"
 	githubdownloader "github.com/NubeDev/flexy/utils/gitdownloader"
 	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/NubeDev/flexy/utils/natsauth"
 	"github.com/NubeDev/flexy/utils/subjects"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
@@ -20,24 +21,55 @@ type Client struct {
 	biosSubjectBuilder *subjects.SubjectBuilder
 	gitDownloader      *githubdownloader.GitHubDownloader
 	natsClient         natlib.NatLib
+	token              string
 }
 
-// New initializes a new Client
-func New(natsURL, globalUUID string) (*Client, error) {
-	nc, err := nats.Connect(natsURL)
+// New initializes a new Client, the options are passed to nats.Connect, eg; natlib.SeedFileOptions
+func New(natsURL, globalUUID string, options ...nats.Option) (*Client, error) {
+	biosSubjectBuilder, err := subjects.NewSubjectBuilder(globalUUID, "bios", subjects.IsBios)
+	if err != nil {
+		return nil, err
+	}
+	nc, err := nats.Connect(natsURL, options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to NATS: %v", err)
 	}
 	return &Client{
 		globalUUID:         globalUUID,
 		natsConn:           nc,
-		biosSubjectBuilder: subjects.NewSubjectBuilder(globalUUID, "bios", subjects.IsBios),
+		biosSubjectBuilder: biosSubjectBuilder,
 		natsClient: natlib.New(natlib.NewOpts{
+			NatsConn:        nc,
 			EnableJetStream: true,
 		}),
 	}, nil
 }
 
+// SetSubjectPrefix sets the site/customer prefix of the device, eg; acme.site-1
+func (inst *Client) SetSubjectPrefix(prefix string) error {
+	sb, err := inst.biosSubjectBuilder.WithPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	inst.biosSubjectBuilder = sb
+	return nil
+}
+
+// SetToken sets the JWT or API key sent with every request
+func (inst *Client) SetToken(token string) {
+	inst.token = token
+}
+
+// request sends a NATS request with the auth header set when a token is configured
+func (inst *Client) request(subject string, data []byte, timeout time.Duration) (*nats.Msg, error) {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	if inst.token != "" {
+		msg.Header.Set(natsauth.HeaderAuthorization, "Bearer "+inst.token)
+	}
+	return inst.natsConn.RequestMsg(msg, timeout)
+}
+
 // sendNATSRequest is a reusable helper function to send a request to a NATS subject
 // and unmarshal the response.
 func (inst *Client) sendNATSRequest(clientUUID, script string, timeout time.Duration) (*nats.Msg, error) {
@@ -49,7 +81,7 @@ func (inst *Client) sendNATSRequest(clientUUID, script string, timeout time.Dura
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request payload: %v", err)
 	}
-	msg, err := inst.natsConn.Request(subject, reqData, timeout)
+	msg, err := inst.request(subject, reqData, timeout)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %v", err)
 	}
@@ -59,7 +91,7 @@ func (inst *Client) sendNATSRequest(clientUUID, script string, timeout time.Dura
 
 // RequestToApp allows sending a NATS request with a dynamic subject and JSON body
 func (inst *Client) RequestToApp(appID, subject string, body []byte, timeout time.Duration) (*nats.Msg, error) {
-	msg, err := inst.natsConn.Request(fmt.Sprintf("%s.%s", appID, subject), body, timeout)
+	msg, err := inst.request(fmt.Sprintf("%s.%s", appID, subject), body, timeout)
 	if err != nil {
 		return nil, fmt.Errorf("NATS request to subject %s failed: %v", subject, err)
 	}
@@ -68,7 +100,7 @@ func (inst *Client) RequestToApp(appID, subject string, body []byte, timeout tim
 
 // RequestWithSubject allows sending a NATS request with a dynamic subject and JSON body
 func (inst *Client) RequestWithSubject(subject string, body []byte, timeout time.Duration) (*nats.Msg, error) {
-	msg, err := inst.natsConn.Request(subject, body, timeout)
+	msg, err := inst.request(subject, body, timeout)
 	if err != nil {
 		return nil, fmt.Errorf("NATS request to subject %s failed: %v", subject, err)
 	}
@@ -76,11 +108,11 @@ func (inst *Client) RequestWithSubject(subject string, body []byte, timeout time
 }
 
 // Helper to build a request and handle the response
-func (inst *Client) biosCommandRequest(body map[string]string, action, entity, op string, timeout time.Duration) (interface{}, error) {
+func (inst *Client) biosCommandRequest(body interface{}, action, entity, op string, timeout time.Duration) (interface{}, error) {
 	// Marshal the request body
 	requestData, err := json.Marshal(body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal %s command: %v", body, err)
+		return nil, fmt.Errorf("failed to marshal %v command: %v", body, err)
 	}
 
 	// Build the subject
@@ -88,7 +120,7 @@ func (inst *Client) biosCommandRequest(body map[string]string, action, entity, o
 
 	log.Info().Msgf("bios-command nats subject: %s", subject)
 	// Send the request
-	request, err := inst.natsConn.Request(subject, requestData, timeout)
+	request, err := inst.request(subject, requestData, timeout)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %v", err)
 	}
@@ -104,24 +136,47 @@ func (inst *Client) biosCommandRequest(body map[string]string, action, entity, o
 }
 
 func (inst *Client) PingHostAllCore(timeout time.Duration) ([]natlib.Response, error) {
-	data := []byte("ping")
-	all, err := inst.natsClient.RequestAll("global.get.system.ping", data, timeout)
-	if err != nil {
+	return inst.PingHostAll(&natlib.RequestAllOpts{Timeout: timeout}, nil)
+}
+
+// PingHostAll pings every bios, ros and app under the client's subject prefix, cb is called for each response as it arrives
+func (inst *Client) PingHostAll(opts *natlib.RequestAllOpts, cb func(resp natlib.Response)) ([]natlib.Response, error) {
+	return inst.PingGroupAll(inst.biosSubjectBuilder.Prefix, opts, cb)
+}
+
+// PingGroupAll pings the devices under group, eg; acme pings every device of a customer and acme.site-1
+// only the devices at one site. An empty group pings every device.
+func (inst *Client) PingGroupAll(group string, opts *natlib.RequestAllOpts, cb func(resp natlib.Response)) ([]natlib.Response, error) {
+	if err := subjects.ValidatePrefix(group); err != nil {
 		return nil, err
 	}
+	if opts == nil {
+		opts = &natlib.RequestAllOpts{}
+	}
+	if inst.token != "" {
+		opts.Header = nats.Header{}
+		opts.Header.Set(natsauth.HeaderAuthorization, "Bearer "+inst.token)
+	}
 	var out []natlib.Response
-	for _, msg := range all {
+	opts.OnResponse = func(msg *nats.Msg) {
 		var m natlib.Response
-		err := json.Unmarshal(msg.Data, &m)
-		if err == nil {
-			out = append(out, m)
+		if err := json.Unmarshal(msg.Data, &m); err != nil {
+			return
+		}
+		out = append(out, m)
+		if cb != nil {
+			cb(m)
 		}
 	}
+	_, err := inst.natsClient.RequestAllWithOpts(subjects.GroupSubject(group, "get", "system", "ping"), []byte("ping"), opts)
+	if err != nil {
+		return nil, err
+	}
 	return out, nil
 }
 
 func (inst *Client) ModuleHelp(clientUUID, moduleUUID string, args []string, timeout time.Duration) (interface{}, error) {
-	request, err := inst.natsConn.Request("subject", []byte(""), timeout)
+	request, err := inst.request("subject", []byte(""), timeout)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %v", err)
 	}
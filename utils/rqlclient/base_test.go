@@ -3,6 +3,7 @@ package rqlclient
 import (
 	"github.com/NubeDev/flexy/utils/helpers/pprint"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -16,7 +17,7 @@ func TestNew(t *testing.T) {
 	//	return
 	//}
 	//pprint.PrintJSON(status)
-	core, err := client.PingHostAllCore()
+	core, err := client.PingHostAllCore(2 * time.Second)
 	if err != nil {
 		return
 	}
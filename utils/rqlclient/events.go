@@ -0,0 +1,16 @@
+package rqlclient
+
+import (
+	"github.com/NubeDev/flexy/utils/events"
+	"github.com/nats-io/nats.go"
+)
+
+// Events subscribes to the device event stream, use a durable name to carry on
+// from the last received event after a disconnect
+func (inst *Client) Events(opts events.SubscribeOpts, cb func(event *events.Event, meta *nats.MsgMetadata)) (*nats.Subscription, error) {
+	js, err := inst.natsConn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	return events.Subscribe(js, inst.globalUUID, opts, cb)
+}
@@ -26,11 +26,11 @@ func (inst *Client) storeCommandRequest(body map[string]interface{}, action stri
 	}
 
 	// Build the subject
-	subject := fmt.Sprintf("%s.post.system.store.%s", inst.globalUUID, action)
+	subject := inst.biosSubjectBuilder.BuildSubject("post", "system", fmt.Sprintf("store.%s", action))
 	log.Info().Msgf("store-command NATS subject: %s", subject)
 
 	// Send the request
-	request, err := inst.natsConn.Request(subject, requestData, timeout)
+	request, err := inst.request(subject, requestData, timeout)
 	if err != nil {
 		return nil, fmt.Errorf("NATS request failed: %v", err)
 	}
@@ -129,3 +129,94 @@ func (inst *Client) DownloadObject(storeName, objectName, destinationPath string
 
 	return response, nil
 }
+
+// GetObjectInfo gets the info of an object, a revision > 0 gets an older revision of it
+func (inst *Client) GetObjectInfo(storeName, objectName string, revision int, timeout time.Duration) (*nats.ObjectInfo, error) {
+	body := map[string]interface{}{
+		"action":     "get.object.info",
+		"storeName":  storeName,
+		"objectName": objectName,
+		"revision":   revision,
+	}
+
+	response, err := inst.storeCommandRequest(body, "get.object.info", timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var info *nats.ObjectInfo
+	err = json.Unmarshal([]byte(response.Payload), &info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response payload: %v", err)
+	}
+	return info, nil
+}
+
+// GetObjectRevisions lists the current object then its kept revisions, newest first
+func (inst *Client) GetObjectRevisions(storeName, objectName string, timeout time.Duration) ([]*nats.ObjectInfo, error) {
+	body := map[string]interface{}{
+		"action":     "get.object.revisions",
+		"storeName":  storeName,
+		"objectName": objectName,
+	}
+
+	response, err := inst.storeCommandRequest(body, "get.object.revisions", timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []*nats.ObjectInfo
+	err = json.Unmarshal([]byte(response.Payload), &revisions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response payload: %v", err)
+	}
+	return revisions, nil
+}
+
+// StoreStat gets the status of a store, eg; its size and number of objects
+func (inst *Client) StoreStat(storeName string, timeout time.Duration) (*natlib.StoreStatus, error) {
+	body := map[string]interface{}{
+		"action":    "get.store.status",
+		"storeName": storeName,
+	}
+
+	response, err := inst.storeCommandRequest(body, "get.store.status", timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var status *natlib.StoreStatus
+	err = json.Unmarshal([]byte(response.Payload), &status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response payload: %v", err)
+	}
+	return status, nil
+}
+
+// CreateStore creates a store, a nil config uses the JetStream defaults
+func (inst *Client) CreateStore(storeName string, config *natlib.StoreConfig, timeout time.Duration) (*natlib.Response, error) {
+	body := map[string]interface{}{
+		"action":    "create.store",
+		"storeName": storeName,
+		"config":    config,
+	}
+	return inst.storeCommandRequest(body, "create.store", timeout)
+}
+
+// DropStore deletes a store and all its objects
+func (inst *Client) DropStore(storeName string, timeout time.Duration) (*natlib.Response, error) {
+	body := map[string]interface{}{
+		"action":    "drop.store",
+		"storeName": storeName,
+	}
+	return inst.storeCommandRequest(body, "drop.store", timeout)
+}
+
+// PurgeStore deletes every object in a store
+func (inst *Client) PurgeStore(storeName string, timeout time.Duration) (*natlib.Response, error) {
+	body := map[string]interface{}{
+		"action":    "purge",
+		"storeName": storeName,
+	}
+	return inst.storeCommandRequest(body, "purge", timeout)
+}
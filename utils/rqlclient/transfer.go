@@ -0,0 +1,64 @@
+package rqlclient
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/NubeDev/flexy/utils/natlib"
+	"github.com/NubeDev/flexy/utils/natsauth"
+	"github.com/nats-io/nats.go"
+)
+
+func (inst *Client) transferOpts(opts *natlib.TransferOpts) *natlib.TransferOpts {
+	if opts == nil {
+		opts = &natlib.TransferOpts{}
+	}
+	if inst.token != "" {
+		opts.Header = nats.Header{}
+		opts.Header.Set(natsauth.HeaderAuthorization, "Bearer "+inst.token)
+	}
+	return opts
+}
+
+func (inst *Client) upload(scope, localPath string, meta map[string]string, opts *natlib.TransferOpts) (*natlib.TransferAck, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info := &natlib.TransferInfo{Name: filepath.Base(localPath), Meta: meta}
+	subject := inst.biosSubjectBuilder.BuildSubject("post", "system", fmt.Sprintf("transfer.%s.upload", scope))
+	return natlib.Upload(inst.natsConn, subject, f, info, inst.transferOpts(opts))
+}
+
+func (inst *Client) download(scope, destPath string, meta map[string]string, opts *natlib.TransferOpts) (*natlib.TransferInfo, error) {
+	subject := inst.biosSubjectBuilder.BuildSubject("get", "system", fmt.Sprintf("transfer.%s.download", scope))
+	return natlib.Download(inst.natsConn, subject, &natlib.TransferInfo{Meta: meta}, destPath, inst.transferOpts(opts))
+}
+
+// StoreUpload uploads a local file into a bios object store in chunks, calling it again after a failure resumes the upload
+func (inst *Client) StoreUpload(storeName, objectName, localPath string, opts *natlib.TransferOpts) (*natlib.TransferAck, error) {
+	return inst.StoreUploadWithMeta(storeName, objectName, localPath, "", nil, opts)
+}
+
+// StoreUploadWithMeta is StoreUpload with the description and metadata of the object, eg; appID, version and arch
+func (inst *Client) StoreUploadWithMeta(storeName, objectName, localPath, description string, metadata map[string]string, opts *natlib.TransferOpts) (*natlib.TransferAck, error) {
+	meta := natlib.ObjectTransferMeta(map[string]string{"storeName": storeName, "objectName": objectName}, description, metadata)
+	return inst.upload("store", localPath, meta, opts)
+}
+
+// StoreDownload downloads an object from a bios object store in chunks
+func (inst *Client) StoreDownload(storeName, objectName, destPath string, opts *natlib.TransferOpts) (*natlib.TransferInfo, error) {
+	return inst.download("store", destPath, map[string]string{"storeName": storeName, "objectName": objectName}, opts)
+}
+
+// FileUpload uploads a local file to a path on the device in chunks
+func (inst *Client) FileUpload(localPath, root, remotePath string, opts *natlib.TransferOpts) (*natlib.TransferAck, error) {
+	return inst.upload("file", localPath, map[string]string{"root": root, "path": remotePath}, opts)
+}
+
+// FileDownload downloads a file from the device in chunks
+func (inst *Client) FileDownload(root, remotePath, destPath string, opts *natlib.TransferOpts) (*natlib.TransferInfo, error) {
+	return inst.download("file", destPath, map[string]string{"root": root, "path": remotePath}, opts)
+}
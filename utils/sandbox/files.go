@@ -0,0 +1,339 @@
+package sandbox
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileInfo is a file or directory, the path is relative to its root
+type FileInfo struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+	Symlink bool      `json:"symlink,omitempty"`
+}
+
+func (fs *FS) fileInfo(root, real string, info os.FileInfo) *FileInfo {
+	return &FileInfo{
+		Name:    info.Name(),
+		Path:    fs.rel(root, real),
+		Size:    info.Size(),
+		Mode:    info.Mode().String(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+		Symlink: info.Mode()&os.ModeSymlink != 0,
+	}
+}
+
+// Stat is the info of a file or directory
+func (fs *FS) Stat(root, path string) (*FileInfo, error) {
+	real, err := fs.Resolve(root, path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return nil, err
+	}
+	return fs.fileInfo(root, real, info), nil
+}
+
+// List is the contents of a directory, the symlinks aren't followed
+func (fs *FS) List(root, path string) ([]*FileInfo, error) {
+	real, err := fs.Resolve(root, path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(real)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed since it was listed
+		}
+		files = append(files, fs.fileInfo(root, filepath.Join(real, entry.Name()), info))
+	}
+	return files, nil
+}
+
+// Read reads up to length bytes from offset, it returns the bytes and the size of the file
+func (fs *FS) Read(root, path string, offset, length int64) ([]byte, int64, error) {
+	if offset < 0 || length < 0 {
+		return nil, 0, errors.New("offset and length can't be negative")
+	}
+	real, err := fs.Resolve(root, path)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(real)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.IsDir() {
+		return nil, 0, fmt.Errorf("%s is a directory", path)
+	}
+	if offset > info.Size() {
+		offset = info.Size()
+	}
+	if remaining := info.Size() - offset; length > remaining {
+		length = remaining
+	}
+	data := make([]byte, length)
+	n, err := f.ReadAt(data, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, 0, err
+	}
+	return data[:n], info.Size(), nil
+}
+
+// Write writes a file (or appends to it), the parent directories are created
+func (fs *FS) Write(root, path string, data []byte, appendData bool, perm os.FileMode) (*FileInfo, error) {
+	real, err := fs.Resolve(root, path)
+	if err != nil {
+		return nil, err
+	}
+	if fs.isRoot(root, real) {
+		return nil, errors.New("a path in the root is required")
+	}
+	if err := os.MkdirAll(filepath.Dir(real), 0755); err != nil {
+		return nil, err
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendData {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(real, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(root, path)
+}
+
+// Mkdir creates a directory and its parents
+func (fs *FS) Mkdir(root, path string) error {
+	real, err := fs.Resolve(root, path)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(real, 0755)
+}
+
+// Chmod changes the permissions of a file or directory
+func (fs *FS) Chmod(root, path string, perm os.FileMode) error {
+	real, err := fs.Resolve(root, path)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(real, perm)
+}
+
+// Move renames a file or directory, it can be moved to another root on the same filesystem
+func (fs *FS) Move(root, path, destRoot, dest string) error {
+	real, err := fs.resolveEntry(root, path)
+	if err != nil {
+		return err
+	}
+	realDest, err := fs.Resolve(destRoot, dest)
+	if err != nil {
+		return err
+	}
+	if fs.isRoot(root, real) || fs.isRoot(destRoot, realDest) {
+		return errors.New("a root can't be moved or replaced")
+	}
+	if err := os.MkdirAll(filepath.Dir(realDest), 0755); err != nil {
+		return err
+	}
+	return os.Rename(real, realDest)
+}
+
+// Delete removes a file or an empty directory, recursive removes a directory and its contents
+func (fs *FS) Delete(root, path string, recursive bool) error {
+	real, err := fs.resolveEntry(root, path)
+	if err != nil {
+		return err
+	}
+	if fs.isRoot(root, real) {
+		return errors.New("a root can't be deleted")
+	}
+	if _, err := os.Lstat(real); err != nil {
+		return err
+	}
+	if recursive {
+		return os.RemoveAll(real)
+	}
+	return os.Remove(real)
+}
+
+// SHA256 is the hex sha256 of a file
+func (fs *FS) SHA256(root, path string) (string, error) {
+	real, err := fs.Resolve(root, path)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(real)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Zip zips a file or directory into dest, the entries start with the name of the directory. The symlinks are left out
+// so a zip can't pick up files from outside of the root.
+func (fs *FS) Zip(root, path, destRoot, dest string) error {
+	real, err := fs.Resolve(root, path)
+	if err != nil {
+		return err
+	}
+	realDest, err := fs.Resolve(destRoot, dest)
+	if err != nil {
+		return err
+	}
+	if within(real, realDest) {
+		return errors.New("the zip can't be inside the directory being zipped")
+	}
+	if err := os.MkdirAll(filepath.Dir(realDest), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(realDest)
+	if err != nil {
+		return err
+	}
+	writer := zip.NewWriter(out)
+	parent := filepath.Dir(real)
+	err = filepath.Walk(real, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(parent, file)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+			_, err = writer.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(realDest)
+	}
+	return err
+}
+
+// Unzip extracts a zip into the dest directory, an entry that would land outside of it or a symlink is refused
+func (fs *FS) Unzip(root, path, destRoot, dest string) error {
+	real, err := fs.Resolve(root, path)
+	if err != nil {
+		return err
+	}
+	realDest, err := fs.Resolve(destRoot, dest)
+	if err != nil {
+		return err
+	}
+	reader, err := zip.OpenReader(real)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		target := filepath.Join(realDest, filepath.FromSlash(file.Name))
+		if !within(realDest, target) || strings.Contains(file.Name, `\`) {
+			return fmt.Errorf("%w: zip entry %s", ErrOutsideRoot, file.Name)
+		}
+		// a symlink already in the dest directory could point outside of the root
+		if realTarget, err := evalExisting(target); err != nil || !within(fs.roots[destRoot], realTarget) {
+			return fmt.Errorf("%w: zip entry %s", ErrOutsideRoot, file.Name)
+		}
+		mode := file.Mode()
+		if mode&os.ModeSymlink != 0 {
+			return fmt.Errorf("zip entry %s is a symlink", file.Name)
+		}
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := unzipFile(file, target, mode.Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unzipFile(file *zip.File, target string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	in, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+Package sandbox confines file operations to named root directories, eg; {"logs": "/var/log", "data": "/ros/apps/data"}.
+A path is always relative to its root, ".." can't climb out of it and a symlink that points outside the root is refused.
+
+	fs, err := sandbox.New(map[string]string{"logs": "/var/log"})
+	data, size, err := fs.Read("logs", "bios/bios.log", 0, 1024)
+*/
+
+var (
+	ErrUnknownRoot = errors.New("unknown root")
+	ErrOutsideRoot = errors.New("path is outside of its root")
+)
+
+// FS is a set of root directories, it's safe to use from several goroutines
+type FS struct {
+	roots map[string]string // real absolute path by root name
+}
+
+// New checks the roots are absolute paths, a root doesn't have to exist yet
+func New(roots map[string]string) (*FS, error) {
+	fs := &FS{roots: map[string]string{}}
+	for name, dir := range roots {
+		if name == "" || !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("root %q must be an absolute path, got %q", name, dir)
+		}
+		real, err := evalExisting(filepath.Clean(dir))
+		if err != nil {
+			return nil, err
+		}
+		fs.roots[name] = real
+	}
+	return fs, nil
+}
+
+// Roots are the root directories by name
+func (fs *FS) Roots() map[string]string {
+	roots := make(map[string]string, len(fs.roots))
+	for name, dir := range fs.roots {
+		roots[name] = dir
+	}
+	return roots
+}
+
+// RootNames are the sorted names of the roots
+func (fs *FS) RootNames() []string {
+	names := make([]string, 0, len(fs.roots))
+	for name := range fs.roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve is the real path of a path in a root, with its symlinks evaluated. The path doesn't have to exist.
+func (fs *FS) Resolve(root, path string) (string, error) {
+	base, ok := fs.roots[root]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownRoot, root)
+	}
+	// cleaning it as an absolute path drops any leading ..
+	full := filepath.Join(base, filepath.Clean("/"+path))
+	real, err := evalExisting(full)
+	if err != nil {
+		return "", err
+	}
+	if !within(base, real) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideRoot, path)
+	}
+	return real, nil
+}
+
+// resolveEntry is like Resolve but a symlink at the end of the path isn't followed, so the link itself is deleted or moved
+func (fs *FS) resolveEntry(root, path string) (string, error) {
+	clean := filepath.Clean("/" + path)
+	if clean == "/" {
+		return fs.Resolve(root, clean)
+	}
+	parent, err := fs.Resolve(root, filepath.Dir(clean))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(clean)), nil
+}
+
+// rel is the path relative to its root, as it's shown to the callers
+func (fs *FS) rel(root, real string) string {
+	rel, err := filepath.Rel(fs.roots[root], real)
+	if err != nil {
+		return filepath.Base(real)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (fs *FS) isRoot(root, real string) bool {
+	return fs.roots[root] == real
+}
+
+func within(base, path string) bool {
+	return path == base || strings.HasPrefix(path, base+string(filepath.Separator)) || base == string(filepath.Separator)
+}
+
+// evalExisting evaluates the symlinks of the part of the path that exists, the rest is added back as is
+func evalExisting(path string) (string, error) {
+	rest := ""
+	for current := path; ; {
+		real, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return path, nil
+		}
+		rest = filepath.Join(filepath.Base(current), rest)
+		current = parent
+	}
+}
@@ -0,0 +1,131 @@
+package sandbox
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestFS(t *testing.T) (*FS, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	logs := filepath.Join(dir, "logs")
+	for _, d := range []string{data, logs} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs, err := New(map[string]string{"data": data, "logs": logs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, dir, data
+}
+
+func TestResolve(t *testing.T) {
+	fs, dir, data := newTestFS(t)
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret"), filepath.Join(data, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(data, "app"), filepath.Join(data, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.Resolve("data", "../../secret")
+	if err != nil || got != filepath.Join(data, "secret") {
+		t.Errorf("../ should stay in the root, got %s %v", got, err)
+	}
+	if _, err := fs.Resolve("data", "escape"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("expected a symlink out of the root to be refused, got %v", err)
+	}
+	if _, err := fs.Resolve("data", "inside/config.yaml"); err != nil {
+		t.Errorf("a symlink inside the root is fine, got %v", err)
+	}
+	if _, err := fs.Resolve("etc", "passwd"); !errors.Is(err, ErrUnknownRoot) {
+		t.Errorf("expected an unknown root, got %v", err)
+	}
+	if _, err := New(map[string]string{"data": "relative/path"}); err == nil {
+		t.Error("expected a relative root to be rejected")
+	}
+
+	// deleting the symlink removes the link, not what it points to
+	if err := fs.Delete("data", "escape", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "secret")); err != nil {
+		t.Errorf("the symlink target shouldn't be deleted: %v", err)
+	}
+	if err := fs.Delete("data", "../", true); err == nil {
+		t.Error("expected the root to be kept")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	fs, _, data := newTestFS(t)
+	info, err := fs.Write("data", "app/config.yaml", []byte("debug: true\n"), false, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Path != "app/config.yaml" || info.Size != 12 {
+		t.Errorf("info = %+v", info)
+	}
+	if _, err := fs.Write("data", "app/config.yaml", []byte("port: 1\n"), true, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, size, err := fs.Read("data", "app/config.yaml", 12, 100)
+	if err != nil || string(got) != "port: 1\n" || size != 20 {
+		t.Errorf("read = %q %d %v", got, size, err)
+	}
+	sum, err := fs.SHA256("data", "app/config.yaml")
+	if err != nil || len(sum) != 64 {
+		t.Errorf("sha256 = %s %v", sum, err)
+	}
+	if err := fs.Chmod("data", "app/config.yaml", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if stat, _ := os.Stat(filepath.Join(data, "app/config.yaml")); stat.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v", stat.Mode())
+	}
+
+	if err := fs.Zip("data", "app", "logs", "backup/app.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Move("data", "app", "data", "old/app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Unzip("logs", "backup/app.zip", "data", "restored"); err != nil {
+		t.Fatal(err)
+	}
+	files, err := fs.List("data", "restored/app")
+	if err != nil || len(files) != 1 || files[0].Path != "restored/app/config.yaml" {
+		t.Errorf("files = %+v %v", files, err)
+	}
+	if err := fs.Delete("data", "restored", false); err == nil {
+		t.Error("expected a directory that isn't empty to need recursive")
+	}
+	if err := fs.Delete("data", "restored", true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnzipSlip(t *testing.T) {
+	fs, _, data := newTestFS(t)
+	f, err := os.Create(filepath.Join(data, "slip.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	entry, _ := w.Create("../../evil.txt")
+	entry.Write([]byte("evil"))
+	w.Close()
+	f.Close()
+	if err := fs.Unzip("data", "slip.zip", "data", "out"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("expected the entry to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+Package semver compares the app versions, eg; v1.0.3, 1.2 or v2.0.0-rc.1. A missing minor or patch is 0 and a version
+with a pre-release (-rc.1) is older than the same version without one.
+*/
+
+// Change is how far apart two versions are
+type Change string
+
+const (
+	None  Change = ""
+	Patch Change = "patch"
+	Minor Change = "minor"
+	Major Change = "major"
+)
+
+// Version is a parsed app version
+type Version struct {
+	Major      int    `json:"major"`
+	Minor      int    `json:"minor"`
+	Patch      int    `json:"patch"`
+	PreRelease string `json:"preRelease,omitempty"`
+}
+
+// Parse parses a version with or without the leading v, build metadata (+abc) is dropped
+func Parse(version string) (*Version, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, pre, _ := strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if s == "" || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid version %q, expected eg; v1.0.3", version)
+	}
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q, expected eg; v1.0.3", version)
+		}
+		numbers[i] = n
+	}
+	return &Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2], PreRelease: pre}, nil
+}
+
+// String returns the version with the leading v
+func (v *Version) String() string {
+	s := fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.PreRelease != "" {
+		s += "-" + v.PreRelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 when v is older, the same or newer than other
+func (v *Version) Compare(other *Version) int {
+	for _, d := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+	switch {
+	case v.PreRelease == other.PreRelease:
+		return 0
+	case v.PreRelease == "":
+		return 1
+	case other.PreRelease == "":
+		return -1
+	}
+	return sign(strings.Compare(v.PreRelease, other.PreRelease))
+}
+
+// ChangeTo is the change from v to a newer version, None when newer isn't newer
+func (v *Version) ChangeTo(newer *Version) Change {
+	switch {
+	case v.Compare(newer) >= 0:
+		return None
+	case v.Major != newer.Major:
+		return Major
+	case v.Minor != newer.Minor:
+		return Minor
+	}
+	return Patch
+}
+
+// Compare compares two version strings, see Version.Compare
+func Compare(a, b string) (int, error) {
+	va, err := Parse(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := Parse(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.Compare(vb), nil
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+package semver
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"v1.0.3", "v1.0.3", 0},
+		{"v1.0.3", "1.0.3", 0},
+		{"v1.0", "v1.0.0", 0},
+		{"v1.0.3", "v1.0.10", -1},
+		{"v1.2.0", "v1.1.9", 1},
+		{"v2.0.0-rc.1", "v2.0.0", -1},
+		{"v2.0.0-rc.2", "v2.0.0-rc.1", 1},
+		{"v1.0.3+abc", "v1.0.3", 0},
+	} {
+		got, err := Compare(tt.a, tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Compare(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+	for _, version := range []string{"", "v", "latest", "v1.x", "v1.2.3.4"} {
+		if _, err := Parse(version); err == nil {
+			t.Errorf("expected %q to be invalid", version)
+		}
+	}
+}
+
+func TestChangeTo(t *testing.T) {
+	for _, tt := range []struct {
+		from, to string
+		want     Change
+	}{
+		{"v1.0.3", "v1.0.4", Patch},
+		{"v1.0.3", "v1.1.0", Minor},
+		{"v1.0.3", "v2.0.0", Major},
+		{"v1.0.3", "v1.0.3", None},
+		{"v1.0.3", "v1.0.2", None},
+	} {
+		from, _ := Parse(tt.from)
+		to, _ := Parse(tt.to)
+		if got := from.ChangeTo(to); got != tt.want {
+			t.Errorf("%s to %s = %q, want %q", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
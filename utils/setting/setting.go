@@ -35,7 +35,14 @@ type Database struct {
 }
 
 type Nats struct {
-	TopicPrefix string `yaml:"topicprefix"`
+	TopicPrefix string   `yaml:"topicprefix"`
+	ApiKeys     []ApiKey `yaml:"apikeys"`
+}
+
+// ApiKey is a static key that can be used instead of a JWT on NATS requests
+type ApiKey struct {
+	Key  string `yaml:"key"`
+	Role string `yaml:"role"`
 }
 
 type Config struct {
@@ -2,14 +2,13 @@ package subjects
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
 	"strings"
 )
 
 // SubjectBuilder is a struct that holds the parameters for building a NATS subject
 type SubjectBuilder struct {
+	Prefix      string // optional site/customer prefix, eg; acme.site-1
 	GlobalUUID  string
 	AppID       string
 	subjectType string
@@ -19,37 +18,124 @@ const globalName = "global"
 const IsApp = "app"
 const IsProxy = "proxy"
 const IsBios = "bios"
+const IsGlobal = globalName // requests sent to every device, eg; global.get.system.ping
 
-// NewSubjectBuilder creates a new SubjectBuilder
-func NewSubjectBuilder(globalUUID, appID string, subjectType string) *SubjectBuilder {
-	if subjectType != IsApp && subjectType != IsProxy && subjectType != IsBios {
-		log.Fatal(fmt.Sprintf("subject type %s is not supported try: %s or %s or %s", subjectType, IsApp, IsProxy, IsBios))
+// NewSubjectBuilder creates a new SubjectBuilder, the ids must be single subject tokens.
+// An empty id is allowed here (eg; a client that only sends global requests), Build rejects it.
+func NewSubjectBuilder(globalUUID, appID string, subjectType string) (*SubjectBuilder, error) {
+	if subjectType != IsApp && subjectType != IsProxy && subjectType != IsBios && subjectType != IsGlobal {
+		return nil, unsupportedType(subjectType)
+	}
+	if globalUUID != "" {
+		if err := validateID("global uuid", globalUUID, false); err != nil {
+			return nil, err
+		}
+	}
+	if appID != "" {
+		if err := validateID("app id", appID, false); err != nil {
+			return nil, err
+		}
 	}
 	return &SubjectBuilder{
 		GlobalUUID:  globalUUID,
 		AppID:       appID,
 		subjectType: subjectType,
+	}, nil
+}
+
+// WithPrefix returns a copy of the builder that puts prefix in front of the bios, proxy and
+// global subjects, eg; acme.site-1.<uuid>.get.system.ping. App subjects stay on the device's
+// local broker so they are never prefixed.
+func (sb *SubjectBuilder) WithPrefix(prefix string) (*SubjectBuilder, error) {
+	if err := ValidatePrefix(prefix); err != nil {
+		return nil, err
 	}
+	out := *sb
+	out.Prefix = prefix
+	return &out, nil
 }
 
+// GlobalSubject builds the broadcast subject for the devices under the builder's prefix
 func (sb *SubjectBuilder) GlobalSubject(action, resource, scope string) string {
-	return fmt.Sprintf("%s.%s.%s.%s", globalName, action, resource, scope)
+	return GroupSubject(sb.Prefix, action, resource, scope)
+}
+
+// GroupSubjects returns the broadcast subject for every level of the builder's prefix, so a device
+// with the prefix acme.site-1 subscribes to global.*, acme.global.* and acme.site-1.global.*
+func (sb *SubjectBuilder) GroupSubjects(action, resource, scope string) []string {
+	out := []string{GroupSubject("", action, resource, scope)}
+	if sb.Prefix == "" {
+		return out
+	}
+	tokens := strings.Split(sb.Prefix, ".")
+	for i := range tokens {
+		out = append(out, GroupSubject(strings.Join(tokens[:i+1], "."), action, resource, scope))
+	}
+	return out
 }
 
+// AddGlobalUUID puts the prefix and global uuid in front of subject
 func (sb *SubjectBuilder) AddGlobalUUID(subject string) string {
+	if sb.Prefix != "" {
+		return fmt.Sprintf("%s.%s.%s", sb.Prefix, sb.GlobalUUID, subject)
+	}
 	return fmt.Sprintf("%s.%s", sb.GlobalUUID, subject)
 }
 
-// BuildSubject builds a NATS subject based on the proxy usage
+// GroupSubject builds the broadcast subject for the devices under group (a prefix or a part of one),
+// eg; GroupSubject("acme.site-1", "get", "system", "ping") is acme.site-1.global.get.system.ping
+func GroupSubject(group, action, resource, scope string) string {
+	s := &Subject{Type: IsGlobal, Prefix: group, Action: action, Resource: resource, Scope: scope}
+	return s.String()
+}
+
+// Subject returns the typed subject for the builder, the scope may be several tokens, eg; manager.install
+func (sb *SubjectBuilder) Subject(action, resource, scope string) *Subject {
+	s := &Subject{Type: sb.subjectType, Action: action, Resource: resource, Scope: scope}
+	switch sb.subjectType {
+	case IsBios:
+		s.Prefix = sb.Prefix
+		s.GlobalUUID = sb.GlobalUUID
+	case IsApp:
+		s.AppID = sb.AppID
+	case IsProxy:
+		s.Prefix = sb.Prefix
+		s.GlobalUUID = sb.GlobalUUID
+		s.AppID = sb.AppID
+	}
+	return s
+}
+
+// BuildSubject builds a NATS subject based on the proxy usage, wildcards are kept so it can
+// build subscription patterns, eg; BuildSubject("get", "system", "proxy.*")
 func (sb *SubjectBuilder) BuildSubject(action, resource, scope string) string {
-	if sb.subjectType == IsBios {
-		return fmt.Sprintf("%s.%s.%s.%s", sb.GlobalUUID, action, resource, scope)
-	} else if sb.subjectType == IsApp {
-		return fmt.Sprintf("%s.%s.%s.%s", sb.AppID, action, resource, scope)
-	} else if sb.subjectType == IsProxy {
-		return fmt.Sprintf("%s.proxy.%s.%s.%s.%s", sb.GlobalUUID, sb.AppID, action, resource, scope)
+	return sb.Subject(action, resource, scope).String()
+}
+
+// Build builds a NATS subject that can be published to, the tokens are validated
+func (sb *SubjectBuilder) Build(action, resource, scope string) (string, error) {
+	s := sb.Subject(action, resource, scope)
+	if err := s.Validate(); err != nil {
+		return "", err
+	}
+	return s.String(), nil
+}
+
+// BuildPattern builds a subscription pattern, any token may be "*" and the scope may end with ">"
+func (sb *SubjectBuilder) BuildPattern(action, resource, scope string) (string, error) {
+	s := sb.Subject(action, resource, scope)
+	if err := s.ValidatePattern(); err != nil {
+		return "", err
+	}
+	return s.String(), nil
+}
+
+// Parse parses a subject of the builder's type, under the builder's prefix
+func (sb *SubjectBuilder) Parse(subject string) (*Subject, error) {
+	if sb.subjectType == IsApp {
+		return ParseAs(sb.subjectType, subject)
 	}
-	return ""
+	return ParsePrefixed(sb.Prefix, sb.subjectType, subject)
 }
 
 // BuildMessage builds a JSON message from a map
@@ -61,31 +147,67 @@ func BuildMessage(payload map[string]interface{}) (string, error) {
 	return string(msgBytes), nil
 }
 
-// GetSubjectParts to split and retrieve everything after "proxy."
+// GetSubjectParts returns everything after "<global-uuid>.proxy.", eg; ros.get.points.all
 func GetSubjectParts(subject string) string {
-	parts := strings.SplitN(subject, "proxy.", 2)
-	if len(parts) < 2 {
-		fmt.Println("No part found after 'proxy.'")
+	tokens := strings.Split(subject, ".")
+	if len(tokens) < 3 || tokens[1] != proxyToken {
 		return ""
 	}
-	return parts[1]
+	return strings.Join(tokens[2:], ".")
 }
 
-// GetAppID to extract appID after "proxy." or return an error
+// GetAppID returns the app id of a proxy subject, <global-uuid>.proxy.<app-id>.<subject>
 func GetAppID(subject string) (string, error) {
-	// Split by "proxy."
-	parts := strings.SplitN(subject, "proxy.", 2)
-	if len(parts) < 2 {
-		return "", errors.New("no part found after 'proxy.'")
+	tokens := strings.Split(subject, ".")
+	if len(tokens) < 4 || tokens[1] != proxyToken {
+		return "", fmt.Errorf("invalid proxy subject %q, expected <global-uuid>.proxy.<app-id>.<subject>", subject)
+	}
+	if err := validateID("app id", tokens[2], false); err != nil {
+		return "", err
 	}
+	return tokens[2], nil
+}
 
-	// Split the remaining part by "."
-	subParts := strings.SplitN(parts[1], ".", 2)
-	if len(subParts) < 2 {
-		return "", errors.New("appID not found after 'proxy.'")
+// Match reports whether a NATS subject matches a subscription pattern,
+// supporting the "*" (single token) and ">" (one or more trailing tokens) wildcards
+func Match(subject, pattern string) bool {
+	subjectTokens := strings.Split(subject, ".")
+	patternTokens := strings.Split(pattern, ".")
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) {
+			return false
+		}
+		if token != "*" && token != subjectTokens[i] {
+			return false
+		}
 	}
+	return len(subjectTokens) == len(patternTokens)
+}
 
-	// The first part should be the appID
-	appID := subParts[0]
-	return appID, nil
+// MatchPattern reports whether every subject matched by the subscription subPattern is also matched by
+// pattern, eg; abc.get.system.* is within *.get.> but abc.> is not. For a subject without wildcards it's Match.
+func MatchPattern(subPattern, pattern string) bool {
+	subTokens := strings.Split(subPattern, ".")
+	patternTokens := strings.Split(pattern, ".")
+	for i, token := range subTokens {
+		if i >= len(patternTokens) {
+			return false
+		}
+		switch patternTokens[i] {
+		case ">":
+			return true
+		case "*":
+			if token == ">" {
+				return false
+			}
+			continue
+		}
+		if token != patternTokens[i] {
+			return false
+		}
+	}
+	return len(subTokens) == len(patternTokens)
 }
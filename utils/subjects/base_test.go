@@ -6,16 +6,43 @@ import (
 )
 
 func TestNewSubjectBuilder(t *testing.T) {
-	sbProxy := NewSubjectBuilder("<global_uuid>", "<app_id>", IsProxy)
-	subjectProxy := sbProxy.BuildSubject("get", "points", "all")
-	fmt.Println("Subject with Proxy:", subjectProxy)
-	// Output: <global_uuid>.proxy.<app_id>.get.points.all
+	sbProxy, err := NewSubjectBuilder("abc", "ros", IsProxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sbProxy.BuildSubject("get", "points", "all"); got != "abc.proxy.ros.get.points.all" {
+		t.Errorf("proxy subject = %s", got)
+	}
+	sbBios, err := NewSubjectBuilder("abc", "bios", IsBios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sbBios.BuildSubject("post", "apps", "manager.install"); got != "abc.post.apps.manager.install" {
+		t.Errorf("bios subject = %s", got)
+	}
+	if got := sbBios.GlobalSubject("get", "system", "ping"); got != "global.get.system.ping" {
+		t.Errorf("global subject = %s", got)
+	}
 
-	// Example when dontUseProxy is true (not using proxy and swapping app_id with global_uuid)
-	sbNoProxy := NewSubjectBuilder("abc", "bios", IsProxy)
-	subjectNoProxy := sbNoProxy.BuildSubject("get", "points", "all")
-	fmt.Println("Subject without Proxy:", subjectNoProxy)
-	// Output: <global_uuid>.get.points.all
+	for _, tt := range []struct{ globalUUID, appID, subjectType string }{
+		{"abc", "ros", "cloud"},
+		{"a.b", "bios", IsBios},
+		{"abc", "ros*", IsApp},
+		{"abc", "ros proxy", IsProxy},
+	} {
+		if _, err := NewSubjectBuilder(tt.globalUUID, tt.appID, tt.subjectType); err == nil {
+			t.Errorf("NewSubjectBuilder(%q, %q, %q) should fail", tt.globalUUID, tt.appID, tt.subjectType)
+		}
+	}
+
+	// an empty id is only rejected once a subject is built
+	sbEmpty, err := NewSubjectBuilder("", "bios", IsBios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sbEmpty.Build("get", "system", "ping"); err == nil {
+		t.Error("Build should reject an empty global uuid")
+	}
 
 	// Building a message
 	payload := map[string]interface{}{
@@ -25,7 +52,66 @@ func TestNewSubjectBuilder(t *testing.T) {
 	}
 	message, _ := BuildMessage(payload)
 	fmt.Println("Message:", message)
-	subject := "abc.proxy.ros.get.points"
-	fmt.Println(GetSubjectParts(subject))
+}
+
+func TestGetSubjectParts(t *testing.T) {
+	// an app id containing "proxy." must not be split on
+	subject := "abc.proxy.app-proxy.get.points"
+	if got := GetSubjectParts(subject); got != "app-proxy.get.points" {
+		t.Errorf("GetSubjectParts = %s", got)
+	}
+	appID, err := GetAppID(subject)
+	if err != nil || appID != "app-proxy" {
+		t.Errorf("GetAppID = %s %v", appID, err)
+	}
+	if got := GetSubjectParts("myproxy.get.system.ping"); got != "" {
+		t.Errorf("GetSubjectParts of a bios subject = %s", got)
+	}
+	if _, err := GetAppID("abc.get.system.proxy.routes"); err == nil {
+		t.Error("GetAppID of a bios subject should fail")
+	}
+}
 
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		subject string
+		pattern string
+		want    bool
+	}{
+		{"abc.get.system.ping", "abc.get.system.ping", true},
+		{"abc.get.system.ping", "abc.get.system.*", true},
+		{"abc.post.system.store.add.object", "*.post.system.store.>", true},
+		{"abc.post.system.store", "*.post.system.store.>", false},
+		{"abc.get.system.ping", "abc.get.*", false},
+		{"abc.get", "abc.get.*", false},
+		{"abc.get.system.ping", ">", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.subject, tt.pattern); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.subject, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		subPattern string
+		pattern    string
+		want       bool
+	}{
+		{"abc.get.system.ping", "*.get.>", true},
+		{"abc.get.system.*", "*.get.>", true},
+		{"abc.get.>", "*.get.>", true},
+		{"abc.>", "*.get.>", false},
+		{"abc.*.system.ping", "*.get.>", false},
+		{"abc.>", "abc.*", false},
+		{"abc.*", "abc.*", true},
+		{"abc.event.app.*", "abc.event.*.*", true},
+		{"abc.event", "abc.event.>", false},
+	}
+	for _, tt := range tests {
+		if got := MatchPattern(tt.subPattern, tt.pattern); got != tt.want {
+			t.Errorf("MatchPattern(%q, %q) = %v, want %v", tt.subPattern, tt.pattern, got, tt.want)
+		}
+	}
 }
@@ -0,0 +1,280 @@
+package subjects
+
+import (
+	"fmt"
+	"strings"
+)
+
+const proxyToken = "proxy"
+
+/*
+Subject is a parsed NATS subject, the forms are
+
+	bios:   <global-uuid>.<action>.<resource>.<scope>
+	app:    <app-id>.<action>.<resource>.<scope>
+	proxy:  <global-uuid>.proxy.<app-id>.<action>.<resource>.<scope>
+	global: global.<action>.<resource>.<scope>
+
+the scope is one or more tokens, eg; manager.install or store.add.object. The bios, proxy and
+global forms can start with a prefix of one or more tokens to group devices by site or customer,
+eg; acme.site-1.<global-uuid>.get.system.ping
+*/
+type Subject struct {
+	Type       string `json:"type"`
+	Prefix     string `json:"prefix,omitempty"`
+	GlobalUUID string `json:"globalUUID,omitempty"`
+	AppID      string `json:"appID,omitempty"`
+	Action     string `json:"action"`
+	Resource   string `json:"resource"`
+	Scope      string `json:"scope"`
+}
+
+// String joins the subject tokens, it doesn't validate them (see Validate and ValidatePattern)
+func (s *Subject) String() string {
+	return strings.Join(s.Tokens(), ".")
+}
+
+// Tokens returns the subject split on ".", with the scope split into its tokens
+func (s *Subject) Tokens() []string {
+	var tokens []string
+	switch s.Type {
+	case IsBios:
+		tokens = []string{s.GlobalUUID}
+	case IsApp:
+		tokens = []string{s.AppID}
+	case IsProxy:
+		tokens = []string{s.GlobalUUID, proxyToken, s.AppID}
+	case IsGlobal:
+		tokens = []string{globalName}
+	}
+	if s.Prefix != "" && s.Type != IsApp {
+		tokens = append(strings.Split(s.Prefix, "."), tokens...)
+	}
+	tokens = append(tokens, s.Action, s.Resource)
+	return append(tokens, strings.Split(s.Scope, ".")...)
+}
+
+// Validate checks the subject can be published to, so no token is empty or a wildcard
+func (s *Subject) Validate() error {
+	return s.validate(false)
+}
+
+// ValidatePattern checks the subject can be subscribed to, any token may be the "*" wildcard
+// and the last token of the scope may be ">", eg; *.get.system.> or abc.post.apps.manager.*
+func (s *Subject) ValidatePattern() error {
+	return s.validate(true)
+}
+
+// Matches reports whether subject matches this subject as a subscription pattern
+func (s *Subject) Matches(subject string) bool {
+	return Match(subject, s.String())
+}
+
+func (s *Subject) validate(pattern bool) error {
+	if s.Prefix != "" {
+		if err := validatePrefix(s.Prefix, pattern); err != nil {
+			return err
+		}
+	}
+	switch s.Type {
+	case IsBios:
+		if err := validateID("global uuid", s.GlobalUUID, pattern); err != nil {
+			return err
+		}
+	case IsApp:
+		if err := validateID("app id", s.AppID, pattern); err != nil {
+			return err
+		}
+	case IsProxy:
+		if err := validateID("global uuid", s.GlobalUUID, pattern); err != nil {
+			return err
+		}
+		if err := validateID("app id", s.AppID, pattern); err != nil {
+			return err
+		}
+	case IsGlobal:
+	default:
+		return unsupportedType(s.Type)
+	}
+	if err := validateID("action", s.Action, pattern); err != nil {
+		return err
+	}
+	if err := validateID("resource", s.Resource, pattern); err != nil {
+		return err
+	}
+	scope := strings.Split(s.Scope, ".")
+	for i, token := range scope {
+		if pattern && token == ">" && i == len(scope)-1 {
+			continue
+		}
+		if err := validateToken(token, pattern); err != nil {
+			return fmt.Errorf("invalid scope %q: %v", s.Scope, err)
+		}
+	}
+	return nil
+}
+
+// ValidatePrefix checks a subject prefix, one or more tokens without wildcards, eg; acme.site-1
+func ValidatePrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	return validatePrefix(prefix, false)
+}
+
+func validatePrefix(prefix string, pattern bool) error {
+	for _, token := range strings.Split(prefix, ".") {
+		if token == globalName || token == proxyToken {
+			return fmt.Errorf("invalid prefix %q: %s is reserved", prefix, token)
+		}
+		if err := validateToken(token, pattern); err != nil {
+			return fmt.Errorf("invalid prefix %q: %v", prefix, err)
+		}
+	}
+	return nil
+}
+
+// validateID checks a value that must be a single subject token
+func validateID(name, value string, pattern bool) error {
+	if err := validateToken(value, pattern); err != nil {
+		return fmt.Errorf("invalid %s %q: %v", name, value, err)
+	}
+	return nil
+}
+
+// validateToken checks a single subject token, with pattern the whole token may be "*"
+func validateToken(token string, pattern bool) error {
+	if token == "" {
+		return fmt.Errorf("empty token")
+	}
+	if pattern && token == "*" {
+		return nil
+	}
+	if strings.ContainsAny(token, ".") {
+		return fmt.Errorf("a token can't contain a \".\"")
+	}
+	if strings.ContainsAny(token, "*>") {
+		return fmt.Errorf("a token can't contain a wildcard")
+	}
+	if strings.ContainsAny(token, " \t\r\n") {
+		return fmt.Errorf("a token can't contain whitespace")
+	}
+	return nil
+}
+
+func unsupportedType(subjectType string) error {
+	return fmt.Errorf("subject type %s is not supported try: %s, %s, %s or %s", subjectType, IsApp, IsProxy, IsBios, IsGlobal)
+}
+
+// Parse parses a published subject. The global and proxy forms are found by their "global" and "proxy"
+// tokens, any other subject is parsed as the bios form; use ParseAs for an app subject.
+func Parse(subject string) (*Subject, error) {
+	tokens := strings.Split(subject, ".")
+	switch {
+	case tokens[0] == globalName:
+		return ParseAs(IsGlobal, subject)
+	case len(tokens) > 1 && tokens[1] == proxyToken:
+		return ParseAs(IsProxy, subject)
+	}
+	return ParseAs(IsBios, subject)
+}
+
+// ParseAs parses a published subject of the given type, so that s.String() returns the subject again
+func ParseAs(subjectType, subject string) (*Subject, error) {
+	s, err := parse(subjectType, subject)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid subject %q: %v", subject, err)
+	}
+	return s, nil
+}
+
+// ParsePrefixed parses a published subject of the given type that starts with prefix
+func ParsePrefixed(prefix, subjectType, subject string) (*Subject, error) {
+	if prefix == "" {
+		return ParseAs(subjectType, subject)
+	}
+	rest, ok := strings.CutPrefix(subject, prefix+".")
+	if !ok {
+		return nil, fmt.Errorf("subject %q doesn't start with the prefix %s", subject, prefix)
+	}
+	s, err := ParseAs(subjectType, rest)
+	if err != nil {
+		return nil, err
+	}
+	s.Prefix = prefix
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid subject %q: %v", subject, err)
+	}
+	return s, nil
+}
+
+// TrimPrefix returns subject without prefix, so it can be checked against the unprefixed patterns of a policy or
+// allowlist. A global subject loses any level of the prefix, eg; acme.global.get.system.ping with the prefix
+// acme.site-1. A subject without the prefix (eg; an event or a local app subject) is returned as it is.
+func TrimPrefix(prefix, subject string) string {
+	if prefix == "" {
+		return subject
+	}
+	if rest, ok := strings.CutPrefix(subject, prefix+"."); ok {
+		return rest
+	}
+	tokens := strings.Split(prefix, ".")
+	for i := len(tokens) - 1; i > 0; i-- {
+		rest, ok := strings.CutPrefix(subject, strings.Join(tokens[:i], ".")+".")
+		if ok && strings.HasPrefix(rest, globalName+".") {
+			return rest
+		}
+	}
+	return subject
+}
+
+// ParsePattern parses a subscription pattern of the given type, eg; ParsePattern(IsBios, "*.get.system.>")
+func ParsePattern(subjectType, pattern string) (*Subject, error) {
+	s, err := parse(subjectType, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ValidatePattern(); err != nil {
+		return nil, fmt.Errorf("invalid subject pattern %q: %v", pattern, err)
+	}
+	return s, nil
+}
+
+func parse(subjectType, subject string) (*Subject, error) {
+	tokens := strings.Split(subject, ".")
+	s := &Subject{Type: subjectType}
+	var prefix int
+	switch subjectType {
+	case IsBios:
+		s.GlobalUUID = tokens[0]
+		prefix = 1
+	case IsApp:
+		s.AppID = tokens[0]
+		prefix = 1
+	case IsProxy:
+		if len(tokens) < 3 || tokens[1] != proxyToken {
+			return nil, fmt.Errorf("invalid proxy subject %q, expected <global-uuid>.proxy.<app-id>.<action>.<resource>.<scope>", subject)
+		}
+		s.GlobalUUID = tokens[0]
+		s.AppID = tokens[2]
+		prefix = 3
+	case IsGlobal:
+		if tokens[0] != globalName {
+			return nil, fmt.Errorf("invalid global subject %q, expected %s.<action>.<resource>.<scope>", subject, globalName)
+		}
+		prefix = 1
+	default:
+		return nil, unsupportedType(subjectType)
+	}
+	rest := tokens[prefix:]
+	if len(rest) < 3 {
+		return nil, fmt.Errorf("invalid %s subject %q, expected <action>.<resource>.<scope> after the %s", subjectType, subject, strings.Join(tokens[:prefix], "."))
+	}
+	s.Action = rest[0]
+	s.Resource = rest[1]
+	s.Scope = strings.Join(rest[2:], ".")
+	return s, nil
+}
@@ -0,0 +1,161 @@
+package subjects
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    Subject
+	}{
+		{"abc.get.system.ping", Subject{Type: IsBios, GlobalUUID: "abc", Action: "get", Resource: "system", Scope: "ping"}},
+		{"abc.post.system.store.add.object", Subject{Type: IsBios, GlobalUUID: "abc", Action: "post", Resource: "system", Scope: "store.add.object"}},
+		{"abc.proxy.ros.post.apps.manager.install", Subject{Type: IsProxy, GlobalUUID: "abc", AppID: "ros", Action: "post", Resource: "apps", Scope: "manager.install"}},
+		{"abc.proxy.proxy.get.system.ping", Subject{Type: IsProxy, GlobalUUID: "abc", AppID: "proxy", Action: "get", Resource: "system", Scope: "ping"}},
+		{"global.get.system.ping", Subject{Type: IsGlobal, Action: "get", Resource: "system", Scope: "ping"}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.subject)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.subject, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.subject, *got, tt.want)
+		}
+		if got.String() != tt.subject {
+			t.Errorf("String() = %q, want %q", got.String(), tt.subject)
+		}
+	}
+
+	app, err := ParseAs(IsApp, "app-abc.post.math.add.int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.AppID != "app-abc" || app.Scope != "add.int" || app.String() != "app-abc.post.math.add.int" {
+		t.Errorf("ParseAs(app) = %+v", app)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		subjectType string
+		subject     string
+	}{
+		{IsBios, "abc.get.system"},
+		{IsBios, "abc.get..ping"},
+		{IsBios, "abc.get.system.*"},
+		{IsBios, "abc.get.system.>"},
+		{IsProxy, "abc.get.system.ping"},
+		{IsProxy, "abc.proxy.ros.get.system"},
+		{IsGlobal, "abc.get.system.ping"},
+		{"cloud", "abc.get.system.ping"},
+	}
+	for _, tt := range tests {
+		if s, err := ParseAs(tt.subjectType, tt.subject); err == nil {
+			t.Errorf("ParseAs(%s, %q) = %+v, want an error", tt.subjectType, tt.subject, s)
+		}
+	}
+}
+
+func TestPattern(t *testing.T) {
+	pattern, err := ParsePattern(IsBios, "*.post.system.store.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pattern.Matches("abc.post.system.store.add.object") || pattern.Matches("abc.get.system.ping") {
+		t.Errorf("pattern %s matched wrongly", pattern)
+	}
+	for _, invalid := range []string{"abc.get.system.>.ping", "abc.get.sys*.ping", "abc.>.system.ping"} {
+		if _, err := ParsePattern(IsBios, invalid); err == nil {
+			t.Errorf("ParsePattern(%q) should fail", invalid)
+		}
+	}
+
+	sb, err := NewSubjectBuilder("abc", "bios", IsBios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := sb.BuildPattern("get", "system", "proxy.*")
+	if err != nil || got != "abc.get.system.proxy.*" {
+		t.Errorf("BuildPattern = %s %v", got, err)
+	}
+	if _, err := sb.Build("get", "system", "proxy.*"); err == nil {
+		t.Error("Build should reject wildcards")
+	}
+	if _, err := sb.Build("get", "sys.tem", "ping"); err == nil {
+		t.Error("Build should reject a dot in the resource")
+	}
+	s, err := sb.Parse("abc.get.system.proxy.routes")
+	if err != nil || s.Scope != "proxy.routes" {
+		t.Errorf("Parse = %+v %v", s, err)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	sb, err := NewSubjectBuilder("abc", "bios", IsBios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb, err = sb.WithPrefix("acme.site-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject := sb.BuildSubject("get", "system", "ping")
+	if subject != "acme.site-1.abc.get.system.ping" {
+		t.Errorf("prefixed subject = %s", subject)
+	}
+	if got := sb.AddGlobalUUID("proxy.>"); got != "acme.site-1.abc.proxy.>" {
+		t.Errorf("AddGlobalUUID = %s", got)
+	}
+	if got := sb.GlobalSubject("get", "system", "ping"); got != "acme.site-1.global.get.system.ping" {
+		t.Errorf("GlobalSubject = %s", got)
+	}
+	want := []string{"global.get.system.ping", "acme.global.get.system.ping", "acme.site-1.global.get.system.ping"}
+	if got := sb.GroupSubjects("get", "system", "ping"); !reflect.DeepEqual(got, want) {
+		t.Errorf("GroupSubjects = %v", got)
+	}
+
+	s, err := sb.Parse(subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Prefix != "acme.site-1" || s.GlobalUUID != "abc" || s.String() != subject {
+		t.Errorf("Parse = %+v", s)
+	}
+	if _, err := sb.Parse("other.site.abc.get.system.ping"); err == nil {
+		t.Error("Parse should reject a subject under another prefix")
+	}
+	for subject, want := range map[string]string{
+		"acme.site-1.abc.get.system.ping":    "abc.get.system.ping",
+		"acme.site-1.global.get.system.ping": "global.get.system.ping",
+		"acme.global.get.system.ping":        "global.get.system.ping",
+		"acme.abc.get.system.ping":           "acme.abc.get.system.ping",
+		"abc.event.app.started":              "abc.event.app.started",
+	} {
+		if got := TrimPrefix(sb.Prefix, subject); got != want {
+			t.Errorf("TrimPrefix(%s) = %s, want %s", subject, got, want)
+		}
+	}
+
+	// app subjects stay on the local broker so they are never prefixed
+	app, err := NewSubjectBuilder("abc", "ros", IsApp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err = app.WithPrefix("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := app.BuildSubject("get", "system", "ping"); got != "ros.get.system.ping" {
+		t.Errorf("app subject = %s", got)
+	}
+
+	for _, invalid := range []string{"acme.*", "acme..site", "acme.global", "acme.proxy"} {
+		if err := ValidatePrefix(invalid); err == nil {
+			t.Errorf("ValidatePrefix(%q) should fail", invalid)
+		}
+	}
+}
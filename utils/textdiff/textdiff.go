@@ -0,0 +1,168 @@
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around a change
+const context = 3
+
+// MaxLines is the most lines a text can have to be diffed, the table is len(from)*len(to) so a bigger file is only
+// reported as replaced
+const MaxLines = 1000
+
+type op struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+/*
+Unified is a unified diff of two texts by line, it's empty when they're the same, eg;
+
+	--- config.yaml
+	+++ config.yaml
+	@@ -1,2 +1,2 @@
+	-port: 1660
+	+port: 1661
+	 debug: true
+
+A text over MaxLines lines is only reported as replaced, with the line counts.
+*/
+func Unified(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+	a, b := splitLines(from), splitLines(to)
+	if len(a) > MaxLines || len(b) > MaxLines {
+		return fmt.Sprintf("--- %s\n+++ %s\nfile replaced, %d lines to %d lines\n", fromName, toName, len(a), len(b))
+	}
+	ops := diff(a, b)
+	var out strings.Builder
+	for _, hunk := range hunks(ops) {
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		out.WriteString(hunk)
+	}
+	return out.String()
+}
+
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diff is the longest common subsequence of the lines, Unified keeps the O(n*m) table under MaxLines*MaxLines
+func diff(a, b []string) []op {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var ops []op
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, op{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, op{'+', b[j]})
+	}
+	return ops
+}
+
+// hunks groups the changes with their context lines
+func hunks(ops []op) []string {
+	var out []string
+	for start := 0; start < len(ops); {
+		first := -1
+		for k := start; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				first = k
+				break
+			}
+		}
+		if first < 0 {
+			break
+		}
+		// the hunk ends once there are more than two contexts of unchanged lines after a change
+		end, unchanged := first, 0
+		for k := first; k < len(ops) && unchanged <= 2*context; k++ {
+			if ops[k].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+				end = k
+			}
+		}
+		from := max(first-context, start)
+		to := min(end+context+1, len(ops))
+		out = append(out, hunk(ops, from, to))
+		start = to
+	}
+	return out
+}
+
+func hunk(ops []op, from, to int) string {
+	// the line numbers of the hunk start
+	aLine, bLine := 1, 1
+	for _, o := range ops[:from] {
+		if o.kind != '+' {
+			aLine++
+		}
+		if o.kind != '-' {
+			bLine++
+		}
+	}
+	var body strings.Builder
+	aCount, bCount := 0, 0
+	for _, o := range ops[from:to] {
+		if o.kind != '+' {
+			aCount++
+		}
+		if o.kind != '-' {
+			bCount++
+		}
+		body.WriteByte(o.kind)
+		body.WriteString(o.line)
+		if !strings.HasSuffix(o.line, "\n") {
+			body.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	return fmt.Sprintf("@@ -%s +%s @@\n%s", hunkRange(aLine, aCount), hunkRange(bLine, bCount), body.String())
+}
+
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
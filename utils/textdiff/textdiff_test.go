@@ -0,0 +1,51 @@
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	if got := Unified("a", "b", "same\n", "same\n"); got != "" {
+		t.Errorf("expected no diff, got %q", got)
+	}
+
+	from := "name: flexy\nport: 1660\ndebug: false\n"
+	to := "name: flexy\nport: 1661\ndebug: false\nlevel: info\n"
+	want := "--- config.yaml\n+++ config.yaml\n@@ -1,3 +1,4 @@\n name: flexy\n-port: 1660\n+port: 1661\n debug: false\n+level: info\n"
+	if got := Unified("config.yaml", "config.yaml", from, to); got != want {
+		t.Errorf("diff =\n%s\nwant\n%s", got, want)
+	}
+
+	want = "--- a\n+++ b\n@@ -0,0 +1 @@\n+new\n"
+	if got := Unified("a", "b", "", "new\n"); got != want {
+		t.Errorf("diff =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestUnifiedHunks(t *testing.T) {
+	from, to := "", ""
+	for i := 0; i < 20; i++ {
+		line := string(rune('a'+i)) + "\n"
+		from += line
+		if i == 1 || i == 18 {
+			line = "changed\n"
+		}
+		to += line
+	}
+	want := "--- a\n+++ b\n" +
+		"@@ -1,5 +1,5 @@\n a\n-b\n+changed\n c\n d\n e\n" +
+		"@@ -16,5 +16,5 @@\n p\n q\n r\n-s\n+changed\n t\n"
+	if got := Unified("a", "b", from, to); got != want {
+		t.Errorf("diff =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnifiedMaxLines(t *testing.T) {
+	from := strings.Repeat("a\n", MaxLines+1)
+	want := fmt.Sprintf("--- a\n+++ b\nfile replaced, %d lines to 1 lines\n", MaxLines+1)
+	if got := Unified("a", "b", from, "b\n"); got != want {
+		t.Errorf("diff = %q, want %q", got, want)
+	}
+}
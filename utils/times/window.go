@@ -0,0 +1,101 @@
+package times
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+Window is a daily maintenance window in local time, with optional days
+
+	02:00-04:00           every day
+	sat,sun 01:00-05:00   only at the weekend, the day is the day the window opens
+	22:00-02:00           runs past midnight
+*/
+type Window struct {
+	Days  map[time.Weekday]bool // empty is every day
+	Start time.Duration         // since midnight
+	End   time.Duration
+	spec  string
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseWindow parses a window like "sat,sun 01:00-05:00", an empty spec is nil which is always open
+func ParseWindow(spec string) (*Window, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	w := &Window{spec: spec}
+	fields := strings.Fields(spec)
+	if len(fields) > 2 {
+		return nil, fmt.Errorf("invalid window %q, expected eg; sat,sun 01:00-05:00", spec)
+	}
+	if len(fields) == 2 {
+		w.Days = map[time.Weekday]bool{}
+		for _, day := range strings.Split(strings.ToLower(fields[0]), ",") {
+			weekday, ok := weekdays[day]
+			if !ok {
+				return nil, fmt.Errorf("invalid window %q, unknown day %q, try: mon, tue, wed, thu, fri, sat or sun", spec, day)
+			}
+			w.Days[weekday] = true
+		}
+	}
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid window %q, expected eg; 02:00-04:00", spec)
+	}
+	var err error
+	if w.Start, err = parseClock(start); err != nil {
+		return nil, fmt.Errorf("invalid window %q: %v", spec, err)
+	}
+	if w.End, err = parseClock(end); err != nil {
+		return nil, fmt.Errorf("invalid window %q: %v", spec, err)
+	}
+	if w.Start == w.End {
+		return nil, fmt.Errorf("invalid window %q, the start and end are the same", spec)
+	}
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected eg; 02:00", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t is in the window, a nil window is always open
+func (w *Window) Contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	clock := t.Sub(midnight)
+	day := t.Weekday()
+	if w.Start < w.End {
+		return clock >= w.Start && clock < w.End && w.openOn(day)
+	}
+	// past midnight, the window opened today or the day before
+	if clock >= w.Start {
+		return w.openOn(day)
+	}
+	return clock < w.End && w.openOn((day+6)%7)
+}
+
+func (w *Window) openOn(day time.Weekday) bool {
+	return len(w.Days) == 0 || w.Days[day]
+}
+
+func (w *Window) String() string {
+	if w == nil {
+		return ""
+	}
+	return w.spec
+}
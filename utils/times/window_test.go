@@ -0,0 +1,44 @@
+package times
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	// 2024-06-01 is a saturday
+	at := func(day int, clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2024, 6, day, c.Hour(), c.Minute(), 0, 0, time.Local)
+	}
+	for _, tt := range []struct {
+		spec string
+		t    time.Time
+		want bool
+	}{
+		{"", at(1, "12:00"), true},
+		{"02:00-04:00", at(1, "02:00"), true},
+		{"02:00-04:00", at(1, "04:00"), false},
+		{"sat,sun 01:00-05:00", at(2, "03:00"), true},
+		{"sat,sun 01:00-05:00", at(3, "03:00"), false},
+		{"22:00-02:00", at(1, "23:30"), true},
+		{"22:00-02:00", at(2, "01:00"), true},
+		{"22:00-02:00", at(2, "03:00"), false},
+		{"fri 22:00-02:00", at(1, "01:00"), true},
+		{"sat 22:00-02:00", at(1, "01:00"), false},
+		{"sat 22:00-02:00", at(2, "01:00"), true},
+	} {
+		w, err := ParseWindow(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Contains(tt.t); got != tt.want {
+			t.Errorf("%q contains %s = %v, want %v", tt.spec, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+	for _, spec := range []string{"02:00", "funday 02:00-04:00", "25:00-26:00", "02:00-02:00", "sat sun 02:00-04:00"} {
+		if _, err := ParseWindow(spec); err == nil {
+			t.Errorf("expected %q to be invalid", spec)
+		}
+	}
+}